@@ -1,24 +1,54 @@
 package main
 
 import (
+	"archive/zip"
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"database/sql"
 	_ "embed"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"io"
+	"maps"
+	"math"
+	"math/rand/v2"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	_ "modernc.org/sqlite" // pure-Go SQLite driver for --db
 )
 
+// SchemaVersion is the format version written to the JSON files this tool generates:
+// index.json, manifest.json, run_manifest.json, summary.json and unavailable.json.
+// Bump it whenever the structure of those files changes so downstream consumers can
+// detect and handle the new layout.
+const SchemaVersion = 1
+
 var (
 	version = "dev" // This will be overridden at build time via ldflags
 
@@ -27,14 +57,25 @@ var (
 		regexp.MustCompile(`/video/(\d+)`),
 		regexp.MustCompile(`/v/(\d+)`),
 	}
+
+	// Pre-compiled regex pattern for extracting the uploader handle from TikTok URLs
+	uploaderPattern = regexp.MustCompile(`/@([^/?#]+)`)
+
+	// Pre-compiled regex pattern for the video ID in downloaded filenames
+	// ("<upload_date>_<id>_<title>.<ext>", upload_date is "NA" when unknown)
+	filenameIDPattern = regexp.MustCompile(`^(?:\d{8}|NA)_(\d+)_`)
+
+	// Pre-compiled regex pattern for the saved date next to each video in the HTML export
+	htmlExportDatePattern = regexp.MustCompile(`\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}`)
 )
 
 // VideoEntry represents a video with its collection information and metadata
 type VideoEntry struct {
 	// From TikTok JSON export
 	Link       string `json:"link"`
-	Date       string `json:"favorited_date"` // When user favorited/liked
-	Collection string `json:"collection"`     // "favorites" or "liked"
+	Date       string `json:"favorited_date"`       // When user favorited/liked
+	Collection string `json:"collection"`           // "favorites", "saved", "liked" or "history"
+	WatchedAt  string `json:"watched_at,omitempty"` // When user watched it (browsing history only)
 
 	// Derived from URL
 	VideoID string `json:"video_id"`
@@ -74,12 +115,32 @@ type YtdlpInfo struct {
 
 // CollectionIndex represents the complete index for a collection
 type CollectionIndex struct {
-	Name        string       `json:"name"`
-	GeneratedAt string       `json:"generated_at"`
-	TotalVideos int          `json:"total_videos"`
-	Downloaded  int          `json:"downloaded"`
-	Failed      int          `json:"failed"`
-	Videos      []VideoEntry `json:"videos"`
+	SchemaVersion int          `json:"schema_version"`
+	Name          string       `json:"name"`
+	GeneratedAt   string       `json:"generated_at"`
+	TotalVideos   int          `json:"total_videos"`
+	Downloaded    int          `json:"downloaded"`
+	Failed        int          `json:"failed"`
+	Videos        []VideoEntry `json:"videos"`
+
+	// Uploaders groups Videos by creator for the HTML gallery (not written to index.json)
+	Uploaders []UploaderGroup `json:"-"`
+
+	// Sections splits the HTML gallery per --group-index-by; empty = one flat grid
+	Sections []IndexSection `json:"-"`
+}
+
+// IndexSection is one headed part of the HTML gallery (a month or a creator)
+type IndexSection struct {
+	ID     string
+	Title  string
+	Videos []VideoEntry
+}
+
+// UploaderGroup is the set of videos in a collection posted by one creator
+type UploaderGroup struct {
+	Handle string
+	Videos []VideoEntry
 }
 
 // CapturedOutput stores stdout and stderr from yt-dlp
@@ -98,8 +159,51 @@ type DownloadSession struct {
 	TotalSuccess   int
 	TotalFailed    int
 	TotalSkipped   int
+	Phases         []PhaseTiming // Time spent in each phase of the run, in order
+}
+
+// PhaseTiming records how long one phase of a run took (yt-dlp download, JSON parse, ...)
+type PhaseTiming struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"-"`
+	Seconds  float64       `json:"seconds"`
+}
+
+// phaseTimer accumulates PhaseTimings. Recording the same phase again (e.g. one
+// yt-dlp run per collection) adds to its total instead of adding a new entry.
+type phaseTimer struct {
+	phases []PhaseTiming
+}
+
+// Record adds the time since start to the named phase
+func (p *phaseTimer) Record(name string, start time.Time) {
+	p.add(name, time.Since(start))
+}
+
+func (p *phaseTimer) add(name string, d time.Duration) {
+	for i := range p.phases {
+		if p.phases[i].Name == name {
+			p.phases[i].Duration += d
+			p.phases[i].Seconds = p.phases[i].Duration.Seconds()
+			return
+		}
+	}
+	p.phases = append(p.phases, PhaseTiming{Name: name, Duration: d, Seconds: d.Seconds()})
+}
+
+// Phases returns the recorded phases in the order they were first seen
+func (p *phaseTimer) Phases() []PhaseTiming {
+	return append([]PhaseTiming(nil), p.phases...)
 }
 
+// Names of the phases timed during a run
+const (
+	PhaseYtdlpDownload = "yt-dlp download"
+	PhaseJSONParse     = "JSON parse"
+	PhaseListWrite     = "list write"
+	PhaseYtdlpRun      = "yt-dlp run"
+)
+
 // CollectionResult tracks results for a single collection
 type CollectionResult struct {
 	Name           string
@@ -108,6 +212,7 @@ type CollectionResult struct {
 	Failed         int
 	Skipped        int
 	FailureDetails []FailureDetail
+	SavedFiles     map[string]string // Video ID -> final file path, as reported by yt-dlp
 }
 
 // FailureDetail contains information about a failed download
@@ -146,22 +251,127 @@ func (e ErrorType) String() string {
 	}
 }
 
-// Data represents the structure of user_data_tiktok.json
+// Data represents the structure of user_data_tiktok.json. Sections are nested value
+// structs rather than pointers, so a section exported as null decodes as empty.
 type Data struct {
-	Activity struct {
-		FavoriteVideos struct {
-			FavoriteVideoList []struct {
-				Link string `json:"Link"`
-				Date string `json:"Date"` // Favorited date from TikTok export
-			} `json:"FavoriteVideoList"`
-		} `json:"Favorite Videos"`
-		LikedVideos struct {
-			ItemFavoriteList []struct {
-				Date string `json:"date"`
-				Link string `json:"link"`
-			} `json:"ItemFavoriteList"`
-		} `json:"Like List"`
-	} `json:"Likes and Favorites"`
+	Activity       likesAndFavorites `json:"Likes and Favorites"`
+	LegacyActivity likesAndFavorites `json:"Activity"` // Older exports keep the same lists under "Activity"
+	YourActivity   struct {
+		BrowsingHistory struct {
+			VideoList exportList[struct {
+				Date string     `json:"Date"` // When the video was watched
+				Link exportLink `json:"Link"`
+			}] `json:"VideoList"`
+		} `json:"Video Browsing History"`
+	} `json:"Your Activity"`
+	// Newer exports keep saved videos in the profile section instead of under Activity
+	Profile struct {
+		SavedVideos struct {
+			SavedVideoList exportList[struct {
+				Link exportLink `json:"Link"`
+				Date string     `json:"Date"` // Saved date from TikTok export
+			}] `json:"SavedVideoList"`
+		} `json:"Saved Videos"`
+	} `json:"Profile"`
+}
+
+// likesAndFavorites is the export section listing favorited and liked videos
+type likesAndFavorites struct {
+	FavoriteVideos struct {
+		FavoriteVideoList exportList[struct {
+			Link exportLink `json:"Link"`
+			Date string     `json:"Date"` // Favorited date from TikTok export
+		}] `json:"FavoriteVideoList"`
+	} `json:"Favorite Videos"`
+	LikedVideos struct {
+		ItemFavoriteList exportList[struct {
+			Date string     `json:"date"`
+			Link exportLink `json:"link"`
+		}] `json:"ItemFavoriteList"`
+	} `json:"Like List"`
+}
+
+// exportLink is the Link of an export item. It is normally a string, but some exports
+// have an array of URLs for one favorite; each URL then becomes its own video.
+type exportLink []string
+
+// UnmarshalJSON decodes a string, an array of strings or null
+func (l *exportLink) UnmarshalJSON(data []byte) error {
+	var link string
+	if err := json.Unmarshal(data, &link); err == nil {
+		*l = exportLink{link}
+		return nil
+	}
+	var links []string
+	if err := json.Unmarshal(data, &links); err != nil {
+		return fmt.Errorf("link must be a string or an array of strings: %v", err)
+	}
+	*l = links
+	return nil
+}
+
+// values returns the URLs to create videos for. An item without a link still yields
+// one (empty) URL, so it is reported rather than silently dropped.
+func (l exportLink) values() []string {
+	if len(l) == 0 {
+		return []string{""}
+	}
+	return l
+}
+
+// exportList is a list in the TikTok export. Some exports encode lists as objects keyed
+// by index ({"0": {...}, "1": {...}}) instead of arrays, so both forms are accepted.
+type exportList[T any] []T
+
+// UnmarshalJSON decodes an array, an index-keyed object (ordered by numeric key,
+// non-numeric keys last) or null
+func (l *exportList[T]) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+	} else {
+		var byKey map[string]json.RawMessage
+		if err := json.Unmarshal(data, &byKey); err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(byKey))
+		for k := range byKey {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			a, errA := strconv.Atoi(keys[i])
+			b, errB := strconv.Atoi(keys[j])
+			switch {
+			case errA == nil && errB == nil:
+				return a < b
+			case errA == nil || errB == nil:
+				return errA == nil // Numeric keys first
+			default:
+				return keys[i] < keys[j]
+			}
+		})
+		for _, k := range keys {
+			raw = append(raw, byKey[k])
+		}
+	}
+
+	// null items are left out rather than becoming videos without a link
+	items := make([]T, 0, len(raw))
+	for _, itemData := range raw {
+		if string(bytes.TrimSpace(itemData)) == "null" {
+			continue
+		}
+		var item T
+		if err := json.Unmarshal(itemData, &item); err != nil {
+			return err
+		}
+		items = append(items, item)
+	}
+	*l = items
+	return nil
 }
 
 // ProgressState tracks real-time download progress for display
@@ -177,7 +387,8 @@ type ProgressState struct {
 
 // ProgressRenderer handles ANSI-based progress display
 type ProgressRenderer struct {
-	enabled     bool      // false if terminal doesn't support ANSI or user disabled it
+	enabled     bool      // false if output isn't a terminal or user disabled it
+	plain       bool      // Terminal without ANSI support: show statusLine instead of the bar
 	lastLineLen int       // track last line length for proper clearing
 	writer      io.Writer // where to write output (defaults to os.Stdout)
 }
@@ -188,12 +399,142 @@ type Config struct {
 	IncludeLiked         bool
 	SkipThumbnails       bool
 	IndexOnly            bool
+	NDJSON               bool // Print the extracted entries to stdout as NDJSON instead of downloading
+	CountOnly            bool // Print only the number of extracted entries to stdout (monitoring)
+	PrintCommand         bool // Print only the yt-dlp command line(s) to stdout instead of downloading
+	PrintPlan            bool // Print the execution plan as JSON to stdout before downloading
+	DryRun               bool // With PrintPlan, stop after the plan without downloading
+	CopyCommand          bool // With PrintCommand, also copy the command line(s) to the clipboard
 	DisableResume        bool // Disable resume functionality (force re-download all videos)
+	AutoResume           bool // Resume an interrupted batch from its checkpoint without asking
 	DisableProgressBar   bool // Disable progress bar (use traditional line-by-line output)
+	TUI                  bool // Show a live list of in-progress/completed/failed downloads instead of the bar
 	JSONFile             string
 	OutputName           string
-	CookieFile           string // Path to Netscape cookies.txt file
-	CookieFromBrowser    string // Browser name (chrome, firefox, edge, safari, etc.)
+	CookieFile           string        // Path to Netscape cookies.txt file
+	CookieFromBrowser    string        // Browser name (chrome, firefox, edge, safari, etc.)
+	Find                 bool          // Search common download folders for the newest export
+	WorkDir              string        // Base directory for lists, downloads and reports (empty = current directory)
+	Paste                bool          // Read the export from the clipboard when the JSON file isn't found
+	FindDir              string        // Directory to search instead of the default download folders
+	PerVideoTimeout      time.Duration // If set, run yt-dlp once per URL with this timeout each
+	WriteTimings         bool          // Run yt-dlp once per URL and append each video's time and size to timings.csv
+	RunRetries           int           // Re-run a failed yt-dlp invocation (batch, chunk or single URL) up to this many times
+	RunRetryDelay        time.Duration // Wait this long before each --run-retries attempt
+	LimitPerUploader     int           // Keep at most this many videos per uploader (0 = unlimited)
+	BatchByUploader      bool          // Group the URL list by uploader so each creator's videos are fetched together
+	UploaderStats        int           // Print the unique uploader count and this many top uploaders after parsing (0 = off)
+	IncrementalIndex     bool          // Merge new results into the existing index.json instead of rebuilding
+	SplitBySource        bool          // Flat mode: write favorites.txt, liked.txt, ... instead of one merged list
+	SeparateRuns         bool          // Flat mode: download each source into its own subdirectory with its own results.txt
+	NormalizeURLs        bool          // Strip tracking params/regional paths and lowercase hosts before writing
+	StripQuery           bool          // Only remove query strings from URLs before writing (no other canonicalization)
+	MediaExtensions      []string      // File extensions counted as downloaded media when indexing (nil = defaultMediaExtensions)
+	FilenameMatch        string        // --filename-match tolerance for finding downloaded files: exact, id or fuzzy ("" = id)
+	IndexGrouping        string        // --group-index-by gallery sections in index.html: date, uploader or none ("" = none)
+	ReportOnly           string        // Output directory to regenerate index and results.txt for, without downloading
+	ExportCreators       string        // Write the unique creators' profile URLs here (.opml or text) instead of downloading
+	ClientCert           string        // PEM client certificate for HTTPS downloads (mirrors requiring mutual TLS)
+	ClientKey            string        // PEM private key for ClientCert
+	InsecureSkipVerify   bool          // Don't verify the server certificate on HTTPS downloads (self-signed mirrors)
+	MaxConnections       int           // Cap on simultaneous connections per host for HTTP downloads (0 = no cap)
+	TraceHTTP            bool          // Log DNS, connect, TLS and first-byte timings of the tool's own HTTP requests
+	YtdlpPath            string        // Existing yt-dlp binary to use instead of downloading yt-dlp.exe (offline mode)
+	WriteLauncher        bool          // Save the suggested yt-dlp command as run_download.bat/.sh
+	WriteYtdlpConf       bool          // Save each list's resolved yt-dlp options as <list>.yt-dlp.conf
+	ExtraArgs            []string      // Appended to every yt-dlp invocation (from YTDLP_EXTRA_ARGS)
+	ConcurrentFragments  int           // yt-dlp --concurrent-fragments (0 = yt-dlp default)
+	MaxRuntime           time.Duration // Stop starting new downloads after this long (0 = no limit)
+	Deadline             time.Time     // When MaxRuntime runs out, set at startup (zero = no limit)
+	URLMapping           bool          // Write mapping.json linking each downloaded file to its TikTok URL
+	Manifest             bool          // Record each downloaded file's size and SHA-256 in manifest.json (checked by "scan")
+	RunManifest          bool          // Write run_manifest.json/.csv joining each export entry with its download result
+	Headers              []string      // Extra HTTP headers ("Key: Value") forwarded to yt-dlp --add-header
+	MinResolution        int           // Prefer formats at least this tall, in pixels (0 = no preference)
+	MaxResolution        int           // Prefer formats at most this tall, in pixels (0 = no preference)
+	WriteComments        bool          // Save comments into the .info.json (yt-dlp --write-comments)
+	WriteSubs            bool          // Download uploaded subtitles (yt-dlp --write-subs)
+	WriteAutoSubs        bool          // Download automatic captions (yt-dlp --write-auto-subs)
+	SubLangs             string        // Subtitle languages to download (yt-dlp --sub-langs, empty = yt-dlp default)
+	MaxFilesize          string        // Skip videos larger than this, e.g. "100M" (yt-dlp --max-filesize, empty = no limit)
+	DBPath               string        // SQLite database to upsert per-video results into (empty = off)
+	WebhookURL           string        // POST a JSON run summary here when the run finishes (empty = off)
+	MergeOutput          string        // Concatenate the downloaded clips into this file with ffmpeg (empty = off)
+	NotifyFormat         string        // Shape of the webhook body: json (default), discord or slack
+	ResultsFormat        string        // Run report format: text (results.txt, default) or apache (results.log)
+	ChunkSize            int           // Split each URL list into batch files of this many URLs (0 = no splitting)
+	Since                time.Time     // Only download videos favorited after this (zero = no cutoff)
+	AutoSince            bool          // --since auto: skip archived videos older than the newest existing file
+	Until                time.Time     // Only download videos favorited before this (zero = no limit)
+	DedupeExisting       bool          // Skip videos whose media file is already in the output directory
+	Precheck             bool          // HEAD each URL before download and drop the ones that 404
+	RunLog               bool          // Tee the console transcript into run-<timestamp>.log
+	NoWaitYtdlp          bool          // On a failed yt-dlp download, print the manual link and carry on without waiting
+	IncludeHistory       bool          // Also download videos from the browsing history (collection "history")
+	WatchedSince         time.Time     // Only keep history videos watched after this (zero = no limit)
+	Order                string        // List order: original (export order, liked after favorites) or chronological
+	AfterID              string        // Only download the videos after this one in its collection's list (empty = all)
+
+	// Rotates the User-Agent per request and yt-dlp run (--rotate-user-agent); nil = off
+	UserAgents *userAgentRotator
+
+	// Total size of the downloads in this run (--size-budget); nil = no limit
+	SizeBudget *sizeBudget
+
+	// Give up downloading yt-dlp.exe after this long (0 = no limit); separate from the
+	// yt-dlp run limits since a binary download is short and a hang there is never useful
+	BinaryDownloadTimeout time.Duration
+
+	// Video ID allowlist/blocklist (--include-ids-file/--exclude-ids-file); a nil
+	// IncludeIDs keeps every video
+	IncludeIDs map[string]bool
+	ExcludeIDs map[string]bool
+
+	// IDs of videos a previous run's index or playlist lists as downloaded (--skip-known)
+	KnownIDs map[string]bool
+
+	// yt-dlp output template per source (--output-template SOURCE=TEMPLATE), relative
+	// to the collection directory; sources without one use defaultOutputTemplate
+	OutputTemplates map[string]string
+
+	// Date window per source (--liked-since, --favorites-until, ...), applied on top of
+	// --since/--until; sources without one aren't narrowed
+	SourceWindows map[string]DateWindow
+
+	// Export layout from --schema-map/--favorites-path; nil = built-in layout
+	ExportSchema *SchemaMap
+
+	// Saved GitHub API release response read instead of the live API (--release-file);
+	// "" = live API
+	ReleaseFile string
+}
+
+// DateWindow limits videos to those favorited after Since and before Until (zero = open)
+type DateWindow struct {
+	Since time.Time
+	Until time.Time
+}
+
+// Sentinel errors for the main failure categories. Returned errors wrap one of these
+// together with the underlying cause, so callers can branch with errors.Is.
+var (
+	ErrJSONParse = errors.New("error parsing JSON")
+	ErrNoAsset   = errors.New("release asset not found")
+	ErrDownload  = errors.New("download failed")
+	ErrYtdlpRun  = errors.New("yt-dlp failed")
+)
+
+// GitHubRelease represents the relevant fields of a GitHub "latest release" API response
+type GitHubRelease struct {
+	TagName string        `json:"tag_name"`
+	Assets  []GitHubAsset `json:"assets"`
+}
+
+// GitHubAsset represents a single downloadable file attached to a GitHub release
+type GitHubAsset struct {
+	Name               string `json:"name"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+	Digest             string `json:"digest"` // e.g. "sha256:<hex>", only present on newer releases
 }
 
 // isFileOlderThan30Days checks if a file's modification time is more than 30 days old
@@ -248,38 +589,70 @@ func backupYtdlp(exeName string) error {
 	return nil
 }
 
-// downloadLatestYtdlp downloads the latest version of yt-dlp from GitHub
-func downloadLatestYtdlp(client *http.Client, exeName string) error {
-	fmt.Printf("[*] Downloading the latest release from GitHub...\n")
+// getWithContext issues a GET request that is cancelled when ctx is done
+func getWithContext(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// fetchYtdlpRelease returns the latest yt-dlp release info, read from releaseFile
+// (--release-file) if set instead of the live API
+func fetchYtdlpRelease(ctx context.Context, client *http.Client, releaseFile string) (*GitHubRelease, error) {
+	var release GitHubRelease
+	if releaseFile != "" {
+		data, err := os.ReadFile(filepath.Clean(releaseFile))
+		if err != nil {
+			return nil, fmt.Errorf("error reading release file: %v", err)
+		}
+		if err := json.Unmarshal(data, &release); err != nil {
+			return nil, fmt.Errorf("%w in %s: %w", ErrJSONParse, releaseFile, err)
+		}
+		fmt.Printf("[*] Using release info from %s instead of the GitHub API\n", releaseFile)
+		return &release, nil
+	}
 
-	// 1. Retrieve the latest release info from GitHub
 	releaseURL := "https://api.github.com/repos/yt-dlp/yt-dlp/releases/latest"
-	resp, err := client.Get(releaseURL)
+	resp, err := getWithContext(ctx, client, releaseURL)
 	if err != nil {
-		return fmt.Errorf("failed to fetch the latest release info: %v", err)
+		return nil, fmt.Errorf("%w: could not fetch the latest release info: %w", ErrDownload, err)
 	}
 	defer func() { _ = resp.Body.Close() }()
-
-	var release struct {
-		Assets []struct {
-			Name               string `json:"name"`
-			BrowserDownloadURL string `json:"browser_download_url"`
-		} `json:"assets"`
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected response from GitHub API: %s", ErrDownload, resp.Status)
 	}
+
 	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
-		return fmt.Errorf("failed to parse GitHub API release JSON: %v", err)
+		return nil, fmt.Errorf("%w from GitHub API: %w", ErrJSONParse, err)
+	}
+	return &release, nil
+}
+
+// downloadYtdlpAsset downloads the named asset of the latest yt-dlp release from
+// GitHub (or the release in releaseFile) and saves it as exeName (e.g. yt-dlp_arm64.exe
+// saved as yt-dlp.exe). The whole download is abandoned, and the partial file removed,
+// once ctx is done.
+func downloadYtdlpAsset(ctx context.Context, client *http.Client, releaseFile, assetName, exeName string) error {
+	fmt.Printf("[*] Downloading the latest release from GitHub...\n")
+
+	// 1. Retrieve the latest release info from GitHub
+	release, err := fetchYtdlpRelease(ctx, client, releaseFile)
+	if err != nil {
+		return err
 	}
 
-	// 2. Find the asset with name "yt-dlp.exe"
+	// 2. Find the asset with the requested name (e.g. "yt-dlp.exe")
 	var downloadURL string
 	for _, asset := range release.Assets {
-		if strings.EqualFold(asset.Name, exeName) {
+		if strings.EqualFold(asset.Name, assetName) {
 			downloadURL = asset.BrowserDownloadURL
 			break
 		}
 	}
 	if downloadURL == "" {
-		return fmt.Errorf("could not find %s in the latest release assets", exeName)
+		return fmt.Errorf("%w: could not find %s in the latest release", ErrNoAsset, assetName)
 	}
 
 	fmt.Printf("[*] Downloading %s...\n", downloadURL)
@@ -291,26 +664,347 @@ func downloadLatestYtdlp(client *http.Client, exeName string) error {
 	}
 	defer func() { _ = out.Close() }()
 
-	downloadResp, err := client.Get(downloadURL)
+	downloadResp, err := getWithContext(ctx, client, downloadURL)
 	if err != nil {
-		return fmt.Errorf("failed to download %s: %v", exeName, err)
+		_ = out.Close()
+		_ = os.Remove(exeName)
+		return fmt.Errorf("%w: %s: %w", ErrDownload, exeName, err)
 	}
 	defer func() { _ = downloadResp.Body.Close() }()
+	if downloadResp.StatusCode != http.StatusOK {
+		_ = out.Close()
+		_ = os.Remove(exeName)
+		return fmt.Errorf("%w: %s: %s", ErrDownload, exeName, downloadResp.Status)
+	}
 
 	// 4. Copy the response body to the file
 	if _, err := io.Copy(out, downloadResp.Body); err != nil {
-		return fmt.Errorf("failed to write %s to disk: %v", exeName, err)
+		_ = out.Close()
+		_ = os.Remove(exeName)
+		return fmt.Errorf("%w: could not write %s to disk: %w", ErrDownload, exeName, err)
 	}
 
 	fmt.Println("[*] Successfully downloaded yt-dlp")
 	return nil
 }
 
+// errorBadExeFormat is Windows' ERROR_BAD_EXE_FORMAT ("%1 is not a valid Win32
+// application"), returned when launching an executable built for another architecture
+const errorBadExeFormat = syscall.Errno(193)
+
+// isBadExeFormat reports whether err means the executable couldn't be started because
+// it was built for a different CPU architecture (ERROR_BAD_EXE_FORMAT on Windows,
+// ENOEXEC elsewhere)
+func isBadExeFormat(err error) bool {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno == errorBadExeFormat || errno == syscall.ENOEXEC
+	}
+	return err != nil && strings.Contains(err.Error(), "not a valid Win32 application")
+}
+
+// ytdlpAssetName returns the yt-dlp release asset for a Windows architecture
+func ytdlpAssetName(goarch string) string {
+	switch goarch {
+	case "386":
+		return "yt-dlp_x86.exe"
+	case "arm64":
+		return "yt-dlp_arm64.exe"
+	default:
+		return "yt-dlp.exe"
+	}
+}
+
+// promptForRedownload asks whether to replace a yt-dlp.exe built for the wrong architecture
+func promptForRedownload(assetName string) bool {
+	fmt.Printf("[*] Would you like to download %s for this PC instead? (Y/n, default is 'Y'): ", assetName)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Scan()
+	input := strings.TrimSpace(strings.ToLower(scanner.Text()))
+	return input == "" || input == "y" || input == "yes"
+}
+
+// localCommandPath returns exeName in a form that runs the file itself: a bare name
+// gets a "./" prefix so exec doesn't look it up on PATH instead
+func localCommandPath(exeName string) string {
+	if strings.ContainsAny(exeName, `/\`) {
+		return exeName
+	}
+	return "." + string(filepath.Separator) + exeName
+}
+
+// checkYtdlpPath validates the binary given with --ytdlp-path: it must be a non-empty
+// file that answers --version. Returns the version it reports.
+func checkYtdlpPath(runner CommandRunner, path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("--ytdlp-path %s: %v", path, err)
+	}
+	if info.IsDir() || info.Size() == 0 {
+		return "", fmt.Errorf("--ytdlp-path %s is not a yt-dlp executable", path)
+	}
+	output, err := runner.Run(localCommandPath(path), "--version")
+	if err != nil {
+		return "", fmt.Errorf("--ytdlp-path %s failed to run: %v", path, err)
+	}
+	return strings.TrimSpace(output.Stdout.String()), nil
+}
+
+// binaryDownloadContext returns the context a yt-dlp.exe download runs under, cancelled
+// after config.BinaryDownloadTimeout (0 = no limit)
+func binaryDownloadContext(config *Config) (context.Context, context.CancelFunc) {
+	if config.BinaryDownloadTimeout > 0 {
+		return context.WithTimeout(context.Background(), config.BinaryDownloadTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// prepareYtdlp makes sure yt-dlp is available before the run. A --ytdlp-path binary is
+// only validated, so offline machines never contact GitHub; otherwise yt-dlp.exe is
+// downloaded or updated as usual.
+func prepareYtdlp(ctx context.Context, client *http.Client, runner CommandRunner, config *Config) error {
+	if config.YtdlpPath == "" {
+		return getOrDownloadYtdlpContext(ctx, client, config.ReleaseFile, "yt-dlp.exe")
+	}
+	version, err := checkYtdlpPath(runner, config.YtdlpPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[*] Using %s (version %s); not checking GitHub for yt-dlp updates\n", config.YtdlpPath, version)
+	return nil
+}
+
+// ytdlpCommand returns the yt-dlp binary to run: --ytdlp-path if given, otherwise the
+// yt-dlp.exe next to this program (psPrefix is `.\` under PowerShell)
+func ytdlpCommand(config *Config, psPrefix string) string {
+	if config.YtdlpPath != "" {
+		return localCommandPath(config.YtdlpPath)
+	}
+	return psPrefix + "yt-dlp.exe"
+}
+
+// repairYtdlpArchitecture test-launches exeName and, if it fails because it was built
+// for a different architecture (e.g. x64 yt-dlp.exe on ARM Windows), offers to replace
+// it with the release asset for goarch. Other launch failures are left for the actual
+// run to report. The previous copy is kept as exeName.old. The download is abandoned
+// once ctx is done (see --timeout-binary-download).
+func repairYtdlpArchitecture(ctx context.Context, runner CommandRunner, client *http.Client, releaseFile, exeName, goarch string, confirm func(assetName string) bool) error {
+	_, err := runner.Run(localCommandPath(exeName), "--version")
+	if !isBadExeFormat(err) {
+		return nil
+	}
+
+	assetName := ytdlpAssetName(goarch)
+	fmt.Printf("[!] %s could not be started: it was built for a different CPU architecture than this PC (%s).\n", exeName, goarch)
+	if !confirm(assetName) {
+		return fmt.Errorf("%s is built for the wrong architecture (download %s manually)", exeName, assetName)
+	}
+
+	if err := backupYtdlp(exeName); err != nil {
+		return fmt.Errorf("backup failed: %v", err)
+	}
+	if err := downloadYtdlpAsset(ctx, client, releaseFile, assetName, exeName); err != nil {
+		if restoreErr := os.Rename(exeName+".old", exeName); restoreErr != nil {
+			return fmt.Errorf("%w (could not restore backup: %v)", err, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// userAgents is the built-in list of realistic desktop browser User-Agents used by --rotate-user-agent
+var userAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36 Edg/131.0.0.0",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:133.0) Gecko/20100101 Firefox/133.0",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/18.1 Safari/605.1.15",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36",
+}
+
+// userAgentRotator cycles through a list of User-Agents from a random starting point,
+// so consecutive calls never return the same one. Safe for concurrent use.
+type userAgentRotator struct {
+	mu     sync.Mutex
+	agents []string
+	next   int
+}
+
+// newUserAgentRotator creates a rotator over agents (which must not be empty)
+func newUserAgentRotator(agents []string) *userAgentRotator {
+	return &userAgentRotator{agents: agents, next: rand.IntN(len(agents))}
+}
+
+// Next returns the next User-Agent. A nil rotator returns "".
+func (r *userAgentRotator) Next() string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ua := r.agents[r.next]
+	r.next = (r.next + 1) % len(r.agents)
+	return ua
+}
+
+// userAgentTransport sets a rotated User-Agent on every outgoing request
+type userAgentTransport struct {
+	rt     http.RoundTripper
+	agents *userAgentRotator
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.agents.Next())
+	return t.rt.RoundTrip(req)
+}
+
+// tracingTransport logs the DNS, connect, TLS and first-byte timings of each request
+// (--trace-http)
+type tracingTransport struct {
+	rt  http.RoundTripper
+	out io.Writer
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	logf := func(format string, args ...any) {
+		_, _ = fmt.Fprintf(t.out, "[trace] %6dms "+format+"\n", append([]any{time.Since(start).Milliseconds()}, args...)...)
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			logf("DNS lookup %s", info.Host)
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			if info.Err != nil {
+				logf("DNS failed: %v", info.Err)
+				return
+			}
+			logf("DNS resolved %d addresses", len(info.Addrs))
+		},
+		ConnectStart: func(network, addr string) {
+			logf("connecting to %s", addr)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err != nil {
+				logf("connect to %s failed: %v", addr, err)
+				return
+			}
+			logf("connected to %s", addr)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				logf("reusing connection to %s", info.Conn.RemoteAddr())
+			}
+		},
+		TLSHandshakeStart: func() {
+			logf("TLS handshake")
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err != nil {
+				logf("TLS handshake failed: %v", err)
+				return
+			}
+			logf("TLS handshake done (%s)", tls.VersionName(state.Version))
+		},
+		GotFirstResponseByte: func() {
+			logf("first response byte")
+		},
+	}
+
+	logf("%s %s", req.Method, req.URL.Redacted())
+	resp, err := t.rt.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		logf("request failed: %v", err)
+		return nil, err
+	}
+	logf("response %s", resp.Status)
+	return resp, nil
+}
+
+// newHTTPClient returns the HTTP client used for downloads (the GitHub release lookup
+// and yt-dlp.exe). With a client certificate configured it presents it on every TLS
+// connection, with --insecure-skip-verify it accepts any server certificate, with
+// --max-connections it caps connections per host, with --rotate-user-agent it rotates
+// the User-Agent per request and with --trace-http it logs each request's timings;
+// otherwise it is http.DefaultClient. The --webhook-url POST and --precheck always use
+// http.DefaultClient, so none of these options (in particular skipping certificate
+// verification) reach them.
+func newHTTPClient(config *Config) (*http.Client, error) {
+	if config.ClientCert == "" && config.ClientKey == "" && !config.InsecureSkipVerify && config.UserAgents == nil && config.MaxConnections == 0 && !config.TraceHTTP {
+		return http.DefaultClient, nil
+	}
+	if (config.ClientCert == "") != (config.ClientKey == "") {
+		return nil, fmt.Errorf("--client-cert and --client-key must be used together")
+	}
+
+	var transport http.RoundTripper = http.DefaultTransport
+	if config.ClientCert != "" || config.InsecureSkipVerify || config.MaxConnections > 0 {
+		customTransport := http.DefaultTransport.(*http.Transport).Clone()
+		if config.ClientCert != "" || config.InsecureSkipVerify {
+			tlsConfig := &tls.Config{
+				MinVersion:         tls.VersionTLS12,
+				InsecureSkipVerify: config.InsecureSkipVerify,
+			}
+			if config.ClientCert != "" {
+				cert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+				if err != nil {
+					return nil, fmt.Errorf("failed to load client certificate: %v", err)
+				}
+				tlsConfig.Certificates = []tls.Certificate{cert}
+			}
+			customTransport.TLSClientConfig = tlsConfig
+		}
+		// Extra requests wait for a free connection instead of opening another one
+		if config.MaxConnections > 0 {
+			customTransport.MaxConnsPerHost = config.MaxConnections
+			customTransport.MaxIdleConnsPerHost = config.MaxConnections
+		}
+		transport = customTransport
+	}
+	if config.UserAgents != nil {
+		transport = &userAgentTransport{rt: transport, agents: config.UserAgents}
+	}
+	if config.TraceHTTP {
+		transport = &tracingTransport{rt: transport, out: os.Stdout}
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// printInsecureWarning makes it impossible to miss that --insecure-skip-verify is on:
+// with verification off, anyone on the network path can swap the downloaded yt-dlp.exe.
+func printInsecureWarning() {
+	banner := strings.Repeat("!", 78)
+	fmt.Println(banner)
+	fmt.Println("[!!!] SECURITY WARNING: --insecure-skip-verify is set.")
+	fmt.Println("[!!!] TLS certificates are NOT verified for downloads. Anyone between you and the")
+	fmt.Println("[!!!] server could replace yt-dlp.exe with malware. Only use this with a mirror you trust.")
+	fmt.Println(banner)
+}
+
 // getOrDownloadYtdlp checks if yt-dlp.exe is present in the current directory.
 // If not, it downloads the latest version from GitHub.
 // If it exists but is older than 30 days, prompts user to update.
 // Accepts an *http.Client so we can mock the download in tests.
 func getOrDownloadYtdlp(client *http.Client, exeName string) error {
+	return getOrDownloadYtdlpContext(context.Background(), client, "", exeName)
+}
+
+// getOrDownloadYtdlpContext is getOrDownloadYtdlp with any download abandoned once
+// ctx is done (see --timeout-binary-download), reading the release info from
+// releaseFile when set
+func getOrDownloadYtdlpContext(ctx context.Context, client *http.Client, releaseFile, exeName string) error {
+	// A zero-byte file is left behind when a previous download was interrupted; it
+	// would be treated as installed and then fail to run, so fetch it again
+	if isEmptyFile(exeName) {
+		fmt.Printf("[!] %s is empty (a previous download was probably interrupted). Downloading it again...\n", exeName)
+		if err := os.Remove(exeName); err != nil {
+			return fmt.Errorf("could not remove empty %s: %v", exeName, err)
+		}
+		return downloadYtdlpAsset(ctx, client, releaseFile, exeName, exeName)
+	}
+
 	// Check if the file already exists
 	if _, err := os.Stat(exeName); err == nil {
 		// File exists - check if it's older than 30 days
@@ -330,12 +1024,12 @@ func getOrDownloadYtdlp(client *http.Client, exeName string) error {
 				}
 
 				// Download new version
-				if err := downloadLatestYtdlp(client, exeName); err != nil {
+				if err := downloadYtdlpAsset(ctx, client, releaseFile, exeName, exeName); err != nil {
 					// Download failed - try to restore backup
 					fmt.Printf("[!] Download failed: %v\n", err)
 					fmt.Printf("[*] Attempting to restore backup...\n")
 					if restoreErr := os.Rename(exeName+".old", exeName); restoreErr != nil {
-						return fmt.Errorf("download failed and could not restore backup: %v (restore error: %v)", err, restoreErr)
+						return fmt.Errorf("%w (could not restore backup: %v)", err, restoreErr)
 					}
 					fmt.Printf("[*] Backup restored. Continuing with existing version.\n")
 					return nil
@@ -353,1292 +1047,7506 @@ func getOrDownloadYtdlp(client *http.Client, exeName string) error {
 
 	// File doesn't exist - download it
 	fmt.Printf("[*] %s not found. Downloading the latest release from GitHub...\n", exeName)
-	return downloadLatestYtdlp(client, exeName)
+	return downloadYtdlpAsset(ctx, client, releaseFile, exeName, exeName)
 }
 
-// parseFavoriteVideosFromFile reads the given JSON file and returns the list of video entries.
-func parseFavoriteVideosFromFile(jsonFile string, includeLiked bool) ([]VideoEntry, error) {
-	file, err := os.Open(filepath.Clean(jsonFile))
+// isEmptyFile reports whether path is an existing regular file with no content
+func isEmptyFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Size() == 0
+}
+
+// ytdlpReleasesURL is where yt-dlp.exe can be downloaded by hand when the automatic download fails
+const ytdlpReleasesURL = "https://github.com/yt-dlp/yt-dlp/releases/latest"
+
+// printManualYtdlpInstructions explains how to install yt-dlp by hand after the
+// automatic download from GitHub failed
+func printManualYtdlpInstructions(out io.Writer, exeName, goarch string) {
+	dir, err := filepath.Abs(filepath.Dir(exeName))
 	if err != nil {
-		return nil, fmt.Errorf("error opening JSON file: %v", err)
+		dir = filepath.Dir(exeName)
 	}
-	defer func() { _ = file.Close() }()
+	assetName := ytdlpAssetName(goarch)
+	_, _ = fmt.Fprintln(out, "[!] yt-dlp could not be downloaded automatically. To install it manually:")
+	_, _ = fmt.Fprintf(out, "    1. Download %s from %s/download/%s\n", assetName, ytdlpReleasesURL, assetName)
+	_, _ = fmt.Fprintf(out, "       (or pick it from %s)\n", ytdlpReleasesURL)
+	_, _ = fmt.Fprintf(out, "    2. Save it as %s in %s\n", filepath.Base(exeName), dir)
+}
 
-	var data Data
-	if err := json.NewDecoder(file).Decode(&data); err != nil {
-		return nil, fmt.Errorf("error parsing JSON: %v", err)
+// waitForManualYtdlp re-checks for exeName each time the user presses Enter, until it
+// shows up or the user types "skip". Returns whether exeName is now present.
+func waitForManualYtdlp(exeName string, in io.Reader, out io.Writer) bool {
+	scanner := bufio.NewScanner(in)
+	for {
+		_, _ = fmt.Fprintf(out, "[*] Press Enter once %s is in place, or type 'skip' to continue without it: ", filepath.Base(exeName))
+		if !scanner.Scan() {
+			_, _ = fmt.Fprintln(out)
+			break
+		}
+		input := strings.TrimSpace(strings.ToLower(scanner.Text()))
+		if input == "s" || input == "skip" {
+			break
+		}
+		if _, err := os.Stat(exeName); err == nil {
+			_, _ = fmt.Fprintf(out, "[*] Found %s, continuing.\n", filepath.Base(exeName))
+			return true
+		}
+		_, _ = fmt.Fprintf(out, "[!] %s still not found.\n", exeName)
 	}
+	_, err := os.Stat(exeName)
+	return err == nil
+}
 
-	videoEntries := make([]VideoEntry, 0)
-
-	// Always add favorited videos
-	for _, item := range data.Activity.FavoriteVideos.FavoriteVideoList {
-		videoEntries = append(videoEntries, VideoEntry{
-			Link:       item.Link,
-			Date:       item.Date,
-			Collection: "favorites",
-		})
+// selfUpdateReleaseURL is the GitHub API endpoint for this tool's latest release
+const selfUpdateReleaseURL = "https://api.github.com/repos/ozskywalker/tiktok-favvideo-downloader/releases/latest"
+
+// parseVersion splits a "v1.2.3" style version into its numeric components.
+// Pre-release and build suffixes (e.g. "-rc1", "+meta") are ignored.
+func parseVersion(v string) ([3]int, bool) {
+	var parts [3]int
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	fields := strings.Split(v, ".")
+	if len(fields) > 3 {
+		return parts, false
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return parts, false
+		}
+		parts[i] = n
 	}
+	return parts, true
+}
 
-	// Add liked videos if the user requested them
-	if includeLiked {
-		for _, item := range data.Activity.LikedVideos.ItemFavoriteList {
-			videoEntries = append(videoEntries, VideoEntry{
-				Link:       item.Link,
-				Date:       item.Date,
-				Collection: "liked",
-			})
+// compareVersions compares two versions, returning -1 if a < b, 0 if equal and 1 if a > b
+func compareVersions(a, b string) (int, error) {
+	va, ok := parseVersion(a)
+	if !ok {
+		return 0, fmt.Errorf("invalid version: %q", a)
+	}
+	vb, ok := parseVersion(b)
+	if !ok {
+		return 0, fmt.Errorf("invalid version: %q", b)
+	}
+	for i := range va {
+		if va[i] < vb[i] {
+			return -1, nil
+		}
+		if va[i] > vb[i] {
+			return 1, nil
 		}
 	}
+	return 0, nil
+}
 
-	return videoEntries, nil
+// selectSelfUpdateAsset picks the release binary matching the given platform.
+// Release binaries are only published for Windows (x86-64 and ARM64).
+func selectSelfUpdateAsset(assets []GitHubAsset, goos, goarch string) (*GitHubAsset, error) {
+	var want string
+	switch {
+	case goos == "windows" && goarch == "amd64":
+		want = "tiktok-favvideo-downloader-x86_64.exe"
+	case goos == "windows" && goarch == "arm64":
+		want = "tiktok-favvideo-downloader-ARM64.exe"
+	default:
+		return nil, fmt.Errorf("%w: no release binary is published for %s/%s", ErrNoAsset, goos, goarch)
+	}
+
+	for i := range assets {
+		if strings.EqualFold(assets[i].Name, want) {
+			return &assets[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: could not find %s in the latest release", ErrNoAsset, want)
 }
 
-// sanitizeCollectionName sanitizes collection names for use as directory names
-func sanitizeCollectionName(name string) string {
-	// Replace invalid characters with underscores
-	invalid := []string{"<", ">", ":", "\"", "/", "\\", "|", "?", "*"}
-	for _, char := range invalid {
-		name = strings.ReplaceAll(name, char, "_")
+// verifyAssetDigest checks that sum (a hex-encoded SHA-256) matches the digest
+// GitHub publishes for a release asset ("sha256:<hex>")
+func verifyAssetDigest(sum, digest string) error {
+	if digest == "" {
+		return fmt.Errorf("release asset has no published checksum, refusing to install it")
 	}
-	// Trim spaces and dots
-	name = strings.Trim(name, " .")
-	if name == "" {
-		name = "unknown"
+	algo, expected, found := strings.Cut(digest, ":")
+	if !found || !strings.EqualFold(algo, "sha256") {
+		return fmt.Errorf("unsupported checksum format: %s", digest)
 	}
-	return name
+	if !strings.EqualFold(sum, expected) {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", expected, sum)
+	}
+	return nil
 }
 
-// extractVideoID extracts the video ID from a TikTok URL.
-// Supports various TikTok URL formats:
-//   - https://www.tiktokv.com/share/video/7600559584901647646/
-//   - https://www.tiktok.com/@user/video/7600559584901647646
-//   - https://m.tiktok.com/v/7600559584901647646.html
-func extractVideoID(url string) string {
-	for _, re := range videoIDPatterns {
-		if matches := re.FindStringSubmatch(url); len(matches) > 1 {
-			return matches[1]
+// replaceExecutable swaps newPath into exePath, keeping the previous binary as exePath.old.
+// Renaming (rather than overwriting) works even while the executable is running on Windows.
+func replaceExecutable(exePath, newPath string) error {
+	oldPath := exePath + ".old"
+	if _, err := os.Stat(oldPath); err == nil {
+		if err := os.Remove(oldPath); err != nil {
+			return fmt.Errorf("failed to delete existing %s: %v", oldPath, err)
 		}
 	}
-	return ""
+	if err := os.Rename(exePath, oldPath); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %v", exePath, oldPath, err)
+	}
+	if err := os.Rename(newPath, exePath); err != nil {
+		// Put the original binary back so the tool keeps working
+		_ = os.Rename(oldPath, exePath)
+		return fmt.Errorf("failed to install new version: %v", err)
+	}
+	return nil
 }
 
-// parseArchiveFile reads yt-dlp's download archive file and returns
-// a set of video IDs that have been successfully downloaded.
-// Archive format: "tiktok <video_id>" per line
-// Returns empty map (not error) if file doesn't exist - this is normal for first run.
-func parseArchiveFile(archivePath string) (map[string]bool, error) {
-	// Check if archive exists
-	if _, err := os.Stat(archivePath); os.IsNotExist(err) {
-		return make(map[string]bool), nil // Empty archive, not an error
-	}
+// selfUpdate checks this tool's latest GitHub release and, if it is newer than
+// currentVersion, downloads the binary for the given platform, verifies its
+// checksum and replaces exePath with it.
+func selfUpdate(client *http.Client, exePath, currentVersion, goos, goarch string) error {
+	fmt.Println("[*] Checking for a newer version of this tool...")
 
-	file, err := os.Open(archivePath)
+	resp, err := client.Get(selfUpdateReleaseURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open archive file %s: %v", archivePath, err)
+		return fmt.Errorf("%w: could not fetch the latest release info: %w", ErrDownload, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: unexpected response from GitHub API: %s", ErrDownload, resp.Status)
 	}
-	defer func() {
-		if closeErr := file.Close(); closeErr != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to close archive file: %v\n", closeErr)
-		}
-	}()
-
-	archive := make(map[string]bool)
-	scanner := bufio.NewScanner(file)
-	lineNum := 0
 
-	for scanner.Scan() {
-		lineNum++
-		line := strings.TrimSpace(scanner.Text())
+	var release GitHubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return fmt.Errorf("%w from GitHub API: %w", ErrJSONParse, err)
+	}
 
-		// Skip empty lines
-		if line == "" {
-			continue
-		}
+	cmp, err := compareVersions(currentVersion, release.TagName)
+	if err != nil {
+		return fmt.Errorf("cannot compare current version %q with latest release %q: %v", currentVersion, release.TagName, err)
+	}
+	if cmp >= 0 {
+		fmt.Printf("[*] Already up to date (version %s)\n", currentVersion)
+		return nil
+	}
 
-		// Parse "tiktok <video_id>" format
-		parts := strings.Fields(line)
-		if len(parts) != 2 {
-			fmt.Printf("[!] Warning: Malformed archive line %d in %s: %s\n",
-				lineNum, archivePath, line)
-			continue
-		}
+	asset, err := selectSelfUpdateAsset(release.Assets, goos, goarch)
+	if err != nil {
+		return err
+	}
 
-		if parts[0] != "tiktok" {
-			fmt.Printf("[!] Warning: Unknown platform %s at line %d in %s\n",
-				parts[0], lineNum, archivePath)
-			continue
-		}
+	fmt.Printf("[*] Updating %s -> %s\n", currentVersion, release.TagName)
+	fmt.Printf("[*] Downloading %s...\n", asset.BrowserDownloadURL)
 
-		videoID := parts[1]
+	downloadResp, err := client.Get(asset.BrowserDownloadURL)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrDownload, asset.Name, err)
+	}
+	defer func() { _ = downloadResp.Body.Close() }()
+	if downloadResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s: %s", ErrDownload, asset.Name, downloadResp.Status)
+	}
 
-		// Basic validation: video ID should be numeric
-		if _, err := strconv.ParseInt(videoID, 10, 64); err != nil {
-			fmt.Printf("[!] Warning: Invalid video ID %s at line %d in %s\n",
-				videoID, lineNum, archivePath)
-			continue
-		}
+	// Write next to the executable so the final rename stays on the same volume
+	tmp, err := os.CreateTemp(filepath.Dir(exePath), ".self-update-*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %v", err)
+	}
+	tmpPath := tmp.Name()
+	defer func() { _ = os.Remove(tmpPath) }() // No-op once renamed into place
 
-		archive[videoID] = true
+	hasher := sha256.New()
+	_, copyErr := io.Copy(io.MultiWriter(tmp, hasher), downloadResp.Body)
+	closeErr := tmp.Close()
+	if copyErr != nil {
+		return fmt.Errorf("%w: could not write %s to disk: %w", ErrDownload, asset.Name, copyErr)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("%w: could not write %s to disk: %w", ErrDownload, asset.Name, closeErr)
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading archive file %s: %v", archivePath, err)
+	if err := verifyAssetDigest(hex.EncodeToString(hasher.Sum(nil)), asset.Digest); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0755); err != nil {
+		return fmt.Errorf("failed to mark new version executable: %v", err)
+	}
+	if err := replaceExecutable(exePath, tmpPath); err != nil {
+		return err
 	}
 
-	return archive, nil
+	fmt.Printf("[*] Successfully updated to %s. The previous version was kept as %s.old\n", release.TagName, filepath.Base(exePath))
+	return nil
 }
 
-// shouldSkipCollection determines if all videos in a collection are already
-// downloaded by checking the archive file. Returns true only if 100% of videos
-// are in the archive.
-//
-// Returns:
-//   - bool: true if yt-dlp can be skipped (all videos downloaded)
-//   - string: informational message for user
-//   - error: error parsing archive (caller should fall back to calling yt-dlp)
-func shouldSkipCollection(entries []VideoEntry, archivePath string) (bool, string, error) {
-	// Empty collection - nothing to download
-	if len(entries) == 0 {
-		return true, "Empty collection", nil
+// parseFavoriteVideosFromFile reads the given JSON file and returns the list of video entries.
+// A nil schema reads the built-in layout.
+func parseFavoriteVideosFromFile(jsonFile string, includeLiked bool, schema *SchemaMap) ([]VideoEntry, error) {
+	file, err := os.Open(filepath.Clean(jsonFile))
+	if err != nil {
+		return nil, fmt.Errorf("error opening JSON file: %v", err)
 	}
+	defer func() { _ = file.Close() }()
 
-	// Parse archive file
-	archive, err := parseArchiveFile(archivePath)
+	return parseFavoriteVideos(file, includeLiked, schema)
+}
+
+// parseFavoriteVideos reads a TikTok export from r and returns its favorited
+// (and, if requested, liked) videos, reading the lists at schema (nil = built-in layout)
+func parseFavoriteVideos(r io.Reader, includeLiked bool, schema *SchemaMap) ([]VideoEntry, error) {
+	content, err := io.ReadAll(r)
 	if err != nil {
-		// Error parsing archive - be conservative, call yt-dlp
-		return false, "", err
+		return nil, fmt.Errorf("error reading export: %v", err)
+	}
+	if isHTMLExport(content) {
+		return parseFavoriteVideosHTML(bytes.NewReader(content), includeLiked)
+	}
+	if schema != nil {
+		return parseFavoriteVideosWithSchema(bytes.NewReader(content), includeLiked, schema)
 	}
 
-	// Empty archive - need to download everything
-	if len(archive) == 0 {
-		msg := fmt.Sprintf("No videos in archive, %d videos need download", len(entries))
-		return false, msg, nil
+	var data Data
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrJSONParse, err)
 	}
 
-	// Extract video IDs from all entries and check against archive
-	var missingIDs []string
-	for _, entry := range entries {
-		videoID := extractVideoID(entry.Link)
+	videoEntries := make([]VideoEntry, 0)
 
-		// If we can't extract video ID, be conservative - don't skip
-		if videoID == "" {
-			msg := fmt.Sprintf("Could not parse video ID from URL: %s", entry.Link)
-			return false, msg, nil
+	// Whichever of the current and the older layout the export uses
+	sections := []likesAndFavorites{data.Activity, data.LegacyActivity}
+
+	// Always add favorited videos
+	for _, section := range sections {
+		for _, item := range section.FavoriteVideos.FavoriteVideoList {
+			for _, link := range item.Link.values() {
+				videoEntries = append(videoEntries, VideoEntry{
+					Link:       link,
+					Date:       item.Date,
+					Collection: "favorites",
+				})
+			}
 		}
+	}
 
-		// Check if video is in archive
-		if !archive[videoID] {
-			missingIDs = append(missingIDs, videoID)
+	// Saved videos from the Profile section of newer exports are favorites too
+	for _, item := range data.Profile.SavedVideos.SavedVideoList {
+		for _, link := range item.Link.values() {
+			videoEntries = append(videoEntries, VideoEntry{
+				Link:       link,
+				Date:       item.Date,
+				Collection: "saved",
+			})
 		}
 	}
 
-	// All videos in archive - safe to skip
-	if len(missingIDs) == 0 {
-		msg := fmt.Sprintf("All %d videos already downloaded", len(entries))
-		return true, msg, nil
+	// Add liked videos if the user requested them
+	if includeLiked {
+		for _, section := range sections {
+			for _, item := range section.LikedVideos.ItemFavoriteList {
+				for _, link := range item.Link.values() {
+					videoEntries = append(videoEntries, VideoEntry{
+						Link:       link,
+						Date:       item.Date,
+						Collection: "liked",
+					})
+				}
+			}
+		}
 	}
 
-	// Partial match - need to call yt-dlp
-	msg := fmt.Sprintf("%d new videos need download (out of %d total)",
-		len(missingIDs), len(entries))
-	return false, msg, nil
+	return videoEntries, nil
 }
 
-// parseInfoJSON reads a yt-dlp .info.json file and extracts metadata
-func parseInfoJSON(infoPath string) (*YtdlpInfo, error) {
-	data, err := os.ReadFile(infoPath)
+// parseBrowsingHistory reads a TikTok JSON export from r and returns the videos in its
+// browsing history, with the watch timestamp in WatchedAt
+func parseBrowsingHistory(r io.Reader) ([]VideoEntry, error) {
+	content, err := io.ReadAll(r)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error reading export: %v", err)
 	}
-	var info YtdlpInfo
-	if err := json.Unmarshal(data, &info); err != nil {
-		return nil, err
+	if isHTMLExport(content) {
+		return nil, fmt.Errorf("browsing history can only be read from the JSON export")
 	}
-	return &info, nil
-}
 
-// getOutputFilename returns the appropriate URL list filename for a collection
-func getOutputFilename(collection string) string {
-	if collection == "liked" {
-		return "liked_videos.txt"
+	var data Data
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrJSONParse, err)
 	}
-	return "fav_videos.txt"
+
+	videoEntries := make([]VideoEntry, 0)
+	for _, item := range data.YourActivity.BrowsingHistory.VideoList {
+		for _, link := range item.Link.values() {
+			videoEntries = append(videoEntries, VideoEntry{
+				Link:       link,
+				Collection: "history",
+				WatchedAt:  item.Date,
+			})
+		}
+	}
+	return videoEntries, nil
 }
 
-// createCollectionDirectories creates directories for each collection
-func createCollectionDirectories(videoEntries []VideoEntry, organizeByCollection bool) error {
-	if !organizeByCollection {
-		return nil
+// loadExportEntries parses the export named in config, adding the browsing history
+// after the favorites (and liked videos) when --include-history is set
+func loadExportEntries(config *Config, includeLiked bool) ([]VideoEntry, error) {
+	entries, err := parseFavoriteVideosFromFile(config.JSONFile, includeLiked, config.ExportSchema)
+	if err != nil || !config.IncludeHistory {
+		return entries, err
 	}
 
-	collections := make(map[string]bool)
-	for _, entry := range videoEntries {
-		collections[sanitizeCollectionName(entry.Collection)] = true
+	file, err := os.Open(filepath.Clean(config.JSONFile))
+	if err != nil {
+		return nil, fmt.Errorf("error opening JSON file: %v", err)
+	}
+	defer func() { _ = file.Close() }()
+	history, err := parseBrowsingHistory(file)
+	if err != nil {
+		return nil, err
 	}
+	return append(entries, history...), nil
+}
 
-	for collection := range collections {
-		if err := os.MkdirAll(collection, 0755); err != nil {
-			return fmt.Errorf("[!!!] Error creating directory %s: %v", collection, err)
-		}
+// isHTMLExport reports whether an export is TikTok's HTML format rather than JSON,
+// judged by content since the file may have been renamed
+func isHTMLExport(content []byte) bool {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(content, []byte("\ufeff")))
+	if len(trimmed) == 0 || trimmed[0] != '<' {
+		return false
 	}
-	return nil
+	head := bytes.ToLower(trimmed[:min(len(trimmed), 512)])
+	return bytes.Contains(head, []byte("<!doctype html")) || bytes.Contains(head, []byte("<html"))
 }
 
-// writeFavoriteVideosToFile writes the video entries to output files, organized by collection if enabled.
-func writeFavoriteVideosToFile(videoEntries []VideoEntry, outputName string, organizeByCollection bool) error {
-	if organizeByCollection {
-		// Create collection directories first
-		if err := createCollectionDirectories(videoEntries, true); err != nil {
-			return err
-		}
+// htmlExportSection maps a heading in the HTML export to the collection its videos
+// belong to ("" for sections that aren't favorites or likes)
+func htmlExportSection(heading string) string {
+	lower := strings.ToLower(heading)
+	switch {
+	case strings.Contains(lower, "favorite video"):
+		return "favorites"
+	case strings.Contains(lower, "like list"), strings.Contains(lower, "liked video"):
+		return "liked"
+	default:
+		return ""
+	}
+}
 
-		// Group entries by collection
-		collectionGroups := make(map[string][]VideoEntry)
-		for _, entry := range videoEntries {
-			collection := sanitizeCollectionName(entry.Collection)
-			collectionGroups[collection] = append(collectionGroups[collection], entry)
-		}
+// parseFavoriteVideosHTML scrapes favorited (and, if requested, liked) videos from
+// TikTok's HTML export. Each section starts with a heading; within it every video
+// is an anchor to its URL, preceded by the date it was saved.
+func parseFavoriteVideosHTML(r io.Reader, includeLiked bool) ([]VideoEntry, error) {
+	videoEntries := make([]VideoEntry, 0)
+	tokenizer := html.NewTokenizer(r)
+
+	var section, date string
+	var heading *strings.Builder
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return nil, fmt.Errorf("error parsing HTML export: %v", err)
+			}
+			return videoEntries, nil
+
+		case html.StartTagToken:
+			token := tokenizer.Token()
+			switch token.DataAtom {
+			case atom.H1, atom.H2, atom.H3, atom.H4:
+				heading = &strings.Builder{}
+			case atom.A:
+				if section == "" || (section == "liked" && !includeLiked) {
+					continue
+				}
+				for _, attr := range token.Attr {
+					if attr.Key == "href" && extractVideoID(attr.Val) != "" {
+						videoEntries = append(videoEntries, VideoEntry{
+							Link:       attr.Val,
+							Date:       date,
+							Collection: section,
+						})
+						date = ""
+					}
+				}
+			}
 
-		// Write separate files for each collection with collection-specific filenames
-		for collection, entries := range collectionGroups {
-			// Use collection-specific filename (fav_videos.txt for favorites, liked_videos.txt for liked)
-			collectionFilename := getOutputFilename(collection)
-			collectionOutputName := filepath.Join(collection, collectionFilename)
-			if err := writeVideoEntriesToFile(entries, collectionOutputName); err != nil {
-				return err
+		case html.EndTagToken:
+			switch tokenizer.Token().DataAtom {
+			case atom.H1, atom.H2, atom.H3, atom.H4:
+				if heading != nil {
+					section = htmlExportSection(heading.String())
+					heading = nil
+				}
+			}
+
+		case html.TextToken:
+			text := string(tokenizer.Text())
+			if heading != nil {
+				heading.WriteString(text)
+			} else if match := htmlExportDatePattern.FindString(text); match != "" {
+				date = match
 			}
-			fmt.Printf("[*] Extracted %d video URLs to '%s'\n", len(entries), collectionOutputName)
 		}
-	} else {
-		// Write all entries to a single file (flat structure)
-		return writeVideoEntriesToFile(videoEntries, outputName)
 	}
-	return nil
 }
 
-// writeVideoEntriesToFile writes video entries to a single file
-func writeVideoEntriesToFile(videoEntries []VideoEntry, outputName string) error {
-	outFile, err := os.Create(outputName)
-	if err != nil {
-		return fmt.Errorf("[!!!] Error creating %s: %v", outputName, err)
+// SchemaMap overrides where the parser looks for videos in the export (--schema-map),
+// so a renamed key in a future TikTok export can be worked around without a new
+// release. List paths are dot-separated keys from the root of the export, with
+// ["quoted key"] and [index] steps where a dot won't do; empty fields keep the
+// built-in layout.
+type SchemaMap struct {
+	FavoritesList string `json:"favorites_list"` // e.g. "Likes and Favorites.Favorite Videos.FavoriteVideoList"
+	LikedList     string `json:"liked_list"`     // e.g. "Likes and Favorites.Like List.ItemFavoriteList"
+	LinkField     string `json:"link_field"`     // Key of the video URL in each list item (default "Link")
+	DateField     string `json:"date_field"`     // Key of the favorited/liked date in each list item (default "Date")
+}
+
+// Built-in export layout, used for any SchemaMap field left empty
+const (
+	defaultFavoritesListPath = "Likes and Favorites.Favorite Videos.FavoriteVideoList"
+	defaultLikedListPath     = "Likes and Favorites.Like List.ItemFavoriteList"
+	defaultLinkField         = "Link"
+	defaultDateField         = "Date"
+)
+
+// Lists of the older export layout, which keeps them under "Activity"
+const (
+	legacyFavoritesListPath = "Activity.Favorite Videos.FavoriteVideoList"
+	legacyLikedListPath     = "Activity.Like List.ItemFavoriteList"
+)
+
+// fillDefaults sets every empty field of s to the built-in layout
+func (s *SchemaMap) fillDefaults() {
+	if s.FavoritesList == "" {
+		s.FavoritesList = defaultFavoritesListPath
 	}
-	defer func() { _ = outFile.Close() }()
+	if s.LikedList == "" {
+		s.LikedList = defaultLikedListPath
+	}
+	if s.LinkField == "" {
+		s.LinkField = defaultLinkField
+	}
+	if s.DateField == "" {
+		s.DateField = defaultDateField
+	}
+}
 
-	for _, entry := range videoEntries {
-		_, writeErr := outFile.WriteString(entry.Link + "\n")
-		if writeErr != nil {
-			return fmt.Errorf("[!!!] Error writing to %s: %v", outputName, writeErr)
+// loadSchemaMap reads a --schema-map file, filling empty fields with the built-in layout
+func loadSchemaMap(path string) (*SchemaMap, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema map: %v", err)
+	}
+	schema := &SchemaMap{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(schema); err != nil {
+		return nil, fmt.Errorf("%w in schema map %s: %w", ErrJSONParse, path, err)
+	}
+	for _, listPath := range []string{schema.FavoritesList, schema.LikedList} {
+		if listPath == "" {
+			continue
+		}
+		if _, err := splitJSONPath(listPath); err != nil {
+			return nil, fmt.Errorf("invalid list path in schema map %s: %v", path, err)
 		}
 	}
-	return nil
-}
 
-// isRunningInPowershell does a simple check to see if we're (likely) in PowerShell.
-func isRunningInPowershell() bool {
-	// A common environment variable set by PowerShell is PSModulePath,
-	// often containing 'PowerShell' in its path. This is a heuristic.
-	return strings.Contains(os.Getenv("PSModulePath"), "PowerShell")
+	schema.fillDefaults()
+	return schema, nil
 }
 
-// CommandRunner interface for testing command execution
-type CommandRunner interface {
-	Run(name string, args ...string) (CapturedOutput, error)
+// applyFavoritesPath returns schema with its favorites list pointed at path (--favorites-path),
+// starting from the built-in layout when schema is nil (no --schema-map)
+func applyFavoritesPath(schema *SchemaMap, path string) (*SchemaMap, error) {
+	if _, err := splitJSONPath(path); err != nil {
+		return nil, fmt.Errorf("invalid --favorites-path: %v", err)
+	}
+	if schema == nil {
+		schema = &SchemaMap{}
+		schema.fillDefaults()
+	}
+	schema.FavoritesList = path
+	return schema, nil
 }
 
-// RealCommandRunner implements CommandRunner using exec.Command
-type RealCommandRunner struct {
-	ProgressRenderer *ProgressRenderer // Optional: if set, renders progress bar
-	ProgressState    *ProgressState    // Optional: if set, tracks progress
+// jsonPathStep is one step of a list path: an object key, or an array index when isIndex is set
+type jsonPathStep struct {
+	key     string
+	index   int
+	isIndex bool
 }
 
-func (r *RealCommandRunner) Run(name string, args ...string) (CapturedOutput, error) {
-	cmd := exec.Command(name, args...)
-
-	var stdoutBuf, stderrBuf bytes.Buffer
-
-	// Get stdout and stderr pipes for line-by-line reading
+// splitJSONPath parses a list path such as
+// `Activity["Favorite Videos"].FavoriteVideoList` into its steps. Plain keys are
+// separated by dots and may contain spaces; a key containing a dot or bracket is
+// written as ["key"] (or ['key']), and [N] selects element N of an array.
+func splitJSONPath(path string) ([]jsonPathStep, error) {
+	var steps []jsonPathStep
+	for i := 0; i < len(path); {
+		if path[i] != '[' {
+			end := i + strings.IndexAny(path[i:]+".", ".[")
+			if end == i {
+				return nil, fmt.Errorf("empty key in path %q", path)
+			}
+			steps = append(steps, jsonPathStep{key: path[i:end]})
+			i = end
+		} else {
+			step, end, err := parseJSONPathBracket(path, i)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
+			i = end
+			if i < len(path) && path[i] != '.' && path[i] != '[' {
+				return nil, fmt.Errorf("expected . or [ after ] in path %q", path)
+			}
+		}
+
+		if i < len(path) && path[i] == '.' {
+			i++
+			if i == len(path) {
+				return nil, fmt.Errorf("empty key in path %q", path)
+			}
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+	return steps, nil
+}
+
+// parseJSONPathBracket parses the ["key"], ['key'] or [N] step starting at path[start],
+// returning the step and the position just after its closing bracket
+func parseJSONPathBracket(path string, start int) (jsonPathStep, int, error) {
+	rest := path[start+1:]
+	if strings.HasPrefix(rest, `"`) || strings.HasPrefix(rest, "'") {
+		closing := rest[:1] + "]"
+		end := strings.Index(rest[1:], closing)
+		if end < 0 {
+			return jsonPathStep{}, 0, fmt.Errorf("unclosed quoted key in path %q", path)
+		}
+		return jsonPathStep{key: rest[1 : 1+end]}, start + 1 + 1 + end + len(closing), nil
+	}
+
+	end := strings.IndexByte(rest, ']')
+	if end < 0 {
+		return jsonPathStep{}, 0, fmt.Errorf("unclosed [ in path %q", path)
+	}
+	index, err := strconv.Atoi(rest[:end])
+	if err != nil || index < 0 {
+		return jsonPathStep{}, 0, fmt.Errorf("invalid index [%s] in path %q", rest[:end], path)
+	}
+	return jsonPathStep{index: index, isIndex: true}, start + 1 + end + 1, nil
+}
+
+// lookupJSONPath follows a list path (see splitJSONPath) from root. Keys fall back to a
+// case-insensitive match, like the built-in layout's struct tags, since exports have
+// turned up with keys such as "favorite videos". A missing key or index returns nil
+// without an error, like an export that has no videos of that kind.
+func lookupJSONPath(root json.RawMessage, path string) (json.RawMessage, error) {
+	steps, err := splitJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	current := root
+	for _, step := range steps {
+		if step.isIndex {
+			var array []json.RawMessage
+			if err := json.Unmarshal(current, &array); err != nil {
+				return nil, fmt.Errorf("[%d] is not an array index on the way to %q", step.index, path)
+			}
+			if step.index >= len(array) {
+				return nil, nil
+			}
+			current = array[step.index]
+			continue
+		}
+
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(current, &object); err != nil {
+			return nil, fmt.Errorf("%q is not an object on the way to %q", step.key, path)
+		}
+		next, ok := object[step.key]
+		if !ok {
+			if next, ok = lookupFoldedKey(object, step.key); !ok {
+				return nil, nil
+			}
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// lookupFoldedKey returns the value of the key in object that matches key ignoring
+// case. If several do, the first in sorted order wins so the result doesn't depend on
+// map iteration.
+func lookupFoldedKey(object map[string]json.RawMessage, key string) (json.RawMessage, bool) {
+	match, found := "", false
+	for k := range object {
+		if strings.EqualFold(k, key) && (!found || k < match) {
+			match, found = k, true
+		}
+	}
+	return object[match], found
+}
+
+// schemaField returns item[key] as a string, falling back to a case-insensitive
+// match since the export itself mixes "Link" and "link"
+func schemaField(item map[string]any, key string) string {
+	value, ok := item[key]
+	if !ok {
+		for k, v := range item {
+			if strings.EqualFold(k, key) {
+				value = v
+				break
+			}
+		}
+	}
+	text, _ := value.(string)
+	return text
+}
+
+// parseFavoriteVideosWithSchema is parseFavoriteVideos for an export whose layout
+// is described by schema instead of the built-in Data struct
+func parseFavoriteVideosWithSchema(r io.Reader, includeLiked bool, schema *SchemaMap) ([]VideoEntry, error) {
+	var root json.RawMessage
+	if err := json.NewDecoder(r).Decode(&root); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrJSONParse, err)
+	}
+
+	lists := []struct{ path, collection string }{{schema.FavoritesList, "favorites"}}
+	if includeLiked {
+		lists = append(lists, struct{ path, collection string }{schema.LikedList, "liked"})
+	}
+
+	videoEntries := make([]VideoEntry, 0)
+	for _, list := range lists {
+		raw, err := lookupJSONPath(root, list.path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrJSONParse, err)
+		}
+		if raw == nil {
+			continue
+		}
+
+		var items exportList[map[string]any]
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, fmt.Errorf("%w: %q is not a list of videos: %w", ErrJSONParse, list.path, err)
+		}
+		for _, item := range items {
+			videoEntries = append(videoEntries, VideoEntry{
+				Link:       schemaField(item, schema.LinkField),
+				Date:       schemaField(item, schema.DateField),
+				Collection: list.collection,
+			})
+		}
+	}
+
+	return videoEntries, nil
+}
+
+// allDataSections are the top-level sections an "All available data" export always
+// contains. A "Custom" export only has the categories the user ticked when requesting it.
+var allDataSections = []string{"Profile", "Video", "Comment", "Direct Message", "Likes and Favorites"}
+
+// ExportSections describes which parts of a TikTok export are present
+type ExportSections struct {
+	Custom       bool // At least one "All available data" section is missing
+	HasFavorites bool // Favorites list present
+	HasSaved     bool // Profile saved videos present (newer exports)
+	HasLiked     bool // Liked list present
+	HasHistory   bool // Browsing history present (downloaded with --include-history)
+}
+
+// inspectExport detects whether jsonFile is an "All available data" or "Custom" export
+// and which video sources it carries, looking the lists up at schema (nil = built-in layout)
+func inspectExport(jsonFile string, schema *SchemaMap) (ExportSections, error) {
+	var sections ExportSections
+
+	content, err := os.ReadFile(filepath.Clean(jsonFile))
+	if err != nil {
+		return sections, fmt.Errorf("error opening JSON file: %v", err)
+	}
+
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(content, &top); err != nil {
+		return sections, fmt.Errorf("%w: %w", ErrJSONParse, err)
+	}
+	for _, name := range allDataSections {
+		if _, ok := top[name]; !ok {
+			// Older exports name the "Likes and Favorites" section "Activity"
+			if _, legacy := top["Activity"]; name == "Likes and Favorites" && legacy {
+				continue
+			}
+			sections.Custom = true
+			break
+		}
+	}
+
+	// Without a schema map the lists may be in either the current or the older layout
+	favoritesPaths := []string{defaultFavoritesListPath, legacyFavoritesListPath}
+	likedPaths := []string{defaultLikedListPath, legacyLikedListPath}
+	if schema != nil {
+		favoritesPaths, likedPaths = []string{schema.FavoritesList}, []string{schema.LikedList}
+	}
+	// A malformed section is reported by parseFavoriteVideosFromFile
+	saved, _ := lookupJSONPath(content, "Profile.Saved Videos.SavedVideoList")
+	history, _ := lookupJSONPath(content, "Your Activity.Video Browsing History.VideoList")
+	sections.HasFavorites = anyJSONPathExists(content, favoritesPaths)
+	sections.HasSaved = saved != nil
+	sections.HasLiked = anyJSONPathExists(content, likedPaths)
+	sections.HasHistory = history != nil
+
+	return sections, nil
+}
+
+// anyJSONPathExists reports whether any of the list paths is present in content
+func anyJSONPathExists(content []byte, paths []string) bool {
+	for _, path := range paths {
+		if value, _ := lookupJSONPath(content, path); value != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// kind returns the export type as TikTok labels it in the download dialog
+func (s ExportSections) kind() string {
+	if s.Custom {
+		return "Custom"
+	}
+	return "All available data"
+}
+
+// favorites reports whether the export has favorited videos in either layout
+func (s ExportSections) favorites() bool {
+	return s.HasFavorites || s.HasSaved
+}
+
+// notice explains how missing sections affect the run, or returns "" when the export
+// has both favorites and liked videos
+func (s ExportSections) notice() string {
+	switch {
+	case !s.favorites() && !s.HasLiked:
+		return fmt.Sprintf("This %s export has neither a Favorite Videos nor a Like List section. Request a new export with \"All available data\" or tick \"Likes and Favorites\".", s.kind())
+	case !s.favorites():
+		return fmt.Sprintf("This %s export has no Favorite Videos section; only liked videos can be downloaded. Request a new export with \"All available data\" to get your favorites.", s.kind())
+	case !s.HasLiked:
+		return fmt.Sprintf("This %s export has no Like List section; only favorited videos will be downloaded.", s.kind())
+	}
+	return ""
+}
+
+// exportHasLiked reports whether the export at jsonFile contains any liked videos.
+// An unreadable export reports true, so the usual prompt and parse error still follow.
+func exportHasLiked(jsonFile string, schema *SchemaMap) bool {
+	entries, err := parseFavoriteVideosFromFile(jsonFile, true, schema)
+	if err != nil {
+		return true
+	}
+	for _, entry := range entries {
+		if entry.Collection == "liked" {
+			return true
+		}
+	}
+	return false
+}
+
+// promptForLiked decides whether liked videos are included. The user is only asked when
+// the export has both sources and liked videos to include; otherwise the available
+// source is selected.
+func promptForLiked(jsonFile string, schema *SchemaMap, sections ExportSections, in io.Reader, out io.Writer) bool {
+	if !sections.favorites() {
+		return sections.HasLiked
+	}
+	if !sections.HasLiked {
+		return false
+	}
+	if !exportHasLiked(jsonFile, schema) {
+		_, _ = fmt.Fprintln(out, "[*] The export has no liked videos, only favorites will be used.")
+		return false
+	}
+
+	_, _ = fmt.Fprint(out, "[*] Would you like to include 'Liked' videos as well? (y/n, default is 'n'): ")
+	scanner := bufio.NewScanner(in)
+	scanner.Scan()
+	input := strings.TrimSpace(strings.ToLower(scanner.Text()))
+	return input == "y" || input == "yes"
+}
+
+// detectExportSections inspects the export and reports its type and missing sections,
+// returning an error when it has no videos to download (with includeHistory, the browsing
+// history alone is enough). If the file can't be inspected both sources are assumed and
+// parsing reports the problem later.
+func detectExportSections(jsonFile string, schema *SchemaMap, includeHistory bool) (ExportSections, error) {
+	sections, err := inspectExport(jsonFile, schema)
+	if err != nil {
+		return ExportSections{HasFavorites: true, HasLiked: true}, nil
+	}
+
+	fmt.Printf("[*] Detected a %q export\n", sections.kind())
+	notice := sections.notice()
+	if notice == "" {
+		return sections, nil
+	}
+	if !sections.favorites() && !sections.HasLiked {
+		if includeHistory && sections.HasHistory {
+			fmt.Printf("[!] This %s export has neither a Favorite Videos nor a Like List section; only the browsing history will be downloaded.\n", sections.kind())
+			return sections, nil
+		}
+		return sections, errors.New(notice)
+	}
+	fmt.Printf("[!] %s\n", notice)
+	return sections, nil
+}
+
+// defaultFindDirs returns the common locations a browser saves the TikTok export to
+func defaultFindDirs() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	return []string{
+		filepath.Join(home, "Downloads"),
+		filepath.Join(home, "Desktop"),
+	}
+}
+
+// isExportCandidate reports whether a filename looks like a TikTok data export
+// (e.g. user_data_tiktok.json or TikTok_Data_1706000000.zip)
+func isExportCandidate(name string) bool {
+	lower := strings.ToLower(name)
+	ext := filepath.Ext(lower)
+	return strings.Contains(lower, "tiktok") && (ext == ".json" || ext == ".zip")
+}
+
+// findNewestExport searches the given directories (non-recursively) for TikTok
+// export files and returns the path of the most recently modified one.
+func findNewestExport(dirs []string) (string, error) {
+	var newestPath string
+	var newestTime time.Time
+
+	for _, dir := range dirs {
+		fmt.Printf("[*] Searching %s for TikTok exports...\n", dir)
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			fmt.Printf("[!] Warning: Could not read %s: %v\n", dir, err)
+			continue
+		}
+		for _, f := range files {
+			if f.IsDir() || !isExportCandidate(f.Name()) {
+				continue
+			}
+			info, err := f.Info()
+			if err != nil {
+				continue
+			}
+			path := filepath.Join(dir, f.Name())
+			fmt.Printf("    found %s (modified %s)\n", path, info.ModTime().Format("2006-01-02 15:04:05"))
+			if newestPath == "" || info.ModTime().After(newestTime) {
+				newestPath = path
+				newestTime = info.ModTime()
+			}
+		}
+	}
+
+	if newestPath == "" {
+		return "", fmt.Errorf("no TikTok export found in %s", strings.Join(dirs, ", "))
+	}
+	return newestPath, nil
+}
+
+// ClipboardReader reads text from the system clipboard. Injected so --paste can be
+// tested without touching the real clipboard.
+type ClipboardReader interface {
+	ReadText() (string, error)
+}
+
+// systemClipboard reads and writes the clipboard with the platform's clipboard tools
+type systemClipboard struct {
+	goos string
+}
+
+// clipboardCommand returns the command that prints the clipboard contents on goos
+func clipboardCommand(goos string) (string, []string) {
+	switch goos {
+	case "windows":
+		// Force UTF-8 so non-ASCII titles in the export survive the console code page
+		return "powershell", []string{"-NoProfile", "-Command", "[Console]::OutputEncoding = [Text.Encoding]::UTF8; Get-Clipboard -Raw"}
+	case "darwin":
+		return "pbpaste", nil
+	default:
+		return "xclip", []string{"-selection", "clipboard", "-o"}
+	}
+}
+
+// ClipboardWriter puts text on the system clipboard (--copy-command)
+type ClipboardWriter interface {
+	WriteText(text string) error
+}
+
+// clipboardWriteCommand returns the command that copies its stdin to the clipboard on goos
+func clipboardWriteCommand(goos string) (string, []string) {
+	switch goos {
+	case "windows":
+		// clip.exe would mangle non-ASCII paths, so read stdin as UTF-8 instead
+		return "powershell", []string{"-NoProfile", "-Command", "[Console]::InputEncoding = [Text.Encoding]::UTF8; Set-Clipboard -Value ([Console]::In.ReadToEnd())"}
+	case "darwin":
+		return "pbcopy", nil
+	default:
+		return "xclip", []string{"-selection", "clipboard", "-i"}
+	}
+}
+
+func (c systemClipboard) WriteText(text string) error {
+	name, args := clipboardWriteCommand(c.goos)
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(text)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error copying to the clipboard with %s: %v", name, err)
+	}
+	return nil
+}
+
+func (c systemClipboard) ReadText() (string, error) {
+	name, args := clipboardCommand(c.goos)
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("error reading the clipboard with %s: %v", name, err)
+	}
+	return string(out), nil
+}
+
+// promptForPaste asks whether to read the export from the clipboard because jsonFile is missing
+func promptForPaste(jsonFile string) bool {
+	fmt.Printf("[*] '%s' was not found. Have you copied the export's contents instead? Read it from the clipboard? (y/N): ", jsonFile)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Scan()
+	input := strings.TrimSpace(strings.ToLower(scanner.Text()))
+	return input == "y" || input == "yes"
+}
+
+// exportFromClipboard reads a pasted TikTok export from the clipboard, checks that
+// it parses with schema (nil = built-in layout), and saves it to a temporary file whose
+// path is returned
+func exportFromClipboard(clipboard ClipboardReader, schema *SchemaMap) (string, error) {
+	text, err := clipboard.ReadText()
+	if err != nil {
+		return "", err
+	}
+	text = strings.TrimSpace(strings.TrimPrefix(text, "\ufeff"))
+	if text == "" {
+		return "", fmt.Errorf("the clipboard is empty (copy the contents of user_data_tiktok.json first)")
+	}
+
+	entries, err := parseFavoriteVideos(strings.NewReader(text), true, schema)
+	if err != nil {
+		return "", fmt.Errorf("the clipboard doesn't contain a TikTok export: %w", err)
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("the clipboard JSON has no favorite or liked videos")
+	}
+
+	out, err := os.CreateTemp("", "user_data_tiktok_*.json")
+	if err != nil {
+		return "", fmt.Errorf("error creating temporary file: %v", err)
+	}
+	defer func() { _ = out.Close() }()
+
+	if _, err := out.WriteString(text); err != nil {
+		_ = os.Remove(out.Name())
+		return "", fmt.Errorf("error saving the pasted export: %v", err)
+	}
+	fmt.Printf("[*] Using the TikTok export pasted from the clipboard (%d videos)\n", len(entries))
+	return out.Name(), nil
+}
+
+// extractExportFromZip copies the JSON export out of a TikTok data zip into a
+// temporary file and returns its path
+func extractExportFromZip(zipPath string) (string, error) {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return "", fmt.Errorf("error opening zip file %s: %v", zipPath, err)
+	}
+	defer func() { _ = r.Close() }()
+
+	for _, f := range r.File {
+		if !strings.EqualFold(filepath.Ext(f.Name), ".json") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", fmt.Errorf("error reading %s from %s: %v", f.Name, zipPath, err)
+		}
+		defer func() { _ = rc.Close() }()
+
+		out, err := os.CreateTemp("", "user_data_tiktok_*.json")
+		if err != nil {
+			return "", fmt.Errorf("error creating temporary file: %v", err)
+		}
+		defer func() { _ = out.Close() }()
+
+		if _, err := io.Copy(out, rc); err != nil {
+			_ = os.Remove(out.Name())
+			return "", fmt.Errorf("error extracting %s: %v", f.Name, err)
+		}
+		return out.Name(), nil
+	}
+	return "", fmt.Errorf("no JSON file found inside %s", zipPath)
+}
+
+// locateExport finds the newest TikTok export in dirs and returns a path to its JSON content
+func locateExport(dirs []string) (string, error) {
+	path, err := findNewestExport(dirs)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("[*] Using newest export: %s\n", path)
+
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		return extractExportFromZip(path)
+	}
+	return path, nil
+}
+
+// sanitizeCollectionName sanitizes collection names for use as directory names
+func sanitizeCollectionName(name string) string {
+	// Replace invalid characters with underscores
+	invalid := []string{"<", ">", ":", "\"", "/", "\\", "|", "?", "*"}
+	for _, char := range invalid {
+		name = strings.ReplaceAll(name, char, "_")
+	}
+	// Trim spaces and dots
+	name = strings.Trim(name, " .")
+	if name == "" {
+		name = "unknown"
+	}
+	return name
+}
+
+// extractVideoID extracts the video ID from a TikTok URL.
+// Supports various TikTok URL formats:
+//   - https://www.tiktokv.com/share/video/7600559584901647646/
+//   - https://www.tiktok.com/@user/video/7600559584901647646
+//   - https://m.tiktok.com/v/7600559584901647646.html
+func extractVideoID(url string) string {
+	for _, re := range videoIDPatterns {
+		if matches := re.FindStringSubmatch(url); len(matches) > 1 {
+			return matches[1]
+		}
+	}
+	return ""
+}
+
+// extractUploader extracts the uploader handle (without "@") from a TikTok URL.
+// Returns "" for URL formats that don't carry the handle, such as share links.
+func extractUploader(url string) string {
+	if matches := uploaderPattern.FindStringSubmatch(url); len(matches) > 1 {
+		return matches[1]
+	}
+	return ""
+}
+
+// filterSince keeps entries favorited after cutoff. Entries without a parseable
+// date are kept, since there's no way to tell whether they're new.
+// Returns the filtered entries and the number of entries dropped.
+func filterSince(entries []VideoEntry, cutoff time.Time) ([]VideoEntry, int) {
+	var kept []VideoEntry
+	for _, entry := range entries {
+		date, err := time.Parse(exportDateLayout, strings.TrimSpace(entry.Date))
+		if err != nil || date.After(cutoff) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, len(entries) - len(kept)
+}
+
+// relativeDatePattern matches relative date expressions such as "30d", "2w", "6mo"
+// or "1y", optionally written as "last 30d"
+var relativeDatePattern = regexp.MustCompile(`^(?:last\s+)?(\d+)\s*(d|w|mo|y)$`)
+
+// parseRelativeDate turns a relative expression ("30d", "2w", "6mo", "1y", "last 30d")
+// into the point in time that long before now
+func parseRelativeDate(expr string, now time.Time) (time.Time, error) {
+	m := relativeDatePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(expr)))
+	if m == nil {
+		return time.Time{}, fmt.Errorf("invalid relative date %q (expected e.g. 30d, 2w, 6mo or 1y)", expr)
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n <= 0 {
+		return time.Time{}, fmt.Errorf("invalid relative date %q (amount must be a positive number)", expr)
+	}
+
+	switch m[2] {
+	case "d":
+		return now.AddDate(0, 0, -n), nil
+	case "w":
+		return now.AddDate(0, 0, -7*n), nil
+	case "mo":
+		return now.AddDate(0, -n, 0), nil
+	default: // "y"
+		return now.AddDate(-n, 0, 0), nil
+	}
+}
+
+// parseDateFilter parses a --since/--until value: an absolute YYYY-MM-DD date or a
+// relative expression understood by parseRelativeDate. For absolute dates endOfDay
+// moves the result to the end of that day, so --until includes the day itself.
+func parseDateFilter(flagName, value string, endOfDay bool, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		if endOfDay {
+			date = date.AddDate(0, 0, 1)
+		}
+		return date, nil
+	}
+	date, err := parseRelativeDate(value, now)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --%s value %q (expected YYYY-MM-DD or a relative date like 30d, 6mo, 1y)", flagName, value)
+	}
+	return date, nil
+}
+
+// parseSinceValue parses a --since value: a YYYY-MM-DD date, a relative expression
+// such as "30d", "auto" to derive the cutoff from existing files (reported by the
+// bool) or "all" for no cutoff, the default
+func parseSinceValue(value string) (time.Time, bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "auto":
+		return time.Time{}, true, nil
+	case "all":
+		return time.Time{}, false, nil
+	}
+	cutoff, err := parseDateFilter("since", value, false, time.Now())
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return cutoff, false, nil
+}
+
+// filterWatchedSince keeps browsing history entries watched after cutoff. Other
+// entries, and history entries without a parseable watch time, are kept.
+// Returns the filtered entries and the number of entries dropped.
+func filterWatchedSince(entries []VideoEntry, cutoff time.Time) ([]VideoEntry, int) {
+	var kept []VideoEntry
+	for _, entry := range entries {
+		watched, err := time.Parse(exportDateLayout, strings.TrimSpace(entry.WatchedAt))
+		if entry.WatchedAt == "" || err != nil || watched.After(cutoff) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, len(entries) - len(kept)
+}
+
+// filterUntil keeps entries favorited before until. Entries without a parseable
+// date are kept. Returns the filtered entries and the number of entries dropped.
+func filterUntil(entries []VideoEntry, until time.Time) ([]VideoEntry, int) {
+	var kept []VideoEntry
+	for _, entry := range entries {
+		date, err := time.Parse(exportDateLayout, strings.TrimSpace(entry.Date))
+		if err != nil || date.Before(until) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, len(entries) - len(kept)
+}
+
+// filterSourceWindows keeps entries favorited inside their source's window. Entries
+// without a parseable date, or from a source without a window, are kept.
+// Returns the filtered entries and the number of entries dropped.
+func filterSourceWindows(entries []VideoEntry, windows map[string]DateWindow) ([]VideoEntry, int) {
+	var kept []VideoEntry
+	for _, entry := range entries {
+		window, ok := windows[sanitizeCollectionName(entry.Collection)]
+		date, err := time.Parse(exportDateLayout, strings.TrimSpace(entry.Date))
+		if !ok || err != nil ||
+			((window.Since.IsZero() || date.After(window.Since)) && (window.Until.IsZero() || date.Before(window.Until))) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, len(entries) - len(kept)
+}
+
+// newestMediaFile returns the modification time and name of the newest media file
+// directly inside dir, or a zero time if there is none (or dir doesn't exist)
+func newestMediaFile(dir string, mediaExts []string) (time.Time, string) {
+	if len(mediaExts) == 0 {
+		mediaExts = defaultMediaExtensions
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return time.Time{}, ""
+	}
+
+	var newest time.Time
+	var newestName string
+	for _, f := range files {
+		if f.IsDir() || !slices.Contains(mediaExts, strings.ToLower(filepath.Ext(f.Name()))) {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(newest) {
+			newest, newestName = info.ModTime(), f.Name()
+		}
+	}
+	return newest, newestName
+}
+
+// videoIDFromFilename extracts the TikTok video ID from a file downloaded with the
+// "<upload_date>_<id>_<title>.<ext>" output template, or "" if it doesn't match
+func videoIDFromFilename(name string) string {
+	if m := filenameIDPattern.FindStringSubmatch(filepath.Base(name)); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// scanDownloadedIDs returns the IDs of the videos whose media files are directly inside dir.
+// A missing dir yields an empty set.
+func scanDownloadedIDs(dir string, mediaExts []string) (map[string]bool, error) {
+	if len(mediaExts) == 0 {
+		mediaExts = defaultMediaExtensions
+	}
+	ids := make(map[string]bool)
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return ids, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error scanning %s for downloaded videos: %v", dir, err)
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !slices.Contains(mediaExts, strings.ToLower(filepath.Ext(f.Name()))) {
+			continue
+		}
+		if id := videoIDFromFilename(f.Name()); id != "" {
+			ids[id] = true
+		}
+	}
+	return ids, nil
+}
+
+// seedArchive adds a "tiktok <id>" line to dir's download_archive.txt for every video
+// with a media file directly in dir, so yt-dlp skips videos downloaded before the
+// archive existed. IDs already in the archive are left alone. Returns how many were added.
+func seedArchive(dir string, mediaExts []string) (int, error) {
+	downloaded, err := scanDownloadedIDs(dir, mediaExts)
+	if err != nil || len(downloaded) == 0 {
+		return 0, err
+	}
+
+	archivePath := filepath.Join(dir, "download_archive.txt")
+	existing, err := parseArchiveFile(archivePath)
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []string
+	for id := range downloaded {
+		if !existing[id] {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	sort.Strings(ids)
+
+	var buf strings.Builder
+	if data, err := os.ReadFile(archivePath); err == nil && len(data) > 0 && data[len(data)-1] != '\n' {
+		buf.WriteString("\n")
+	}
+	for _, id := range ids {
+		buf.WriteString("tiktok " + id + "\n")
+	}
+
+	f, err := os.OpenFile(archivePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %v", archivePath, err)
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := f.WriteString(buf.String()); err != nil {
+		return 0, fmt.Errorf("failed to write %s: %v", archivePath, err)
+	}
+	return len(ids), nil
+}
+
+// runSeedArchive seeds the download archive of root (flat layout) and of each of its
+// subdirectories (collection layout) from the media files already there
+func runSeedArchive(root string, mediaExts []string) error {
+	dirs := []string{root}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", root, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, filepath.Join(root, entry.Name()))
+		}
+	}
+
+	total := 0
+	for _, dir := range dirs {
+		added, err := seedArchive(dir, mediaExts)
+		if err != nil {
+			return err
+		}
+		if added > 0 {
+			fmt.Printf("[*] Added %d videos to %s\n", added, filepath.Join(dir, "download_archive.txt"))
+		}
+		total += added
+	}
+	if total == 0 {
+		fmt.Println("[*] No new downloaded videos found; archives are up to date")
+	}
+	return nil
+}
+
+// reconcileArchive makes dir's download_archive.txt match the media files directly in
+// dir: "tiktok <id>" lines whose video is no longer on disk are removed, so the next run
+// downloads it again, and videos on disk missing from the archive are added. Other lines
+// are kept as they are. Returns the IDs added and removed, each sorted.
+func reconcileArchive(dir string, mediaExts []string) (added, removed []string, err error) {
+	downloaded, err := scanDownloadedIDs(dir, mediaExts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	archivePath := filepath.Join(dir, "download_archive.txt")
+	data, err := os.ReadFile(archivePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("failed to read %s: %v", archivePath, err)
+	}
+
+	var kept []string
+	archived := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if parts := strings.Fields(line); len(parts) == 2 && parts[0] == "tiktok" {
+			if !downloaded[parts[1]] {
+				removed = append(removed, parts[1])
+				continue
+			}
+			archived[parts[1]] = true
+		}
+		kept = append(kept, line)
+	}
+	for id := range downloaded {
+		if !archived[id] {
+			added = append(added, id)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return nil, nil, nil
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	var buf strings.Builder
+	for _, line := range kept {
+		buf.WriteString(line + "\n")
+	}
+	for _, id := range added {
+		buf.WriteString("tiktok " + id + "\n")
+	}
+	tmpPath := archivePath + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(buf.String()), 0644); err != nil {
+		return nil, nil, fmt.Errorf("failed to write %s: %v", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, archivePath); err != nil {
+		return nil, nil, fmt.Errorf("failed to save %s: %v", archivePath, err)
+	}
+	return added, removed, nil
+}
+
+// runResumeArchive reconciles the download archive of root (flat layout) and of each
+// of its subdirectories (collection layout) with the media files there. Only
+// directories that already have an archive or downloaded videos are touched.
+func runResumeArchive(root string, mediaExts []string, out io.Writer) error {
+	dirs := []string{root}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", root, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, filepath.Join(root, entry.Name()))
+		}
+	}
+
+	changed := false
+	for _, dir := range dirs {
+		added, removed, err := reconcileArchive(dir, mediaExts)
+		if err != nil {
+			return err
+		}
+		if len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+		changed = true
+		fmt.Fprintf(out, "[*] %s: added %d videos found on disk, removed %d videos missing from disk\n",
+			filepath.Join(dir, "download_archive.txt"), len(added), len(removed))
+		for _, id := range removed {
+			fmt.Fprintf(out, "  - %s (will be downloaded again)\n", id)
+		}
+	}
+	if !changed {
+		fmt.Fprintln(out, "[*] Download archives already match the videos on disk")
+	}
+	return nil
+}
+
+// generatedArtifacts are the fixed filenames the tool writes next to the videos
+var generatedArtifacts = map[string]bool{
+	"fav_videos.txt":       true,
+	"liked_videos.txt":     true,
+	"history_videos.txt":   true,
+	"saved_videos.txt":     true,
+	"favorites.txt":        true, // --split-by-source lists
+	"liked.txt":            true,
+	"history.txt":          true,
+	"saved.txt":            true,
+	"download_archive.txt": true,
+	"results.txt":          true,
+	"results.log":          true, // --results-format apache
+	"summary.json":         true,
+	"unavailable.json":     true,
+	"index.html":           true,
+	"index.json":           true,
+	"mapping.json":         true,
+	"timings.csv":          true,
+	"run_manifest.json":    true,
+	"run_manifest.csv":     true,
+	"run_download.bat":     true, // --write-launcher scripts
+	"run_download.sh":      true,
+}
+
+// generatedArtifactPattern matches the generated files with variable names: --chunk-size
+// batches, partial and checkpoint files, ffmpeg concat lists, --write-ytdlp-conf configs
+// and --run-log transcripts
+var generatedArtifactPattern = regexp.MustCompile(`^((fav|liked|history|saved)_videos_\d{3,}\.txt|.+\.(partial\.txt|progress\.json|concat\.txt|yt-dlp\.conf)|run-\d{8}-\d{6}\.log)$`)
+
+// isGeneratedArtifact reports whether a filename is one the tool generates (lists,
+// archives, reports and indexes), as opposed to downloaded media and metadata
+func isGeneratedArtifact(name string) bool {
+	return generatedArtifacts[name] || generatedArtifactPattern.MatchString(name)
+}
+
+// findArtifacts lists the generated files in root (flat layout) and in each of its
+// subdirectories (collection layout)
+func findArtifacts(root string) ([]string, error) {
+	dirs := []string{root}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", root, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, filepath.Join(root, entry.Name()))
+		}
+	}
+
+	var artifacts []string
+	for _, dir := range dirs {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", dir, err)
+		}
+		for _, file := range files {
+			if file.Type().IsRegular() && isGeneratedArtifact(file.Name()) {
+				artifacts = append(artifacts, filepath.Join(dir, file.Name()))
+			}
+		}
+	}
+	return artifacts, nil
+}
+
+// runClean removes the generated files under root, leaving videos, thumbnails and
+// .info.json files alone. Unless force is set, the files are listed and the user
+// is asked to confirm. Returns how many files were removed.
+func runClean(root string, force bool, in io.Reader, out io.Writer) (int, error) {
+	artifacts, err := findArtifacts(root)
+	if err != nil {
+		return 0, err
+	}
+	if len(artifacts) == 0 {
+		_, _ = fmt.Fprintf(out, "[*] No generated files found in %s\n", root)
+		return 0, nil
+	}
+
+	if !force {
+		_, _ = fmt.Fprintf(out, "[*] Found %d generated files (videos are kept):\n", len(artifacts))
+		for _, path := range artifacts {
+			_, _ = fmt.Fprintf(out, "  %s\n", path)
+		}
+		_, _ = fmt.Fprint(out, "[*] Delete these files? (y/n, default is 'n'): ")
+		scanner := bufio.NewScanner(in)
+		scanner.Scan()
+		input := strings.TrimSpace(strings.ToLower(scanner.Text()))
+		if input != "y" && input != "yes" {
+			_, _ = fmt.Fprintln(out, "[*] Nothing deleted.")
+			return 0, nil
+		}
+	}
+
+	removed := 0
+	for _, path := range artifacts {
+		if err := os.Remove(path); err != nil {
+			return removed, fmt.Errorf("error removing %s: %v", path, err)
+		}
+		removed++
+	}
+	_, _ = fmt.Fprintf(out, "[*] Removed %d generated files from %s\n", removed, root)
+	return removed, nil
+}
+
+// parseCleanArgs splits the clean command's arguments into the --force switch and
+// the directory to clean (default the current directory)
+func parseCleanArgs(args []string) (force bool, dir string) {
+	dir = "."
+	for _, arg := range args {
+		if arg == "--force" || arg == "-force" || arg == "-f" {
+			force = true
+		} else {
+			dir = arg
+		}
+	}
+	return force, dir
+}
+
+// applyDedupeExisting drops videos that already have a media file in their output
+// directory (each collection folder, or baseDir in flat mode), catching files the
+// download archive doesn't know about, e.g. ones copied in by hand
+func applyDedupeExisting(config *Config, entries []VideoEntry, baseDir string) []VideoEntry {
+	if !config.DedupeExisting {
+		return entries
+	}
+
+	var kept []VideoEntry
+	scanned := make(map[string]map[string]bool)
+	removed := 0
+	for _, entry := range entries {
+		dir := baseDir
+		if config.OrganizeByCollection {
+			dir = filepath.Join(baseDir, sanitizeCollectionName(entry.Collection))
+		}
+		ids, ok := scanned[dir]
+		if !ok {
+			var err error
+			if ids, err = scanDownloadedIDs(dir, config.MediaExtensions); err != nil {
+				fmt.Printf("[!] Warning: %v\n", err)
+			}
+			scanned[dir] = ids
+		}
+		if id := extractVideoID(entry.Link); id != "" && ids[id] {
+			removed++
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	if removed > 0 {
+		fmt.Printf("[*] Skipping %d videos already on disk (--dedupe-existing)\n", removed)
+	}
+	return kept
+}
+
+// applySinceCutoff narrows the entries to download to those favorited before --until,
+// inside their source's window and after the --since date. With --since auto, the
+// cutoff for each output directory (each collection folder, or baseDir itself in flat
+// mode) is the modification time of the newest media file already in it, so re-runs
+// only fetch new favorites. Only videos in that directory's download archive are
+// skipped by it, so a video that failed or was never attempted is always retried.
+func applySinceCutoff(config *Config, entries []VideoEntry, baseDir string) []VideoEntry {
+	if !config.Until.IsZero() {
+		var dropped int
+		entries, dropped = filterUntil(entries, config.Until)
+		if dropped > 0 {
+			fmt.Printf("[*] Skipping %d videos favorited after %s (--until)\n", dropped, config.Until.Format(exportDateLayout))
+		}
+	}
+	if len(config.SourceWindows) > 0 {
+		var dropped int
+		entries, dropped = filterSourceWindows(entries, config.SourceWindows)
+		if dropped > 0 {
+			fmt.Printf("[*] Skipping %d videos outside their source's date window (--liked-since, --favorites-until, ...)\n", dropped)
+		}
+	}
+	if !config.Since.IsZero() {
+		kept, dropped := filterSince(entries, config.Since)
+		if dropped > 0 {
+			fmt.Printf("[*] Skipping %d videos favorited before %s (--since)\n", dropped, config.Since.Format("2006-01-02"))
+		}
+		return kept
+	}
+	if !config.AutoSince {
+		return entries
+	}
+
+	var kept []VideoEntry
+	cutoffs := make(map[string]time.Time)
+	archives := make(map[string]map[string]bool)
+	for _, entry := range entries {
+		dir := baseDir
+		if config.OrganizeByCollection {
+			dir = filepath.Join(baseDir, sanitizeCollectionName(entry.Collection))
+		}
+		cutoff, ok := cutoffs[dir]
+		if !ok {
+			cutoff, archives[dir] = autoSinceCutoff(dir, config.MediaExtensions)
+			cutoffs[dir] = cutoff
+		}
+		if !cutoff.IsZero() && archives[dir][extractVideoID(entry.Link)] {
+			if filtered, _ := filterSince([]VideoEntry{entry}, cutoff); len(filtered) == 0 {
+				continue
+			}
+		}
+		kept = append(kept, entry)
+	}
+	if dropped := len(entries) - len(kept); dropped > 0 {
+		fmt.Printf("[*] Skipping %d downloaded videos favorited before the newest existing file (--since auto)\n", dropped)
+	}
+	return kept
+}
+
+// autoSinceCutoff returns the --since auto cutoff for dir, the modification time of its
+// newest media file, along with the video IDs in its download archive. The cutoff is
+// in UTC like the export's dates; it is zero when dir has no media files.
+func autoSinceCutoff(dir string, mediaExts []string) (time.Time, map[string]bool) {
+	cutoff, name := newestMediaFile(dir, mediaExts)
+	if cutoff.IsZero() {
+		return cutoff, nil
+	}
+	archived, err := parseArchiveFile(filepath.Join(dir, "download_archive.txt"))
+	if err != nil {
+		fmt.Printf("[!] Warning: %v\n", err)
+	}
+	cutoff = cutoff.UTC()
+	fmt.Printf("[*] %s: skipping downloaded videos favorited before %s UTC (newest existing file: %s)\n",
+		dir, cutoff.Format(exportDateLayout), name)
+	return cutoff, archived
+}
+
+// limitPerUploader keeps at most limit videos per uploader, in first-seen order.
+// Entries whose uploader can't be determined from the URL are always kept.
+// Returns the filtered entries and the number of entries dropped.
+func limitPerUploader(entries []VideoEntry, limit int) ([]VideoEntry, int) {
+	counts := make(map[string]int)
+	result := make([]VideoEntry, 0, len(entries))
+	dropped := 0
+	for _, entry := range entries {
+		uploader := strings.ToLower(extractUploader(entry.Link))
+		if uploader != "" {
+			if counts[uploader] >= limit {
+				dropped++
+				continue
+			}
+			counts[uploader]++
+		}
+		result = append(result, entry)
+	}
+	return result, dropped
+}
+
+// groupByUploader reorders entries so each uploader's videos are next to each other
+// (--batch-by-uploader). Uploaders keep the order they're first seen in and their
+// videos keep their relative order; entries whose uploader can't be determined from
+// the URL go last.
+func groupByUploader(entries []VideoEntry) []VideoEntry {
+	groups := make(map[string][]VideoEntry)
+	var order []string
+	var unknown []VideoEntry
+	for _, entry := range entries {
+		uploader := strings.ToLower(extractUploader(entry.Link))
+		if uploader == "" {
+			unknown = append(unknown, entry)
+			continue
+		}
+		if _, seen := groups[uploader]; !seen {
+			order = append(order, uploader)
+		}
+		groups[uploader] = append(groups[uploader], entry)
+	}
+
+	grouped := make([]VideoEntry, 0, len(entries))
+	for _, uploader := range order {
+		grouped = append(grouped, groups[uploader]...)
+	}
+	return append(grouped, unknown...)
+}
+
+// regionalPathPattern matches a leading language/region path segment such as "/en/" or "/pt-BR/"
+var regionalPathPattern = regexp.MustCompile(`^/[a-zA-Z]{2}(?:[-_][a-zA-Z]{2,4})?(/@)`)
+
+// normalizeURL canonicalizes a TikTok URL: lowercases the scheme and host, drops the
+// query string (tracking and language params) and fragment, strips a leading regional
+// path segment and any trailing slash. Unparseable input is returned trimmed but otherwise unchanged.
+func normalizeURL(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.Path = regionalPathPattern.ReplaceAllString(u.Path, "$1")
+	u.RawPath = ""
+	if len(u.Path) > 1 {
+		u.Path = strings.TrimRight(u.Path, "/")
+	}
+	return u.String()
+}
+
+// stripQuery removes the query string from rawURL (?lang=en&is_from_webapp=1, ...),
+// leaving the scheme, host, path and any fragment exactly as they were
+func stripQuery(rawURL string) string {
+	query := strings.IndexByte(rawURL, '?')
+	if query < 0 {
+		return rawURL
+	}
+	if fragment := strings.IndexByte(rawURL, '#'); fragment >= 0 {
+		if fragment < query {
+			return rawURL // The "?" is part of the fragment
+		}
+		return rawURL[:query] + rawURL[fragment:]
+	}
+	return rawURL[:query]
+}
+
+// normalizeEntries rewrites each entry's link with normalizeURL and drops entries that
+// become duplicates of an earlier link in the same collection.
+// Returns the normalized entries and the number of duplicates removed.
+func normalizeEntries(entries []VideoEntry) ([]VideoEntry, int) {
+	return rewriteEntries(entries, normalizeURL)
+}
+
+// rewriteEntries rewrites each entry's link with rewrite and drops entries that become
+// duplicates of an earlier link in the same collection.
+// Returns the rewritten entries and the number of duplicates removed.
+func rewriteEntries(entries []VideoEntry, rewrite func(string) string) ([]VideoEntry, int) {
+	seen := make(map[string]bool)
+	result := make([]VideoEntry, 0, len(entries))
+	removed := 0
+	for _, entry := range entries {
+		entry.Link = rewrite(entry.Link)
+		key := entry.Collection + "\x00" + entry.Link
+		if seen[key] {
+			removed++
+			continue
+		}
+		seen[key] = true
+		result = append(result, entry)
+	}
+	return result, removed
+}
+
+// applyEntryFilters applies the user's list filters (e.g. --limit-per-uploader) to parsed entries
+func applyEntryFilters(config *Config, entries []VideoEntry) []VideoEntry {
+	if config.NormalizeURLs {
+		var removed int
+		entries, removed = normalizeEntries(entries)
+		if removed > 0 {
+			fmt.Printf("[*] Normalized URLs (%d duplicate links removed)\n", removed)
+		}
+	} else if config.StripQuery {
+		var removed int
+		entries, removed = rewriteEntries(entries, stripQuery)
+		if removed > 0 {
+			fmt.Printf("[*] Removed query strings from URLs (%d duplicate links removed)\n", removed)
+		}
+	}
+	if config.IncludeIDs != nil || len(config.ExcludeIDs) > 0 {
+		var dropped int
+		entries, dropped = filterByIDs(entries, config.IncludeIDs, config.ExcludeIDs)
+		if dropped > 0 {
+			fmt.Printf("[*] Filtered by ID lists (%d videos skipped)\n", dropped)
+		}
+	}
+	if !config.WatchedSince.IsZero() {
+		var dropped int
+		entries, dropped = filterWatchedSince(entries, config.WatchedSince)
+		if dropped > 0 {
+			fmt.Printf("[*] Skipping %d history videos watched before %s (--watched-since)\n", dropped, config.WatchedSince.Format(exportDateLayout))
+		}
+	}
+	if config.LimitPerUploader > 0 {
+		var dropped int
+		entries, dropped = limitPerUploader(entries, config.LimitPerUploader)
+		if dropped > 0 {
+			fmt.Printf("[*] Limited to %d videos per uploader (%d videos skipped)\n", config.LimitPerUploader, dropped)
+		}
+	}
+	if config.Order == OrderChronological {
+		entries = sortChronological(entries)
+	}
+	if config.BatchByUploader {
+		entries = groupByUploader(entries)
+	}
+	return entries
+}
+
+// List orders accepted by --order
+const (
+	OrderOriginal      = "original"
+	OrderChronological = "chronological"
+)
+
+// sortChronological returns entries ordered by their export Date, oldest first, merging
+// favorites and liked videos into one timeline. Entries without a parseable date keep
+// their relative order and go last.
+func sortChronological(entries []VideoEntry) []VideoEntry {
+	type datedEntry struct {
+		entry VideoEntry
+		date  time.Time
+		ok    bool
+	}
+	dated := make([]datedEntry, len(entries))
+	for i, entry := range entries {
+		date, err := time.Parse(exportDateLayout, strings.TrimSpace(entry.Date))
+		dated[i] = datedEntry{entry, date, err == nil}
+	}
+	sort.SliceStable(dated, func(i, j int) bool {
+		if dated[i].ok != dated[j].ok {
+			return dated[i].ok
+		}
+		return dated[i].ok && dated[i].date.Before(dated[j].date)
+	})
+
+	sorted := make([]VideoEntry, len(dated))
+	for i, d := range dated {
+		sorted[i] = d.entry
+	}
+	return sorted
+}
+
+// parseIDsFile reads a list of video IDs for --include-ids-file/--exclude-ids-file.
+// Each line holds a numeric ID, a TikTok video URL or a download archive entry
+// ("tiktok <id>"); blank lines and lines starting with # are ignored.
+func parseIDsFile(path string) (map[string]bool, error) {
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("error opening ID list: %v", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	ids := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id := parseVideoIDValue(line)
+		if id == "" {
+			return nil, fmt.Errorf("%s line %d: %q is not a video ID or TikTok URL", path, lineNum, line)
+		}
+		ids[id] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading ID list: %v", err)
+	}
+	return ids, nil
+}
+
+// parseVideoIDValue returns the video ID in an ID list line, or "" if there is none
+func parseVideoIDValue(value string) string {
+	value = strings.TrimPrefix(value, "tiktok ")
+	if _, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return value
+	}
+	return extractVideoID(value)
+}
+
+// parseKnownIDs extracts the IDs of the videos a previous run downloaded from one of
+// its artifacts (--skip-known): an index.json, an index.html or an M3U playlist
+func parseKnownIDs(path string) (map[string]bool, error) {
+	content, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	ids := make(map[string]bool)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var index CollectionIndex
+		if err := json.Unmarshal(content, &index); err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrJSONParse, path, err)
+		}
+		for _, v := range index.Videos {
+			id := v.VideoID
+			if id == "" {
+				id = extractVideoID(v.Link)
+			}
+			if v.Downloaded && id != "" {
+				ids[id] = true
+			}
+		}
+
+	case ".html", ".htm":
+		// Downloaded videos are the cards marked data-status="downloaded"; their
+		// data-file is the local filename, which carries the video ID
+		tokenizer := html.NewTokenizer(bytes.NewReader(content))
+		for {
+			tt := tokenizer.Next()
+			if tt == html.ErrorToken {
+				if err := tokenizer.Err(); err != io.EOF {
+					return nil, fmt.Errorf("error parsing %s: %v", path, err)
+				}
+				break
+			}
+			if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+				continue
+			}
+			var status, file string
+			for _, attr := range tokenizer.Token().Attr {
+				switch attr.Key {
+				case "data-status":
+					status = attr.Val
+				case "data-file":
+					file = attr.Val
+				}
+			}
+			if status == "downloaded" {
+				if id := videoIDFromFilename(file); id != "" {
+					ids[id] = true
+				}
+			}
+		}
+
+	case ".m3u", ".m3u8":
+		// Each non-comment line is a media path or URL
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(strings.TrimPrefix(line, "\ufeff"))
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			id := extractVideoID(line)
+			if id == "" {
+				id = videoIDFromFilename(filepath.Base(strings.ReplaceAll(line, "\\", "/")))
+			}
+			if id != "" {
+				ids[id] = true
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported file %s for --skip-known (expected index.json, index.html or an .m3u playlist)", path)
+	}
+	return ids, nil
+}
+
+// applySkipKnown drops the videos a previous run's index or playlist (--skip-known)
+// shows as already downloaded. Like --dedupe-existing it only narrows what gets
+// downloaded; the index still covers every video.
+func applySkipKnown(config *Config, entries []VideoEntry) []VideoEntry {
+	if len(config.KnownIDs) == 0 {
+		return entries
+	}
+	kept, dropped := filterByIDs(entries, nil, config.KnownIDs)
+	if dropped > 0 {
+		fmt.Printf("[*] Skipping %d videos already in the previous index (--skip-known)\n", dropped)
+	}
+	return kept
+}
+
+// precheckConcurrency bounds how many --precheck requests are in flight at once
+const precheckConcurrency = 8
+
+// precheckEntries sends a HEAD request for each entry's URL and drops the ones the
+// server reports as gone (404 or 410). Anything else, including network errors, is
+// kept and left for yt-dlp to judge. Once ctx is done the unchecked entries are kept.
+// Returns the kept entries in their original order and the dropped ones.
+func precheckEntries(ctx context.Context, client *http.Client, entries []VideoEntry) ([]VideoEntry, []VideoEntry) {
+	gone := make([]bool, len(entries))
+	sem := make(chan struct{}, precheckConcurrency)
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			gone[i] = linkIsGone(ctx, client, entry.Link)
+		}()
+	}
+	wg.Wait()
+
+	var kept, dropped []VideoEntry
+	for i, entry := range entries {
+		if gone[i] {
+			dropped = append(dropped, entry)
+		} else {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, dropped
+}
+
+// linkIsGone reports whether a HEAD request to url gets a 404 or 410
+func linkIsGone(ctx context.Context, client *http.Client, url string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone
+}
+
+// applyPrecheck drops dead links with precheckEntries when --precheck is set
+func applyPrecheck(ctx context.Context, config *Config, client *http.Client, entries []VideoEntry) []VideoEntry {
+	if !config.Precheck || len(entries) == 0 {
+		return entries
+	}
+	fmt.Printf("[*] Checking %d video URLs before download (--precheck)...\n", len(entries))
+	kept, dropped := precheckEntries(ctx, client, entries)
+	for _, entry := range dropped {
+		fmt.Printf("[!] Dropping dead link: %s\n", entry.Link)
+	}
+	if len(dropped) > 0 {
+		fmt.Printf("[*] Skipping %d videos that are no longer available (--precheck)\n", len(dropped))
+	}
+	return kept
+}
+
+// sliceAfterID drops the video with the given ID and everything before it in the same
+// collection, keeping the other collections whole. Returns false if no entry has the ID.
+func sliceAfterID(entries []VideoEntry, id string) ([]VideoEntry, bool) {
+	cut := slices.IndexFunc(entries, func(entry VideoEntry) bool { return extractVideoID(entry.Link) == id })
+	if cut < 0 {
+		return entries, false
+	}
+	collection := entries[cut].Collection
+	kept := make([]VideoEntry, 0, len(entries))
+	for i, entry := range entries {
+		if i <= cut && entry.Collection == collection {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return kept, true
+}
+
+// applyAfterID continues a list from just after config.AfterID (--after-id). An ID that
+// isn't in the list leaves it whole, since the archive still skips finished videos.
+func applyAfterID(config *Config, entries []VideoEntry) []VideoEntry {
+	if config.AfterID == "" {
+		return entries
+	}
+	kept, found := sliceAfterID(entries, config.AfterID)
+	if !found {
+		fmt.Printf("[!] Video %s (--after-id) is not in the list, downloading all %d videos\n", config.AfterID, len(entries))
+		return entries
+	}
+	fmt.Printf("[*] Starting after video %s (--after-id): %d videos skipped\n", config.AfterID, len(entries)-len(kept))
+	return kept
+}
+
+// filterByIDs keeps the entries whose video ID is in include (all, if include is nil)
+// and not in exclude. With an include list, entries without a parseable ID are dropped.
+// Returns the kept entries and how many were dropped.
+func filterByIDs(entries []VideoEntry, include, exclude map[string]bool) ([]VideoEntry, int) {
+	kept := make([]VideoEntry, 0, len(entries))
+	for _, entry := range entries {
+		id := extractVideoID(entry.Link)
+		if include != nil && !include[id] {
+			continue
+		}
+		if id != "" && exclude[id] {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return kept, len(entries) - len(kept)
+}
+
+// parseArchiveFile reads yt-dlp's download archive file and returns
+// a set of video IDs that have been successfully downloaded.
+// Archive format: "tiktok <video_id>" per line
+// Returns empty map (not error) if file doesn't exist - this is normal for first run.
+func parseArchiveFile(archivePath string) (map[string]bool, error) {
+	// Check if archive exists
+	if _, err := os.Stat(archivePath); os.IsNotExist(err) {
+		return make(map[string]bool), nil // Empty archive, not an error
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive file %s: %v", archivePath, err)
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close archive file: %v\n", closeErr)
+		}
+	}()
+
+	archive := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip empty lines
+		if line == "" {
+			continue
+		}
+
+		// Parse "tiktok <video_id>" format
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			fmt.Printf("[!] Warning: Malformed archive line %d in %s: %s\n",
+				lineNum, archivePath, line)
+			continue
+		}
+
+		if parts[0] != "tiktok" {
+			fmt.Printf("[!] Warning: Unknown platform %s at line %d in %s\n",
+				parts[0], lineNum, archivePath)
+			continue
+		}
+
+		videoID := parts[1]
+
+		// Basic validation: video ID should be numeric
+		if _, err := strconv.ParseInt(videoID, 10, 64); err != nil {
+			fmt.Printf("[!] Warning: Invalid video ID %s at line %d in %s\n",
+				videoID, lineNum, archivePath)
+			continue
+		}
+
+		archive[videoID] = true
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading archive file %s: %v", archivePath, err)
+	}
+
+	return archive, nil
+}
+
+// shouldSkipCollection determines if all videos in a collection are already
+// downloaded by checking the archive file. Returns true only if 100% of videos
+// are in the archive.
+//
+// Returns:
+//   - bool: true if yt-dlp can be skipped (all videos downloaded)
+//   - string: informational message for user
+//   - error: error parsing archive (caller should fall back to calling yt-dlp)
+func shouldSkipCollection(entries []VideoEntry, archivePath string) (bool, string, error) {
+	// Empty collection - nothing to download
+	if len(entries) == 0 {
+		return true, "Empty collection", nil
+	}
+
+	// Parse archive file
+	archive, err := parseArchiveFile(archivePath)
+	if err != nil {
+		// Error parsing archive - be conservative, call yt-dlp
+		return false, "", err
+	}
+
+	// Empty archive - need to download everything
+	if len(archive) == 0 {
+		msg := fmt.Sprintf("No videos in archive, %d videos need download", len(entries))
+		return false, msg, nil
+	}
+
+	// Extract video IDs from all entries and check against archive
+	var missingIDs []string
+	for _, entry := range entries {
+		videoID := extractVideoID(entry.Link)
+
+		// If we can't extract video ID, be conservative - don't skip
+		if videoID == "" {
+			msg := fmt.Sprintf("Could not parse video ID from URL: %s", entry.Link)
+			return false, msg, nil
+		}
+
+		// Check if video is in archive
+		if !archive[videoID] {
+			missingIDs = append(missingIDs, videoID)
+		}
+	}
+
+	// All videos in archive - safe to skip
+	if len(missingIDs) == 0 {
+		msg := fmt.Sprintf("All %d videos already downloaded", len(entries))
+		return true, msg, nil
+	}
+
+	// Partial match - need to call yt-dlp
+	msg := fmt.Sprintf("%d new videos need download (out of %d total)",
+		len(missingIDs), len(entries))
+	return false, msg, nil
+}
+
+// parseInfoJSON reads a yt-dlp .info.json file and extracts metadata
+func parseInfoJSON(infoPath string) (*YtdlpInfo, error) {
+	data, err := os.ReadFile(infoPath)
+	if err != nil {
+		return nil, err
+	}
+	var info YtdlpInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// getOutputFilename returns the appropriate URL list filename for a collection
+func getOutputFilename(collection string) string {
+	if collection == "liked" {
+		return "liked_videos.txt"
+	}
+	if collection == "history" {
+		return "history_videos.txt"
+	}
+	if collection == "saved" {
+		return "saved_videos.txt"
+	}
+	return "fav_videos.txt"
+}
+
+// createCollectionDirectories creates directories for each collection under baseDir
+func createCollectionDirectories(videoEntries []VideoEntry, baseDir string, organizeByCollection bool) error {
+	if !organizeByCollection {
+		return nil
+	}
+
+	collections := make(map[string]bool)
+	for _, entry := range videoEntries {
+		collections[sanitizeCollectionName(entry.Collection)] = true
+	}
+
+	for collection := range collections {
+		dir := filepath.Join(baseDir, collection)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("[!!!] Error creating directory %s: %v", dir, err)
+		}
+	}
+	return nil
+}
+
+// writeFavoriteVideosToFile writes the video entries to output files, organized by collection if enabled.
+func writeFavoriteVideosToFile(videoEntries []VideoEntry, outputName string, organizeByCollection bool) error {
+	return writeFavoriteVideosToDir(videoEntries, ".", outputName, organizeByCollection)
+}
+
+// writeFavoriteVideosToDir is writeFavoriteVideosToFile with the lists (and collection
+// directories) placed under baseDir, e.g. the --work-dir of the run
+func writeFavoriteVideosToDir(videoEntries []VideoEntry, baseDir, outputName string, organizeByCollection bool) error {
+	if organizeByCollection {
+		// Create collection directories first
+		if err := createCollectionDirectories(videoEntries, baseDir, true); err != nil {
+			return err
+		}
+
+		// Group entries by collection
+		collectionGroups := make(map[string][]VideoEntry)
+		for _, entry := range videoEntries {
+			collection := sanitizeCollectionName(entry.Collection)
+			collectionGroups[collection] = append(collectionGroups[collection], entry)
+		}
+
+		// Write separate files for each collection with collection-specific filenames
+		for collection, entries := range collectionGroups {
+			// Use collection-specific filename (fav_videos.txt for favorites, liked_videos.txt for liked)
+			collectionFilename := getOutputFilename(collection)
+			collectionOutputName := filepath.Join(baseDir, collection, collectionFilename)
+			if err := writeVideoEntriesToFile(entries, collectionOutputName); err != nil {
+				return err
+			}
+			fmt.Printf("[*] Extracted %d video URLs to '%s'\n", len(entries), collectionOutputName)
+		}
+	} else {
+		// Write all entries to a single file (flat structure)
+		return writeVideoEntriesToFile(videoEntries, resolveInDir(baseDir, outputName))
+	}
+	return nil
+}
+
+// resolveInDir returns name relative to dir; absolute names and an empty dir leave it as is
+func resolveInDir(dir, name string) string {
+	if dir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
+
+// workPath returns where the run's artifact name goes: inside --work-dir if one was given
+func workPath(config *Config, name string) string {
+	return resolveInDir(config.WorkDir, name)
+}
+
+// listCollectionName returns the collection a URL list belongs to for progress and
+// results: its directory in organize mode or with --separate-runs, "videos" for lists
+// at the top of the work dir
+func listCollectionName(config *Config, outputName string) string {
+	dir := filepath.Dir(outputName)
+	if dir == "." || (!config.SeparateRuns && dir == filepath.Clean(workPath(config, "."))) {
+		return "videos"
+	}
+	return filepath.Base(dir)
+}
+
+// sourceListFilename returns the per-source URL list filename used by --split-by-source
+func sourceListFilename(collection string) string {
+	return collection + ".txt"
+}
+
+// writeEntriesBySource writes one URL list per source (favorites.txt, liked.txt, ...)
+// into dir instead of a single merged list. Returns the source names in first-seen order.
+func writeEntriesBySource(videoEntries []VideoEntry, dir string) ([]string, error) {
+	var sources []string
+	groups := make(map[string][]VideoEntry)
+	for _, entry := range videoEntries {
+		source := sanitizeCollectionName(entry.Collection)
+		if _, ok := groups[source]; !ok {
+			sources = append(sources, source)
+		}
+		groups[source] = append(groups[source], entry)
+	}
+
+	for _, source := range sources {
+		outputName := filepath.Join(dir, sourceListFilename(source))
+		if err := writeVideoEntriesToFile(groups[source], outputName); err != nil {
+			return nil, err
+		}
+		fmt.Printf("[*] Extracted %d video URLs to '%s'\n", len(groups[source]), outputName)
+	}
+	return sources, nil
+}
+
+// listRun is one yt-dlp run: a URL list, the videos in it and the config it
+// downloads with
+type listRun struct {
+	source  string
+	file    string
+	entries []VideoEntry
+	config  *Config
+}
+
+// separateSourceRuns sets up --separate-runs: each source gets its own subdirectory of
+// the work dir holding its URL list, downloads, archive and results.txt
+func separateSourceRuns(config *Config, videoEntries []VideoEntry) ([]listRun, error) {
+	var runs []listRun
+	seen := make(map[string]bool)
+	for _, entry := range videoEntries {
+		source := sanitizeCollectionName(entry.Collection)
+		if seen[source] {
+			continue
+		}
+		seen[source] = true
+
+		sourceConfig := *config
+		sourceConfig.WorkDir = workPath(config, source)
+		if err := os.MkdirAll(sourceConfig.WorkDir, 0755); err != nil {
+			return nil, fmt.Errorf("[!!!] Error creating %s: %v", sourceConfig.WorkDir, err)
+		}
+		sourceEntries := getEntriesForCollection(videoEntries, source)
+		outputName := workPath(&sourceConfig, sourceListFilename(source))
+		if err := writeVideoEntriesToFile(sourceEntries, outputName); err != nil {
+			return nil, err
+		}
+		fmt.Printf("[*] Extracted %d video URLs to '%s'\n", len(sourceEntries), outputName)
+		runs = append(runs, listRun{source, outputName, sourceEntries, &sourceConfig})
+	}
+	return runs, nil
+}
+
+// writeDownloadLists writes the URL lists for entries and returns a run for each: one
+// per collection in organize mode, otherwise the merged list or one per source
+func writeDownloadLists(config *Config, baseDir string, entries []VideoEntry) ([]listRun, error) {
+	if !config.OrganizeByCollection && config.SeparateRuns {
+		return separateSourceRuns(config, entries)
+	}
+	if !config.OrganizeByCollection && config.SplitBySource {
+		sources, err := writeEntriesBySource(entries, baseDir)
+		if err != nil {
+			return nil, err
+		}
+		var runs []listRun
+		for _, source := range sources {
+			runs = append(runs, listRun{source, filepath.Join(baseDir, sourceListFilename(source)), getEntriesForCollection(entries, source), config})
+		}
+		return runs, nil
+	}
+
+	if err := writeFavoriteVideosToDir(entries, baseDir, config.OutputName, config.OrganizeByCollection); err != nil {
+		return nil, err
+	}
+	if !config.OrganizeByCollection {
+		return []listRun{{"", workPath(config, config.OutputName), entries, config}}, nil
+	}
+	var runs []listRun
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		collection := sanitizeCollectionName(entry.Collection)
+		if seen[collection] {
+			continue
+		}
+		seen[collection] = true
+		listFile := filepath.Join(baseDir, collection, getOutputFilename(collection))
+		runs = append(runs, listRun{collection, listFile, getEntriesForCollection(entries, collection), config})
+	}
+	return runs, nil
+}
+
+// writeSourceResults writes the results file for a single --separate-runs source
+func writeSourceResults(run listRun, result *CollectionResult, start time.Time) error {
+	session := &DownloadSession{
+		StartTime:   start,
+		EndTime:     time.Now(),
+		Collections: []CollectionResult{*result},
+	}
+	session.TotalAttempted, session.TotalSuccess, session.TotalFailed, session.TotalSkipped =
+		calculateSessionTotals(session.Collections)
+	return writeResults(run.config, session, run.entries)
+}
+
+// launcherFilename returns the --write-launcher script name for goos
+func launcherFilename(goos string) string {
+	if goos == "windows" {
+		return "run_download.bat"
+	}
+	return "run_download.sh"
+}
+
+// absOrSelf returns the absolute form of path, or path itself if that fails
+func absOrSelf(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// suggestedYtdlpArgs returns the suggested yt-dlp command for one URL list as argv,
+// with absolute paths so a launcher works no matter where it's started from
+func suggestedYtdlpArgs(config *Config, listFile string, entries []VideoEntry) []string {
+	exe := config.YtdlpPath
+	if exe == "" {
+		exe = "yt-dlp.exe"
+	}
+	outputDir := filepath.Dir(listFile)
+	if !config.OrganizeByCollection {
+		outputDir = workPath(config, ".")
+	}
+	return []string{
+		absOrSelf(exe),
+		"-a", absOrSelf(listFile),
+		"--output", absOrSelf(resolveInDir(outputDir, outputTemplateFor(config, entries))),
+		"--write-info-json",
+		"--write-thumbnail",
+	}
+}
+
+// batchArgPattern matches arguments that are safe unquoted in a .bat file
+var batchArgPattern = regexp.MustCompile(`^[A-Za-z0-9_.:\\/=,+-]+$`)
+
+// quoteBatchArg quotes arg for a Windows batch file. % is doubled since batch files
+// would otherwise expand yt-dlp's %(field)s templates as variables.
+func quoteBatchArg(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	if batchArgPattern.MatchString(arg) {
+		return arg
+	}
+	return `"` + arg + `"`
+}
+
+// shellArgPattern matches arguments that are safe unquoted in a POSIX shell script
+var shellArgPattern = regexp.MustCompile(`^[A-Za-z0-9_.:/=,+-]+$`)
+
+// quoteShellArg single-quotes arg for a POSIX shell script when needed
+func quoteShellArg(arg string) string {
+	if shellArgPattern.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// quoteConsoleArg quotes arg for pasting into cmd.exe or PowerShell. Unlike
+// quoteBatchArg, % is left alone since only batch files expand %% pairs.
+func quoteConsoleArg(arg string) string {
+	if batchArgPattern.MatchString(arg) {
+		return arg
+	}
+	return `"` + arg + `"`
+}
+
+// commandLine joins args into one command line quoted for goos's shell
+func commandLine(goos string, args []string) string {
+	quote := quoteShellArg
+	if goos == "windows" {
+		quote = quoteConsoleArg
+	}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// writeLauncher writes a script to path that runs each command in turn: a .bat file
+// (CRLF, pauses at the end so a double-clicked window stays open) for Windows, or an
+// executable sh script elsewhere
+func writeLauncher(path, goos string, commands [][]string) error {
+	var b strings.Builder
+	if goos == "windows" {
+		b.WriteString("@echo off\r\n")
+		b.WriteString("rem Re-runs the yt-dlp download suggested by tiktok-favvideo-downloader\r\n")
+		for _, args := range commands {
+			quoted := make([]string, len(args))
+			for i, arg := range args {
+				quoted[i] = quoteBatchArg(arg)
+			}
+			b.WriteString(strings.Join(quoted, " ") + "\r\n")
+		}
+		b.WriteString("pause\r\n")
+	} else {
+		b.WriteString("#!/bin/sh\n")
+		b.WriteString("# Re-runs the yt-dlp download suggested by tiktok-favvideo-downloader\n")
+		for _, args := range commands {
+			quoted := make([]string, len(args))
+			for i, arg := range args {
+				quoted[i] = quoteShellArg(arg)
+			}
+			b.WriteString(strings.Join(quoted, " ") + "\n")
+		}
+	}
+
+	mode := os.FileMode(0644)
+	if goos != "windows" {
+		mode = 0755
+	}
+	if err := os.WriteFile(path, []byte(b.String()), mode); err != nil {
+		return fmt.Errorf("error writing launcher: %v", err)
+	}
+	// WriteFile keeps the mode of an existing file, so set it explicitly
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("error making launcher executable: %v", err)
+	}
+	return nil
+}
+
+// ytdlpConfigFilename returns the --write-ytdlp-conf file for listFile, e.g.
+// fav_videos.yt-dlp.conf. It's deliberately not yt-dlp.conf, which yt-dlp would load
+// by itself from the working directory on every later run.
+func ytdlpConfigFilename(listFile string) string {
+	return strings.TrimSuffix(listFile, filepath.Ext(listFile)) + ".yt-dlp.conf"
+}
+
+// ytdlpListPaths returns the output template path and download archive yt-dlp is given
+// for listFile, the same way runYtdlpWithRunner resolves them
+func ytdlpListPaths(config *Config, listFile string, entries []VideoEntry) (outputFormat, archivePath string) {
+	template := outputTemplateFor(config, entries)
+	if config.OrganizeByCollection {
+		dir := filepath.Dir(listFile)
+		return resolveInDir(dir, template), filepath.Join(dir, "download_archive.txt")
+	}
+	return workPath(config, template), workPath(config, "download_archive.txt")
+}
+
+// ytdlpConfigArgs returns the resolved yt-dlp options of a download of listFile, with
+// absolute paths so the config works from any directory
+func ytdlpConfigArgs(config *Config, listFile string, entries []VideoEntry) []string {
+	outputFormat, archivePath := ytdlpListPaths(config, listFile, entries)
+	args := []string{"--batch-file", absOrSelf(listFile)}
+	return append(args, ytdlpOptionArgs(config, absOrSelf(outputFormat), absOrSelf(archivePath), false)...)
+}
+
+// writeYtdlpConfig writes args to path as a yt-dlp config file, one option and its
+// values per line, so the run can be repeated with yt-dlp --config-location path
+func writeYtdlpConfig(path string, args []string) error {
+	var b strings.Builder
+	b.WriteString("# yt-dlp options written by tiktok-favvideo-downloader\n")
+	b.WriteString("# Run with: yt-dlp --config-location " + quoteShellArg(path) + "\n")
+	for i, arg := range args {
+		if i > 0 {
+			if strings.HasPrefix(arg, "-") {
+				b.WriteString("\n")
+			} else {
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString(quoteShellArg(arg))
+	}
+	b.WriteString("\n")
+
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("error writing yt-dlp config: %v", err)
+	}
+	return nil
+}
+
+// listChunk is one --chunk-size slice of a URL list and the batch file it was written to
+type listChunk struct {
+	File    string
+	Entries []VideoEntry
+}
+
+// chunkListFilename returns the name of the n-th (1-based) chunk of a list file,
+// e.g. fav_videos.txt -> fav_videos_001.txt
+func chunkListFilename(outputName string, n int) string {
+	ext := filepath.Ext(outputName)
+	return fmt.Sprintf("%s_%03d%s", strings.TrimSuffix(outputName, ext), n, ext)
+}
+
+// writeChunkedLists splits entries into batch files of at most chunkSize URLs next to
+// outputName (fav_videos_001.txt, fav_videos_002.txt, ...). A list that already fits
+// is returned as a single chunk using outputName itself, without writing anything.
+func writeChunkedLists(entries []VideoEntry, outputName string, chunkSize int) ([]listChunk, error) {
+	if chunkSize <= 0 || len(entries) <= chunkSize {
+		return []listChunk{{File: outputName, Entries: entries}}, nil
+	}
+
+	var chunks []listChunk
+	for start := 0; start < len(entries); start += chunkSize {
+		end := min(start+chunkSize, len(entries))
+		chunk := listChunk{File: chunkListFilename(outputName, len(chunks)+1), Entries: entries[start:end]}
+		if err := writeVideoEntriesToFile(chunk.Entries, chunk.File); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	fmt.Printf("[*] Split %d video URLs from '%s' into %d chunks of up to %d\n", len(entries), outputName, len(chunks), chunkSize)
+	return chunks, nil
+}
+
+// writeVideoEntriesToFile writes video entries to a single file
+func writeVideoEntriesToFile(videoEntries []VideoEntry, outputName string) error {
+	outFile, err := os.Create(outputName)
+	if err != nil {
+		return fmt.Errorf("[!!!] Error creating %s: %v", outputName, err)
+	}
+	defer func() { _ = outFile.Close() }()
+
+	for _, entry := range videoEntries {
+		_, writeErr := outFile.WriteString(entry.Link + "\n")
+		if writeErr != nil {
+			return fmt.Errorf("[!!!] Error writing to %s: %v", outputName, writeErr)
+		}
+	}
+	return nil
+}
+
+// isRunningInPowershell does a simple check to see if we're (likely) in PowerShell.
+func isRunningInPowershell() bool {
+	// A common environment variable set by PowerShell is PSModulePath,
+	// often containing 'PowerShell' in its path. This is a heuristic.
+	return strings.Contains(os.Getenv("PSModulePath"), "PowerShell")
+}
+
+// CommandRunner interface for testing command execution
+type CommandRunner interface {
+	Run(name string, args ...string) (CapturedOutput, error)
+}
+
+// ContextCommandRunner is implemented by runners whose commands can be cancelled
+// via a context (e.g. to enforce a per-video timeout)
+type ContextCommandRunner interface {
+	RunContext(ctx context.Context, name string, args ...string) (CapturedOutput, error)
+}
+
+// runWithContext runs the command with ctx if the runner supports cancellation,
+// otherwise it falls back to a plain Run
+func runWithContext(ctx context.Context, runner CommandRunner, name string, args ...string) (CapturedOutput, error) {
+	if ctxRunner, ok := runner.(ContextCommandRunner); ok {
+		return ctxRunner.RunContext(ctx, name, args...)
+	}
+	return runner.Run(name, args...)
+}
+
+// RealCommandRunner implements CommandRunner using exec.Command
+type RealCommandRunner struct {
+	ProgressRenderer *ProgressRenderer // Optional: if set, renders progress bar
+	ProgressState    *ProgressState    // Optional: if set, tracks progress
+	Checkpoint       io.Writer         // Optional: if set, receives a copy of stdout (see batchCheckpoint)
+	TUI              *tuiView          // Optional: if set, takes over the console (see --tui)
+}
+
+func (r *RealCommandRunner) Run(name string, args ...string) (CapturedOutput, error) {
+	return r.RunContext(context.Background(), name, args...)
+}
+
+// RunContext runs the command, killing it if ctx is cancelled or times out
+func (r *RealCommandRunner) RunContext(ctx context.Context, name string, args ...string) (CapturedOutput, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	// Don't wait forever on output pipes after the process has been killed
+	cmd.WaitDelay = 5 * time.Second
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	// Get stdout and stderr pipes for line-by-line reading
 	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
-		return CapturedOutput{}, err
+		return CapturedOutput{}, err
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return CapturedOutput{}, err
+	}
+
+	// Start the command
+	if err := cmd.Start(); err != nil {
+		return CapturedOutput{}, err
+	}
+
+	// Process output using the extracted function
+	// We pass tee readers so we can capture the raw output while processing it
+	stdoutSinks := []io.Writer{&stdoutBuf}
+	if r.Checkpoint != nil {
+		stdoutSinks = append(stdoutSinks, r.Checkpoint)
+	}
+	stderrSinks := []io.Writer{&stderrBuf}
+	var console, errConsole io.Writer = os.Stdout, os.Stderr
+	if r.TUI != nil {
+		// The TUI draws everything itself; the raw lines only go to the captured output
+		stdoutSinks = append(stdoutSinks, r.TUI)
+		stderrSinks = append(stderrSinks, r.TUI)
+		console, errConsole = io.Discard, io.Discard
+	}
+	stdoutTee := io.TeeReader(stdoutPipe, io.MultiWriter(stdoutSinks...))
+	stderrTee := io.TeeReader(stderrPipe, io.MultiWriter(stderrSinks...))
+
+	// Note: processOutput now returns just error, as it doesn't build the CapturedOutput
+	// We build CapturedOutput here from the buffers
+	processErr := processOutput(stdoutTee, stderrTee, console, errConsole, r.ProgressRenderer, r.ProgressState)
+
+	// Wait for command to complete
+	cmdErr := cmd.Wait()
+
+	// Combine output line-by-line
+	combined := combineOutputLines(stdoutBuf.String(), stderrBuf.String())
+
+	// Return command error if it failed, otherwise process error
+	finalErr := cmdErr
+	if finalErr == nil {
+		finalErr = processErr
+	}
+
+	return CapturedOutput{
+		Stdout:   stdoutBuf,
+		Stderr:   stderrBuf,
+		Combined: combined,
+	}, finalErr
+}
+
+// processOutput handles reading from stdout/stderr and updating progress
+// Separated from Run for testing purposes
+func processOutput(stdout, stderr io.Reader, stdoutWriter, stderrWriter io.Writer, renderer *ProgressRenderer, state *ProgressState) error {
+	// Process stdout and stderr line-by-line in goroutines
+	done := make(chan bool, 2)
+
+	// Process stdout
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			line := scanner.Text()
+
+			// File reports are only for us (see savedPathTemplate)
+			if isSavedPathLine(line) {
+				continue
+			}
+
+			// Check for progress line if progress rendering is enabled
+			if renderer != nil && state != nil {
+				current, _, isProgress, err := parseProgressLine(line)
+				if err == nil && isProgress {
+					// Update progress state
+					state.CurrentIndex = state.InitialSkipped + current
+					// state.TotalVideos is already set correctly
+					// Render progress bar
+					renderer.renderProgress(state)
+					continue // Don't print progress lines when using progress bar
+				}
+
+				// Check for skip line (already downloaded videos)
+				if isSkipLine(line) {
+					// Increment progress for skipped videos
+					state.CurrentIndex++
+					state.SkippedCount++
+					// Render progress bar
+					renderer.renderProgress(state)
+					continue // Don't print skip lines when using progress bar
+				}
+
+				// Check for error line (failed downloads)
+				if isErrorLine(line) {
+					// Increment failure count for errors
+					state.FailureCount++
+					// Don't render here - let it fall through to normal print logic
+					// which will clear, print, and re-render properly
+				}
+
+				// Check for verbose line when progress bar is enabled
+				if renderer.enabled && isVerboseLine(line) {
+					continue // Don't print verbose lines when using progress bar
+				}
+			}
+
+			// For non-progress lines or when progress bar is disabled
+			if renderer != nil && renderer.enabled {
+				// Clear progress bar before printing regular line
+				renderer.clearProgress()
+			}
+			_, _ = fmt.Fprintln(stdoutWriter, line) // Ignore errors writing to stdout
+			if renderer != nil && renderer.enabled {
+				// Re-render progress after printing line
+				renderer.renderProgress(state)
+			}
+		}
+		done <- true
+	}()
+
+	// Process stderr
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			line := scanner.Text()
+
+			// Check for error line (failed downloads) when progress bar is enabled
+			if renderer != nil && state != nil {
+				if isErrorLine(line) {
+					// Increment failure count for errors
+					state.FailureCount++
+				}
+			}
+
+			// Clear progress bar before printing error line
+			if renderer != nil && renderer.enabled {
+				renderer.clearProgress()
+			}
+			_, _ = fmt.Fprintln(stderrWriter, line) // Display line
+			// Re-render progress bar after printing error line
+			if renderer != nil && renderer.enabled {
+				renderer.renderProgress(state)
+			}
+		}
+		done <- true
+	}()
+
+	// Wait for both goroutines to finish
+	<-done
+	<-done
+
+	// Clear progress bar when processing finishes
+	if renderer != nil {
+		renderer.clearProgress()
+		_, _ = fmt.Fprintln(stdoutWriter) // Add newline after clearing
+	}
+
+	return nil
+}
+
+// combineOutputLines merges stdout and stderr into a single line-by-line array
+func combineOutputLines(stdout, stderr string) []string {
+	lines := make([]string, 0)
+	lines = append(lines, strings.Split(stdout, "\n")...)
+	lines = append(lines, strings.Split(stderr, "\n")...)
+	return lines
+}
+
+// savedPathPrefix marks the line yt-dlp prints for each finished download (see
+// savedPathTemplate), so the exact file is known instead of guessed from the template
+const savedPathPrefix = "[saved] "
+
+// savedPathTemplate makes yt-dlp print "[saved] <id> <path>" once a video has been
+// moved to its final name (after any merging or conversion)
+const savedPathTemplate = "after_move:" + savedPathPrefix + "%(id)s %(filepath)s"
+
+// isSavedPathLine reports whether line is a savedPathTemplate report
+func isSavedPathLine(line string) bool {
+	return strings.HasPrefix(line, savedPathPrefix)
+}
+
+// parseSavedPaths collects the files yt-dlp reported via savedPathTemplate, keyed by video ID
+func parseSavedPaths(lines []string) map[string]string {
+	saved := make(map[string]string)
+	for _, line := range lines {
+		if !isSavedPathLine(line) {
+			continue
+		}
+		id, path, ok := strings.Cut(strings.TrimPrefix(line, savedPathPrefix), " ")
+		path = strings.TrimSpace(path)
+		if ok && id != "" && path != "" && path != "NA" {
+			saved[id] = path
+		}
+	}
+	return saved
+}
+
+// applySavedPaths returns a copy of entries with LocalFilename set to the file yt-dlp
+// reported for each video, so indexing doesn't have to guess it
+func applySavedPaths(entries []VideoEntry, saved map[string]string) []VideoEntry {
+	if len(saved) == 0 {
+		return entries
+	}
+	result := make([]VideoEntry, len(entries))
+	copy(result, entries)
+	for i := range result {
+		if path, ok := saved[extractVideoID(result[i].Link)]; ok {
+			result[i].LocalFilename = filepath.Base(strings.ReplaceAll(path, "\\", "/"))
+		}
+	}
+	return result
+}
+
+// parseYtdlpOutput extracts failure details from yt-dlp output
+// yt-dlp error format: ERROR: [TikTok] VIDEO_ID: error message
+func parseYtdlpOutput(lines []string, entries []VideoEntry) []FailureDetail {
+	failures := make([]FailureDetail, 0)
+
+	// Build video ID to URL map
+	idToURL := make(map[string]string)
+	for _, entry := range entries {
+		if entry.VideoID != "" {
+			idToURL[entry.VideoID] = entry.Link
+		}
+	}
+
+	// Regex: ERROR: [TikTok] VIDEO_ID: error message
+	errorPattern := regexp.MustCompile(`ERROR:\s*\[TikTok\]\s*(\d+):\s*(.+)`)
+
+	for _, line := range lines {
+		matches := errorPattern.FindStringSubmatch(line)
+		if len(matches) >= 3 {
+			videoID := matches[1]
+			errorMsg := strings.TrimSpace(matches[2])
+
+			failures = append(failures, FailureDetail{
+				VideoID:      videoID,
+				VideoURL:     idToURL[videoID],
+				ErrorMessage: errorMsg,
+				ErrorType:    categorizeError(errorMsg),
+			})
+		}
+	}
+
+	return failures
+}
+
+// categorizeError classifies error messages into types
+func categorizeError(errorMsg string) ErrorType {
+	msgLower := strings.ToLower(errorMsg)
+
+	if strings.Contains(msgLower, "ip address is blocked") {
+		return ErrorIPBlocked
+	}
+	if strings.Contains(msgLower, "log in for access") ||
+		strings.Contains(msgLower, "not comfortable for some audiences") {
+		return ErrorAuthRequired
+	}
+	if strings.Contains(msgLower, "not available") ||
+		strings.Contains(msgLower, "private video") {
+		return ErrorNotAvailable
+	}
+	if strings.Contains(msgLower, "timeout") ||
+		strings.Contains(msgLower, "connection refused") {
+		return ErrorNetworkTimeout
+	}
+
+	return ErrorOther
+}
+
+// parseProgressLine extracts progress information from yt-dlp output
+// yt-dlp outputs progress lines like: "[download] Downloading item 5 of 127"
+// Returns: (currentIndex, total, isProgressLine, error)
+func parseProgressLine(line string) (int, int, bool, error) {
+	// Match pattern: [download] Downloading item X of Y
+	re := regexp.MustCompile(`\[download\] Downloading item (\d+) of (\d+)`)
+	matches := re.FindStringSubmatch(line)
+
+	if len(matches) != 3 {
+		return 0, 0, false, nil // Not a progress line
+	}
+
+	current, err1 := strconv.Atoi(matches[1])
+	total, err2 := strconv.Atoi(matches[2])
+
+	if err1 != nil || err2 != nil {
+		return 0, 0, false, fmt.Errorf("failed to parse progress numbers")
+	}
+
+	return current, total, true, nil
+}
+
+// isSkipLine detects when yt-dlp skips an already-downloaded video
+// yt-dlp outputs: "[download] <filename> has already been downloaded" or "has already been recorded in the archive"
+// Returns: true if this is a skip message
+func isSkipLine(line string) bool {
+	return strings.Contains(line, "has already been downloaded") ||
+		strings.Contains(line, "has already been recorded in the archive")
+}
+
+// isVerboseLine returns true if the line is routine yt-dlp output that can be suppressed
+// when progress bar is enabled. These are informational messages that add noise without value.
+// ERROR and WARNING messages are never considered verbose and will always be displayed.
+func isVerboseLine(line string) bool {
+	// Never suppress errors or warnings
+	if strings.Contains(line, "ERROR:") || strings.Contains(line, "WARNING:") {
+		return false
+	}
+
+	verbosePatterns := []string{
+		"[generic] Extracting URL:",
+		"[generic] ",
+		": Downloading webpage",
+		"[redirect] Following redirect to",
+		"[TikTok] Extracting URL:",
+		"[info] ",
+		": Downloading 1 format(s):",
+		"Video thumbnail is already present",
+		"Video metadata is already present",
+		"[download] 100%",
+	}
+
+	for _, pattern := range verbosePatterns {
+		if strings.Contains(line, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// consoleStdout is the real console while --run-log has os.Stdout redirected into its tee
+var consoleStdout *os.File
+
+// ansiEscapePattern matches ANSI escape sequences (colors, cursor movement, line clearing)
+var ansiEscapePattern = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// transcriptWriter turns one console stream into plain log lines: ANSI escapes are
+// removed and, for lines redrawn in place with \r (the progress bar), only the final
+// state is kept. Whole lines are written under mu, so the stdout and stderr writers
+// can share one log without splitting each other's lines.
+type transcriptWriter struct {
+	mu   *sync.Mutex
+	w    io.Writer
+	line []byte
+}
+
+func (t *transcriptWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b != '\n' {
+			t.line = append(t.line, b)
+			continue
+		}
+		if err := t.writeLine(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// writeLine writes the buffered line to the log and resets the buffer
+func (t *transcriptWriter) writeLine() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	line := ansiEscapePattern.ReplaceAllString(string(t.line), "")
+	if i := strings.LastIndex(strings.TrimRight(line, "\r"), "\r"); i >= 0 {
+		line = line[i+1:]
+	}
+	line = strings.TrimRight(line, "\r")
+	t.line = t.line[:0]
+	_, err := io.WriteString(t.w, line+"\n")
+	return err
+}
+
+// Flush writes any unterminated last line
+func (t *transcriptWriter) Flush() error {
+	if len(t.line) == 0 {
+		return nil
+	}
+	return t.writeLine()
+}
+
+// runLogFilename returns the --run-log file name for a run started at t
+func runLogFilename(t time.Time) string {
+	return "run-" + t.Format("20060102-150405") + ".log"
+}
+
+// startRunLog tees everything written to stdout and stderr into a plain-text log at
+// path, while still showing it on the console. The returned stop function restores
+// the console and closes the log.
+func startRunLog(path string) (func() error, error) {
+	logFile, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating run log %s: %v", path, err)
+	}
+	var logMu sync.Mutex
+	var transcripts []*transcriptWriter
+
+	origStdout, origStderr := os.Stdout, os.Stderr
+	var wg sync.WaitGroup
+	tee := func(console *os.File) (*os.File, error) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		transcript := &transcriptWriter{mu: &logMu, w: logFile}
+		transcripts = append(transcripts, transcript)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = io.Copy(io.MultiWriter(console, transcript), r)
+			_ = r.Close()
+		}()
+		return w, nil
+	}
+
+	stdoutPipe, err := tee(origStdout)
+	if err != nil {
+		_ = logFile.Close()
+		return nil, fmt.Errorf("error redirecting output to the run log: %v", err)
+	}
+	stderrPipe, err := tee(origStderr)
+	if err != nil {
+		_ = stdoutPipe.Close()
+		wg.Wait()
+		_ = logFile.Close()
+		return nil, fmt.Errorf("error redirecting output to the run log: %v", err)
+	}
+	os.Stdout, os.Stderr = stdoutPipe, stderrPipe
+	consoleStdout = origStdout
+
+	stop := func() error {
+		os.Stdout, os.Stderr = origStdout, origStderr
+		consoleStdout = nil
+		_ = stdoutPipe.Close()
+		_ = stderrPipe.Close()
+		wg.Wait()
+		for _, transcript := range transcripts {
+			if err := transcript.Flush(); err != nil {
+				_ = logFile.Close()
+				return err
+			}
+		}
+		return logFile.Close()
+	}
+	return stop, nil
+}
+
+// isErrorLine detects when yt-dlp encounters an error during download
+// yt-dlp outputs errors like: "ERROR: [TikTok] VIDEO_ID: error message"
+// Returns: true if this is an error message
+func isErrorLine(line string) bool {
+	return strings.Contains(line, "ERROR: [TikTok]")
+}
+
+// stdoutIsTerminal reports whether stdout is a console rather than a pipe or file. With
+// --run-log stdout is a pipe to the log tee, so look at the console behind it instead.
+func stdoutIsTerminal() bool {
+	stdout := os.Stdout
+	if consoleStdout != nil {
+		stdout = consoleStdout
+	}
+	fileInfo, err := stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return fileInfo.Mode()&os.ModeCharDevice != 0
+}
+
+// supportsANSI checks if the terminal supports ANSI escape codes
+func supportsANSI() bool {
+	// If output is piped or redirected, disable ANSI
+	if !stdoutIsTerminal() {
+		return false
+	}
+
+	// Check for TERM environment variable (common on Unix-like systems)
+	term := os.Getenv("TERM")
+	if term != "" && term != "dumb" {
+		return true
+	}
+
+	// Check for Windows Terminal or other modern Windows terminals
+	// Windows Terminal sets WT_SESSION
+	if os.Getenv("WT_SESSION") != "" {
+		return true
+	}
+
+	// ConEmu sets ConEmuANSI
+	if os.Getenv("ConEmuANSI") == "ON" {
+		return true
+	}
+
+	// Default to false for safety (no progress bar if unsure)
+	return false
+}
+
+// renderProgress displays a live progress bar using ANSI escape codes
+// Format: "Downloading favorites (87/92) | ████████████░░░ 94.6% | Success: 85 | Failed: 2"
+func (pr *ProgressRenderer) renderProgress(state *ProgressState) {
+	if !pr.enabled {
+		return
+	}
+
+	// Default to stdout if no writer specified
+	out := pr.writer
+	if out == nil {
+		out = os.Stdout
+	}
+
+	// Consoles without ANSI still understand \r, so keep one plain line updated in place
+	if pr.plain {
+		pr.printLine(out, "\r"+statusLine(state.CurrentIndex, state.TotalVideos, state.FailureCount))
+		return
+	}
+
+	// Calculate percentage
+	percentage := 0.0
+	if state.TotalVideos > 0 {
+		percentage = float64(state.CurrentIndex) / float64(state.TotalVideos) * 100
+	}
+
+	// Create progress bar (20 characters wide)
+	barWidth := 20
+	filledWidth := int(float64(barWidth) * percentage / 100)
+	if filledWidth > barWidth {
+		filledWidth = barWidth
+	}
+
+	bar := strings.Repeat("█", filledWidth) + strings.Repeat("░", barWidth-filledWidth)
+
+	// Color codes
+	green := "\033[32m"
+	yellow := "\033[33m"
+	red := "\033[31m"
+	reset := "\033[0m"
+
+	// Build progress line
+	line := fmt.Sprintf("\rDownloading %s (%d/%d) | %s %.1f%% | %sSuccess: %d%s | %sSkipped: %d%s | %sFailed: %d%s",
+		state.CollectionName,
+		state.CurrentIndex,
+		state.TotalVideos,
+		bar,
+		percentage,
+		green,
+		state.SuccessCount,
+		reset,
+		yellow,
+		state.SkippedCount,
+		reset,
+		red,
+		state.FailureCount,
+		reset,
+	)
+	pr.printLine(out, line)
+}
+
+// printLine prints a progress line over the previous one (line starts with \r)
+func (pr *ProgressRenderer) printLine(out io.Writer, line string) {
+	// Clear previous line if it was longer
+	if len(line) < pr.lastLineLen {
+		line += strings.Repeat(" ", pr.lastLineLen-len(line))
+	}
+	pr.lastLineLen = len(line)
+
+	// Print progress (using \r to overwrite current line)
+	_, _ = fmt.Fprint(out, line)
+}
+
+// statusLine formats the plain progress line, e.g. "Downloaded 412/1983, 21 failed"
+func statusLine(done, total, failed int) string {
+	return fmt.Sprintf("Downloaded %d/%d, %d failed", done, total, failed)
+}
+
+// clearProgress clears the progress bar line
+func (pr *ProgressRenderer) clearProgress() {
+	if !pr.enabled || pr.lastLineLen == 0 {
+		return
+	}
+
+	// Default to stdout if no writer specified
+	out := pr.writer
+	if out == nil {
+		out = os.Stdout
+	}
+
+	// Clear line and move to start
+	_, _ = fmt.Fprint(out, "\r"+strings.Repeat(" ", pr.lastLineLen)+"\r")
+	pr.lastLineLen = 0
+}
+
+// tuiStatus is where one video is in a --tui run
+type tuiStatus int
+
+const (
+	tuiPending tuiStatus = iota
+	tuiDownloading
+	tuiDone
+	tuiSkipped
+	tuiFailed
+)
+
+// tuiItem is one video in the --tui list
+type tuiItem struct {
+	URL    string
+	Status tuiStatus
+	Error  string
+}
+
+// extractingURLPattern matches the line yt-dlp prints when it starts on a URL
+var extractingURLPattern = regexp.MustCompile(`^\[TikTok\] Extracting URL: (\S+)`)
+
+// tuiErrorPattern matches yt-dlp's per-video error line (see parseYtdlpOutput)
+var tuiErrorPattern = regexp.MustCompile(`ERROR:\s*\[TikTok\]\s*(\d+):\s*(.+)`)
+
+// tuiModel is the state behind the --tui view, driven by yt-dlp's output lines. It
+// knows nothing about the terminal, so the transitions can be tested on their own.
+type tuiModel struct {
+	Collection string
+	Items      []tuiItem
+	byID       map[string]int
+	current    int   // index of the video being downloaded (-1 = none)
+	started    []int // indexes in the order yt-dlp started them
+}
+
+// newTUIModel creates a model with every entry pending
+func newTUIModel(collection string, entries []VideoEntry) *tuiModel {
+	m := &tuiModel{Collection: collection, byID: make(map[string]int), current: -1}
+	for i, entry := range entries {
+		m.Items = append(m.Items, tuiItem{URL: entry.Link})
+		if id := extractVideoID(entry.Link); id != "" {
+			if _, ok := m.byID[id]; !ok {
+				m.byID[id] = i
+			}
+		}
+	}
+	return m
+}
+
+// Update moves videos between states for one line of yt-dlp output. A video counts as
+// done once yt-dlp reports the finished download or moves on to the next URL.
+func (m *tuiModel) Update(line string) {
+	if match := extractingURLPattern.FindStringSubmatch(line); match != nil {
+		m.finishCurrent()
+		if i, ok := m.byID[extractVideoID(match[1])]; ok {
+			m.Items[i].Status = tuiDownloading
+			m.current = i
+			m.started = append(m.started, i)
+		}
+		return
+	}
+	if match := tuiErrorPattern.FindStringSubmatch(line); match != nil {
+		if i, ok := m.byID[match[1]]; ok {
+			m.Items[i].Status = tuiFailed
+			m.Items[i].Error = strings.TrimSpace(match[2])
+			if i == m.current {
+				m.current = -1
+			}
+		}
+		return
+	}
+	if m.current < 0 {
+		return
+	}
+	if isSkipLine(line) {
+		m.Items[m.current].Status = tuiSkipped
+		m.current = -1
+	} else if downloadedSizePattern.MatchString(line) {
+		m.finishCurrent()
+	}
+}
+
+// Finish marks the video still in progress as done once yt-dlp has exited
+func (m *tuiModel) Finish() {
+	m.finishCurrent()
+}
+
+func (m *tuiModel) finishCurrent() {
+	if m.current >= 0 && m.Items[m.current].Status == tuiDownloading {
+		m.Items[m.current].Status = tuiDone
+	}
+	m.current = -1
+}
+
+// Counts returns how many videos are done, skipped and failed
+func (m *tuiModel) Counts() (done, skipped, failed int) {
+	for _, item := range m.Items {
+		switch item.Status {
+		case tuiDone:
+			done++
+		case tuiSkipped:
+			skipped++
+		case tuiFailed:
+			failed++
+		}
+	}
+	return done, skipped, failed
+}
+
+// tuiRows is how many of the most recently started videos the --tui view lists
+const tuiRows = 10
+
+// Lines returns the view's text: a status header and the most recently started videos
+func (m *tuiModel) Lines() []string {
+	done, skipped, failed := m.Counts()
+	lines := []string{fmt.Sprintf("%s: %d/%d done, %d skipped, %d failed",
+		m.Collection, done+skipped+failed, len(m.Items), skipped, failed)}
+	recent := m.started[max(0, len(m.started)-tuiRows):]
+	for _, i := range recent {
+		item := m.Items[i]
+		switch item.Status {
+		case tuiDownloading:
+			lines = append(lines, "  ...  "+item.URL)
+		case tuiDone:
+			lines = append(lines, "  ok   "+item.URL)
+		case tuiSkipped:
+			lines = append(lines, "  skip "+item.URL)
+		case tuiFailed:
+			lines = append(lines, "  FAIL "+item.URL+" ("+item.Error+")")
+		}
+	}
+	return lines
+}
+
+// tuiView draws a tuiModel in place on an ANSI terminal, redrawing after every line of
+// yt-dlp output written to it. Safe for the stdout and stderr copies to share.
+type tuiView struct {
+	mu      sync.Mutex
+	model   *tuiModel
+	out     io.Writer
+	partial []byte
+	drawn   int // lines drawn last time, to move the cursor back over
+}
+
+func (v *tuiView) Write(p []byte) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.partial = append(v.partial, p...)
+	for {
+		newline := bytes.IndexByte(v.partial, '\n')
+		if newline < 0 {
+			break
+		}
+		v.model.Update(strings.TrimRight(string(v.partial[:newline]), "\r"))
+		v.partial = v.partial[newline+1:]
+	}
+	v.render()
+	return len(p), nil
+}
+
+// Finish settles the last video and leaves the final state on screen
+func (v *tuiView) Finish() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.model.Finish()
+	v.render()
+}
+
+func (v *tuiView) render() {
+	var b strings.Builder
+	if v.drawn > 0 {
+		fmt.Fprintf(&b, "\033[%dA", v.drawn)
+	}
+	lines := v.model.Lines()
+	for _, line := range lines {
+		b.WriteString("\033[2K" + line + "\n")
+	}
+	v.drawn = len(lines)
+	_, _ = io.WriteString(v.out, b.String())
+}
+
+// calculateSessionTotals aggregates totals across all collections
+func calculateSessionTotals(collections []CollectionResult) (attempted, success, failed, skipped int) {
+	for _, col := range collections {
+		attempted += col.Attempted
+		success += col.Success
+		failed += col.Failed
+		skipped += col.Skipped
+	}
+	return
+}
+
+// printSessionSummary displays end-of-session summary to console
+func printSessionSummary(session *DownloadSession) {
+	duration := session.EndTime.Sub(session.StartTime)
+
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("                        DOWNLOAD SESSION SUMMARY")
+	fmt.Println(strings.Repeat("=", 80))
+	fmt.Printf("Duration: %s\n", formatDuration(int(duration.Seconds())))
+	fmt.Printf("Total Videos Attempted: %d\n", session.TotalAttempted)
+	fmt.Printf("  ✓ Successfully Downloaded: %d\n", session.TotalSuccess)
+	fmt.Printf("  - Skipped (Already Downloaded): %d\n", session.TotalSkipped)
+	fmt.Printf("  ✗ Failed: %d\n\n", session.TotalFailed)
+
+	if len(session.Collections) > 1 {
+		fmt.Println("Collection Breakdown:")
+		for _, col := range session.Collections {
+			fmt.Printf("  %s:\n", col.Name)
+			fmt.Printf("    Attempted: %-4d | Success: %-4d | Skipped: %-4d | Failed: %d\n",
+				col.Attempted, col.Success, col.Skipped, col.Failed)
+		}
+		fmt.Println()
+	}
+
+	if len(session.Phases) > 0 {
+		fmt.Println("Timing Breakdown:")
+		for _, phase := range session.Phases {
+			fmt.Printf("  %-16s %s\n", phase.Name+":", phase.Duration.Round(time.Millisecond))
+		}
+		fmt.Println()
+	}
+
+	if session.TotalFailed > 0 {
+		fmt.Println("For detailed failure information, see results.txt")
+	}
+	fmt.Println(strings.Repeat("=", 80))
+}
+
+// formatDuration converts seconds to a human-readable duration string
+func formatDuration(seconds int) string {
+	if seconds < 60 {
+		return fmt.Sprintf("%ds", seconds)
+	}
+	minutes := seconds / 60
+	secs := seconds % 60
+	if minutes < 60 {
+		return fmt.Sprintf("%dm %ds", minutes, secs)
+	}
+	hours := minutes / 60
+	mins := minutes % 60
+	return fmt.Sprintf("%dh %dm %ds", hours, mins, secs)
+}
+
+// RunSummary is the structure of summary.json: the run's counts (as sent to
+// --webhook-url) plus the per-phase timing breakdown
+type RunSummary struct {
+	SchemaVersion int `json:"schema_version"`
+	WebhookPayload
+	Phases []PhaseTiming `json:"phases"`
+}
+
+// writeSummaryFile writes the session summary, including phase timings, as JSON to path
+func writeSummaryFile(path string, session *DownloadSession) error {
+	summary := RunSummary{SchemaVersion: SchemaVersion, WebhookPayload: newWebhookPayload(session), Phases: session.Phases}
+	if summary.Phases == nil {
+		summary.Phases = []PhaseTiming{}
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %v", path, err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// UnavailableVideo is one entry in unavailable.json
+type UnavailableVideo struct {
+	URL        string `json:"url"`
+	VideoID    string `json:"video_id"`
+	Collection string `json:"collection"`
+	Reason     string `json:"reason"`
+}
+
+// UnavailableReport is the structure of unavailable.json: videos yt-dlp reported as
+// deleted, private or otherwise gone, so they can be pruned from favorites
+type UnavailableReport struct {
+	SchemaVersion int                `json:"schema_version"`
+	Generated     time.Time          `json:"generated"`
+	Videos        []UnavailableVideo `json:"videos"`
+}
+
+// writeUnavailableFile writes the session's "not available" failures to path, replacing
+// any previous report. Returns the number of videos listed.
+func writeUnavailableFile(path string, session *DownloadSession) (int, error) {
+	report := UnavailableReport{SchemaVersion: SchemaVersion, Generated: session.EndTime, Videos: []UnavailableVideo{}}
+	for _, col := range session.Collections {
+		for _, failure := range col.FailureDetails {
+			if failure.ErrorType != ErrorNotAvailable {
+				continue
+			}
+			report.Videos = append(report.Videos, UnavailableVideo{
+				URL:        failure.VideoURL,
+				VideoID:    failure.VideoID,
+				Collection: col.Name,
+				Reason:     failure.ErrorMessage,
+			})
+		}
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode %s: %v", path, err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return 0, fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return len(report.Videos), nil
+}
+
+// WebhookCollection is the per-collection part of the --webhook-url payload
+type WebhookCollection struct {
+	Name      string `json:"name"`
+	Attempted int    `json:"attempted"`
+	Success   int    `json:"success"`
+	Failed    int    `json:"failed"`
+	Skipped   int    `json:"skipped"`
+}
+
+// WebhookPayload is the JSON summary POSTed to --webhook-url when a run ends.
+// Status is "success" when nothing failed, "failure" when videos failed or the run
+// stopped on an error (given in Error) and "skipped" when yt-dlp was never started.
+type WebhookPayload struct {
+	Status          string              `json:"status"`
+	Error           string              `json:"error,omitempty"`
+	Version         string              `json:"version"`
+	StartTime       time.Time           `json:"start_time"`
+	EndTime         time.Time           `json:"end_time"`
+	DurationSeconds float64             `json:"duration_seconds"`
+	Attempted       int                 `json:"attempted"`
+	Success         int                 `json:"success"`
+	Failed          int                 `json:"failed"`
+	Skipped         int                 `json:"skipped"`
+	Collections     []WebhookCollection `json:"collections"`
+}
+
+// newWebhookPayload summarizes a finished download session for --webhook-url
+func newWebhookPayload(session *DownloadSession) WebhookPayload {
+	payload := WebhookPayload{
+		Status:          "success",
+		Version:         version,
+		StartTime:       session.StartTime,
+		EndTime:         session.EndTime,
+		DurationSeconds: session.EndTime.Sub(session.StartTime).Seconds(),
+		Attempted:       session.TotalAttempted,
+		Success:         session.TotalSuccess,
+		Failed:          session.TotalFailed,
+		Skipped:         session.TotalSkipped,
+		Collections:     []WebhookCollection{},
+	}
+	if session.TotalFailed > 0 {
+		payload.Status = "failure"
+	}
+	for _, col := range session.Collections {
+		payload.Collections = append(payload.Collections, WebhookCollection{
+			Name:      col.Name,
+			Attempted: col.Attempted,
+			Success:   col.Success,
+			Failed:    col.Failed,
+			Skipped:   col.Skipped,
+		})
+	}
+	return payload
+}
+
+// finalWebhookPayload summarizes a run as it ends: runErr (the error the run stopped
+// on) makes it a failure, and a run that never started yt-dlp is "skipped"
+func finalWebhookPayload(session *DownloadSession, ytdlpRan bool, runErr error) WebhookPayload {
+	payload := newWebhookPayload(session)
+	switch {
+	case runErr != nil:
+		payload.Status = "failure"
+		payload.Error = runErr.Error()
+	case !ytdlpRan:
+		payload.Status = "skipped"
+	}
+	return payload
+}
+
+// Notification formats accepted by --notify-format
+const (
+	NotifyFormatJSON    = "json"
+	NotifyFormatDiscord = "discord"
+	NotifyFormatSlack   = "slack"
+)
+
+// DiscordMessage is a Discord webhook message: a short content line plus one embed
+type DiscordMessage struct {
+	Content string         `json:"content"`
+	Embeds  []DiscordEmbed `json:"embeds"`
+}
+
+// DiscordEmbed is a rich embed within a Discord message
+type DiscordEmbed struct {
+	Title       string              `json:"title"`
+	Description string              `json:"description,omitempty"`
+	Color       int                 `json:"color"`
+	Fields      []DiscordEmbedField `json:"fields"`
+	Timestamp   string              `json:"timestamp,omitempty"`
+}
+
+// DiscordEmbedField is a single name/value field in a Discord embed
+type DiscordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// SlackMessage is a Slack incoming-webhook message. Text is the fallback shown in
+// notifications; Blocks carry the formatted summary.
+type SlackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []SlackBlock `json:"blocks"`
+}
+
+// SlackBlock is a Block Kit block (header or section)
+type SlackBlock struct {
+	Type   string      `json:"type"`
+	Text   *SlackText  `json:"text,omitempty"`
+	Fields []SlackText `json:"fields,omitempty"`
+}
+
+// SlackText is a Block Kit text object ("plain_text" or "mrkdwn")
+type SlackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Embed colors for Discord notifications
+const (
+	discordColorSuccess = 0x2ECC71
+	discordColorFailure = 0xE74C3C
+)
+
+// webhookSummaryLine returns a one-line human-readable summary of a run
+func webhookSummaryLine(payload WebhookPayload) string {
+	switch {
+	case payload.Error != "":
+		return fmt.Sprintf("TikTok download failed: %s", payload.Error)
+	case payload.Status == "skipped":
+		return "TikTok download skipped: yt-dlp was not run"
+	case payload.Status == "success":
+		return fmt.Sprintf("TikTok download finished: %d downloaded, %d skipped", payload.Success, payload.Skipped)
+	}
+	return fmt.Sprintf("TikTok download finished with %d failures (%d downloaded, %d skipped)", payload.Failed, payload.Success, payload.Skipped)
+}
+
+// webhookDuration formats the run duration rounded to the second
+func webhookDuration(payload WebhookPayload) string {
+	return time.Duration(payload.DurationSeconds * float64(time.Second)).Round(time.Second).String()
+}
+
+// formatDiscordMessage shapes a run summary as a Discord message (content + embed)
+func formatDiscordMessage(payload WebhookPayload) DiscordMessage {
+	embed := DiscordEmbed{
+		Title:     "TikTok download summary",
+		Color:     discordColorSuccess,
+		Timestamp: payload.EndTime.UTC().Format(time.RFC3339),
+		Fields: []DiscordEmbedField{
+			{Name: "Attempted", Value: strconv.Itoa(payload.Attempted), Inline: true},
+			{Name: "Downloaded", Value: strconv.Itoa(payload.Success), Inline: true},
+			{Name: "Failed", Value: strconv.Itoa(payload.Failed), Inline: true},
+			{Name: "Skipped", Value: strconv.Itoa(payload.Skipped), Inline: true},
+			{Name: "Duration", Value: webhookDuration(payload), Inline: true},
+		},
+	}
+	if payload.Status != "success" {
+		embed.Color = discordColorFailure
+	}
+
+	var lines []string
+	for _, col := range payload.Collections {
+		lines = append(lines, fmt.Sprintf("**%s**: %d/%d downloaded, %d failed", col.Name, col.Success, col.Attempted, col.Failed))
+	}
+	embed.Description = strings.Join(lines, "\n")
+
+	return DiscordMessage{Content: webhookSummaryLine(payload), Embeds: []DiscordEmbed{embed}}
+}
+
+// formatSlackMessage shapes a run summary as a Slack message (fallback text + blocks)
+func formatSlackMessage(payload WebhookPayload) SlackMessage {
+	blocks := []SlackBlock{
+		{Type: "header", Text: &SlackText{Type: "plain_text", Text: "TikTok download summary"}},
+		{Type: "section", Fields: []SlackText{
+			{Type: "mrkdwn", Text: fmt.Sprintf("*Attempted*\n%d", payload.Attempted)},
+			{Type: "mrkdwn", Text: fmt.Sprintf("*Downloaded*\n%d", payload.Success)},
+			{Type: "mrkdwn", Text: fmt.Sprintf("*Failed*\n%d", payload.Failed)},
+			{Type: "mrkdwn", Text: fmt.Sprintf("*Skipped*\n%d", payload.Skipped)},
+			{Type: "mrkdwn", Text: fmt.Sprintf("*Duration*\n%s", webhookDuration(payload))},
+		}},
+	}
+
+	if len(payload.Collections) > 0 {
+		var lines []string
+		for _, col := range payload.Collections {
+			lines = append(lines, fmt.Sprintf("*%s*: %d/%d downloaded, %d failed", col.Name, col.Success, col.Attempted, col.Failed))
+		}
+		blocks = append(blocks, SlackBlock{Type: "section", Text: &SlackText{Type: "mrkdwn", Text: strings.Join(lines, "\n")}})
+	}
+
+	return SlackMessage{Text: webhookSummaryLine(payload), Blocks: blocks}
+}
+
+// buildWebhookBody encodes the run summary in the given --notify-format
+func buildWebhookBody(format string, payload WebhookPayload) ([]byte, error) {
+	switch format {
+	case "", NotifyFormatJSON:
+		return json.Marshal(payload)
+	case NotifyFormatDiscord:
+		return json.Marshal(formatDiscordMessage(payload))
+	case NotifyFormatSlack:
+		return json.Marshal(formatSlackMessage(payload))
+	default:
+		return nil, fmt.Errorf("unknown notification format %q (expected json, discord or slack)", format)
+	}
+}
+
+// sendWebhook POSTs the run summary as JSON to webhookURL, shaped according to
+// format (see buildWebhookBody). Any non-2xx response is treated as an error.
+func sendWebhook(client *http.Client, webhookURL, format string, payload WebhookPayload) error {
+	data, err := buildWebhookBody(format, payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %v", err)
+	}
+
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// notifyWebhook sends the summary of a run that is ending to --webhook-url. A failed
+// notification is only a warning, it doesn't change how the run ended.
+func notifyWebhook(config *Config, session *DownloadSession, ytdlpRan bool, runErr error) {
+	if session.EndTime.IsZero() {
+		session.EndTime = time.Now()
+	}
+	payload := finalWebhookPayload(session, ytdlpRan, runErr)
+	if err := sendWebhook(http.DefaultClient, config.WebhookURL, config.NotifyFormat, payload); err != nil {
+		fmt.Printf("[!] Warning: %v\n", err)
+	} else {
+		fmt.Println("[*] Sent run summary to webhook")
+	}
+}
+
+// isDownloadRun reports whether the command line downloads videos, as opposed to a
+// mode that only writes lists or reports (--ndjson, --count-only, --index-only, ...)
+func isDownloadRun(config *Config) bool {
+	return config.ReportOnly == "" && !config.NDJSON && !config.CountOnly && config.ExportCreators == "" &&
+		!config.PrintCommand && !config.DryRun && !config.IndexOnly
+}
+
+// Run report formats accepted by --results-format
+const (
+	ResultsFormatText   = "text"
+	ResultsFormatApache = "apache"
+)
+
+// resultsFilename returns the name of the run report written in the given format
+func resultsFilename(format string) string {
+	if format == ResultsFormatApache {
+		return "results.log"
+	}
+	return "results.txt"
+}
+
+// writeResults appends the session results to the run report in config's work dir,
+// in config.ResultsFormat. entries are the videos queued for download.
+func writeResults(config *Config, session *DownloadSession, entries []VideoEntry) error {
+	path := workPath(config, resultsFilename(config.ResultsFormat))
+	if config.ResultsFormat == ResultsFormatApache {
+		return writeApacheResults(path, session, entries)
+	}
+	return writeResultsFileTo(path, session)
+}
+
+// writeApacheResults appends one Apache combined-log-style line per queued video to
+// resultsPath, so the run can be fed to log analyzers. Downloads are logged as 200 with
+// the file size, failures with the HTTP status closest to their error type, and videos
+// yt-dlp skipped (already in the archive) as 304.
+func writeApacheResults(resultsPath string, session *DownloadSession, entries []VideoEntry) error {
+	failed := make(map[string]FailureDetail)
+	saved := make(map[string]string)
+	for _, col := range session.Collections {
+		for _, failure := range col.FailureDetails {
+			failed[failure.VideoURL] = failure
+		}
+		maps.Copy(saved, col.SavedFiles)
+	}
+
+	f, err := os.OpenFile(resultsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", resultsPath, err)
+	}
+	w := bufio.NewWriter(f)
+	for _, entry := range entries {
+		status, size := http.StatusNotModified, int64(0)
+		if failure, ok := failed[entry.Link]; ok {
+			status = failureStatus(failure.ErrorType)
+		} else if path, ok := saved[extractVideoID(entry.Link)]; ok {
+			status = http.StatusOK
+			if info, err := os.Stat(path); err == nil {
+				size = info.Size()
+			}
+		}
+		_, _ = fmt.Fprintln(w, apacheLogLine(session.EndTime, entry.Link, status, size))
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write %s: %v", resultsPath, err)
+	}
+	return f.Close()
+}
+
+// apacheLogLine formats one download in the Apache combined log format, e.g.
+// - - - [15/Oct/2026:10:00:00 +0000] "GET https://www.tiktok.com/@u/video/1 HTTP/1.1" 200 1234 "-" "yt-dlp"
+// Like Apache, a size of 0 is written as "-".
+func apacheLogLine(t time.Time, url string, status int, size int64) string {
+	bytes := "-"
+	if size > 0 {
+		bytes = strconv.FormatInt(size, 10)
+	}
+	return fmt.Sprintf(`- - - [%s] "GET %s HTTP/1.1" %d %s "-" "yt-dlp"`, t.Format("02/Jan/2006:15:04:05 -0700"), url, status, bytes)
+}
+
+// failureStatus maps a failure category to the HTTP status a log analyzer expects
+func failureStatus(errorType ErrorType) int {
+	switch errorType {
+	case ErrorIPBlocked:
+		return http.StatusTooManyRequests
+	case ErrorAuthRequired:
+		return http.StatusForbidden
+	case ErrorNotAvailable:
+		return http.StatusNotFound
+	case ErrorNetworkTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeResultsFileTo appends the session results to the given results file
+func writeResultsFileTo(resultsPath string, session *DownloadSession) error {
+	// Open in append mode, create if doesn't exist
+	f, err := os.OpenFile(resultsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", resultsPath, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	w := bufio.NewWriter(f)
+	defer func() { _ = w.Flush() }()
+
+	// Session separator (for multiple sessions in same file)
+	_, _ = fmt.Fprintf(w, "\n%s\n", strings.Repeat("=", 80))
+	_, _ = fmt.Fprintf(w, "TikTok Video Downloader - Session Results\n")
+	_, _ = fmt.Fprintf(w, "Generated: %s\n", session.EndTime.Format("2006-01-02 15:04:05"))
+	_, _ = fmt.Fprintf(w, "Duration: %s\n", formatDuration(int(session.EndTime.Sub(session.StartTime).Seconds())))
+	_, _ = fmt.Fprintf(w, "%s\n\n", strings.Repeat("=", 80))
+
+	// Summary
+	_, _ = fmt.Fprintf(w, "SUMMARY\n")
+	_, _ = fmt.Fprintf(w, "=======\n")
+	_, _ = fmt.Fprintf(w, "Total Videos Attempted: %d\n", session.TotalAttempted)
+	_, _ = fmt.Fprintf(w, "Successfully Downloaded: %d\n", session.TotalSuccess)
+	_, _ = fmt.Fprintf(w, "Skipped: %d\n", session.TotalSkipped)
+	_, _ = fmt.Fprintf(w, "Failed: %d\n\n", session.TotalFailed)
+
+	if session.TotalFailed == 0 {
+		_, _ = fmt.Fprintf(w, "All videos downloaded successfully!\n")
+		return nil
+	}
+
+	// Failed downloads
+	_, _ = fmt.Fprintf(w, "FAILED DOWNLOADS\n")
+	_, _ = fmt.Fprintf(w, "================\n\n")
+
+	for _, col := range session.Collections {
+		if len(col.FailureDetails) == 0 {
+			continue
+		}
+
+		_, _ = fmt.Fprintf(w, "Collection: %s (%d failures)\n", col.Name, len(col.FailureDetails))
+		_, _ = fmt.Fprintf(w, "%s\n\n", strings.Repeat("-", 50))
+
+		for i, failure := range col.FailureDetails {
+			_, _ = fmt.Fprintf(w, "%d. Video ID: %s\n", i+1, failure.VideoID)
+			_, _ = fmt.Fprintf(w, "   URL: %s\n", failure.VideoURL)
+			_, _ = fmt.Fprintf(w, "   Error Type: %s\n", failure.ErrorType.String())
+			_, _ = fmt.Fprintf(w, "   Error: %s\n\n", failure.ErrorMessage)
+		}
+	}
+
+	// Troubleshooting tips
+	_, _ = fmt.Fprintf(w, "\nTROUBLESHOOTING TIPS\n")
+	_, _ = fmt.Fprintf(w, "====================\n")
+	writeTroubleshootingTips(w, session)
+
+	return nil
+}
+
+// writeTroubleshootingTips writes context-specific troubleshooting advice
+func writeTroubleshootingTips(w *bufio.Writer, session *DownloadSession) {
+	// Count error types
+	errorCounts := make(map[ErrorType]int)
+	for _, col := range session.Collections {
+		for _, failure := range col.FailureDetails {
+			errorCounts[failure.ErrorType]++
+		}
+	}
+
+	// Write tips for each encountered error type
+	if count := errorCounts[ErrorIPBlocked]; count > 0 {
+		_, _ = fmt.Fprintf(w, "IP Blocked (%d videos):\n", count)
+		_, _ = fmt.Fprintf(w, "  - Your IP may be rate-limited by TikTok\n")
+		_, _ = fmt.Fprintf(w, "  - Try again after waiting 30-60 minutes\n")
+		_, _ = fmt.Fprintf(w, "  - Consider using a VPN or different network\n\n")
+	}
+
+	if count := errorCounts[ErrorAuthRequired]; count > 0 {
+		_, _ = fmt.Fprintf(w, "Authentication Required (%d videos):\n", count)
+		_, _ = fmt.Fprintf(w, "  - These videos require login to view (age-restricted content)\n")
+		_, _ = fmt.Fprintf(w, "  - Retry with cookies to download these videos:\n")
+		_, _ = fmt.Fprintf(w, "    * Use --cookies cookies.txt (Netscape format)\n")
+		_, _ = fmt.Fprintf(w, "    * OR use --cookies-from-browser firefox\n")
+		_, _ = fmt.Fprintf(w, "  - See: https://github.com/yt-dlp/yt-dlp/wiki/FAQ#how-do-i-pass-cookies-to-yt-dlp\n")
+		_, _ = fmt.Fprintf(w, "    NB: cookies-from-browser may not work with Chromium-based browsers, refer to yt-dlp issue 7271 https://github.com/yt-dlp/yt-dlp/issues/7271\n\n")
+	}
+
+	if count := errorCounts[ErrorNotAvailable]; count > 0 {
+		_, _ = fmt.Fprintf(w, "Not Available (%d videos):\n", count)
+		_, _ = fmt.Fprintf(w, "  - Videos may be deleted, private, or region-locked\n")
+		_, _ = fmt.Fprintf(w, "  - Check if the video still exists by opening the URL\n\n")
+	}
+
+	if count := errorCounts[ErrorNetworkTimeout]; count > 0 {
+		_, _ = fmt.Fprintf(w, "Network Timeout (%d videos):\n", count)
+		_, _ = fmt.Fprintf(w, "  - Check your internet connection\n")
+		_, _ = fmt.Fprintf(w, "  - Retry the download session\n\n")
+	}
+}
+
+// runYtdlpChunked runs yt-dlp once per --chunk-size batch file of the list and
+// combines the per-chunk results. Without --chunk-size it is just runYtdlp.
+func runYtdlpChunked(psPrefix, outputName string, config *Config, entries []VideoEntry) (*CollectionResult, error) {
+	chunks, err := writeChunkedLists(entries, outputName, config.ChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 1 {
+		return runYtdlpRetryingDrive(psPrefix, chunks[0].File, config, chunks[0].Entries)
+	}
+
+	var combined *CollectionResult
+	var lastErr error
+	for i, chunk := range chunks {
+		fmt.Printf("[*] Running chunk %d of %d (%s)\n", i+1, len(chunks), chunk.File)
+		result, err := runYtdlpRetryingDrive(psPrefix, chunk.File, config, chunk.Entries)
+		if err != nil {
+			lastErr = err
+		}
+		combined = mergeCollectionResults(combined, result)
+	}
+	return combined, lastErr
+}
+
+// driveRetryLimit is how many times a run is retried after its output drive disappeared
+const driveRetryLimit = 3
+
+// errOutputUnreachable reports that the output folder vanished during a run
+var errOutputUnreachable = errors.New("output folder is no longer reachable")
+
+// Windows error codes for a drive or network share that went away. They are only
+// checked on Windows, since the same numbers mean other things elsewhere.
+const (
+	errorNotReady       = syscall.Errno(21) // ERROR_NOT_READY
+	errorBadNetpath     = syscall.Errno(53) // ERROR_BAD_NETPATH
+	errorDevNotExist    = syscall.Errno(55) // ERROR_DEV_NOT_EXIST
+	errorNetnameDeleted = syscall.Errno(64) // ERROR_NETNAME_DELETED
+)
+
+// isDriveGoneError reports whether err means the drive being written to disappeared
+// (a USB stick pulled out, a network share dropped) rather than an ordinary failure
+func isDriveGoneError(err error) bool {
+	if errors.Is(err, errOutputUnreachable) {
+		return true
+	}
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	switch errno {
+	case syscall.ENODEV, syscall.ENXIO, syscall.EIO, syscall.ESTALE:
+		return true
+	case errorNotReady, errorBadNetpath, errorDevNotExist, errorNetnameDeleted:
+		return runtime.GOOS == "windows"
+	}
+	return false
+}
+
+// ytdlpDriveGonePattern matches the errors yt-dlp prints when a write fails because the
+// drive went away (Python's errno/WinError text for the codes isDriveGoneError checks)
+var ytdlpDriveGonePattern = regexp.MustCompile(`(?i)^ERROR:.*(\[Errno (5|6|19|116)\]|\[WinError (21|53|55|64)\]|input/output error|no such device|stale file handle|device is not ready|network name is no longer available|network path was not found)`)
+
+// ytdlpDriveGoneLine returns the first line of yt-dlp output reporting a write that
+// failed because the drive went away, or "" if there is none
+func ytdlpDriveGoneLine(lines []string) string {
+	for _, line := range lines {
+		if ytdlpDriveGonePattern.MatchString(strings.TrimSpace(line)) {
+			return strings.TrimSpace(line)
+		}
+	}
+	return ""
+}
+
+// ytdlpOutputDir returns the directory yt-dlp saves the videos of outputName's list
+// into: the collection directory in organize mode, otherwise the work dir
+func ytdlpOutputDir(config *Config, outputName string) string {
+	if config.OrganizeByCollection {
+		return filepath.Dir(outputName)
+	}
+	return workPath(config, ".")
+}
+
+// outputDirReachable returns errOutputUnreachable if dir can no longer be reached
+func outputDirReachable(dir string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("%w: %v", errOutputUnreachable, err)
+	}
+	return nil
+}
+
+// withDriveRetry runs op, and while it fails because the drive went away asks confirm
+// whether to try again (the user reconnects the drive first), up to retries times
+func withDriveRetry(dir string, retries int, confirm func(dir string) bool, op func() error) error {
+	err := op()
+	for attempt := 0; attempt < retries && isDriveGoneError(err); attempt++ {
+		if !confirm(dir) {
+			return err
+		}
+		err = op()
+	}
+	return err
+}
+
+// promptForDriveRetry asks the user to reconnect the drive holding dir
+func promptForDriveRetry(dir string) bool {
+	fmt.Printf("\n[!] %s can no longer be reached (was a USB or network drive disconnected?).\n", dir)
+	fmt.Print("[*] Reconnect it and press Enter to retry, or type 'skip' to give up on this batch: ")
+
+	scanner := bufio.NewScanner(os.Stdin)
+	if !scanner.Scan() {
+		return false
+	}
+	input := strings.TrimSpace(strings.ToLower(scanner.Text()))
+	return input != "skip" && input != "s"
+}
+
+// runYtdlpRetryingDrive is runYtdlp, re-running the batch (already downloaded videos
+// are skipped via the archive) if its output drive disappeared part way through
+func runYtdlpRetryingDrive(psPrefix, outputName string, config *Config, entries []VideoEntry) (*CollectionResult, error) {
+	dir := ytdlpOutputDir(config, outputName)
+	var result *CollectionResult
+	var runErr error
+	err := withDriveRetry(dir, driveRetryLimit, promptForDriveRetry, func() error {
+		result, runErr = runYtdlp(psPrefix, outputName, config, entries)
+		if isDriveGoneError(runErr) {
+			return runErr
+		}
+		return outputDirReachable(dir)
+	})
+	if err != nil && runErr == nil {
+		runErr = err
+	}
+	return result, runErr
+}
+
+// mergeCollectionResults adds the counts and failures of next into total. Either may be nil.
+func mergeCollectionResults(total, next *CollectionResult) *CollectionResult {
+	if next == nil {
+		return total
+	}
+	if total == nil {
+		merged := *next
+		merged.FailureDetails = append([]FailureDetail{}, next.FailureDetails...)
+		merged.SavedFiles = maps.Clone(next.SavedFiles)
+		return &merged
+	}
+	total.Attempted += next.Attempted
+	total.Success += next.Success
+	total.Failed += next.Failed
+	total.Skipped += next.Skipped
+	total.FailureDetails = append(total.FailureDetails, next.FailureDetails...)
+	if len(next.SavedFiles) > 0 {
+		if total.SavedFiles == nil {
+			total.SavedFiles = make(map[string]string)
+		}
+		maps.Copy(total.SavedFiles, next.SavedFiles)
+	}
+	return total
+}
+
+// runYtdlp runs the yt-dlp command for the user
+func runYtdlp(psPrefix, outputName string, config *Config, entries []VideoEntry) (*CollectionResult, error) {
+	// --tui needs cursor movement, so it falls back to the progress bar/plain output
+	// on consoles without ANSI support
+	if config.TUI && supportsANSI() {
+		view := &tuiView{model: newTUIModel(listCollectionName(config, outputName), entries), out: os.Stdout}
+		defer view.Finish()
+		return runYtdlpWithRunner(&RealCommandRunner{TUI: view}, psPrefix, outputName, config, entries)
+	}
+
+	// Create progress renderer if enabled
+	var renderer *ProgressRenderer
+	var state *ProgressState
+	// Consoles without ANSI support get the plain status line instead of the bar
+	if !config.DisableProgressBar && stdoutIsTerminal() {
+		collectionName := listCollectionName(config, outputName)
+		renderer = &ProgressRenderer{
+			enabled: true,
+			plain:   !supportsANSI(),
+			writer:  os.Stdout,
+		}
+		state = &ProgressState{
+			CollectionName: collectionName,
+			TotalVideos:    len(entries),
+		}
+	}
+
+	runner := &RealCommandRunner{
+		ProgressRenderer: renderer,
+		ProgressState:    state,
+	}
+
+	return runYtdlpWithRunner(runner, psPrefix, outputName, config, entries)
+}
+
+// ytdlpOptionArgs builds the yt-dlp options for a download into outputFormat, everything
+// but the URLs. reportSaved adds the --print of each saved file that the tool itself
+// parses (see savedPathTemplate), which a plain yt-dlp run has no use for.
+func ytdlpOptionArgs(config *Config, outputFormat, archivePath string, reportSaved bool) []string {
+	args := []string{
+		"--output", outputFormat,
+		"--write-info-json", // Save metadata JSON for each video
+	}
+	if reportSaved {
+		// Report each finished file; --print implies --quiet, so keep the normal output
+		args = append(args, "--print", savedPathTemplate, "--no-quiet")
+	}
+
+	// Add thumbnail download unless skipped
+	if !config.SkipThumbnails {
+		args = append(args, "--write-thumbnail")
+		args = append(args, "--convert-thumbnails", "jpg") // Ensure consistent .jpg extension
+	}
+
+	// Archive comments and subtitles if requested
+	args = append(args, metadataArgs(config)...)
+
+	// Add cookie arguments if configured
+	if config.CookieFile != "" {
+		args = append(args, "--cookies", config.CookieFile)
+	}
+	if config.CookieFromBrowser != "" {
+		args = append(args, "--cookies-from-browser", config.CookieFromBrowser)
+	}
+
+	// Steer yt-dlp's format choice towards the requested resolution range
+	if sortSpec := buildResolutionSort(config.MinResolution, config.MaxResolution); sortSpec != "" {
+		args = append(args, "-S", sortSpec)
+	}
+
+	// Forward custom HTTP headers in the order given
+	for _, header := range config.Headers {
+		args = append(args, "--add-header", header)
+	}
+
+	// Download fragments of each video in parallel
+	if config.ConcurrentFragments > 0 {
+		args = append(args, "--concurrent-fragments", strconv.Itoa(config.ConcurrentFragments))
+	}
+
+	// Skip videos over the size limit
+	if config.MaxFilesize != "" {
+		args = append(args, "--max-filesize", config.MaxFilesize)
+	}
+
+	// Add resume functionality flags unless disabled
+	if !config.DisableResume {
+		// Add flags for resume functionality
+		args = append(args, "--download-archive", archivePath)
+		args = append(args, "--no-overwrites")
+		args = append(args, "--continue")
+	}
+
+	// The user's own options (YTDLP_EXTRA_ARGS) go last so they can override ours
+	return append(args, config.ExtraArgs...)
+}
+
+// runYtdlpWithRunner allows dependency injection for testing
+func runYtdlpWithRunner(runner CommandRunner, psPrefix, outputName string, config *Config, entries []VideoEntry) (*CollectionResult, error) {
+	collectionName := listCollectionName(config, outputName)
+
+	// Calculate archive file path (matches logic below at lines 1159-1165)
+	var archivePath string
+	if config.OrganizeByCollection {
+		dir := filepath.Dir(outputName)
+		archivePath = filepath.Join(dir, "download_archive.txt")
+	} else {
+		archivePath = workPath(config, "download_archive.txt")
+	}
+
+	// Optimization: Filter out already downloaded videos if resume is enabled
+	videosToDownload := entries
+	skippedCount := 0
+
+	if !config.DisableResume {
+		archive, err := parseArchiveFile(archivePath)
+		if err == nil && len(archive) > 0 {
+			var filtered []VideoEntry
+			for _, entry := range entries {
+				videoID := extractVideoID(entry.Link)
+				// If ID found and in archive, skip
+				if videoID != "" && archive[videoID] {
+					skippedCount++
+				} else {
+					filtered = append(filtered, entry)
+				}
+			}
+			videosToDownload = filtered
+		}
+	}
+
+	// Offer to pick up an interrupted batch where it stopped. Videos before the
+	// checkpoint were already attempted, so they're skipped even if they failed.
+	progressPath := batchProgressPath(outputName)
+	if !config.DisableResume {
+		if progress, err := loadBatchProgress(progressPath); err != nil {
+			fmt.Printf("[!] Warning: Ignoring unreadable progress file: %v\n", err)
+		} else if pos := resumePosition(progress, videosToDownload); pos > 0 {
+			if config.AutoResume || promptForResume(progress) {
+				fmt.Printf("[*] Resuming %s from video %d of %d\n", collectionName, progress.Index+1, progress.Total)
+				skippedCount += pos
+				videosToDownload = videosToDownload[pos:]
+			}
+		}
+	}
+
+	// Update ProgressState if available
+	if realRunner, ok := runner.(*RealCommandRunner); ok && realRunner.ProgressState != nil {
+		realRunner.ProgressState.InitialSkipped = skippedCount
+		realRunner.ProgressState.SkippedCount = skippedCount
+		realRunner.ProgressState.CurrentIndex = skippedCount
+		// TotalVideos remains len(entries)
+	}
+
+	// If all videos are skipped, we can return early
+	if len(videosToDownload) == 0 {
+		fmt.Printf("[*] %s collection: All %d videos already downloaded (skipping yt-dlp)\n",
+			collectionName, len(entries))
+
+		return &CollectionResult{
+			Name:           collectionName,
+			Attempted:      len(entries),
+			Failed:         0,
+			Success:        len(entries), // All considered success (skipped)
+			Skipped:        len(entries),
+			FailureDetails: []FailureDetail{},
+		}, nil
+	}
+
+	// Nothing new is started once the --max-runtime budget is spent
+	ctx := context.Background()
+	if !config.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, config.Deadline)
+		defer cancel()
+	}
+	if ctx.Err() != nil || config.SizeBudget.Exhausted() {
+		fmt.Printf("[!] %s collection: %s reached, leaving %d videos for the next run\n",
+			collectionName, budgetFlag(config), len(videosToDownload))
+		return &CollectionResult{
+			Name:           collectionName,
+			Attempted:      skippedCount,
+			Success:        skippedCount,
+			Skipped:        skippedCount,
+			FailureDetails: []FailureDetail{},
+		}, nil
+	}
+
+	// If we have skipped some but not all, notify user
+	if skippedCount > 0 {
+		fmt.Printf("[*] %s collection: %d videos to download (%d skipped)\n",
+			collectionName, len(videosToDownload), skippedCount)
+	}
+
+	fmt.Println("[*] Running yt-dlp now...")
+	cmdStr := ytdlpCommand(config, psPrefix)
+
+	// Configure output format based on organization preference
+	// New format includes video ID and truncated title for better identification
+	var outputFormat string
+	template := outputTemplateFor(config, videosToDownload)
+	if config.OrganizeByCollection {
+		// Include directory from outputName so videos download to collection folder
+		outputFormat = resolveInDir(filepath.Dir(outputName), template)
+	} else {
+		// Flat structure with new format
+		outputFormat = workPath(config, template)
+	}
+
+	// Determine which file to pass to yt-dlp. With a per-video timeout, a run-time or a
+	// size budget, yt-dlp is invoked once per URL instead so it can be stopped between
+	// videos; --timings needs the same to time each video.
+	targetFile := outputName
+	perVideo := config.PerVideoTimeout > 0 || !config.Deadline.IsZero() || config.SizeBudget != nil || config.WriteTimings
+
+	// If we filtered the list, write a temporary file (per-video mode passes URLs directly)
+	if skippedCount > 0 && !perVideo {
+		tempFile := outputName + ".partial.txt"
+		// Ensure directory exists (should already exist from main, but just in case)
+		if config.OrganizeByCollection {
+			_ = os.MkdirAll(filepath.Dir(tempFile), 0755)
+		}
+
+		if err := writeVideoEntriesToFile(videosToDownload, tempFile); err != nil {
+			fmt.Printf("[!] Warning: Failed to create partial list: %v. Using full list.\n", err)
+			// Fallback to full list, reset offsets
+			if realRunner, ok := runner.(*RealCommandRunner); ok && realRunner.ProgressState != nil {
+				realRunner.ProgressState.InitialSkipped = 0
+				realRunner.ProgressState.SkippedCount = 0
+				realRunner.ProgressState.CurrentIndex = 0
+			}
+		} else {
+			targetFile = tempFile
+			defer func() { _ = os.Remove(tempFile) }() // Clean up temp file
+		}
+	}
+
+	args := ytdlpOptionArgs(config, outputFormat, archivePath, true)
+
+	// Record how far yt-dlp gets so an interrupted run can be resumed
+	var checkpoint *batchCheckpoint
+	if !config.DisableResume || !config.Deadline.IsZero() {
+		batch := videosToDownload
+		if targetFile == outputName && !perVideo {
+			batch = entries // Partial list wasn't written, yt-dlp sees the full list
+		}
+		checkpoint = newBatchCheckpoint(progressPath, outputName, entries, batch)
+		if realRunner, ok := runner.(*RealCommandRunner); ok {
+			realRunner.Checkpoint = checkpoint
+			defer func() { realRunner.Checkpoint = nil }()
+		}
+	}
+
+	// Execute and capture output
+	var output CapturedOutput
+	var timeouts []FailureDetail
+	var timings []VideoTiming
+	var remaining int
+	var err error
+	if perVideo {
+		output, timeouts, timings, remaining, err = runYtdlpPerVideo(ctx, runner, cmdStr, args, videosToDownload, config, checkpoint)
+	} else {
+		batchArgs := append([]string{"-a", targetFile}, args...)
+		if ua := config.UserAgents.Next(); ua != "" {
+			batchArgs = append(batchArgs, "--user-agent", ua)
+		}
+		output, err = runWithRetries(ctx, config.RunRetries, config.RunRetryDelay, func() (CapturedOutput, error) {
+			return runner.Run(cmdStr, batchArgs...)
+		})
+	}
+
+	if config.WriteTimings && len(timings) > 0 {
+		timingsPath := filepath.Join(filepath.Dir(outputName), timingsFilename)
+		if err := appendTimingsCSV(timingsPath, timings); err != nil {
+			fmt.Printf("[!] Warning: %v\n", err)
+		}
+	}
+
+	switch {
+	case remaining > 0:
+		// Keep the checkpoint so the next run can pick up the rest
+		fmt.Printf("[!] %s collection: %s reached, %d videos left. Re-run to continue.\n", collectionName, budgetFlag(config), remaining)
+	case err == nil:
+		// yt-dlp got to the end of the list, so there's nothing left to resume. A failed
+		// run keeps the checkpoint so the next one can pick up where it stopped.
+		_ = os.Remove(progressPath)
+	}
+
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrYtdlpRun, err)
+	}
+	// yt-dlp only reports a drive that went away as a failed write in its output
+	if line := ytdlpDriveGoneLine(output.Combined); line != "" {
+		err = fmt.Errorf("%w: %w: %s", ErrYtdlpRun, errOutputUnreachable, line)
+	}
+
+	// Parse output to extract failures
+	failures := parseYtdlpOutput(output.Combined, videosToDownload)
+	failures = append(failures, timeouts...)
+
+	// Build result summary
+	// Get final skipped count from state (includes those skipped by yt-dlp during run)
+	finalSkipped := skippedCount
+	if realRunner, ok := runner.(*RealCommandRunner); ok && realRunner.ProgressState != nil {
+		finalSkipped = realRunner.ProgressState.SkippedCount
+	}
+
+	attempted := len(entries) - remaining
+	result := &CollectionResult{
+		Name:           collectionName,
+		Attempted:      attempted,
+		Failed:         len(failures),
+		Success:        attempted - len(failures) - finalSkipped,
+		Skipped:        finalSkipped,
+		FailureDetails: failures,
+		SavedFiles:     parseSavedPaths(output.Combined),
+	}
+
+	// Safety check for negative success count
+	if result.Success < 0 {
+		result.Success = 0
+	}
+
+	if err != nil || len(failures) > 0 {
+		fmt.Printf("[!] Download completed with %d failures out of %d videos.\n",
+			result.Failed, len(videosToDownload))
+	} else {
+		if skippedCount > 0 {
+			fmt.Printf("[*] Successfully downloaded %d new videos.\n", result.Success)
+		} else {
+			fmt.Printf("[*] Successfully downloaded all %d videos.\n", result.Success)
+		}
+	}
+
+	return result, err
+}
+
+// BatchProgress is the checkpoint persisted while yt-dlp works through a URL list,
+// so a run that is killed mid-batch can be resumed from where it stopped
+type BatchProgress struct {
+	List      string    `json:"list"`
+	Index     int       `json:"index"` // 0-based position in the list of the video being downloaded
+	URL       string    `json:"url"`
+	Total     int       `json:"total"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// batchProgressPath returns the checkpoint file used for a URL list
+func batchProgressPath(outputName string) string {
+	return outputName + ".progress.json"
+}
+
+// saveBatchProgress writes the checkpoint atomically so a kill mid-write can't corrupt it
+func saveBatchProgress(path string, progress BatchProgress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("failed to encode progress: %v", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write progress file %s: %v", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to save progress file %s: %v", path, err)
+	}
+	return nil
+}
+
+// loadBatchProgress reads a checkpoint. Returns nil (not error) if there is none.
+func loadBatchProgress(path string) (*BatchProgress, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read progress file %s: %v", path, err)
+	}
+	var progress BatchProgress
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return nil, fmt.Errorf("failed to parse progress file %s: %v", path, err)
+	}
+	return &progress, nil
+}
+
+// resumePosition returns the index in entries of the checkpointed video, or -1 if the
+// checkpoint is missing or its video is no longer in the list. Matching by URL rather
+// than index keeps resume safe when the export changed between runs.
+func resumePosition(progress *BatchProgress, entries []VideoEntry) int {
+	if progress == nil || progress.URL == "" {
+		return -1
+	}
+	for i, entry := range entries {
+		if entry.Link == progress.URL {
+			return i
+		}
+	}
+	return -1
+}
+
+// promptForResume asks the user whether to resume an interrupted batch (default is yes)
+func promptForResume(progress *BatchProgress) bool {
+	fmt.Printf("[*] A previous run of '%s' stopped at video %d of %d (%s).\n",
+		progress.List, progress.Index+1, progress.Total, progress.UpdatedAt.Local().Format("2006-01-02 15:04"))
+	fmt.Print("[*] Resume from there? (Y/n, default is 'Y'): ")
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Scan()
+	input := strings.TrimSpace(strings.ToLower(scanner.Text()))
+
+	return input == "" || input == "y" || input == "yes"
+}
+
+// batchCheckpoint persists yt-dlp's position in a URL list. As an io.Writer it watches
+// yt-dlp's stdout for "Downloading item X of Y" lines; per-video runs call reached directly.
+type batchCheckpoint struct {
+	path    string
+	list    string
+	total   int
+	batch   []VideoEntry   // the list yt-dlp was given (may be a filtered subset)
+	indexOf map[string]int // position of each URL in the full list
+	partial []byte         // incomplete trailing line from the last Write
+}
+
+// newBatchCheckpoint creates a checkpoint for a run over batch, a subset of the full list entries
+func newBatchCheckpoint(path, list string, entries, batch []VideoEntry) *batchCheckpoint {
+	indexOf := make(map[string]int, len(entries))
+	for i, entry := range entries {
+		if _, ok := indexOf[entry.Link]; !ok {
+			indexOf[entry.Link] = i
+		}
+	}
+	return &batchCheckpoint{path: path, list: list, total: len(entries), batch: batch, indexOf: indexOf}
+}
+
+// reached records that yt-dlp has started on batch item i. Failures are only
+// reported, since losing a checkpoint must never abort a download.
+func (c *batchCheckpoint) reached(i int) {
+	if c == nil || i < 0 || i >= len(c.batch) {
+		return
+	}
+	url := c.batch[i].Link
+	progress := BatchProgress{
+		List:      c.list,
+		Index:     c.indexOf[url],
+		URL:       url,
+		Total:     c.total,
+		UpdatedAt: time.Now(),
+	}
+	if err := saveBatchProgress(c.path, progress); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
+
+// Write scans complete lines for yt-dlp progress messages. It never returns an error
+// so it can sit in an io.MultiWriter without interrupting output capture.
+func (c *batchCheckpoint) Write(p []byte) (int, error) {
+	c.partial = append(c.partial, p...)
+	for {
+		newline := bytes.IndexByte(c.partial, '\n')
+		if newline < 0 {
+			break
+		}
+		line := strings.TrimRight(string(c.partial[:newline]), "\r")
+		c.partial = c.partial[newline+1:]
+		if current, _, isProgress, err := parseProgressLine(line); err == nil && isProgress {
+			c.reached(current - 1)
+		}
+	}
+	return len(p), nil
+}
+
+// runYtdlpPerVideo invokes yt-dlp once per URL, giving each invocation its own
+// timeout (config.PerVideoTimeout) so a single hanging video cannot stall the whole
+// collection. Timed-out videos are returned as failures and the loop moves on to the
+// next URL. A zero timeout means no per-video limit. Other failures are retried per
+// config.RunRetries. Once ctx is done or config.SizeBudget is used up no further videos
+// are started; their count is returned as remaining. If checkpoint is non-nil, the position
+// is recorded before each video, so it points at the first unstarted video on early exit.
+// With a config.UserAgents rotator each invocation gets the next User-Agent. Each
+// started video's wall-clock time and downloaded size is returned in the timings.
+func runYtdlpPerVideo(ctx context.Context, runner CommandRunner, cmdStr string, args []string, entries []VideoEntry, config *Config, checkpoint *batchCheckpoint) (CapturedOutput, []FailureDetail, []VideoTiming, int, error) {
+	timeout := config.PerVideoTimeout
+	var combined CapturedOutput
+	var timeouts []FailureDetail
+	var timings []VideoTiming
+	var lastErr error
+
+	// Per-video runs don't print "Downloading item X of Y", so advance the progress bar here
+	var renderer *ProgressRenderer
+	var state *ProgressState
+	if realRunner, ok := runner.(*RealCommandRunner); ok {
+		renderer, state = realRunner.ProgressRenderer, realRunner.ProgressState
+	}
+
+	for i, entry := range entries {
+		checkpoint.reached(i)
+		if ctx.Err() != nil || config.SizeBudget.Exhausted() {
+			if renderer != nil {
+				renderer.clearProgress()
+			}
+			return combined, timeouts, timings, len(entries) - i, lastErr
+		}
+		if renderer != nil && state != nil {
+			state.CurrentIndex = state.InitialSkipped + i + 1
+			renderer.renderProgress(state)
+		}
+
+		videoArgs := append([]string{}, args...)
+		if ua := config.UserAgents.Next(); ua != "" {
+			videoArgs = append(videoArgs, "--user-agent", ua)
+		}
+		videoArgs = append(videoArgs, "--", entry.Link)
+
+		// The video in flight is allowed to finish when the run-wide budget runs out.
+		// A timed-out video isn't retried; it's reported as a timeout below.
+		var timedOut bool
+		start := time.Now()
+		output, err := runWithRetries(ctx, config.RunRetries, config.RunRetryDelay, func() (CapturedOutput, error) {
+			var videoCtx context.Context
+			var cancel context.CancelFunc
+			if timeout > 0 {
+				videoCtx, cancel = context.WithTimeout(context.Background(), timeout)
+			} else {
+				videoCtx, cancel = context.WithCancel(context.Background())
+			}
+			defer cancel()
+			output, err := runWithContext(videoCtx, runner, cmdStr, videoArgs...)
+			timedOut = errors.Is(videoCtx.Err(), context.DeadlineExceeded)
+			if timedOut {
+				return output, nil
+			}
+			return output, err
+		})
+
+		combined.Stdout.Write(output.Stdout.Bytes())
+		combined.Stderr.Write(output.Stderr.Bytes())
+		combined.Combined = append(combined.Combined, output.Combined...)
+		config.SizeBudget.Record(output.Combined)
+		timings = append(timings, VideoTiming{
+			VideoID: extractVideoID(entry.Link),
+			Start:   start,
+			End:     time.Now(),
+			Bytes:   downloadedBytes(output.Combined),
+		})
+
+		if timedOut {
+			if renderer != nil {
+				renderer.clearProgress()
+			}
+			fmt.Printf("[!] yt-dlp timed out after %s, skipping: %s\n", timeout, entry.Link)
+			timeouts = append(timeouts, FailureDetail{
+				VideoID:      extractVideoID(entry.Link),
+				VideoURL:     entry.Link,
+				ErrorMessage: fmt.Sprintf("yt-dlp timed out after %s", timeout),
+				ErrorType:    ErrorNetworkTimeout,
+			})
+			if state != nil {
+				state.FailureCount++
+			}
+			continue
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	return combined, timeouts, timings, 0, lastErr
+}
+
+// timingsFilename is the --timings report written next to each URL list
+const timingsFilename = "timings.csv"
+
+// VideoTiming is how long one yt-dlp invocation took in per-video mode and how much
+// it downloaded (0 for videos that were skipped or failed)
+type VideoTiming struct {
+	VideoID string
+	Start   time.Time
+	End     time.Time
+	Bytes   int64
+}
+
+// appendTimingsCSV appends timings to the CSV at path as id,seconds,bytes rows,
+// writing the header first if the file is new
+func appendTimingsCSV(path string, timings []VideoTiming) error {
+	_, statErr := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	w := csv.NewWriter(f)
+	if os.IsNotExist(statErr) {
+		_ = w.Write([]string{"id", "seconds", "bytes"})
+	}
+	for _, t := range timings {
+		seconds := strconv.FormatFloat(t.End.Sub(t.Start).Seconds(), 'f', 3, 64)
+		_ = w.Write([]string{t.VideoID, seconds, strconv.FormatInt(t.Bytes, 10)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return f.Close()
+}
+
+// runWithRetries calls run, and while it fails calls it again up to retries more times,
+// waiting delay before each attempt (--run-retries). This is a blunt retry of the whole
+// invocation, regardless of why it failed. Only the last attempt's output is returned,
+// so errors a retry got past aren't reported as failures. No retry is started once ctx
+// is done (--max-runtime), including while waiting.
+func runWithRetries(ctx context.Context, retries int, delay time.Duration, run func() (CapturedOutput, error)) (CapturedOutput, error) {
+	output, err := run()
+	for attempt := 1; attempt <= retries && err != nil; attempt++ {
+		if ctx.Err() != nil {
+			break
+		}
+		fmt.Printf("[!] yt-dlp failed (%v); retrying in %s (attempt %d of %d)\n", err, delay, attempt, retries)
+		select {
+		case <-ctx.Done():
+			fmt.Println("[!] Time budget used up; not retrying yt-dlp")
+			return output, err
+		case <-time.After(delay):
+		}
+		output, err = run()
+	}
+	return output, err
+}
+
+// HTML template for the visual index browser
+//
+//go:embed templates/index.html
+var htmlTemplate string
+
+// Gallery groupings accepted by --group-index-by
+const (
+	GroupIndexNone     = "none"
+	GroupIndexDate     = "date"
+	GroupIndexUploader = "uploader"
+)
+
+// buildIndexSections splits entries into gallery sections for the given grouping mode.
+// Returns nil for none, which keeps the flat grid.
+func buildIndexSections(mode string, entries []VideoEntry) []IndexSection {
+	switch mode {
+	case GroupIndexDate:
+		return groupEntriesByMonth(entries)
+	case GroupIndexUploader:
+		var sections []IndexSection
+		for _, group := range groupEntriesByUploader(entries) {
+			sections = append(sections, IndexSection{
+				ID:     "group-" + strings.ToLower(group.Handle),
+				Title:  "@" + group.Handle,
+				Videos: group.Videos,
+			})
+		}
+		return sections
+	default:
+		return nil
+	}
+}
+
+// groupEntriesByMonth groups entries by the month they were saved (favorited or liked),
+// newest first, with undated videos last. Videos keep their original order.
+func groupEntriesByMonth(entries []VideoEntry) []IndexSection {
+	const undated = "undated"
+	groups := make(map[string]*IndexSection)
+	var order []string
+	for _, entry := range entries {
+		key, title := undated, "Undated"
+		if date, err := time.Parse(exportDateLayout, strings.TrimSpace(entry.Date)); err == nil {
+			key, title = date.Format("2006-01"), date.Format("January 2006")
+		}
+		if _, ok := groups[key]; !ok {
+			groups[key] = &IndexSection{ID: "group-" + key, Title: title}
+			order = append(order, key)
+		}
+		groups[key].Videos = append(groups[key].Videos, entry)
+	}
+
+	// "undated" sorts after the digits, so reversing puts it first; move it back to the end
+	sort.Sort(sort.Reverse(sort.StringSlice(order)))
+	if len(order) > 0 && order[0] == undated {
+		order = append(order[1:], undated)
+	}
+	result := make([]IndexSection, 0, len(order))
+	for _, key := range order {
+		result = append(result, *groups[key])
+	}
+	return result
+}
+
+// groupEntriesByUploader groups entries by uploader handle (parsed from the URL, falling
+// back to yt-dlp's uploader_id), sorted alphabetically. Videos keep their original order.
+func groupEntriesByUploader(entries []VideoEntry) []UploaderGroup {
+	groups := make(map[string]*UploaderGroup)
+	var order []string
+	for _, entry := range entries {
+		handle := extractUploader(entry.Link)
+		if handle == "" {
+			handle = entry.CreatorID
+		}
+		if handle == "" {
+			handle = "unknown"
+		}
+		key := strings.ToLower(handle)
+		if _, ok := groups[key]; !ok {
+			groups[key] = &UploaderGroup{Handle: handle}
+			order = append(order, key)
+		}
+		groups[key].Videos = append(groups[key].Videos, entry)
+	}
+
+	sort.Strings(order)
+	result := make([]UploaderGroup, 0, len(order))
+	for _, key := range order {
+		result = append(result, *groups[key])
+	}
+	return result
+}
+
+// getTemplateFuncs returns template helper functions for HTML template rendering.
+//
+// Thread-safety: This function returns a new FuncMap on each call, so it is safe to
+// call concurrently from multiple goroutines. The returned FuncMap itself contains
+// closures that are stateless and safe for concurrent use within Go's html/template
+// package, which handles synchronization internally during template execution.
+//
+// Note: Currently, the application generates indexes sequentially, but this function
+// is designed to support concurrent index generation if needed in the future.
+func getTemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"formatDuration": func(seconds int) string {
+			m := seconds / 60
+			s := seconds % 60
+			return fmt.Sprintf("%d:%02d", m, s)
+		},
+		"formatNumber": func(n int64) string {
+			if n >= 1000000 {
+				return fmt.Sprintf("%.1fM", float64(n)/1000000)
+			}
+			if n >= 1000 {
+				return fmt.Sprintf("%.1fK", float64(n)/1000)
+			}
+			return fmt.Sprintf("%d", n)
+		},
+	}
+}
+
+// writeJSONIndex writes the collection index as JSON
+func writeJSONIndex(dir string, index *CollectionIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "index.json"), data, 0644)
+}
+
+// writeHTMLIndex generates the HTML visual browser
+func writeHTMLIndex(dir string, index *CollectionIndex) error {
+	tmpl, err := template.New("index").Funcs(getTemplateFuncs()).Parse(htmlTemplate)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(dir, "index.html"))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	return tmpl.Execute(f, index)
+}
+
+// defaultMediaExtensions are the file extensions yt-dlp produces for TikTok videos by default
+var defaultMediaExtensions = []string{".mp4", ".mkv", ".webm", ".mov"}
+
+// parseMediaExtensions parses a comma-separated extension list ("mp3, .M4A") into
+// lowercase extensions with a leading dot
+func parseMediaExtensions(list string) []string {
+	var exts []string
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts = append(exts, ext)
+	}
+	return exts
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Filename matching tolerances accepted by --filename-match, strictest first
+const (
+	FilenameMatchExact = "exact" // Only the file yt-dlp reported or named in .info.json
+	FilenameMatchID    = "id"    // Also look for the video's ID between underscores (default)
+	FilenameMatchFuzzy = "fuzzy" // Also the ID anywhere in the name, even for videos without .info.json
+)
+
+// findMediaFile looks for a downloaded media file for videoID (named *_<videoID>_*.<ext>)
+// with one of the given extensions, as loosely as match (--filename-match) allows.
+// Returns the base filename, or "" if none exists.
+// Files are matched by ID rather than by name, since yt-dlp may sanitize Unicode titles
+// and handles differently than the name it records in .info.json, and the directory is
+// listed rather than globbed so names like "[alice]" aren't read as patterns.
+func findMediaFile(collectionDir, videoID string, mediaExts []string, match string) string {
+	files, err := os.ReadDir(collectionDir)
+	if err != nil {
+		return ""
+	}
+
+	// Prefer an exact ID match on our "<upload_date>_<id>_" prefix, then any name
+	// containing the ID between underscores (custom output templates), then with
+	// --filename-match fuzzy any name containing the ID at all
+	fallback, fuzzy := "", ""
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !slices.Contains(mediaExts, strings.ToLower(filepath.Ext(name))) {
+			continue
+		}
+		if videoIDFromFilename(name) == videoID {
+			return name
+		}
+		if fallback == "" && strings.Contains(name, "_"+videoID+"_") {
+			fallback = name
+		}
+		if fuzzy == "" && match == FilenameMatchFuzzy && containsVideoID(name, videoID) {
+			fuzzy = name
+		}
+	}
+	if fallback != "" {
+		return fallback
+	}
+	return fuzzy
+}
+
+// containsVideoID reports whether name contains videoID as a whole number, so ID 123
+// doesn't match a file of video 91234
+func containsVideoID(name, videoID string) bool {
+	if videoID == "" {
+		return false
+	}
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	for start := 0; ; {
+		i := strings.Index(name[start:], videoID)
+		if i < 0 {
+			return false
+		}
+		i += start
+		end := i + len(videoID)
+		if (i == 0 || !isDigit(name[i-1])) && (end == len(name) || !isDigit(name[end])) {
+			return true
+		}
+		start = i + 1
+	}
+}
+
+// orphanMediaFile finds the media file of a video that has no .info.json, which only
+// --filename-match fuzzy looks for
+func orphanMediaFile(collectionDir, videoID string, mediaExts []string, match string) string {
+	if match != FilenameMatchFuzzy {
+		return ""
+	}
+	return findMediaFile(collectionDir, videoID, mediaExts, match)
+}
+
+// findInfoFiles returns the paths of the .info.json files in collectionDir. Like
+// findMediaFile it lists the directory instead of globbing; a missing directory has none.
+func findInfoFiles(collectionDir string) ([]string, error) {
+	files, err := os.ReadDir(collectionDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var infoFiles []string
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".info.json") {
+			infoFiles = append(infoFiles, filepath.Join(collectionDir, file.Name()))
+		}
+	}
+	return infoFiles, nil
+}
+
+// generateCollectionIndex creates JSON and HTML indexes for a collection after download.
+// It enriches entries with metadata from yt-dlp's .info.json files and generates
+// both index.json (machine-readable) and index.html (visual browser) files.
+func generateCollectionIndex(collectionDir string, entries []VideoEntry, failures []FailureDetail) error {
+	return generateCollectionIndexWithExtensions(collectionDir, entries, failures, nil, FilenameMatchID, GroupIndexNone)
+}
+
+// generateCollectionIndexWithExtensions is generateCollectionIndex matching downloaded
+// media by the given extensions (nil = defaultMediaExtensions), e.g. .mp3 for audio
+// downloads, with the match tolerance (--filename-match), and sectioning the gallery by
+// grouping (--group-index-by)
+func generateCollectionIndexWithExtensions(collectionDir string, entries []VideoEntry, failures []FailureDetail, mediaExts []string, match, grouping string) error {
+	collectionName := filepath.Base(collectionDir)
+	fmt.Printf("[*] Generating index for %s (%d videos)...\n", collectionName, len(entries))
+	// 1. Scan for .info.json files in the directory
+	infoFiles, err := findInfoFiles(collectionDir)
+	if err != nil {
+		return fmt.Errorf("collection %q: error scanning for info files: %v", collectionName, err)
+	}
+
+	enrichedEntries := enrichEntries(collectionDir, entries, failures, infoFiles, mediaExts, match)
+	return writeCollectionIndex(collectionDir, enrichedEntries, grouping)
+}
+
+// enrichEntries returns a copy of entries populated with metadata from the given
+// .info.json files and with each video's download status checked on disk.
+// Media files are matched by mediaExts (nil = defaultMediaExtensions).
+func enrichEntries(collectionDir string, entries []VideoEntry, failures []FailureDetail, infoFiles []string, mediaExts []string, match string) []VideoEntry {
+	collectionName := filepath.Base(collectionDir)
+	if len(mediaExts) == 0 {
+		mediaExts = defaultMediaExtensions
+	}
+
+	// 2. Build video ID to info map
+	infoMap := make(map[string]*YtdlpInfo)
+	for _, f := range infoFiles {
+		info, err := parseInfoJSON(f)
+		if err != nil {
+			fmt.Printf("[!] Warning: Failed to parse %s: %v\n", f, err)
+			continue
+		}
+		infoMap[info.ID] = info
+	}
+	fmt.Printf("[*] Found %d metadata files for %s\n", len(infoMap), collectionName)
+
+	// 3. Build failure map for quick lookup
+	failureMap := make(map[string]string)
+	for _, f := range failures {
+		failureMap[f.VideoID] = f.ErrorMessage
+	}
+
+	// 4. Create a copy of entries to avoid mutating the input slice
+	enrichedEntries := make([]VideoEntry, len(entries))
+	copy(enrichedEntries, entries)
+
+	// 5. Enrich entries with metadata
+	for i := range enrichedEntries {
+		videoID := extractVideoID(enrichedEntries[i].Link)
+		enrichedEntries[i].VideoID = videoID
+
+		// The file yt-dlp reported saving (see applySavedPaths) beats any guess below
+		reported := enrichedEntries[i].LocalFilename
+		if reported != "" && !fileExists(filepath.Join(collectionDir, reported)) {
+			reported = ""
+		}
+
+		// Warn if video ID could not be extracted from URL
+		if videoID == "" {
+			fmt.Printf("[!] Warning: Could not extract video ID from URL: %s\n", enrichedEntries[i].Link)
+			enrichedEntries[i].Downloaded = false
+			enrichedEntries[i].DownloadError = "Invalid URL format - could not extract video ID"
+			continue
+		}
+
+		if info, ok := infoMap[videoID]; ok {
+			enrichedEntries[i].Title = info.Title
+			enrichedEntries[i].Creator = info.Uploader
+			enrichedEntries[i].CreatorID = info.UploaderID
+			enrichedEntries[i].UploadDate = info.UploadDate
+			enrichedEntries[i].Description = info.Description
+			enrichedEntries[i].Duration = info.Duration
+			enrichedEntries[i].ViewCount = info.ViewCount
+			enrichedEntries[i].LikeCount = info.LikeCount
+			enrichedEntries[i].ThumbnailURL = info.Thumbnail
+
+			// Determine the local filename from the info (use basename only)
+			baseFilename := ""
+			if reported != "" {
+				baseFilename = reported
+				enrichedEntries[i].LocalFilename = baseFilename
+			} else if info.Filename != "" {
+				// Normalize path separators before extracting basename
+				// yt-dlp may write Windows-style paths (\) in .info.json even on Unix systems
+				// (e.g., if the file was created on Windows and read on Linux, or vice versa)
+				normalizedFilename := strings.ReplaceAll(info.Filename, "\\", "/")
+				baseFilename = filepath.Base(normalizedFilename)
+
+				// yt-dlp records the pre-conversion name (e.g. .mp4 when audio was extracted
+				// to .mp3), so look for the media file by ID if neither it nor its .part exists
+				if match != FilenameMatchExact &&
+					!fileExists(filepath.Join(collectionDir, baseFilename)) && !fileExists(filepath.Join(collectionDir, baseFilename+".part")) {
+					if found := findMediaFile(collectionDir, videoID, mediaExts, match); found != "" {
+						baseFilename = found
+					}
+				}
+				enrichedEntries[i].LocalFilename = baseFilename
+			} else {
+				// Fallback: If filename is not in .info.json, try to find the media file by video ID
+				// This handles cases where yt-dlp doesn't populate the filename field
+				// (.info.json, .part, .ytdl, etc. are excluded by the extension filter)
+				if match != FilenameMatchExact {
+					baseFilename = findMediaFile(collectionDir, videoID, mediaExts, match)
+				}
+				enrichedEntries[i].LocalFilename = baseFilename
+			}
+
+			// Check if video file actually exists (not just .info.json)
+			videoPath := filepath.Join(collectionDir, baseFilename)
+			partialPath := videoPath + ".part"
+
+			if _, err := os.Stat(partialPath); err == nil {
+				// Partial download exists
+				enrichedEntries[i].Downloaded = false
+				enrichedEntries[i].DownloadError = "Download incomplete (found .part file)"
+			} else if baseFilename != "" {
+				if _, err := os.Stat(videoPath); err == nil {
+					// Full video file exists
+					enrichedEntries[i].Downloaded = true
+				} else {
+					// Info exists but video file is missing
+					enrichedEntries[i].Downloaded = false
+					enrichedEntries[i].DownloadError = "Video file missing (metadata only)"
+				}
+			} else {
+				// No filename in metadata
+				enrichedEntries[i].Downloaded = false
+				enrichedEntries[i].DownloadError = "Metadata incomplete (missing filename)"
+			}
+
+			// Check for thumbnail file (try common extensions)
+			// Use the base filename (without extension) to search for thumbnails
+			if baseFilename != "" {
+				baseWithoutExt := strings.TrimSuffix(baseFilename, filepath.Ext(baseFilename))
+				for _, ext := range []string{".jpg", ".webp", ".png", ".JPG", ".WEBP", ".PNG"} {
+					thumbFilename := baseWithoutExt + ext
+					thumbPath := filepath.Join(collectionDir, thumbFilename)
+					if _, err := os.Stat(thumbPath); err == nil {
+						enrichedEntries[i].ThumbnailFile = thumbFilename
+						break
+					}
+				}
+			}
+		} else if reported != "" {
+			// Saved without readable metadata; the file itself is what counts
+			enrichedEntries[i].Downloaded = true
+		} else if found := orphanMediaFile(collectionDir, videoID, mediaExts, match); found != "" {
+			// No metadata, but --filename-match fuzzy found the video's file by ID
+			enrichedEntries[i].LocalFilename = found
+			enrichedEntries[i].Downloaded = true
+		} else {
+			enrichedEntries[i].LocalFilename = ""
+			enrichedEntries[i].Downloaded = false
+			// Use actual error message if available
+			if errMsg, ok := failureMap[videoID]; ok {
+				enrichedEntries[i].DownloadError = errMsg
+			} else {
+				enrichedEntries[i].DownloadError = "Video not downloaded or metadata unavailable"
+			}
+		}
+	}
+
+	return enrichedEntries
+}
+
+// writeCollectionIndex writes index.json and index.html for already-enriched entries,
+// sectioning the gallery by grouping (--group-index-by)
+func writeCollectionIndex(collectionDir string, enrichedEntries []VideoEntry, grouping string) error {
+	collectionName := filepath.Base(collectionDir)
+
+	// 5. Create index struct
+	index := CollectionIndex{
+		SchemaVersion: SchemaVersion,
+		Name:          filepath.Base(collectionDir),
+		GeneratedAt:   time.Now().Format("2006-01-02 15:04:05"),
+		TotalVideos:   len(enrichedEntries),
+		Videos:        enrichedEntries,
+		Uploaders:     groupEntriesByUploader(enrichedEntries),
+		Sections:      buildIndexSections(grouping, enrichedEntries),
+	}
+
+	// Count downloaded/failed
+	for _, e := range enrichedEntries {
+		if e.Downloaded {
+			index.Downloaded++
+		} else {
+			index.Failed++
+		}
+	}
+
+	// 5. Write JSON index
+	if err := writeJSONIndex(collectionDir, &index); err != nil {
+		return fmt.Errorf("collection %q: error writing JSON index: %v", collectionName, err)
+	}
+
+	// 6. Generate HTML index
+	if err := writeHTMLIndex(collectionDir, &index); err != nil {
+		return fmt.Errorf("collection %q: error writing HTML index: %v", collectionName, err)
+	}
+
+	return nil
+}
+
+// loadExistingIndex reads a previously generated index.json from dir.
+// Returns nil (not an error) if no index exists yet.
+func loadExistingIndex(dir string) (*CollectionIndex, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var index CollectionIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("error parsing existing index.json: %v", err)
+	}
+	return &index, nil
+}
+
+// mergeIndexEntries rebuilds the entry list in the order of entries, reusing the
+// known (already downloaded) index entries and taking the rest from fresh, which
+// must hold the remaining entries in the same relative order
+func mergeIndexEntries(entries []VideoEntry, known map[string]VideoEntry, fresh []VideoEntry) []VideoEntry {
+	merged := make([]VideoEntry, 0, len(entries))
+	next := 0
+	for _, entry := range entries {
+		if prev, ok := known[extractVideoID(entry.Link)]; ok {
+			// Keep metadata from the previous index, but export fields from this run
+			prev.Link = entry.Link
+			prev.Date = entry.Date
+			prev.Collection = entry.Collection
+			merged = append(merged, prev)
+			continue
+		}
+		merged = append(merged, fresh[next])
+		next++
+	}
+	return merged
+}
+
+// updateCollectionIndex merges new results into an existing index.json instead of
+// rebuilding it from scratch. Videos already recorded as downloaded (and still on
+// disk) are reused as-is, so only new or previously failed videos have their
+// .info.json parsed. Falls back to a full rebuild if there is no usable index.
+func updateCollectionIndex(collectionDir string, entries []VideoEntry, failures []FailureDetail, mediaExts []string, match, grouping string) error {
+	collectionName := filepath.Base(collectionDir)
+
+	existing, err := loadExistingIndex(collectionDir)
+	if err != nil {
+		fmt.Printf("[!] Warning: %v, rebuilding index for %s\n", err, collectionName)
+	}
+	if existing == nil {
+		return generateCollectionIndexWithExtensions(collectionDir, entries, failures, mediaExts, match, grouping)
+	}
+
+	known := make(map[string]VideoEntry)
+	for _, v := range existing.Videos {
+		if !v.Downloaded || v.VideoID == "" || v.LocalFilename == "" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(collectionDir, v.LocalFilename)); err == nil {
+			known[v.VideoID] = v
+		}
+	}
+
+	var pending []VideoEntry
+	pendingIDs := make(map[string]bool)
+	for _, entry := range entries {
+		id := extractVideoID(entry.Link)
+		if _, ok := known[id]; ok {
+			continue
+		}
+		pending = append(pending, entry)
+		pendingIDs[id] = true
+	}
+	fmt.Printf("[*] Updating index for %s (%d unchanged, %d to refresh)...\n", collectionName, len(entries)-len(pending), len(pending))
+
+	// Only parse the metadata files belonging to videos that need refreshing
+	allInfoFiles, err := findInfoFiles(collectionDir)
+	if err != nil {
+		return fmt.Errorf("collection %q: error scanning for info files: %v", collectionName, err)
+	}
+	var infoFiles []string
+	for _, f := range allInfoFiles {
+		for id := range pendingIDs {
+			if id != "" && strings.Contains(filepath.Base(f), "_"+id+"_") {
+				infoFiles = append(infoFiles, f)
+				break
+			}
+		}
+	}
+
+	fresh := enrichEntries(collectionDir, pending, failures, infoFiles, mediaExts, match)
+	return writeCollectionIndex(collectionDir, mergeIndexEntries(entries, known, fresh), grouping)
+}
+
+// indexCollection regenerates (or, with --incremental-index, updates) a collection's indexes,
+// plus mapping.json with --url-mapping, run_manifest.json with --run-manifest and the
+// --db results database
+func indexCollection(config *Config, collectionDir string, entries []VideoEntry, failures []FailureDetail) error {
+	var err error
+	if config.IncrementalIndex {
+		err = updateCollectionIndex(collectionDir, entries, failures, config.MediaExtensions, config.FilenameMatch, config.IndexGrouping)
+	} else {
+		err = generateCollectionIndexWithExtensions(collectionDir, entries, failures, config.MediaExtensions, config.FilenameMatch, config.IndexGrouping)
+	}
+	if err != nil || (!config.URLMapping && config.DBPath == "" && !config.Manifest && !config.RunManifest) {
+		return err
+	}
+
+	index, err := loadExistingIndex(collectionDir)
+	if err != nil || index == nil {
+		return err
+	}
+	if config.URLMapping {
+		if err := writeURLMapping(collectionDir, index.Videos); err != nil {
+			return err
+		}
+	}
+	if config.Manifest {
+		if err := writeManifest(collectionDir, index.Videos); err != nil {
+			return err
+		}
+	}
+	if config.RunManifest {
+		if _, err := writeRunManifest(collectionDir, index.Videos); err != nil {
+			return err
+		}
+	}
+	if config.DBPath != "" {
+		return writeResultsDB(config.DBPath, collectionDir, index.Videos)
+	}
+	return nil
+}
+
+// findFFmpeg returns the ffmpeg to use for --merge-output: ffmpeg.exe next to yt-dlp.exe
+// in the current directory, otherwise ffmpeg from PATH
+func findFFmpeg() (string, error) {
+	if fileExists("ffmpeg.exe") {
+		return filepath.Abs("ffmpeg.exe")
+	}
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return "", fmt.Errorf("--merge-output needs ffmpeg: put ffmpeg.exe next to yt-dlp.exe or add it to PATH")
+	}
+	return path, nil
+}
+
+// mergeInputFiles returns the downloaded media files for entries in list order, looking
+// in each entry's collection directory when organized by collection. Videos that were
+// not downloaded are left out, and a video in several collections is included once.
+func mergeInputFiles(config *Config, baseDir string, entries []VideoEntry) []string {
+	mediaExts := config.MediaExtensions
+	if mediaExts == nil {
+		mediaExts = defaultMediaExtensions
+	}
+
+	var files []string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		videoID := extractVideoID(entry.Link)
+		if videoID == "" || seen[videoID] {
+			continue
+		}
+		dir := baseDir
+		if config.OrganizeByCollection {
+			dir = filepath.Join(baseDir, sanitizeCollectionName(entry.Collection))
+		}
+		if name := findMediaFile(dir, videoID, mediaExts, config.FilenameMatch); name != "" {
+			seen[videoID] = true
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	return files
+}
+
+// writeConcatList writes an ffmpeg concat demuxer list naming each file by absolute
+// path, escaping single quotes as the demuxer expects
+func writeConcatList(path string, files []string) error {
+	var b strings.Builder
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return fmt.Errorf("error resolving %s: %v", file, err)
+		}
+		fmt.Fprintf(&b, "file '%s'\n", strings.ReplaceAll(abs, "'", `'\''`))
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("error writing ffmpeg concat list: %v", err)
+	}
+	return nil
+}
+
+// mergeDownloads concatenates files, in order, into output using ffmpeg's concat demuxer.
+// Streams are copied rather than re-encoded, so the merge is quick and lossless.
+func mergeDownloads(runner CommandRunner, ffmpeg string, files []string, output string) error {
+	if len(files) == 0 {
+		return fmt.Errorf("no downloaded videos to merge")
+	}
+
+	listFile := output + ".concat.txt"
+	if err := writeConcatList(listFile, files); err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(listFile) }()
+
+	fmt.Printf("[*] Merging %d videos into %s...\n", len(files), output)
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-f", "concat", "-safe", "0", "-i", listFile, "-c", "copy", output}
+	if _, err := runner.Run(ffmpeg, args...); err != nil {
+		return fmt.Errorf("ffmpeg failed to merge videos: %v", err)
+	}
+	return nil
+}
+
+// resultsSchema creates the --db results table. Rows are keyed by video ID (or the
+// URL when no ID could be extracted) so re-runs update rather than duplicate them.
+const resultsSchema = `CREATE TABLE IF NOT EXISTS results (
+	id            TEXT PRIMARY KEY,
+	url           TEXT NOT NULL,
+	uploader      TEXT,
+	date          TEXT,
+	status        TEXT NOT NULL,
+	local_path    TEXT,
+	downloaded_at TEXT
+)`
+
+// resultsUpsert inserts or updates one result row. downloaded_at keeps the time a
+// video was first seen downloaded, so re-indexing doesn't move it forward.
+const resultsUpsert = `INSERT INTO results (id, url, uploader, date, status, local_path, downloaded_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET
+	url = excluded.url,
+	uploader = excluded.uploader,
+	date = excluded.date,
+	status = excluded.status,
+	local_path = excluded.local_path,
+	downloaded_at = COALESCE(results.downloaded_at, excluded.downloaded_at)`
+
+// resultStatus returns the --db status for an index entry: "downloaded", "failed" or "pending"
+func resultStatus(entry VideoEntry) string {
+	switch {
+	case entry.Downloaded:
+		return "downloaded"
+	case entry.DownloadError != "":
+		return "failed"
+	default:
+		return "pending"
+	}
+}
+
+// writeResultsDB upserts one row per entry into the SQLite database at dbPath,
+// creating the database and results table if needed
+func writeResultsDB(dbPath, collectionDir string, entries []VideoEntry) error {
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return fmt.Errorf("error opening database %s: %v", dbPath, err)
+	}
+	defer func() { _ = db.Close() }()
+
+	if _, err := db.Exec(resultsSchema); err != nil {
+		return fmt.Errorf("error creating results table in %s: %v", dbPath, err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting database transaction: %v", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmt, err := tx.Prepare(resultsUpsert)
+	if err != nil {
+		return fmt.Errorf("error preparing database upsert: %v", err)
+	}
+	defer func() { _ = stmt.Close() }()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, entry := range entries {
+		id := entry.VideoID
+		if id == "" {
+			id = extractVideoID(entry.Link)
+		}
+		if id == "" {
+			id = entry.Link
+		}
+
+		uploader := entry.Creator
+		if uploader == "" {
+			uploader = entry.CreatorID
+		}
+
+		var localPath, downloadedAt sql.NullString
+		if entry.Downloaded {
+			downloadedAt = sql.NullString{String: now, Valid: true}
+			if entry.LocalFilename != "" {
+				localPath = sql.NullString{String: filepath.Join(collectionDir, entry.LocalFilename), Valid: true}
+			}
+		}
+
+		if _, err := stmt.Exec(id, entry.Link, uploader, entry.Date, resultStatus(entry), localPath, downloadedAt); err != nil {
+			return fmt.Errorf("error writing result for %s to database: %v", entry.Link, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing results to %s: %v", dbPath, err)
+	}
+	return nil
+}
+
+// writeURLMapping writes mapping.json in collectionDir, mapping each downloaded file's
+// local filename to the TikTok URL it came from
+func writeURLMapping(collectionDir string, entries []VideoEntry) error {
+	mapping := make(map[string]string)
+	for _, entry := range entries {
+		if entry.Downloaded && entry.LocalFilename != "" {
+			mapping[entry.LocalFilename] = entry.Link
+		}
+	}
+
+	data, err := json.MarshalIndent(mapping, "", "  ")
+	if err != nil {
+		return fmt.Errorf("collection %q: error encoding URL mapping: %v", filepath.Base(collectionDir), err)
+	}
+	if err := os.WriteFile(filepath.Join(collectionDir, "mapping.json"), data, 0644); err != nil {
+		return fmt.Errorf("collection %q: error writing URL mapping: %v", filepath.Base(collectionDir), err)
+	}
+	return nil
+}
+
+// manifestFilename is the per-collection record of downloaded files checked by "scan"
+const manifestFilename = "manifest.json"
+
+// ManifestFile records a downloaded file as it was when the manifest was written
+type ManifestFile struct {
+	VideoID string    `json:"video_id"`
+	File    string    `json:"file"`
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256"`
+	ModTime time.Time `json:"mod_time"` // Lets a rewrite skip re-hashing unchanged files
+}
+
+// Manifest is the contents of manifest.json
+type Manifest struct {
+	SchemaVersion int            `json:"schema_version"`
+	GeneratedAt   string         `json:"generated_at"`
+	Files         []ManifestFile `json:"files"`
+}
+
+// loadManifest reads manifest.json from dir. Returns nil without error if there is none.
+func loadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", filepath.Join(dir, manifestFilename), err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrJSONParse, filepath.Join(dir, manifestFilename), err)
+	}
+	return &manifest, nil
+}
+
+// hashFile returns the hex-encoded SHA-256 of a file's contents
+func hashFile(path string) (string, error) {
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = file.Close() }()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// writeManifest records the size and SHA-256 of each downloaded file in collectionDir
+// to manifest.json. Files whose size and modification time match the previous manifest
+// keep their recorded hash instead of being read again. Records of files that are no
+// longer downloaded are kept, so "scan" still reports them if they went missing.
+func writeManifest(collectionDir string, entries []VideoEntry) error {
+	previous, err := loadManifest(collectionDir)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]ManifestFile)
+	var order []string
+	if previous != nil {
+		for _, f := range previous.Files {
+			if _, ok := known[f.File]; !ok {
+				order = append(order, f.File)
+			}
+			known[f.File] = f
+		}
+	}
+
+	for _, entry := range entries {
+		if !entry.Downloaded || entry.LocalFilename == "" {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(collectionDir, entry.LocalFilename))
+		if err != nil {
+			continue
+		}
+		record := ManifestFile{VideoID: entry.VideoID, File: entry.LocalFilename, Size: info.Size(), ModTime: info.ModTime().UTC()}
+		if old, ok := known[record.File]; ok && old.Size == record.Size && old.ModTime.Equal(record.ModTime) {
+			record.SHA256 = old.SHA256
+		} else {
+			if record.SHA256, err = hashFile(filepath.Join(collectionDir, record.File)); err != nil {
+				return fmt.Errorf("collection %q: error hashing %s: %v", filepath.Base(collectionDir), record.File, err)
+			}
+		}
+		if _, ok := known[record.File]; !ok {
+			order = append(order, record.File)
+		}
+		known[record.File] = record
+	}
+
+	manifest := Manifest{SchemaVersion: SchemaVersion, GeneratedAt: time.Now().Format(time.RFC3339), Files: make([]ManifestFile, 0, len(order))}
+	for _, name := range order {
+		manifest.Files = append(manifest.Files, known[name])
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("collection %q: error encoding manifest: %v", filepath.Base(collectionDir), err)
+	}
+	if err := os.WriteFile(filepath.Join(collectionDir, manifestFilename), data, 0644); err != nil {
+		return fmt.Errorf("collection %q: error writing manifest: %v", filepath.Base(collectionDir), err)
+	}
+	return nil
+}
+
+// Run manifest files written per collection with --run-manifest
+const (
+	runManifestFilename    = "run_manifest.json"
+	runManifestCSVFilename = "run_manifest.csv"
+)
+
+// Video statuses in the run manifest
+const (
+	RunStatusDownloaded = "downloaded"
+	RunStatusFailed     = "failed"
+)
+
+// RunManifestEntry joins a video's export entry with its download result
+type RunManifestEntry struct {
+	VideoID   string `json:"video_id"`
+	URL       string `json:"url"`
+	Source    string `json:"source"`         // Collection the export listed it in
+	Date      string `json:"date,omitempty"` // Favorited/liked date from the export
+	Status    string `json:"status"`         // downloaded or failed
+	LocalPath string `json:"local_path,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// RunManifestTotals are the counts every report of a collection should agree on
+type RunManifestTotals struct {
+	Videos     int `json:"videos"`
+	Downloaded int `json:"downloaded"`
+	Failed     int `json:"failed"`
+}
+
+// RunManifest is the contents of run_manifest.json: one record per video ID, the
+// single join that the collection's results and CSV are derived from
+type RunManifest struct {
+	SchemaVersion int                `json:"schema_version"`
+	GeneratedAt   string             `json:"generated_at"`
+	Totals        RunManifestTotals  `json:"totals"`
+	Videos        []RunManifestEntry `json:"videos"`
+}
+
+// joinRunManifest joins indexed entries (export entry plus download status) into one
+// record per video ID, keeping the first entry for a repeated ID. Videos whose ID
+// couldn't be extracted are keyed by their URL. Paths are relative to the collection
+// and sizes are left for writeRunManifest.
+func joinRunManifest(entries []VideoEntry) *RunManifest {
+	manifest := &RunManifest{SchemaVersion: SchemaVersion, Videos: []RunManifestEntry{}}
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		key := entry.VideoID
+		if key == "" {
+			key = entry.Link
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		record := RunManifestEntry{VideoID: entry.VideoID, URL: entry.Link, Source: entry.Collection, Date: entry.Date, Status: RunStatusFailed, Error: entry.DownloadError}
+		if entry.Downloaded {
+			record.Status, record.LocalPath, record.Error = RunStatusDownloaded, entry.LocalFilename, ""
+			manifest.Totals.Downloaded++
+		} else {
+			manifest.Totals.Failed++
+		}
+		manifest.Videos = append(manifest.Videos, record)
+	}
+	manifest.Totals.Videos = len(manifest.Videos)
+	return manifest
+}
+
+// collectionResult summarizes the manifest as a session result: downloaded videos
+// count as successes and everything else as a failure with its recorded reason
+func (m *RunManifest) collectionResult(name string) CollectionResult {
+	result := CollectionResult{Name: name, Attempted: m.Totals.Videos, Success: m.Totals.Downloaded, FailureDetails: []FailureDetail{}}
+	for _, v := range m.Videos {
+		if v.Status == RunStatusDownloaded {
+			continue
+		}
+		result.FailureDetails = append(result.FailureDetails, FailureDetail{
+			VideoID:      v.VideoID,
+			VideoURL:     v.URL,
+			ErrorMessage: v.Error,
+			ErrorType:    categorizeError(v.Error),
+		})
+	}
+	result.Failed = len(result.FailureDetails)
+	return result
+}
+
+// writeRunManifestCSV writes the manifest's records to path as CSV, one row per video
+func writeRunManifestCSV(path string, manifest *RunManifest) error {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	_ = w.Write([]string{"video_id", "url", "source", "date", "status", "local_path", "size", "error"})
+	for _, v := range manifest.Videos {
+		size := ""
+		if v.Size > 0 {
+			size = strconv.FormatInt(v.Size, 10)
+		}
+		_ = w.Write([]string{v.VideoID, v.URL, v.Source, v.Date, v.Status, v.LocalPath, size, v.Error})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("error encoding %s: %v", path, err)
+	}
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	return nil
+}
+
+// writeRunManifest writes run_manifest.json and run_manifest.csv for the indexed entries
+// of collectionDir (--run-manifest), with the size of each downloaded file
+func writeRunManifest(collectionDir string, entries []VideoEntry) (*RunManifest, error) {
+	manifest := joinRunManifest(entries)
+	manifest.GeneratedAt = time.Now().Format(time.RFC3339)
+	for i, v := range manifest.Videos {
+		if v.LocalPath == "" {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(collectionDir, v.LocalPath)); err == nil {
+			manifest.Videos[i].Size = info.Size()
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("collection %q: error encoding run manifest: %v", filepath.Base(collectionDir), err)
+	}
+	if err := os.WriteFile(filepath.Join(collectionDir, runManifestFilename), data, 0644); err != nil {
+		return nil, fmt.Errorf("collection %q: error writing run manifest: %v", filepath.Base(collectionDir), err)
+	}
+	if err := writeRunManifestCSV(filepath.Join(collectionDir, runManifestCSVFilename), manifest); err != nil {
+		return nil, fmt.Errorf("collection %q: %v", filepath.Base(collectionDir), err)
+	}
+	return manifest, nil
+}
+
+// Drift kinds reported by "scan"
+const (
+	DriftDeleted = "deleted"
+	DriftChanged = "changed"
+)
+
+// ManifestDrift is a file that no longer matches its manifest record
+type ManifestDrift struct {
+	Path   string // File path including its directory
+	Kind   string // DriftDeleted or DriftChanged
+	Detail string
+}
+
+// scanManifest checks every file recorded in dir's manifest.json against the disk,
+// hashing up to concurrency files at once. Drift is reported in manifest order.
+// Returns found=false if dir has no manifest.
+func scanManifest(dir string, concurrency int) (drift []ManifestDrift, checked int, found bool, err error) {
+	manifest, err := loadManifest(dir)
+	if err != nil || manifest == nil {
+		return nil, 0, false, err
+	}
+
+	results := make([]*ManifestDrift, len(manifest.Files))
+	errs := make([]error, len(manifest.Files))
+	sem := make(chan struct{}, max(concurrency, 1))
+	var wg sync.WaitGroup
+	for i, f := range manifest.Files {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i], errs[i] = checkManifestFile(filepath.Join(dir, f.File), f)
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, len(manifest.Files), true, err
+	}
+	for _, d := range results {
+		if d != nil {
+			drift = append(drift, *d)
+		}
+	}
+	return drift, len(manifest.Files), true, nil
+}
+
+// checkManifestFile compares the file at path with its manifest record. Returns nil
+// if it is unchanged.
+func checkManifestFile(path string, f ManifestFile) (*ManifestDrift, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return &ManifestDrift{Path: path, Kind: DriftDeleted, Detail: "file is missing"}, nil
 	}
-	stderrPipe, err := cmd.StderrPipe()
+	if info.Size() != f.Size {
+		return &ManifestDrift{Path: path, Kind: DriftChanged, Detail: fmt.Sprintf("size %d, recorded %d", info.Size(), f.Size)}, nil
+	}
+	sum, err := hashFile(path)
 	if err != nil {
-		return CapturedOutput{}, err
+		return nil, fmt.Errorf("error hashing %s: %v", path, err)
 	}
-
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return CapturedOutput{}, err
+	if !strings.EqualFold(sum, f.SHA256) {
+		return &ManifestDrift{Path: path, Kind: DriftChanged, Detail: "contents differ (SHA-256 mismatch)"}, nil
 	}
+	return nil, nil
+}
 
-	// Process output using the extracted function
-	// We pass tee readers so we can capture the raw output while processing it
-	stdoutTee := io.TeeReader(stdoutPipe, &stdoutBuf)
-	stderrTee := io.TeeReader(stderrPipe, &stderrBuf)
+// runScan verifies the manifests in root (flat layout) and in each of its
+// subdirectories (collection layout), hashing up to concurrency files at once and
+// printing any drift. Returns the number of drifted files.
+func runScan(root string, concurrency int, out io.Writer) (int, error) {
+	dirs := []string{root}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return 0, fmt.Errorf("error reading %s: %v", root, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, filepath.Join(root, entry.Name()))
+		}
+	}
 
-	// Note: processOutput now returns just error, as it doesn't build the CapturedOutput
-	// We build CapturedOutput here from the buffers
-	processErr := processOutput(stdoutTee, stderrTee, os.Stdout, os.Stderr, r.ProgressRenderer, r.ProgressState)
+	manifests, checked, drifted := 0, 0, 0
+	for _, dir := range dirs {
+		drift, n, found, err := scanManifest(dir, concurrency)
+		if err != nil {
+			return drifted, err
+		}
+		if !found {
+			continue
+		}
+		manifests++
+		checked += n
+		for _, d := range drift {
+			_, _ = fmt.Fprintf(out, "[!] %s: %s (%s)\n", d.Kind, d.Path, d.Detail)
+		}
+		drifted += len(drift)
+	}
 
-	// Wait for command to complete
-	cmdErr := cmd.Wait()
+	if manifests == 0 {
+		return 0, fmt.Errorf("no %s found in %s (write one with --manifest)", manifestFilename, root)
+	}
+	if drifted == 0 {
+		_, _ = fmt.Fprintf(out, "[*] All %d recorded files are intact\n", checked)
+	} else {
+		_, _ = fmt.Fprintf(out, "[!] %d of %d recorded files have changed or are missing\n", drifted, checked)
+	}
+	return drifted, nil
+}
 
-	// Combine output line-by-line
-	combined := combineOutputLines(stdoutBuf.String(), stderrBuf.String())
+// parseScanArgs parses the arguments of "scan [--concurrency N] [dir]". Without
+// --concurrency, one file per CPU is hashed at a time.
+func parseScanArgs(args []string) (dir string, concurrency int, err error) {
+	dir, concurrency = ".", runtime.NumCPU()
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value, isFlag := "", false
+		switch {
+		case arg == "--concurrency" || arg == "-concurrency":
+			if i+1 >= len(args) {
+				return "", 0, fmt.Errorf("--concurrency needs a number")
+			}
+			i++
+			value, isFlag = args[i], true
+		case strings.HasPrefix(arg, "--concurrency="):
+			value, isFlag = strings.TrimPrefix(arg, "--concurrency="), true
+		default:
+			dir = arg
+		}
+		if isFlag {
+			if concurrency, err = strconv.Atoi(value); err != nil || concurrency < 1 {
+				return "", 0, fmt.Errorf("--concurrency must be a positive integer")
+			}
+		}
+	}
+	return dir, concurrency, nil
+}
 
-	// Return command error if it failed, otherwise process error
-	finalErr := cmdErr
-	if finalErr == nil {
-		finalErr = processErr
+// readURLList reads a yt-dlp batch file (one URL per line, "#" comments allowed)
+// such as fav_videos.txt back into video entries
+func readURLList(path string) ([]VideoEntry, error) {
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("error opening URL list: %v", err)
 	}
+	defer func() { _ = file.Close() }()
 
-	return CapturedOutput{
-		Stdout:   stdoutBuf,
-		Stderr:   stderrBuf,
-		Combined: combined,
-	}, finalErr
+	var entries []VideoEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		entries = append(entries, VideoEntry{Link: line})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading URL list %s: %v", path, err)
+	}
+	return entries, nil
 }
 
-// processOutput handles reading from stdout/stderr and updating progress
-// Separated from Run for testing purposes
-func processOutput(stdout, stderr io.Reader, stdoutWriter, stderrWriter io.Writer, renderer *ProgressRenderer, state *ProgressState) error {
-	// Process stdout and stderr line-by-line in goroutines
-	done := make(chan bool, 2)
+// collectionResultFromIndex summarizes an index as a session result through the same
+// join as the run manifest, so results.txt counts each video once like run_manifest.json
+func collectionResultFromIndex(name string, index *CollectionIndex) CollectionResult {
+	return joinRunManifest(index.Videos).collectionResult(name)
+}
 
-	// Process stdout
-	go func() {
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			line := scanner.Text()
+// regenerateReports rebuilds index.json/index.html for each collection under dir (or dir
+// itself when organizeByCollection is false) from what is on disk, then appends a
+// results.txt section to dir. No downloads are attempted.
+func regenerateReports(dir string, entries []VideoEntry, organizeByCollection bool, mediaExts []string, match, grouping string) (*DownloadSession, error) {
+	session := &DownloadSession{StartTime: time.Now()}
 
-			// Check for progress line if progress rendering is enabled
-			if renderer != nil && state != nil {
-				current, _, isProgress, err := parseProgressLine(line)
-				if err == nil && isProgress {
-					// Update progress state
-					state.CurrentIndex = state.InitialSkipped + current
-					// state.TotalVideos is already set correctly
-					// Render progress bar
-					renderer.renderProgress(state)
-					continue // Don't print progress lines when using progress bar
-				}
+	type reportTarget struct {
+		dir     string
+		entries []VideoEntry
+	}
+	var targets []reportTarget
+	if organizeByCollection {
+		var collections []string
+		seen := make(map[string]bool)
+		for _, entry := range entries {
+			collection := sanitizeCollectionName(entry.Collection)
+			if !seen[collection] {
+				seen[collection] = true
+				collections = append(collections, collection)
+			}
+		}
+		for _, collection := range collections {
+			collectionDir := filepath.Join(dir, collection)
+			if info, err := os.Stat(collectionDir); err != nil || !info.IsDir() {
+				fmt.Printf("[!] Skipping %s: no %s directory in %s\n", collection, collection, dir)
+				continue
+			}
+			targets = append(targets, reportTarget{collectionDir, getEntriesForCollection(entries, collection)})
+		}
+	} else {
+		targets = append(targets, reportTarget{dir, entries})
+	}
 
-				// Check for skip line (already downloaded videos)
-				if isSkipLine(line) {
-					// Increment progress for skipped videos
-					state.CurrentIndex++
-					state.SkippedCount++
-					// Render progress bar
-					renderer.renderProgress(state)
-					continue // Don't print skip lines when using progress bar
-				}
+	for _, target := range targets {
+		if err := generateCollectionIndexWithExtensions(target.dir, target.entries, nil, mediaExts, match, grouping); err != nil {
+			return nil, err
+		}
+		index, err := loadExistingIndex(target.dir)
+		if err != nil {
+			return nil, err
+		}
+		session.Collections = append(session.Collections, collectionResultFromIndex(filepath.Base(target.dir), index))
+	}
 
-				// Check for error line (failed downloads)
-				if isErrorLine(line) {
-					// Increment failure count for errors
-					state.FailureCount++
-					// Don't render here - let it fall through to normal print logic
-					// which will clear, print, and re-render properly
-				}
+	session.EndTime = time.Now()
+	session.TotalAttempted, session.TotalSuccess, session.TotalFailed, session.TotalSkipped =
+		calculateSessionTotals(session.Collections)
 
-				// Check for verbose line when progress bar is enabled
-				if renderer.enabled && isVerboseLine(line) {
-					continue // Don't print verbose lines when using progress bar
-				}
-			}
+	if err := writeResultsFileTo(filepath.Join(dir, "results.txt"), session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
 
-			// For non-progress lines or when progress bar is disabled
-			if renderer != nil && renderer.enabled {
-				// Clear progress bar before printing regular line
-				renderer.clearProgress()
-			}
-			_, _ = fmt.Fprintln(stdoutWriter, line) // Ignore errors writing to stdout
-			if renderer != nil && renderer.enabled {
-				// Re-render progress after printing line
-				renderer.renderProgress(state)
-			}
+// getEntriesForCollection filters video entries for a specific collection
+func getEntriesForCollection(entries []VideoEntry, collection string) []VideoEntry {
+	var result []VideoEntry
+	for _, e := range entries {
+		if sanitizeCollectionName(e.Collection) == collection {
+			result = append(result, e)
 		}
-		done <- true
-	}()
+	}
+	return result
+}
 
-	// Process stderr
-	go func() {
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			line := scanner.Text()
+// DoctorCheck is the outcome of a single environment diagnostic
+type DoctorCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
 
-			// Check for error line (failed downloads) when progress bar is enabled
-			if renderer != nil && state != nil {
-				if isErrorLine(line) {
-					// Increment failure count for errors
-					state.FailureCount++
-				}
-			}
+// DoctorReport is the output of "doctor --json"
+type DoctorReport struct {
+	Version   string        `json:"version"`
+	AllPassed bool          `json:"all_passed"`
+	Checks    []DoctorCheck `json:"checks"`
+}
 
-			// Clear progress bar before printing error line
-			if renderer != nil && renderer.enabled {
-				renderer.clearProgress()
-			}
-			_, _ = fmt.Fprintln(stderrWriter, line) // Display line
-			// Re-render progress bar after printing error line
-			if renderer != nil && renderer.enabled {
-				renderer.renderProgress(state)
-			}
-		}
-		done <- true
-	}()
+// doctorEndpoints are the sites the doctor command checks network reachability for
+var doctorEndpoints = []struct {
+	Name string
+	URL  string
+}{
+	{"github.com reachable", "https://github.com"},
+	{"tiktok.com reachable", "https://www.tiktok.com"},
+}
 
-	// Wait for both goroutines to finish
-	<-done
-	<-done
+// silentCommandRunner runs a command and captures its output without echoing it
+type silentCommandRunner struct{}
 
-	// Clear progress bar when processing finishes
-	if renderer != nil {
-		renderer.clearProgress()
-		_, _ = fmt.Fprintln(stdoutWriter) // Add newline after clearing
+func (silentCommandRunner) Run(name string, args ...string) (CapturedOutput, error) {
+	var out CapturedOutput
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &out.Stdout
+	cmd.Stderr = &out.Stderr
+	err := cmd.Run()
+	out.Combined = combineOutputLines(out.Stdout.String(), out.Stderr.String())
+	return out, err
+}
+
+// checkDirWritable verifies files can be created in dir by writing and removing a temp file
+func checkDirWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return err
 	}
+	name := f.Name()
+	_ = f.Close()
+	return os.Remove(name)
+}
 
+// ensureOutputWritable fails early with a clear message if dir is not writable,
+// rather than letting a later os.Create fail with a cryptic error mid-run
+func ensureOutputWritable(dir string) error {
+	if err := checkDirWritable(dir); err != nil {
+		absDir, absErr := filepath.Abs(dir)
+		if absErr != nil {
+			absDir = dir
+		}
+		return fmt.Errorf("output directory %q is not writable (%v). Move the program to a folder you can write to, or check the folder's permissions", absDir, err)
+	}
 	return nil
 }
 
-// combineOutputLines merges stdout and stderr into a single line-by-line array
-func combineOutputLines(stdout, stderr string) []string {
-	lines := make([]string, 0)
-	lines = append(lines, strings.Split(stdout, "\n")...)
-	lines = append(lines, strings.Split(stderr, "\n")...)
-	return lines
+// checkYtdlp verifies yt-dlp is present and can report its version
+func checkYtdlp(runner CommandRunner, exeName string) DoctorCheck {
+	check := DoctorCheck{Name: "yt-dlp present"}
+	if _, err := os.Stat(exeName); err != nil {
+		check.Detail = fmt.Sprintf("%s not found (it will be downloaded on the next run)", exeName)
+		return check
+	}
+
+	// Run the local copy explicitly rather than relying on PATH lookup
+	output, err := runner.Run(localCommandPath(exeName), "--version")
+	if err != nil {
+		check.Detail = fmt.Sprintf("%s exists but failed to run: %v", exeName, err)
+		return check
+	}
+	check.Passed = true
+	check.Detail = "version " + strings.TrimSpace(output.Stdout.String())
+	return check
 }
 
-// parseYtdlpOutput extracts failure details from yt-dlp output
-// yt-dlp error format: ERROR: [TikTok] VIDEO_ID: error message
-func parseYtdlpOutput(lines []string, entries []VideoEntry) []FailureDetail {
-	failures := make([]FailureDetail, 0)
+// checkReachable verifies an HTTP request to url gets any response at all
+func checkReachable(client *http.Client, name, url string) DoctorCheck {
+	check := DoctorCheck{Name: name}
+	resp, err := client.Get(url)
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	_ = resp.Body.Close()
+	check.Passed = true
+	check.Detail = resp.Status
+	return check
+}
 
-	// Build video ID to URL map
-	idToURL := make(map[string]string)
-	for _, entry := range entries {
-		if entry.VideoID != "" {
-			idToURL[entry.VideoID] = entry.Link
-		}
+// checkOutputDir verifies the output directory is writable
+func checkOutputDir(dir string) DoctorCheck {
+	check := DoctorCheck{Name: "output directory writable"}
+	if err := checkDirWritable(dir); err != nil {
+		check.Detail = fmt.Sprintf("cannot write to %s: %v", dir, err)
+		return check
 	}
+	check.Passed = true
+	check.Detail = dir
+	return check
+}
 
-	// Regex: ERROR: [TikTok] VIDEO_ID: error message
-	errorPattern := regexp.MustCompile(`ERROR:\s*\[TikTok\]\s*(\d+):\s*(.+)`)
+// checkJSONFile verifies the export file exists and can be parsed
+func checkJSONFile(path string) DoctorCheck {
+	check := DoctorCheck{Name: "TikTok export found"}
+	if _, err := os.Stat(path); err != nil {
+		check.Detail = fmt.Sprintf("%s not found", path)
+		return check
+	}
+	entries, err := parseFavoriteVideosFromFile(path, true, nil)
+	if err != nil {
+		check.Detail = fmt.Sprintf("%s could not be parsed: %v", path, err)
+		return check
+	}
+	check.Passed = true
+	check.Detail = fmt.Sprintf("%s (%d video entries)", path, len(entries))
+	return check
+}
 
-	for _, line := range lines {
-		matches := errorPattern.FindStringSubmatch(line)
-		if len(matches) >= 3 {
-			videoID := matches[1]
-			errorMsg := strings.TrimSpace(matches[2])
+// runDoctor runs all environment diagnostics
+func runDoctor(client *http.Client, runner CommandRunner, exeName, outputDir, jsonFile string) []DoctorCheck {
+	checks := []DoctorCheck{checkYtdlp(runner, exeName)}
+	for _, endpoint := range doctorEndpoints {
+		checks = append(checks, checkReachable(client, endpoint.Name, endpoint.URL))
+	}
+	checks = append(checks, checkOutputDir(outputDir), checkJSONFile(jsonFile))
+	return checks
+}
 
-			failures = append(failures, FailureDetail{
-				VideoID:      videoID,
-				VideoURL:     idToURL[videoID],
-				ErrorMessage: errorMsg,
-				ErrorType:    categorizeError(errorMsg),
-			})
+// printDoctorReport prints a pass/fail checklist and returns true if every check passed
+func printDoctorReport(w io.Writer, checks []DoctorCheck) bool {
+	allPassed := true
+	_, _ = fmt.Fprintln(w, "[*] Environment check:")
+	for _, c := range checks {
+		status := "PASS"
+		if !c.Passed {
+			status = "FAIL"
+			allPassed = false
+		}
+		_, _ = fmt.Fprintf(w, "  [%s] %s: %s\n", status, c.Name, c.Detail)
+	}
+	return allPassed
+}
+
+// printDoctorJSON writes the checklist as a DoctorReport JSON document and returns
+// true if every check passed
+func printDoctorJSON(w io.Writer, checks []DoctorCheck) bool {
+	report := DoctorReport{Version: version, AllPassed: true, Checks: checks}
+	if report.Checks == nil {
+		report.Checks = []DoctorCheck{}
+	}
+	for _, c := range checks {
+		if !c.Passed {
+			report.AllPassed = false
 		}
 	}
 
-	return failures
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(report)
+	return report.AllPassed
 }
 
-// categorizeError classifies error messages into types
-func categorizeError(errorMsg string) ErrorType {
-	msgLower := strings.ToLower(errorMsg)
+// exportPathEnv names the environment variable holding the export path for scripted setups
+const exportPathEnv = "TIKTOK_EXPORT_PATH"
 
-	if strings.Contains(msgLower, "ip address is blocked") {
-		return ErrorIPBlocked
-	}
-	if strings.Contains(msgLower, "log in for access") ||
-		strings.Contains(msgLower, "not comfortable for some audiences") {
-		return ErrorAuthRequired
+// defaultExportPath returns the export to use when none is given on the command line:
+// TIKTOK_EXPORT_PATH if set, otherwise user_data_tiktok.json. An explicit path
+// argument always wins over both.
+func defaultExportPath(getenv func(string) string) string {
+	if path := strings.TrimSpace(getenv(exportPathEnv)); path != "" {
+		return path
 	}
-	if strings.Contains(msgLower, "not available") ||
-		strings.Contains(msgLower, "private video") {
-		return ErrorNotAvailable
+	return "user_data_tiktok.json"
+}
+
+// parseDoctorArgs splits the doctor command's arguments into the --json switch
+// and the export file to check (default from defaultExportPath)
+func parseDoctorArgs(args []string) (jsonOutput bool, jsonFile string) {
+	jsonFile = defaultExportPath(os.Getenv)
+	for _, arg := range args {
+		if arg == "--json" || arg == "-json" {
+			jsonOutput = true
+		} else {
+			jsonFile = arg
+		}
 	}
-	if strings.Contains(msgLower, "timeout") ||
-		strings.Contains(msgLower, "connection refused") {
-		return ErrorNetworkTimeout
+	return jsonOutput, jsonFile
+}
+
+// isDoctorJSON reports whether the command line asks for "doctor --json"
+func isDoctorJSON(args []string) bool {
+	if len(args) == 0 || args[0] != "doctor" {
+		return false
 	}
+	jsonOutput, _ := parseDoctorArgs(args[1:])
+	return jsonOutput
+}
 
-	return ErrorOther
+// UploaderCount is the number of videos from one uploader in an export
+type UploaderCount struct {
+	Handle string
+	Count  int
+}
+
+// ExportStats holds aggregate figures about a TikTok export
+type ExportStats struct {
+	Favorites       int
+	Liked           int
+	Uploaders       []UploaderCount // Sorted by count (descending), then handle
+	UnknownUploader int             // Entries whose URL doesn't carry the uploader (e.g. share links)
+	FirstDate       time.Time       // Zero if no entry has a parseable date
+	LastDate        time.Time
 }
 
-// parseProgressLine extracts progress information from yt-dlp output
-// yt-dlp outputs progress lines like: "[download] Downloading item 5 of 127"
-// Returns: (currentIndex, total, isProgressLine, error)
-func parseProgressLine(line string) (int, int, bool, error) {
-	// Match pattern: [download] Downloading item X of Y
-	re := regexp.MustCompile(`\[download\] Downloading item (\d+) of (\d+)`)
-	matches := re.FindStringSubmatch(line)
+// exportDateLayout is the timestamp format used in the TikTok export's Date fields
+const exportDateLayout = "2006-01-02 15:04:05"
+
+// computeExportStats aggregates counts, uploaders and the date range over entries
+func computeExportStats(entries []VideoEntry) ExportStats {
+	var stats ExportStats
+	stats.Uploaders, stats.UnknownUploader = countUploaders(entries)
+	for _, entry := range entries {
+		if entry.Collection == "liked" {
+			stats.Liked++
+		} else {
+			stats.Favorites++
+		}
 
-	if len(matches) != 3 {
-		return 0, 0, false, nil // Not a progress line
+		if date, err := time.Parse(exportDateLayout, strings.TrimSpace(entry.Date)); err == nil {
+			if stats.FirstDate.IsZero() || date.Before(stats.FirstDate) {
+				stats.FirstDate = date
+			}
+			if date.After(stats.LastDate) {
+				stats.LastDate = date
+			}
+		}
 	}
 
-	current, err1 := strconv.Atoi(matches[1])
-	total, err2 := strconv.Atoi(matches[2])
+	return stats
+}
 
-	if err1 != nil || err2 != nil {
-		return 0, 0, false, fmt.Errorf("failed to parse progress numbers")
+// countUploaders counts videos per uploader handle parsed from the URL (handles are
+// matched case-insensitively), sorted by count (descending), then handle. Also returns
+// how many entries have no handle in their URL.
+func countUploaders(entries []VideoEntry) ([]UploaderCount, int) {
+	var unknown int
+	counts := make(map[string]*UploaderCount)
+	for _, entry := range entries {
+		handle := extractUploader(entry.Link)
+		if handle == "" {
+			unknown++
+			continue
+		}
+		key := strings.ToLower(handle)
+		if counts[key] == nil {
+			counts[key] = &UploaderCount{Handle: handle}
+		}
+		counts[key].Count++
 	}
 
-	return current, total, true, nil
+	uploaders := make([]UploaderCount, 0, len(counts))
+	for _, c := range counts {
+		uploaders = append(uploaders, *c)
+	}
+	sort.Slice(uploaders, func(i, j int) bool {
+		if uploaders[i].Count != uploaders[j].Count {
+			return uploaders[i].Count > uploaders[j].Count
+		}
+		return strings.ToLower(uploaders[i].Handle) < strings.ToLower(uploaders[j].Handle)
+	})
+	return uploaders, unknown
 }
 
-// isSkipLine detects when yt-dlp skips an already-downloaded video
-// yt-dlp outputs: "[download] <filename> has already been downloaded" or "has already been recorded in the archive"
-// Returns: true if this is a skip message
-func isSkipLine(line string) bool {
-	return strings.Contains(line, "has already been downloaded") ||
-		strings.Contains(line, "has already been recorded in the archive")
-}
+// printUploaderStats prints the --uploader-stats summary: the number of unique
+// uploaders and the topN of them by video count
+func printUploaderStats(w io.Writer, entries []VideoEntry, topN int) {
+	uploaders, unknown := countUploaders(entries)
+	_, _ = fmt.Fprintf(w, "[*] %d unique uploaders", len(uploaders))
+	if unknown > 0 {
+		_, _ = fmt.Fprintf(w, " (%d links don't name the uploader)", unknown)
+	}
+	_, _ = fmt.Fprintln(w)
 
-// isVerboseLine returns true if the line is routine yt-dlp output that can be suppressed
-// when progress bar is enabled. These are informational messages that add noise without value.
-// ERROR and WARNING messages are never considered verbose and will always be displayed.
-func isVerboseLine(line string) bool {
-	// Never suppress errors or warnings
-	if strings.Contains(line, "ERROR:") || strings.Contains(line, "WARNING:") {
-		return false
+	if len(uploaders) > topN {
+		uploaders = uploaders[:topN]
+	}
+	if len(uploaders) > 0 {
+		_, _ = fmt.Fprintf(w, "[*] Top %d uploaders by video count:\n", len(uploaders))
+		for _, u := range uploaders {
+			_, _ = fmt.Fprintf(w, "    @%s: %d\n", u.Handle, u.Count)
+		}
 	}
+}
 
-	verbosePatterns := []string{
-		"[generic] Extracting URL:",
-		"[generic] ",
-		": Downloading webpage",
-		"[redirect] Following redirect to",
-		"[TikTok] Extracting URL:",
-		"[info] ",
-		": Downloading 1 format(s):",
-		"Video thumbnail is already present",
-		"Video metadata is already present",
-		"[download] 100%",
+// printExportStats prints the aggregates, listing at most topN uploaders
+func printExportStats(w io.Writer, stats ExportStats, topN int) {
+	_, _ = fmt.Fprintln(w, "[*] Export statistics:")
+	_, _ = fmt.Fprintf(w, "  Favorites:        %d\n", stats.Favorites)
+	_, _ = fmt.Fprintf(w, "  Liked:            %d\n", stats.Liked)
+	_, _ = fmt.Fprintf(w, "  Unique uploaders: %d", len(stats.Uploaders))
+	if stats.UnknownUploader > 0 {
+		_, _ = fmt.Fprintf(w, " (%d links don't name the uploader)", stats.UnknownUploader)
+	}
+	_, _ = fmt.Fprintln(w)
+	if stats.FirstDate.IsZero() {
+		_, _ = fmt.Fprintln(w, "  Date range:       unknown")
+	} else {
+		_, _ = fmt.Fprintf(w, "  Date range:       %s to %s\n", stats.FirstDate.Format("2006-01-02"), stats.LastDate.Format("2006-01-02"))
 	}
 
-	for _, pattern := range verbosePatterns {
-		if strings.Contains(line, pattern) {
-			return true
+	if len(stats.Uploaders) > 0 {
+		_, _ = fmt.Fprintln(w, "  Top uploaders:")
+		for i, u := range stats.Uploaders {
+			if i == topN {
+				break
+			}
+			_, _ = fmt.Fprintf(w, "    @%s: %d\n", u.Handle, u.Count)
 		}
 	}
-	return false
 }
 
-// isErrorLine detects when yt-dlp encounters an error during download
-// yt-dlp outputs errors like: "ERROR: [TikTok] VIDEO_ID: error message"
-// Returns: true if this is an error message
-func isErrorLine(line string) bool {
-	return strings.Contains(line, "ERROR: [TikTok]")
+// ExportDiff is what changed between an older and a newer export
+type ExportDiff struct {
+	Added   []VideoEntry // In the new export only, in its order
+	Removed []VideoEntry // In the old export only, in its order
 }
 
-// supportsANSI checks if the terminal supports ANSI escape codes
-func supportsANSI() bool {
-	// Check if stdout is a terminal (not piped or redirected)
-	fileInfo, err := os.Stdout.Stat()
-	if err != nil {
-		return false
+// diffKey identifies a video within its collection, by ID where the URL has one
+func diffKey(entry VideoEntry) string {
+	id := extractVideoID(entry.Link)
+	if id == "" {
+		id = entry.Link
 	}
+	return entry.Collection + "/" + id
+}
 
-	// If output is piped or redirected, disable ANSI
-	if (fileInfo.Mode() & os.ModeCharDevice) == 0 {
-		return false
+// diffExports compares two exports' videos. A video counts as the same if it is in the
+// same collection with the same ID, so a changed share-link form isn't reported.
+func diffExports(oldEntries, newEntries []VideoEntry) ExportDiff {
+	inOld := make(map[string]bool, len(oldEntries))
+	for _, entry := range oldEntries {
+		inOld[diffKey(entry)] = true
 	}
-
-	// Check for TERM environment variable (common on Unix-like systems)
-	term := os.Getenv("TERM")
-	if term != "" && term != "dumb" {
-		return true
+	inNew := make(map[string]bool, len(newEntries))
+	for _, entry := range newEntries {
+		inNew[diffKey(entry)] = true
 	}
 
-	// Check for Windows Terminal or other modern Windows terminals
-	// Windows Terminal sets WT_SESSION
-	if os.Getenv("WT_SESSION") != "" {
-		return true
+	var diff ExportDiff
+	for _, entry := range newEntries {
+		if !inOld[diffKey(entry)] {
+			diff.Added = append(diff.Added, entry)
+		}
 	}
-
-	// ConEmu sets ConEmuANSI
-	if os.Getenv("ConEmuANSI") == "ON" {
-		return true
+	for _, entry := range oldEntries {
+		if !inNew[diffKey(entry)] {
+			diff.Removed = append(diff.Removed, entry)
+		}
 	}
-
-	// Default to false for safety (no progress bar if unsure)
-	return false
+	return diff
 }
 
-// renderProgress displays a live progress bar using ANSI escape codes
-// Format: "Downloading favorites (87/92) | ████████████░░░ 94.6% | Success: 85 | Failed: 2"
-func (pr *ProgressRenderer) renderProgress(state *ProgressState) {
-	if !pr.enabled {
-		return
+// parseDiffArgs parses the arguments of "diff [--added FILE] old.json new.json"
+func parseDiffArgs(args []string) (oldFile, newFile, addedFile string, err error) {
+	var files []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--added" || arg == "-added":
+			if i+1 >= len(args) {
+				return "", "", "", fmt.Errorf("--added needs a file name")
+			}
+			i++
+			addedFile = args[i]
+		case strings.HasPrefix(arg, "--added="):
+			addedFile = strings.TrimPrefix(arg, "--added=")
+		default:
+			files = append(files, arg)
+		}
+	}
+	if len(files) != 2 {
+		return "", "", "", fmt.Errorf("usage: diff [--added FILE] old.json new.json")
 	}
+	return files[0], files[1], addedFile, nil
+}
 
-	// Default to stdout if no writer specified
-	out := pr.writer
-	if out == nil {
-		out = os.Stdout
+// runDiff prints the videos added to and removed from an export between two data
+// requests, and writes the added URLs to addedFile if it isn't empty
+func runDiff(oldFile, newFile, addedFile string, out io.Writer) error {
+	oldEntries, err := parseFavoriteVideosFromFile(oldFile, true, nil)
+	if err != nil {
+		return fmt.Errorf("error loading '%s': %v", oldFile, err)
+	}
+	newEntries, err := parseFavoriteVideosFromFile(newFile, true, nil)
+	if err != nil {
+		return fmt.Errorf("error loading '%s': %v", newFile, err)
 	}
 
-	// Calculate percentage
-	percentage := 0.0
-	if state.TotalVideos > 0 {
-		percentage = float64(state.CurrentIndex) / float64(state.TotalVideos) * 100
+	diff := diffExports(oldEntries, newEntries)
+	_, _ = fmt.Fprintf(out, "[*] %d added, %d removed between '%s' and '%s'\n", len(diff.Added), len(diff.Removed), oldFile, newFile)
+	for _, entry := range diff.Added {
+		_, _ = fmt.Fprintf(out, "+ [%s] %s\n", entry.Collection, entry.Link)
+	}
+	for _, entry := range diff.Removed {
+		_, _ = fmt.Fprintf(out, "- [%s] %s\n", entry.Collection, entry.Link)
 	}
 
-	// Create progress bar (20 characters wide)
-	barWidth := 20
-	filledWidth := int(float64(barWidth) * percentage / 100)
-	if filledWidth > barWidth {
-		filledWidth = barWidth
+	if addedFile != "" {
+		var list strings.Builder
+		for _, entry := range diff.Added {
+			list.WriteString(entry.Link + "\n")
+		}
+		if err := os.WriteFile(addedFile, []byte(list.String()), 0644); err != nil {
+			return fmt.Errorf("error writing %s: %v", addedFile, err)
+		}
+		_, _ = fmt.Fprintf(out, "[*] Wrote %d added URLs to '%s'\n", len(diff.Added), addedFile)
 	}
+	return nil
+}
 
-	bar := strings.Repeat("█", filledWidth) + strings.Repeat("░", barWidth-filledWidth)
+// runSubcommand dispatches subcommands such as "self-update" that run instead of
+// the normal download workflow. Returns the exit code and whether name was a subcommand.
+func runSubcommand(name string, args []string) (int, bool) {
+	switch name {
+	case "self-update":
+		exePath, err := os.Executable()
+		if err != nil {
+			fmt.Printf("[!!!] Could not locate the running executable: %v\n", err)
+			return 1, true
+		}
+		if err := selfUpdate(http.DefaultClient, exePath, version, runtime.GOOS, runtime.GOARCH); err != nil {
+			fmt.Printf("[!!!] Self-update failed: %v\n", err)
+			return 1, true
+		}
+		return 0, true
+
+	case "doctor":
+		jsonOutput, jsonFile := parseDoctorArgs(args)
+		checks := runDoctor(http.DefaultClient, silentCommandRunner{}, "yt-dlp.exe", ".", jsonFile)
+		report := printDoctorReport
+		if jsonOutput {
+			report = printDoctorJSON
+		}
+		if !report(os.Stdout, checks) {
+			return 1, true
+		}
+		return 0, true
 
-	// Color codes
-	green := "\033[32m"
-	yellow := "\033[33m"
-	red := "\033[31m"
-	reset := "\033[0m"
+	case "stats":
+		jsonFile := defaultExportPath(os.Getenv)
+		if len(args) > 0 {
+			jsonFile = args[0]
+		}
+		entries, err := parseFavoriteVideosFromFile(jsonFile, true, nil)
+		if err != nil {
+			fmt.Printf("[!!!] Error loading '%s': %v\n", jsonFile, err)
+			return 1, true
+		}
+		printExportStats(os.Stdout, computeExportStats(entries), 10)
+		return 0, true
 
-	// Build progress line
-	line := fmt.Sprintf("\rDownloading %s (%d/%d) | %s %.1f%% | %sSuccess: %d%s | %sSkipped: %d%s | %sFailed: %d%s",
-		state.CollectionName,
-		state.CurrentIndex,
-		state.TotalVideos,
-		bar,
-		percentage,
-		green,
-		state.SuccessCount,
-		reset,
-		yellow,
-		state.SkippedCount,
-		reset,
-		red,
-		state.FailureCount,
-		reset,
-	)
+	case "seed-archive":
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		if err := runSeedArchive(dir, nil); err != nil {
+			fmt.Printf("[!!!] Error seeding download archive: %v\n", err)
+			return 1, true
+		}
+		return 0, true
 
-	// Clear previous line if it was longer
-	if len(line) < pr.lastLineLen {
-		line += strings.Repeat(" ", pr.lastLineLen-len(line))
-	}
-	pr.lastLineLen = len(line)
+	case "resume-archive":
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		if err := runResumeArchive(dir, nil, os.Stdout); err != nil {
+			fmt.Printf("[!!!] Error reconciling download archive: %v\n", err)
+			return 1, true
+		}
+		return 0, true
 
-	// Print progress (using \r to overwrite current line)
-	_, _ = fmt.Fprint(out, line)
-}
+	case "scan":
+		dir, concurrency, err := parseScanArgs(args)
+		if err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			return 1, true
+		}
+		drifted, err := runScan(dir, concurrency, os.Stdout)
+		if err != nil {
+			fmt.Printf("[!!!] Error scanning %s: %v\n", dir, err)
+			return 1, true
+		}
+		if drifted > 0 {
+			return 1, true
+		}
+		return 0, true
 
-// clearProgress clears the progress bar line
-func (pr *ProgressRenderer) clearProgress() {
-	if !pr.enabled || pr.lastLineLen == 0 {
-		return
-	}
+	case "diff":
+		oldFile, newFile, addedFile, err := parseDiffArgs(args)
+		if err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			return 1, true
+		}
+		if err := runDiff(oldFile, newFile, addedFile, os.Stdout); err != nil {
+			fmt.Printf("[!!!] %v\n", err)
+			return 1, true
+		}
+		return 0, true
 
-	// Default to stdout if no writer specified
-	out := pr.writer
-	if out == nil {
-		out = os.Stdout
+	case "clean":
+		force, dir := parseCleanArgs(args)
+		if _, err := runClean(dir, force, os.Stdin, os.Stdout); err != nil {
+			fmt.Printf("[!!!] Error cleaning %s: %v\n", dir, err)
+			return 1, true
+		}
+		return 0, true
 	}
+	return 0, false
+}
 
-	// Clear line and move to start
-	_, _ = fmt.Fprint(out, "\r"+strings.Repeat(" ", pr.lastLineLen)+"\r")
-	pr.lastLineLen = 0
+// NDJSONEntry is one line of --ndjson output
+type NDJSONEntry struct {
+	URL    string `json:"url"`
+	Source string `json:"source"` // "favorites" or "liked"
+	Date   string `json:"date"`
 }
 
-// calculateSessionTotals aggregates totals across all collections
-func calculateSessionTotals(collections []CollectionResult) (attempted, success, failed, skipped int) {
-	for _, col := range collections {
-		attempted += col.Attempted
-		success += col.Success
-		failed += col.Failed
-		skipped += col.Skipped
+// writeNDJSON writes one JSON object per entry to w. Each line is written as soon as
+// it is encoded, so a consumer such as jq sees entries while the rest are produced.
+func writeNDJSON(w io.Writer, entries []VideoEntry) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for _, entry := range entries {
+		if err := encoder.Encode(NDJSONEntry{URL: entry.Link, Source: entry.Collection, Date: entry.Date}); err != nil {
+			return fmt.Errorf("error writing NDJSON: %v", err)
+		}
 	}
-	return
+	return nil
 }
 
-// printSessionSummary displays end-of-session summary to console
-func printSessionSummary(session *DownloadSession) {
-	duration := session.EndTime.Sub(session.StartTime)
+// isNDJSON reports whether --ndjson was given, before the flags are parsed, so that
+// even the banner can be kept off stdout
+func isNDJSON(args []string) bool {
+	return boolFlagGiven(args, "ndjson")
+}
 
-	fmt.Println("\n" + strings.Repeat("=", 80))
-	fmt.Println("                        DOWNLOAD SESSION SUMMARY")
-	fmt.Println(strings.Repeat("=", 80))
-	fmt.Printf("Duration: %s\n", formatDuration(int(duration.Seconds())))
-	fmt.Printf("Total Videos Attempted: %d\n", session.TotalAttempted)
-	fmt.Printf("  ✓ Successfully Downloaded: %d\n", session.TotalSuccess)
-	fmt.Printf("  - Skipped (Already Downloaded): %d\n", session.TotalSkipped)
-	fmt.Printf("  ✗ Failed: %d\n\n", session.TotalFailed)
+// isCountOnly reports whether --count-only was given, before the flags are parsed
+func isCountOnly(args []string) bool {
+	return boolFlagGiven(args, "count-only")
+}
 
-	if len(session.Collections) > 1 {
-		fmt.Println("Collection Breakdown:")
-		for _, col := range session.Collections {
-			fmt.Printf("  %s:\n", col.Name)
-			fmt.Printf("    Attempted: %-4d | Success: %-4d | Skipped: %-4d | Failed: %d\n",
-				col.Attempted, col.Success, col.Skipped, col.Failed)
+// isPrintCommand reports whether --print-command or --copy-command was given, before
+// the flags are parsed
+func isPrintCommand(args []string) bool {
+	return boolFlagGiven(args, "print-command") || boolFlagGiven(args, "copy-command")
+}
+
+// isPrintPlan reports whether --print-plan was given, before the flags are parsed
+func isPrintPlan(args []string) bool {
+	return boolFlagGiven(args, "print-plan")
+}
+
+// boolFlagGiven reports whether the boolean flag name is set in args
+func boolFlagGiven(args []string, flagName string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") && name == flagName {
+			enabled, err := strconv.ParseBool(value)
+			return !hasValue || (err == nil && enabled)
 		}
-		fmt.Println()
 	}
+	return false
+}
 
-	if session.TotalFailed > 0 {
-		fmt.Println("For detailed failure information, see results.txt")
+// pipelineEntries returns the entries a run would download, for the modes that report
+// them instead. Liked videos are always included, so a pipeline is never prompted.
+func pipelineEntries(config *Config, baseDir string) ([]VideoEntry, error) {
+	entries, err := loadExportEntries(config, true)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Println(strings.Repeat("=", 80))
+	entries = applyEntryFilters(config, entries)
+	entries = applyAfterID(config, entries)
+	entries = applySinceCutoff(config, entries, baseDir)
+	entries = applyDedupeExisting(config, entries, baseDir)
+	return applySkipKnown(config, entries), nil
 }
 
-// formatDuration converts seconds to a human-readable duration string
-func formatDuration(seconds int) string {
-	if seconds < 60 {
-		return fmt.Sprintf("%ds", seconds)
+// runNDJSON streams the entries that would be downloaded to out as NDJSON (filter
+// liked videos on .source)
+func runNDJSON(config *Config, baseDir string, out io.Writer) error {
+	entries, err := pipelineEntries(config, baseDir)
+	if err != nil {
+		return err
 	}
-	minutes := seconds / 60
-	secs := seconds % 60
-	if minutes < 60 {
-		return fmt.Sprintf("%dm %ds", minutes, secs)
+	fmt.Printf("[*] Writing %d entries as NDJSON\n", len(entries))
+	return writeNDJSON(out, entries)
+}
+
+// runPrintCommand writes the URL lists and prints just the yt-dlp command line for each
+// to out, one per line, without running them. With a clipboard they are copied there too.
+func runPrintCommand(config *Config, baseDir, goos string, out io.Writer, clipboard ClipboardWriter) error {
+	entries, err := pipelineEntries(config, baseDir)
+	if err != nil {
+		return err
+	}
+	runs, err := writeDownloadLists(config, baseDir, entries)
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	for _, run := range runs {
+		lines = append(lines, commandLine(goos, suggestedYtdlpArgs(run.config, run.file, run.entries)))
+	}
+	text := strings.Join(lines, "\n")
+	if _, err := fmt.Fprintln(out, text); err != nil {
+		return err
+	}
+	if clipboard != nil {
+		if err := clipboard.WriteText(text); err != nil {
+			return err
+		}
+		fmt.Println("[*] Copied the yt-dlp command to the clipboard")
 	}
-	hours := minutes / 60
-	mins := minutes % 60
-	return fmt.Sprintf("%dh %dm %ds", hours, mins, secs)
+	return nil
 }
 
-// writeResultsFile appends session results to results.txt
-func writeResultsFile(session *DownloadSession) error {
-	resultsPath := "results.txt"
-
-	// Open in append mode, create if doesn't exist
-	f, err := os.OpenFile(resultsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open results.txt: %v", err)
-	}
-	defer func() { _ = f.Close() }()
+// ExecutionPlan is what a run is about to do, printed as JSON by --print-plan for UI
+// wrappers and audits
+type ExecutionPlan struct {
+	JSONFile             string       `json:"json_file"`
+	OutputDir            string       `json:"output_dir"`
+	OrganizeByCollection bool         `json:"organize_by_collection"`
+	TotalVideos          int          `json:"total_videos"`
+	Sources              []PlanSource `json:"sources"`
+	Ytdlp                PlanYtdlp    `json:"ytdlp"`
+	Runs                 []PlanRun    `json:"runs"`
+	DryRun               bool         `json:"dry_run"` // Nothing is downloaded after the plan (--dry-run)
+}
 
-	w := bufio.NewWriter(f)
-	defer func() { _ = w.Flush() }()
+// PlanSource is the number of videos taken from one part of the export
+type PlanSource struct {
+	Name   string `json:"name"` // favorites, liked, saved or history
+	Videos int    `json:"videos"`
+}
 
-	// Session separator (for multiple sessions in same file)
-	_, _ = fmt.Fprintf(w, "\n%s\n", strings.Repeat("=", 80))
-	_, _ = fmt.Fprintf(w, "TikTok Video Downloader - Session Results\n")
-	_, _ = fmt.Fprintf(w, "Generated: %s\n", session.EndTime.Format("2006-01-02 15:04:05"))
-	_, _ = fmt.Fprintf(w, "Duration: %s\n", formatDuration(int(session.EndTime.Sub(session.StartTime).Seconds())))
-	_, _ = fmt.Fprintf(w, "%s\n\n", strings.Repeat("=", 80))
+// PlanYtdlp is the yt-dlp binary a plan runs
+type PlanYtdlp struct {
+	Path    string `json:"path"`
+	Version string `json:"version,omitempty"` // Empty if the binary isn't there yet or failed to run
+}
 
-	// Summary
-	_, _ = fmt.Fprintf(w, "SUMMARY\n")
-	_, _ = fmt.Fprintf(w, "=======\n")
-	_, _ = fmt.Fprintf(w, "Total Videos Attempted: %d\n", session.TotalAttempted)
-	_, _ = fmt.Fprintf(w, "Successfully Downloaded: %d\n", session.TotalSuccess)
-	_, _ = fmt.Fprintf(w, "Skipped: %d\n", session.TotalSkipped)
-	_, _ = fmt.Fprintf(w, "Failed: %d\n\n", session.TotalFailed)
+// PlanRun is one yt-dlp invocation of a plan
+type PlanRun struct {
+	Source         string   `json:"source,omitempty"` // Collection or source of the list (empty for the merged list)
+	ListFile       string   `json:"list_file"`
+	Videos         int      `json:"videos"`
+	OutputTemplate string   `json:"output_template"`
+	Command        []string `json:"command"` // argv of the batch run (per-video modes pass the URLs one at a time instead)
+}
 
-	if session.TotalFailed == 0 {
-		_, _ = fmt.Fprintf(w, "All videos downloaded successfully!\n")
-		return nil
+// buildExecutionPlan describes the download of entries through runs. runner asks the
+// yt-dlp binary for its version.
+func buildExecutionPlan(config *Config, baseDir string, runs []listRun, entries []VideoEntry, runner CommandRunner) *ExecutionPlan {
+	plan := &ExecutionPlan{
+		JSONFile:             config.JSONFile,
+		OutputDir:            absOrSelf(baseDir),
+		OrganizeByCollection: config.OrganizeByCollection,
+		TotalVideos:          len(entries),
+		Sources:              []PlanSource{},
+		Runs:                 []PlanRun{},
+		DryRun:               config.DryRun,
 	}
 
-	// Failed downloads
-	_, _ = fmt.Fprintf(w, "FAILED DOWNLOADS\n")
-	_, _ = fmt.Fprintf(w, "================\n\n")
+	counts := make(map[string]int)
+	for _, entry := range entries {
+		if counts[entry.Collection] == 0 {
+			plan.Sources = append(plan.Sources, PlanSource{Name: entry.Collection})
+		}
+		counts[entry.Collection]++
+	}
+	for i := range plan.Sources {
+		plan.Sources[i].Videos = counts[plan.Sources[i].Name]
+	}
 
-	for _, col := range session.Collections {
-		if len(col.FailureDetails) == 0 {
-			continue
+	exeName := config.YtdlpPath
+	if exeName == "" {
+		exeName = "yt-dlp.exe"
+	}
+	plan.Ytdlp.Path = absOrSelf(exeName)
+	if _, err := os.Stat(exeName); err == nil {
+		if output, err := runner.Run(localCommandPath(exeName), "--version"); err == nil {
+			plan.Ytdlp.Version = strings.TrimSpace(output.Stdout.String())
 		}
+	}
 
-		_, _ = fmt.Fprintf(w, "Collection: %s (%d failures)\n", col.Name, len(col.FailureDetails))
-		_, _ = fmt.Fprintf(w, "%s\n\n", strings.Repeat("-", 50))
+	for _, run := range runs {
+		outputFormat, archivePath := ytdlpListPaths(run.config, run.file, run.entries)
+		command := append([]string{ytdlpCommand(run.config, ""), "-a", run.file},
+			ytdlpOptionArgs(run.config, outputFormat, archivePath, true)...)
+		plan.Runs = append(plan.Runs, PlanRun{
+			Source:         run.source,
+			ListFile:       run.file,
+			Videos:         len(run.entries),
+			OutputTemplate: outputFormat,
+			Command:        command,
+		})
+	}
+	return plan
+}
 
-		for i, failure := range col.FailureDetails {
-			_, _ = fmt.Fprintf(w, "%d. Video ID: %s\n", i+1, failure.VideoID)
-			_, _ = fmt.Fprintf(w, "   URL: %s\n", failure.VideoURL)
-			_, _ = fmt.Fprintf(w, "   Error Type: %s\n", failure.ErrorType.String())
-			_, _ = fmt.Fprintf(w, "   Error: %s\n\n", failure.ErrorMessage)
-		}
+// writePlan writes plan to w as indented JSON
+func writePlan(w io.Writer, plan *ExecutionPlan) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(plan)
+}
+
+// runDryRunPlan writes the URL lists and prints the plan for --print-plan --dry-run
+// without downloading anything. Liked videos are always included, like --print-command.
+func runDryRunPlan(config *Config, baseDir string, out io.Writer, runner CommandRunner) error {
+	entries, err := pipelineEntries(config, baseDir)
+	if err != nil {
+		return err
 	}
+	runs, err := writeDownloadLists(config, baseDir, entries)
+	if err != nil {
+		return err
+	}
+	return writePlan(out, buildExecutionPlan(config, baseDir, runs, entries, runner))
+}
 
-	// Troubleshooting tips
-	_, _ = fmt.Fprintf(w, "\nTROUBLESHOOTING TIPS\n")
-	_, _ = fmt.Fprintf(w, "====================\n")
-	writeTroubleshootingTips(w, session)
+// OPML is the outline document --export-creators writes for a .opml file
+type OPML struct {
+	XMLName  xml.Name      `xml:"opml"`
+	Version  string        `xml:"version,attr"`
+	Title    string        `xml:"head>title"`
+	Outlines []OPMLOutline `xml:"body>outline"`
+}
 
-	return nil
+// OPMLOutline is one creator in the OPML document
+type OPMLOutline struct {
+	Text string `xml:"text,attr"`
+	Type string `xml:"type,attr"`
+	URL  string `xml:"url,attr"`
 }
 
-// writeTroubleshootingTips writes context-specific troubleshooting advice
-func writeTroubleshootingTips(w *bufio.Writer, session *DownloadSession) {
-	// Count error types
-	errorCounts := make(map[ErrorType]int)
-	for _, col := range session.Collections {
-		for _, failure := range col.FailureDetails {
-			errorCounts[failure.ErrorType]++
+// creatorProfileURL returns the TikTok profile page of an uploader handle
+func creatorProfileURL(handle string) string {
+	return "https://www.tiktok.com/@" + handle
+}
+
+// writeCreatorFeed writes the unique creators of entries, most-favorited first, to
+// path: an OPML outline for a .opml file, otherwise one profile URL per line.
+// Returns the number of creators written.
+func writeCreatorFeed(path string, entries []VideoEntry) (int, error) {
+	uploaders, _ := countUploaders(entries)
+
+	var data []byte
+	if strings.EqualFold(filepath.Ext(path), ".opml") {
+		doc := OPML{Version: "2.0", Title: "TikTok creators from favorites"}
+		for _, u := range uploaders {
+			doc.Outlines = append(doc.Outlines, OPMLOutline{Text: "@" + u.Handle, Type: "link", URL: creatorProfileURL(u.Handle)})
+		}
+		out, err := xml.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return 0, fmt.Errorf("error encoding OPML: %v", err)
+		}
+		data = append([]byte(xml.Header), out...)
+		data = append(data, '\n')
+	} else {
+		var b strings.Builder
+		for _, u := range uploaders {
+			b.WriteString(creatorProfileURL(u.Handle) + "\n")
 		}
+		data = []byte(b.String())
 	}
 
-	// Write tips for each encountered error type
-	if count := errorCounts[ErrorIPBlocked]; count > 0 {
-		_, _ = fmt.Fprintf(w, "IP Blocked (%d videos):\n", count)
-		_, _ = fmt.Fprintf(w, "  - Your IP may be rate-limited by TikTok\n")
-		_, _ = fmt.Fprintf(w, "  - Try again after waiting 30-60 minutes\n")
-		_, _ = fmt.Fprintf(w, "  - Consider using a VPN or different network\n\n")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return 0, fmt.Errorf("error writing %s: %v", path, err)
 	}
+	return len(uploaders), nil
+}
 
-	if count := errorCounts[ErrorAuthRequired]; count > 0 {
-		_, _ = fmt.Fprintf(w, "Authentication Required (%d videos):\n", count)
-		_, _ = fmt.Fprintf(w, "  - These videos require login to view (age-restricted content)\n")
-		_, _ = fmt.Fprintf(w, "  - Retry with cookies to download these videos:\n")
-		_, _ = fmt.Fprintf(w, "    * Use --cookies cookies.txt (Netscape format)\n")
-		_, _ = fmt.Fprintf(w, "    * OR use --cookies-from-browser firefox\n")
-		_, _ = fmt.Fprintf(w, "  - See: https://github.com/yt-dlp/yt-dlp/wiki/FAQ#how-do-i-pass-cookies-to-yt-dlp\n")
-		_, _ = fmt.Fprintf(w, "    NB: cookies-from-browser may not work with Chromium-based browsers, refer to yt-dlp issue 7271 https://github.com/yt-dlp/yt-dlp/issues/7271\n\n")
+// runExportCreators writes the creator feed for --export-creators. Liked videos are
+// always included, like --ndjson, so the export never prompts.
+func runExportCreators(config *Config) error {
+	entries, err := loadExportEntries(config, true)
+	if err != nil {
+		return err
 	}
-
-	if count := errorCounts[ErrorNotAvailable]; count > 0 {
-		_, _ = fmt.Fprintf(w, "Not Available (%d videos):\n", count)
-		_, _ = fmt.Fprintf(w, "  - Videos may be deleted, private, or region-locked\n")
-		_, _ = fmt.Fprintf(w, "  - Check if the video still exists by opening the URL\n\n")
+	entries = applyEntryFilters(config, entries)
+	path := workPath(config, config.ExportCreators)
+	count, err := writeCreatorFeed(path, entries)
+	if err != nil {
+		return err
 	}
+	fmt.Printf("[*] Wrote %d creators to %s\n", count, path)
+	return nil
+}
 
-	if count := errorCounts[ErrorNetworkTimeout]; count > 0 {
-		_, _ = fmt.Fprintf(w, "Network Timeout (%d videos):\n", count)
-		_, _ = fmt.Fprintf(w, "  - Check your internet connection\n")
-		_, _ = fmt.Fprintf(w, "  - Retry the download session\n\n")
+// runCountOnly prints just the number of entries that would be downloaded to out, so a
+// health check can read it without parsing any other output
+func runCountOnly(config *Config, baseDir string, out io.Writer) error {
+	entries, err := pipelineEntries(config, baseDir)
+	if err != nil {
+		return err
 	}
+	_, err = fmt.Fprintln(out, len(entries))
+	return err
 }
 
-// runYtdlp runs the yt-dlp command for the user
-func runYtdlp(psPrefix, outputName string, organizeByCollection, skipThumbnails, disableResume, disableProgressBar bool, cookieFile, cookieFromBrowser string, entries []VideoEntry) (*CollectionResult, error) {
-	// Create progress renderer if enabled
-	var renderer *ProgressRenderer
-	var state *ProgressState
-	if !disableProgressBar && supportsANSI() {
-		collectionName := filepath.Base(filepath.Dir(outputName))
-		if collectionName == "." {
-			collectionName = "videos"
-		}
-		renderer = &ProgressRenderer{
-			enabled: true,
-			writer:  os.Stdout,
-		}
-		state = &ProgressState{
-			CollectionName: collectionName,
-			TotalVideos:    len(entries),
-		}
+// runReportOnly regenerates the index and results.txt for config.ReportOnly from the
+// original list: a .txt URL list (reported against the directory itself) or the JSON export
+func runReportOnly(config *Config) {
+	dir := config.ReportOnly
+	if err := ensureOutputWritable(dir); err != nil {
+		fmt.Printf("[!!!] Error: %v\n", err)
+		os.Exit(1)
 	}
 
-	runner := &RealCommandRunner{
-		ProgressRenderer: renderer,
-		ProgressState:    state,
+	var entries []VideoEntry
+	var err error
+	organizeByCollection := config.OrganizeByCollection
+	if strings.EqualFold(filepath.Ext(config.JSONFile), ".txt") {
+		entries, err = readURLList(config.JSONFile)
+		organizeByCollection = false
+	} else {
+		entries, err = parseFavoriteVideosFromFile(config.JSONFile, true, config.ExportSchema)
+	}
+	if err != nil {
+		fmt.Printf("[!!!] Error loading '%s': %v\n", config.JSONFile, err)
+		os.Exit(1)
 	}
+	fmt.Printf("[*] Report-only mode: checking %d videos from '%s' against %s\n", len(entries), config.JSONFile, dir)
+	entries = applyEntryFilters(config, entries)
 
-	return runYtdlpWithRunner(runner, psPrefix, outputName, organizeByCollection, skipThumbnails, disableResume, cookieFile, cookieFromBrowser, entries)
+	session, err := regenerateReports(dir, entries, organizeByCollection, config.MediaExtensions, config.FilenameMatch, config.IndexGrouping)
+	if err != nil {
+		fmt.Printf("[!!!] Error regenerating reports: %v\n", err)
+		os.Exit(1)
+	}
+	printSessionSummary(session)
+	fmt.Printf("[*] Regenerated indexes and %s\n", filepath.Join(dir, "results.txt"))
 }
 
-// runYtdlpWithRunner allows dependency injection for testing
-func runYtdlpWithRunner(runner CommandRunner, psPrefix, outputName string, organizeByCollection, skipThumbnails, disableResume bool, cookieFile, cookieFromBrowser string, entries []VideoEntry) (*CollectionResult, error) {
-	collectionName := filepath.Base(filepath.Dir(outputName))
-	if collectionName == "." {
-		collectionName = "videos"
+// buildResolutionSort builds a yt-dlp -S (format sort) value for a resolution range:
+//   - max only:  "res:720"  - largest resolution not above 720p
+//   - min only:  "+res:480" - smallest resolution not below 480p
+//   - both:      "res:720"  - the largest format not above max is also the closest to
+//     the range, so it is at least min whenever such a format exists
+//
+// In each case yt-dlp falls back to the nearest format outside the range rather than
+// failing, and "+size" prefers the smaller file among formats of equal resolution.
+// Returns "" when neither bound is set.
+func buildResolutionSort(minRes, maxRes int) string {
+	switch {
+	case maxRes > 0:
+		return fmt.Sprintf("res:%d,+size", maxRes)
+	case minRes > 0:
+		return fmt.Sprintf("+res:%d,+size", minRes)
 	}
+	return ""
+}
 
-	// Calculate archive file path (matches logic below at lines 1159-1165)
-	var archivePath string
-	if organizeByCollection {
-		dir := filepath.Dir(outputName)
-		archivePath = filepath.Join(dir, "download_archive.txt")
-	} else {
-		archivePath = "download_archive.txt"
+// validateResolutionRange checks --min-resolution/--max-resolution values
+func validateResolutionRange(minRes, maxRes int) error {
+	if minRes < 0 || maxRes < 0 {
+		return fmt.Errorf("resolutions must not be negative")
+	}
+	if minRes > 0 && maxRes > 0 && minRes > maxRes {
+		return fmt.Errorf("--min-resolution (%d) is greater than --max-resolution (%d)", minRes, maxRes)
 	}
+	return nil
+}
 
-	// Optimization: Filter out already downloaded videos if resume is enabled
-	videosToDownload := entries
-	skippedCount := 0
+// subLangPattern matches one --sub-langs item: a language code or a yt-dlp regex such
+// as "en.*", optionally prefixed with "-" to exclude it ("-live_chat")
+var subLangPattern = regexp.MustCompile(`^-?[A-Za-z0-9._*+-]+$`)
 
-	if !disableResume {
-		archive, err := parseArchiveFile(archivePath)
-		if err == nil && len(archive) > 0 {
-			var filtered []VideoEntry
-			for _, entry := range entries {
-				videoID := extractVideoID(entry.Link)
-				// If ID found and in archive, skip
-				if videoID != "" && archive[videoID] {
-					skippedCount++
-				} else {
-					filtered = append(filtered, entry)
-				}
-			}
-			videosToDownload = filtered
+// validateSubtitleFlags checks that --sub-langs is well formed and has something to apply to
+func validateSubtitleFlags(subLangs string, writeSubs, writeAutoSubs bool) error {
+	if subLangs == "" {
+		return nil
+	}
+	if !writeSubs && !writeAutoSubs {
+		return fmt.Errorf("--sub-langs needs --write-subs or --write-auto-subs")
+	}
+	for _, lang := range strings.Split(subLangs, ",") {
+		if !subLangPattern.MatchString(lang) {
+			return fmt.Errorf("invalid --sub-langs entry %q (expected e.g. en,de or en.*)", lang)
 		}
 	}
+	return nil
+}
 
-	// Update ProgressState if available
-	if realRunner, ok := runner.(*RealCommandRunner); ok && realRunner.ProgressState != nil {
-		realRunner.ProgressState.InitialSkipped = skippedCount
-		realRunner.ProgressState.SkippedCount = skippedCount
-		realRunner.ProgressState.CurrentIndex = skippedCount
-		// TotalVideos remains len(entries)
+// metadataArgs returns the yt-dlp flags for the extra metadata to archive alongside
+// each video: comments (stored in the .info.json) and subtitles
+func metadataArgs(config *Config) []string {
+	var args []string
+	if config.WriteComments {
+		args = append(args, "--write-comments")
+	}
+	if config.WriteSubs {
+		args = append(args, "--write-subs")
 	}
+	if config.WriteAutoSubs {
+		args = append(args, "--write-auto-subs")
+	}
+	if config.SubLangs != "" {
+		args = append(args, "--sub-langs", config.SubLangs)
+	}
+	return args
+}
 
-	// If all videos are skipped, we can return early
-	if len(videosToDownload) == 0 {
-		fmt.Printf("[*] %s collection: All %d videos already downloaded (skipping yt-dlp)\n",
-			collectionName, len(entries))
+// fileSizePattern matches the sizes yt-dlp's --max-filesize accepts: a number with an
+// optional k/M/G/T... suffix (case-insensitive), e.g. 500k, 100M or 1.5G
+var fileSizePattern = regexp.MustCompile(`(?i)^\d+(\.\d+)?[kmgtpezy]?$`)
 
-		return &CollectionResult{
-			Name:           collectionName,
-			Attempted:      len(entries),
-			Failed:         0,
-			Success:        len(entries), // All considered success (skipped)
-			Skipped:        len(entries),
-			FailureDetails: []FailureDetail{},
-		}, nil
+// validateMaxFilesize checks a --max-filesize value before it is passed to yt-dlp
+func validateMaxFilesize(size string) error {
+	if size != "" && !fileSizePattern.MatchString(size) {
+		return fmt.Errorf("invalid --max-filesize %q (expected e.g. 500k, 100M or 1.5G)", size)
 	}
+	return nil
+}
 
-	// If we have skipped some but not all, notify user
-	if skippedCount > 0 {
-		fmt.Printf("[*] %s collection: %d videos to download (%d skipped)\n",
-			collectionName, len(videosToDownload), skippedCount)
+// parseByteSize converts a size such as 500k, 100M or 1.5G to bytes. Suffixes are
+// binary (k = 1024), matching how yt-dlp reads --max-filesize.
+func parseByteSize(size string) (int64, error) {
+	if !fileSizePattern.MatchString(size) {
+		return 0, fmt.Errorf("invalid size %q (expected e.g. 500M, 10G or 1.5T)", size)
+	}
+	number, multiplier := size, 1.0
+	if power := strings.IndexRune("kmgtpezy", unicode.ToLower(rune(size[len(size)-1]))); power >= 0 {
+		number = size[:len(size)-1]
+		multiplier = math.Pow(1024, float64(power+1))
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %v", size, err)
 	}
+	bytes := value * multiplier
+	if bytes > math.MaxInt64 {
+		return 0, fmt.Errorf("size %q is too large", size)
+	}
+	return int64(bytes), nil
+}
 
-	fmt.Println("[*] Running yt-dlp now...")
-	cmdStr := fmt.Sprintf("%syt-dlp.exe", psPrefix)
+// downloadedSizePattern matches the line yt-dlp prints when a download finishes, e.g.
+// "[download] 100% of   12.34MiB in 00:00:03 at 3.95MiB/s". In-flight progress lines
+// ("100.0% of 12.34MiB at ...") have no " in ", so a file is only counted once.
+var downloadedSizePattern = regexp.MustCompile(`\[download\]\s+100% of\s+~?\s*([\d.]+)\s*([KMGTPEZY]?)(i?)B in `)
+
+// sizeBudget tracks how much has been downloaded in this run against --size-budget.
+// Safe for concurrent use.
+type sizeBudget struct {
+	mu    sync.Mutex
+	limit int64
+	used  int64
+}
 
-	// Configure output format based on organization preference
-	// New format includes video ID and truncated title for better identification
-	var outputFormat string
-	if organizeByCollection {
-		// Include directory from outputName so videos download to collection folder
-		dir := filepath.Dir(outputName)
-		outputFormat = filepath.Join(dir, "%(upload_date)s_%(id)s_%(title).50B.%(ext)s")
-	} else {
-		// Flat structure with new format
-		outputFormat = "%(upload_date)s_%(id)s_%(title).50B.%(ext)s"
+// newSizeBudget creates a budget of limit bytes
+func newSizeBudget(limit int64) *sizeBudget {
+	return &sizeBudget{limit: limit}
+}
+
+// downloadedBytes adds up the sizes of the downloads yt-dlp reported finishing in lines
+func downloadedBytes(lines []string) int64 {
+	var total int64
+	for _, line := range lines {
+		m := downloadedSizePattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		value, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			continue
+		}
+		base := 1000.0
+		if m[3] != "" {
+			base = 1024
+		}
+		power := 0
+		if m[2] != "" {
+			power = strings.Index("KMGTPEZY", m[2]) + 1
+		}
+		total += int64(value * math.Pow(base, float64(power)))
 	}
+	return total
+}
 
-	// Determine which file to pass to yt-dlp
-	targetFile := outputName
+// Record adds the sizes of the downloads yt-dlp reported finishing in lines
+func (b *sizeBudget) Record(lines []string) {
+	if b == nil {
+		return
+	}
+	total := downloadedBytes(lines)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used += total
+}
 
-	// If we filtered the list, write a temporary file
-	if skippedCount > 0 {
-		tempFile := outputName + ".partial.txt"
-		// Ensure directory exists (should already exist from main, but just in case)
-		if organizeByCollection {
-			_ = os.MkdirAll(filepath.Dir(tempFile), 0755)
-		}
+// Used returns the number of bytes recorded so far
+func (b *sizeBudget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
 
-		if err := writeVideoEntriesToFile(videosToDownload, tempFile); err != nil {
-			fmt.Printf("[!] Warning: Failed to create partial list: %v. Using full list.\n", err)
-			// Fallback to full list, reset offsets
-			if realRunner, ok := runner.(*RealCommandRunner); ok && realRunner.ProgressState != nil {
-				realRunner.ProgressState.InitialSkipped = 0
-				realRunner.ProgressState.SkippedCount = 0
-				realRunner.ProgressState.CurrentIndex = 0
+// Exhausted reports whether the downloads so far have reached the budget. A nil
+// budget is never exhausted.
+func (b *sizeBudget) Exhausted() bool {
+	return b != nil && b.Used() >= b.limit
+}
+
+// budgetFlag names the limit that stopped a collection early, for the console message
+func budgetFlag(config *Config) string {
+	if config.SizeBudget.Exhausted() {
+		return "--size-budget"
+	}
+	return "--max-runtime"
+}
+
+// extraArgsEnv names the environment variable holding yt-dlp options to always append
+const extraArgsEnv = "YTDLP_EXTRA_ARGS"
+
+// splitArgs splits a command-line string into arguments on whitespace. Single or double
+// quotes group text containing spaces (--format "best video") and may sit inside an
+// argument (--format="best video"); "" gives an empty argument. Backslashes are taken
+// literally so Windows paths survive.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
 			}
-		} else {
-			targetFile = tempFile
-			defer func() { _ = os.Remove(tempFile) }() // Clean up temp file
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
 		}
 	}
-
-	// Build yt-dlp arguments with metadata options
-	args := []string{
-		"-a", targetFile,
-		"--output", outputFormat,
-		"--write-info-json", // Save metadata JSON for each video
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in %q", quote, s)
+	}
+	if inArg {
+		args = append(args, current.String())
 	}
+	return args, nil
+}
 
-	// Add thumbnail download unless skipped
-	if !skipThumbnails {
-		args = append(args, "--write-thumbnail")
-		args = append(args, "--convert-thumbnails", "jpg") // Ensure consistent .jpg extension
-	}
+// defaultOutputTemplate names downloads by upload date, video ID and truncated title
+const defaultOutputTemplate = "%(upload_date)s_%(id)s_%(title).50B.%(ext)s"
 
-	// Add cookie arguments if configured
-	if cookieFile != "" {
-		args = append(args, "--cookies", cookieFile)
+// outputTemplateFor returns the yt-dlp output template for a run: the --output-template
+// of the entries' source when they all share one, otherwise defaultOutputTemplate
+func outputTemplateFor(config *Config, entries []VideoEntry) string {
+	if len(config.OutputTemplates) == 0 || len(entries) == 0 {
+		return defaultOutputTemplate
 	}
-	if cookieFromBrowser != "" {
-		args = append(args, "--cookies-from-browser", cookieFromBrowser)
+	source := sanitizeCollectionName(entries[0].Collection)
+	for _, entry := range entries[1:] {
+		if sanitizeCollectionName(entry.Collection) != source {
+			return defaultOutputTemplate
+		}
 	}
-
-	// Add resume functionality flags unless disabled
-	if !disableResume {
-		// Add flags for resume functionality
-		args = append(args, "--download-archive", archivePath)
-		args = append(args, "--no-overwrites")
-		args = append(args, "--continue")
+	if template, ok := config.OutputTemplates[source]; ok {
+		return template
 	}
+	return defaultOutputTemplate
+}
 
-	// Execute and capture output
-	output, err := runner.Run(cmdStr, args...)
-
-	// Parse output to extract failures
-	failures := parseYtdlpOutput(output.Combined, videosToDownload)
+// outputTemplateMap collects repeated --output-template SOURCE=TEMPLATE values
+type outputTemplateMap map[string]string
 
-	// Build result summary
-	// Get final skipped count from state (includes those skipped by yt-dlp during run)
-	finalSkipped := skippedCount
-	if realRunner, ok := runner.(*RealCommandRunner); ok && realRunner.ProgressState != nil {
-		finalSkipped = realRunner.ProgressState.SkippedCount
+func (m outputTemplateMap) String() string {
+	pairs := make([]string, 0, len(m))
+	for source, template := range m {
+		pairs = append(pairs, source+"="+template)
 	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
 
-	result := &CollectionResult{
-		Name:           filepath.Base(filepath.Dir(outputName)),
-		Attempted:      len(entries),
-		Failed:         len(failures),
-		Success:        len(entries) - len(failures) - finalSkipped,
-		Skipped:        finalSkipped,
-		FailureDetails: failures,
+// Set validates and records one source's template
+func (m outputTemplateMap) Set(value string) error {
+	source, template, found := strings.Cut(value, "=")
+	source = strings.ToLower(strings.TrimSpace(source))
+	template = strings.TrimSpace(template)
+	if !found || source == "" || template == "" {
+		return fmt.Errorf("invalid output template %q (expected SOURCE=TEMPLATE, e.g. liked=liked/%%(id)s.%%(ext)s)", value)
 	}
-
-	// Safety check for negative success count
-	if result.Success < 0 {
-		result.Success = 0
+	if !strings.Contains(template, "%(ext)s") {
+		return fmt.Errorf("output template for %s must contain %%(ext)s", source)
 	}
-
-	if err != nil || len(failures) > 0 {
-		fmt.Printf("[!] Download completed with %d failures out of %d videos.\n",
-			result.Failed, len(videosToDownload))
-	} else {
-		if skippedCount > 0 {
-			fmt.Printf("[*] Successfully downloaded %d new videos.\n", result.Success)
-		} else {
-			fmt.Printf("[*] Successfully downloaded all %d videos.\n", result.Success)
-		}
+	if _, dup := m[source]; dup {
+		return fmt.Errorf("output template for %s given more than once", source)
 	}
-
-	return result, err
+	m[source] = template
+	return nil
 }
 
-// HTML template for the visual index browser
-//
-//go:embed templates/index.html
-var htmlTemplate string
+// headerList collects repeated --add-header values
+type headerList []string
 
-// getTemplateFuncs returns template helper functions for HTML template rendering.
-//
-// Thread-safety: This function returns a new FuncMap on each call, so it is safe to
-// call concurrently from multiple goroutines. The returned FuncMap itself contains
-// closures that are stateless and safe for concurrent use within Go's html/template
-// package, which handles synchronization internally during template execution.
-//
-// Note: Currently, the application generates indexes sequentially, but this function
-// is designed to support concurrent index generation if needed in the future.
-func getTemplateFuncs() template.FuncMap {
-	return template.FuncMap{
-		"formatDuration": func(seconds int) string {
-			m := seconds / 60
-			s := seconds % 60
-			return fmt.Sprintf("%d:%02d", m, s)
-		},
-		"formatNumber": func(n int64) string {
-			if n >= 1000000 {
-				return fmt.Sprintf("%.1fM", float64(n)/1000000)
-			}
-			if n >= 1000 {
-				return fmt.Sprintf("%.1fK", float64(n)/1000)
-			}
-			return fmt.Sprintf("%d", n)
-		},
-	}
+func (h *headerList) String() string {
+	return strings.Join(*h, ", ")
 }
 
-// writeJSONIndex writes the collection index as JSON
-func writeJSONIndex(dir string, index *CollectionIndex) error {
-	data, err := json.MarshalIndent(index, "", "  ")
-	if err != nil {
+// Set validates and appends one header
+func (h *headerList) Set(value string) error {
+	if err := validateHeader(value); err != nil {
 		return err
 	}
-	return os.WriteFile(filepath.Join(dir, "index.json"), data, 0644)
+	*h = append(*h, value)
+	return nil
 }
 
-// writeHTMLIndex generates the HTML visual browser
-func writeHTMLIndex(dir string, index *CollectionIndex) error {
-	tmpl, err := template.New("index").Funcs(getTemplateFuncs()).Parse(htmlTemplate)
-	if err != nil {
-		return err
+// validateHeader checks a header has the "Key: Value" form with a valid field name
+func validateHeader(header string) error {
+	key, value, found := strings.Cut(header, ":")
+	key = strings.TrimSpace(key)
+	if !found || key == "" || strings.TrimSpace(value) == "" {
+		return fmt.Errorf("invalid header %q (expected \"Key: Value\")", header)
 	}
-
-	f, err := os.Create(filepath.Join(dir, "index.html"))
-	if err != nil {
-		return err
+	for _, r := range key {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return fmt.Errorf("invalid header name %q", key)
+		}
 	}
-	defer func() { _ = f.Close() }()
+	return nil
+}
 
-	return tmpl.Execute(f, index)
+// defaultConfigFile is the config file read from the current directory when --config isn't given
+const defaultConfigFile = "tiktok-favvideo-downloader.json"
+
+// ConfigFile is the structure of the config file. Each profile maps flag names
+// (without dashes) to values, e.g. {"audio": {"media-ext": "mp3,m4a", "no-thumbnails": true}}.
+// Repeatable flags such as add-header take a list. Defaults has the same form and is
+// applied on every run, profile or not.
+type ConfigFile struct {
+	Defaults map[string]any            `json:"defaults"`
+	Profiles map[string]map[string]any `json:"profiles"`
 }
 
-// generateCollectionIndex creates JSON and HTML indexes for a collection after download.
-// It enriches entries with metadata from yt-dlp's .info.json files and generates
-// both index.json (machine-readable) and index.html (visual browser) files.
-func generateCollectionIndex(collectionDir string, entries []VideoEntry, failures []FailureDetail) error {
-	collectionName := filepath.Base(collectionDir)
-	fmt.Printf("[*] Generating index for %s (%d videos)...\n", collectionName, len(entries))
-	// 1. Scan for .info.json files in the directory
-	infoFiles, err := filepath.Glob(filepath.Join(collectionDir, "*.info.json"))
+// userConfigPath returns the per-user config file read in every working directory,
+// e.g. %AppData%\tiktok-dl\config.json ("" if there is no user config directory)
+func userConfigPath() string {
+	dir, err := os.UserConfigDir()
 	if err != nil {
-		return fmt.Errorf("collection %q: error scanning for info files: %v", collectionName, err)
+		return ""
 	}
+	return filepath.Join(dir, "tiktok-dl", "config.json")
+}
 
-	// 2. Build video ID to info map
-	infoMap := make(map[string]*YtdlpInfo)
-	for _, f := range infoFiles {
-		info, err := parseInfoJSON(f)
-		if err != nil {
-			fmt.Printf("[!] Warning: Failed to parse %s: %v\n", f, err)
+// applyConfigLayers fills in the flags on fs not given on the command line from the
+// config files at paths, nearest first (the working directory's, then the user's; ""
+// entries and missing files are skipped). The named profile comes first, from the
+// nearest file that has it, followed by each file's defaults, so the precedence is
+// flags > profile > working-directory defaults > user defaults. Returns the file the
+// profile came from and the files whose defaults were applied.
+func applyConfigLayers(fs *flag.FlagSet, profileName string, paths []string) (profilePath string, defaultsFrom []string, err error) {
+	var files []*ConfigFile
+	var filePaths []string
+	for _, path := range paths {
+		if path == "" {
 			continue
 		}
-		infoMap[info.ID] = info
+		cfg, err := loadConfigFile(path)
+		if err != nil {
+			return "", nil, err
+		}
+		if cfg != nil {
+			files = append(files, cfg)
+			filePaths = append(filePaths, path)
+		}
 	}
-	fmt.Printf("[*] Found %d metadata files for %s\n", len(infoMap), collectionName)
 
-	// 3. Build failure map for quick lookup
-	failureMap := make(map[string]string)
-	for _, f := range failures {
-		failureMap[f.VideoID] = f.ErrorMessage
+	if profileName != "" {
+		for i, cfg := range files {
+			if profile, ok := cfg.Profiles[profileName]; ok {
+				if err := applyProfile(fs, profileName, profile); err != nil {
+					return "", nil, err
+				}
+				profilePath = filePaths[i]
+				break
+			}
+		}
+		if profilePath == "" {
+			return "", nil, missingProfileError(profileName, files, filePaths, paths)
+		}
 	}
 
-	// 4. Create a copy of entries to avoid mutating the input slice
-	enrichedEntries := make([]VideoEntry, len(entries))
-	copy(enrichedEntries, entries)
-
-	// 5. Enrich entries with metadata
-	for i := range enrichedEntries {
-		videoID := extractVideoID(enrichedEntries[i].Link)
-		enrichedEntries[i].VideoID = videoID
-
-		// Warn if video ID could not be extracted from URL
-		if videoID == "" {
-			fmt.Printf("[!] Warning: Could not extract video ID from URL: %s\n", enrichedEntries[i].Link)
-			enrichedEntries[i].Downloaded = false
-			enrichedEntries[i].DownloadError = "Invalid URL format - could not extract video ID"
+	for i, cfg := range files {
+		if len(cfg.Defaults) == 0 {
 			continue
 		}
+		if err := applyProfile(fs, "defaults in "+filePaths[i], cfg.Defaults); err != nil {
+			return "", nil, err
+		}
+		defaultsFrom = append(defaultsFrom, filePaths[i])
+	}
+	return profilePath, defaultsFrom, nil
+}
 
-		if info, ok := infoMap[videoID]; ok {
-			enrichedEntries[i].Title = info.Title
-			enrichedEntries[i].Creator = info.Uploader
-			enrichedEntries[i].CreatorID = info.UploaderID
-			enrichedEntries[i].UploadDate = info.UploadDate
-			enrichedEntries[i].Description = info.Description
-			enrichedEntries[i].Duration = info.Duration
-			enrichedEntries[i].ViewCount = info.ViewCount
-			enrichedEntries[i].LikeCount = info.LikeCount
-			enrichedEntries[i].ThumbnailURL = info.Thumbnail
-
-			// Determine the local filename from the info (use basename only)
-			baseFilename := ""
-			if info.Filename != "" {
-				// Normalize path separators before extracting basename
-				// yt-dlp may write Windows-style paths (\) in .info.json even on Unix systems
-				// (e.g., if the file was created on Windows and read on Linux, or vice versa)
-				normalizedFilename := strings.ReplaceAll(info.Filename, "\\", "/")
-				baseFilename = filepath.Base(normalizedFilename)
-				enrichedEntries[i].LocalFilename = baseFilename
-			} else {
-				// Fallback: If filename is not in .info.json, try to find the video file by video ID
-				// This handles cases where yt-dlp doesn't populate the filename field
-				// Look for files matching the pattern: *_<videoID>_*.mp4 (or other video extensions)
-				pattern := filepath.Join(collectionDir, fmt.Sprintf("*_%s_*", videoID))
-				matches, err := filepath.Glob(pattern + ".*")
-				if err == nil && len(matches) > 0 {
-					// Found potential matches - filter for video files (exclude .info.json, .part, .ytdl, etc.)
-					for _, match := range matches {
-						ext := strings.ToLower(filepath.Ext(match))
-						if ext == ".mp4" || ext == ".mkv" || ext == ".webm" || ext == ".mov" {
-							baseFilename = filepath.Base(match)
-							enrichedEntries[i].LocalFilename = baseFilename
-							break
-						}
-					}
-				}
-			}
-
-			// Check if video file actually exists (not just .info.json)
-			videoPath := filepath.Join(collectionDir, baseFilename)
-			partialPath := videoPath + ".part"
-
-			if _, err := os.Stat(partialPath); err == nil {
-				// Partial download exists
-				enrichedEntries[i].Downloaded = false
-				enrichedEntries[i].DownloadError = "Download incomplete (found .part file)"
-			} else if baseFilename != "" {
-				if _, err := os.Stat(videoPath); err == nil {
-					// Full video file exists
-					enrichedEntries[i].Downloaded = true
-				} else {
-					// Info exists but video file is missing
-					enrichedEntries[i].Downloaded = false
-					enrichedEntries[i].DownloadError = "Video file missing (metadata only)"
-				}
-			} else {
-				// No filename in metadata
-				enrichedEntries[i].Downloaded = false
-				enrichedEntries[i].DownloadError = "Metadata incomplete (missing filename)"
-			}
-
-			// Check for thumbnail file (try common extensions)
-			// Use the base filename (without extension) to search for thumbnails
-			if baseFilename != "" {
-				baseWithoutExt := strings.TrimSuffix(baseFilename, filepath.Ext(baseFilename))
-				for _, ext := range []string{".jpg", ".webp", ".png", ".JPG", ".WEBP", ".PNG"} {
-					thumbFilename := baseWithoutExt + ext
-					thumbPath := filepath.Join(collectionDir, thumbFilename)
-					if _, err := os.Stat(thumbPath); err == nil {
-						enrichedEntries[i].ThumbnailFile = thumbFilename
-						break
-					}
-				}
-			}
-		} else {
-			enrichedEntries[i].Downloaded = false
-			// Use actual error message if available
-			if errMsg, ok := failureMap[videoID]; ok {
-				enrichedEntries[i].DownloadError = errMsg
-			} else {
-				enrichedEntries[i].DownloadError = "Video not downloaded or metadata unavailable"
+// missingProfileError explains that no config file has the named profile
+func missingProfileError(name string, files []*ConfigFile, filePaths, paths []string) error {
+	if len(files) == 0 {
+		return fmt.Errorf("--profile %s: config file %s not found", name, paths[0])
+	}
+	var names []string
+	for _, cfg := range files {
+		for n := range cfg.Profiles {
+			if !slices.Contains(names, n) {
+				names = append(names, n)
 			}
 		}
 	}
+	sort.Strings(names)
+	return fmt.Errorf("profile %q not found in %s (available: %s)", name, strings.Join(filePaths, " or "), strings.Join(names, ", "))
+}
 
-	// 5. Create index struct
-	index := CollectionIndex{
-		Name:        filepath.Base(collectionDir),
-		GeneratedAt: time.Now().Format("2006-01-02 15:04:05"),
-		TotalVideos: len(enrichedEntries),
-		Videos:      enrichedEntries,
+// loadConfigFile reads the config file at path. A missing file is not an error and returns nil.
+func loadConfigFile(path string) (*ConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %s: %v", path, err)
 	}
 
-	// Count downloaded/failed
-	for _, e := range enrichedEntries {
-		if e.Downloaded {
-			index.Downloaded++
-		} else {
-			index.Failed++
-		}
+	var cfg ConfigFile
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%w in config file %s: %w", ErrJSONParse, path, err)
 	}
+	return &cfg, nil
+}
 
-	// 5. Write JSON index
-	if err := writeJSONIndex(collectionDir, &index); err != nil {
-		return fmt.Errorf("collection %q: error writing JSON index: %v", collectionName, err)
+// profileValueStrings converts a profile value from the config file into the string
+// form(s) flag.Set expects. Lists produce one value per element.
+func profileValueStrings(value any) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}, nil
+	case []any:
+		var values []string
+		for _, item := range v {
+			strs, err := profileValueStrings(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, strs...)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unsupported value %v", value)
 	}
+}
 
-	// 6. Generate HTML index
-	if err := writeHTMLIndex(collectionDir, &index); err != nil {
-		return fmt.Errorf("collection %q: error writing HTML index: %v", collectionName, err)
+// applyProfile sets the flags in profile on fs, skipping any flag already given on the
+// command line so explicit flags always win over profile values
+func applyProfile(fs *flag.FlagSet, name string, profile map[string]any) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	keys := make([]string, 0, len(profile))
+	for key := range profile {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 
+	for _, key := range keys {
+		flagName := strings.TrimLeft(key, "-")
+		if flagName == "profile" || flagName == "config" || fs.Lookup(flagName) == nil {
+			return fmt.Errorf("profile %q: unknown flag %q", name, key)
+		}
+		if explicit[flagName] {
+			continue
+		}
+		values, err := profileValueStrings(profile[key])
+		if err != nil {
+			return fmt.Errorf("profile %q: flag %q: %v", name, key, err)
+		}
+		for _, value := range values {
+			if err := fs.Set(flagName, value); err != nil {
+				return fmt.Errorf("profile %q: flag %q: %v", name, key, err)
+			}
+		}
+	}
 	return nil
 }
 
-// getEntriesForCollection filters video entries for a specific collection
-func getEntriesForCollection(entries []VideoEntry, collection string) []VideoEntry {
-	var result []VideoEntry
-	for _, e := range entries {
-		if sanitizeCollectionName(e.Collection) == collection {
-			result = append(result, e)
-		}
-	}
-	return result
+// flagWasSet reports whether the named flag was given on the command line
+func flagWasSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
 
 func getExeName() string {
@@ -1777,11 +8685,95 @@ func parseFlags() *Config {
 
 	flatStructure := flag.Bool("flat-structure", false, "Disable collection organization (use flat directory structure)")
 	noThumbnails := flag.Bool("no-thumbnails", false, "Skip thumbnail download (faster, less storage)")
+	ndjson := flag.Bool("ndjson", false, "Print one JSON object (url, source, date) per extracted video to stdout instead of downloading; logs go to stderr")
+	countOnly := flag.Bool("count-only", false, "Print only the number of extracted video URLs to stdout instead of downloading; logs go to stderr")
+	exportCreators := flag.String("export-creators", "", "Write the unique creators' profile URLs to this file (OPML for .opml, otherwise one URL per line) instead of downloading")
+	printCommand := flag.Bool("print-command", false, "Write the URL lists and print only the yt-dlp command line(s) to stdout instead of downloading; logs go to stderr")
+	printPlan := flag.Bool("print-plan", false, "Print the execution plan (sources, counts, output dir, yt-dlp and its commands) as JSON to stdout before downloading; logs go to stderr")
+	dryRun := flag.Bool("dry-run", false, "With --print-plan, write the URL lists and print the plan without downloading")
+	copyCommand := flag.Bool("copy-command", false, "Like --print-command, and also copy the command line(s) to the clipboard")
 	indexOnly := flag.Bool("index-only", false, "Regenerate indexes from existing .info.json files without downloading")
 	disableResume := flag.Bool("disable-resume", false, "Disable resume functionality (force re-download all videos)")
+	autoResume := flag.Bool("resume", false, "Resume an interrupted batch from where it stopped without asking")
 	noProgressBar := flag.Bool("no-progress-bar", false, "Disable progress bar (use traditional line-by-line output)")
+	tui := flag.Bool("tui", false, "Show a live list of downloading, finished and failed videos (ANSI terminals only)")
 	cookies := flag.String("cookies", "", "Path to Netscape cookies.txt file for authentication")
 	cookiesFromBrowser := flag.String("cookies-from-browser", "", "Extract cookies from browser (chrome, firefox, edge, safari, etc.)")
+	paste := flag.Bool("paste", false, "If the JSON file isn't found, read the export pasted to the clipboard instead")
+	find := flag.Bool("find", false, "Search the Downloads folder for the newest TikTok export (.json or .zip)")
+	perVideoTimeout := flag.Duration("per-video-timeout", 0, "Run yt-dlp once per video and skip any video taking longer than this (e.g. 5m)")
+	timings := flag.Bool("timings", false, "Run yt-dlp once per video and record each video's download time and size in timings.csv")
+	runRetries := flag.Int("run-retries", 0, "Re-run a failed yt-dlp invocation (each batch, chunk or, with --per-video-timeout, each video) up to N times")
+	runRetryDelay := flag.Duration("run-retry-delay", 30*time.Second, "Wait this long before each --run-retries attempt")
+	splitBySource := flag.Bool("split-by-source", false, "With --flat-structure, write one URL list per source (favorites.txt, liked.txt) instead of one merged list")
+	separateRuns := flag.Bool("separate-runs", false, "With --flat-structure, run yt-dlp once per source into its own subdirectory with its own results.txt")
+	normalizeURLs := flag.Bool("normalize-urls", false, "Canonicalize video URLs (strip query strings and regional paths, lowercase host) before writing")
+	stripQueryFlag := flag.Bool("strip-query", false, "Remove only the query string (?lang=..., tracking params) from video URLs before writing")
+	incrementalIndex := flag.Bool("incremental-index", false, "Merge new results into the existing index instead of rebuilding it")
+	since := flag.String("since", "", "Only download videos favorited after this date (YYYY-MM-DD or relative like 30d, 6mo, 1y); \"auto\" skips downloaded videos older than the newest existing file")
+	includeHistory := flag.Bool("include-history", false, "Also download the videos in the export's browsing history (watched videos)")
+	watchedSince := flag.String("watched-since", "", "With --include-history, only keep videos watched after this date (YYYY-MM-DD or relative like 7d, 2w)")
+	until := flag.String("until", "", "Only download videos favorited up to this date (YYYY-MM-DD or relative like 30d, 6mo, 1y)")
+	favoritesSince := flag.String("favorites-since", "", "Only download favorites favorited after this date (YYYY-MM-DD or relative like 30d); other sources are unaffected")
+	favoritesUntil := flag.String("favorites-until", "", "Only download favorites favorited up to this date (YYYY-MM-DD or relative like 30d); other sources are unaffected")
+	likedSince := flag.String("liked-since", "", "Only download liked videos liked after this date (YYYY-MM-DD or relative like 30d); other sources are unaffected")
+	likedUntil := flag.String("liked-until", "", "Only download liked videos liked up to this date (YYYY-MM-DD or relative like 30d); other sources are unaffected")
+	runLog := flag.Bool("run-log", false, "Also write the full console output to run-<timestamp>.log")
+	dedupeExisting := flag.Bool("dedupe-existing", false, "Scan the output folders for already-downloaded video IDs and skip those URLs")
+	precheck := flag.Bool("precheck", false, "Send a HEAD request for each URL first and drop links that return 404/410")
+	chunkSize := flag.Int("chunk-size", 0, "Split URL lists into batch files of N URLs and run yt-dlp once per file (0 = off)")
+	includeIDsFile := flag.String("include-ids-file", "", "Only download videos whose IDs (or URLs) are listed in this file, one per line")
+	afterID := flag.String("after-id", "", "Only download the videos listed after this video ID (or URL) in its collection, to continue a partial run")
+	skipKnown := flag.String("skip-known", "", "Don't download videos a previous run's index.json, index.html or .m3u playlist lists as downloaded")
+	excludeIDsFile := flag.String("exclude-ids-file", "", "Never download videos whose IDs (or URLs) are listed in this file, one per line")
+	order := flag.String("order", OrderOriginal, "URL list order: original (export order, liked after favorites) or chronological (oldest favorited/liked first)")
+	limitPerUploaderFlag := flag.Int("limit-per-uploader", 0, "Keep at most N videos per uploader (0 = unlimited)")
+	batchByUploader := flag.Bool("batch-by-uploader", false, "Group the URL list by uploader so each creator's videos are downloaded together")
+	uploaderStats := flag.Int("uploader-stats", 0, "After parsing, print the number of unique uploaders and the top N by video count")
+	mediaExt := flag.String("media-ext", "", "Comma-separated media file extensions to look for when indexing (e.g. mp3,m4a for audio downloads)")
+	reportOnly := flag.String("report-only", "", "Regenerate index.html/index.json and results.txt for an existing output directory without downloading")
+	clientCert := flag.String("client-cert", "", "PEM client certificate for downloading from mirrors that require one")
+	clientKey := flag.String("client-key", "", "PEM private key for --client-cert")
+	schemaMap := flag.String("schema-map", "", "JSON file mapping the favorites/liked list paths and link/date fields to a changed export layout")
+	favoritesPath := flag.String("favorites-path", "", "Path to the favorites list in the export JSON, e.g. \"Activity.Favorite Videos.FavoriteVideoList\"")
+	releaseFile := flag.String("release-file", "", "Saved GitHub API release JSON to read yt-dlp's release info from instead of the live API")
+	insecureSkipVerify := flag.Bool("insecure-skip-verify", false, "Don't verify TLS certificates when downloading (only for mirrors with self-signed certs)")
+	noWaitYtdlp := flag.Bool("no-wait-ytdlp", false, "If yt-dlp.exe can't be downloaded, print the manual download link and continue instead of waiting for it")
+	writeLauncher := flag.Bool("write-launcher", false, "Save the suggested yt-dlp command as run_download.bat (Windows) or run_download.sh to re-run later")
+	writeYtdlpConf := flag.Bool("write-ytdlp-conf", false, "Save each list's yt-dlp options as <list>.yt-dlp.conf for use with yt-dlp --config-location")
+	traceHTTP := flag.Bool("trace-http", false, "Log DNS, connect, TLS and first-byte timings for the GitHub and yt-dlp.exe downloads")
+	ytdlpPath := flag.String("ytdlp-path", "", "Use this yt-dlp binary and never download or update yt-dlp.exe (for offline machines)")
+	maxConnections := flag.Int("max-connections", 0, "Open at most N connections per host when downloading yt-dlp.exe (0 = no limit)")
+	binaryDownloadTimeout := flag.Duration("timeout-binary-download", 10*time.Minute, "Give up downloading/updating yt-dlp.exe after this long (0 = no limit)")
+	maxRuntime := flag.Duration("max-runtime", 0, "Stop starting new downloads after this long and save what's left for the next run (e.g. 2h)")
+	var headers headerList
+	outputTemplates := outputTemplateMap{}
+	flag.Var(outputTemplates, "output-template", "yt-dlp output template for one source as SOURCE=TEMPLATE, e.g. liked=liked/%(title)s.%(ext)s (repeatable)")
+	flag.Var(&headers, "add-header", "Extra HTTP header for yt-dlp as \"Key: Value\" (repeatable)")
+	minResolution := flag.Int("min-resolution", 0, "Prefer video formats at least this many pixels tall (e.g. 480)")
+	maxResolution := flag.Int("max-resolution", 0, "Prefer video formats at most this many pixels tall (e.g. 720)")
+	rotateUserAgent := flag.Bool("rotate-user-agent", false, "Rotate through realistic browser User-Agents for downloads and yt-dlp runs")
+	mergeOutput := flag.String("merge-output", "", "After downloading, concatenate all videos in list order into this file with ffmpeg")
+	webhookURL := flag.String("webhook-url", "", "POST a JSON summary (counts, duration) to this URL when the run ends, including on errors")
+	notifyFormat := flag.String("notify-format", NotifyFormatJSON, "Webhook payload format: json, discord or slack")
+	groupIndexBy := flag.String("group-index-by", GroupIndexNone, "Section the index.html gallery by date (month saved), uploader or none")
+	filenameMatchFlag := flag.String("filename-match", FilenameMatchID, "How loosely downloaded files are matched to videos for the index: exact, id or fuzzy")
+	resultsFormat := flag.String("results-format", ResultsFormatText, "Run report format: text (results.txt) or apache (combined-log lines in results.log)")
+	dbPath := flag.String("db", "", "SQLite database file to store per-video results in (created if missing)")
+	manifest := flag.Bool("manifest", false, "Record each downloaded file's size and SHA-256 in manifest.json so the scan command can detect changes")
+	urlMapping := flag.Bool("url-mapping", false, "Write mapping.json linking each downloaded file to its original TikTok URL")
+	runManifest := flag.Bool("run-manifest", false, "Write run_manifest.json and run_manifest.csv joining each export entry with its download result")
+	maxFilesize := flag.String("max-filesize", "", "Skip videos larger than this size, e.g. 100M or 1.5G (yt-dlp --max-filesize)")
+	sizeBudgetFlag := flag.String("size-budget", "", "Stop starting new downloads once this much has been downloaded in the run, e.g. 10G")
+	writeComments := flag.Bool("write-comments", false, "Save each video's comments into its .info.json (slower)")
+	writeSubs := flag.Bool("write-subs", false, "Download subtitles uploaded with each video")
+	writeAutoSubs := flag.Bool("write-auto-subs", false, "Download automatically generated captions")
+	subLangs := flag.String("sub-langs", "", "Subtitle languages to download, comma-separated (e.g. en,de or en.*; needs --write-subs or --write-auto-subs)")
+	fragments := flag.Int("fragments", 0, "Number of fragments yt-dlp downloads concurrently per video (yt-dlp -N)")
+	workDir := flag.String("work-dir", "", "Write URL lists, downloads, indexes and reports under this directory instead of the current one")
+	findDir := flag.String("find-dir", "", "Directory to search for the newest TikTok export (implies --find)")
+	configPath := flag.String("config", defaultConfigFile, "Config file to read --profile from")
+	profile := flag.String("profile", "", "Apply the named profile from the config file (explicit flags override it)")
 	help := flag.Bool("help", false, "Show help message")
 	h := flag.Bool("h", false, "Show help message")
 
@@ -1792,6 +8784,20 @@ func parseFlags() *Config {
 		os.Exit(0)
 	}
 
+	// Fill in flags from the selected profile and the config files' defaults; anything
+	// given explicitly is kept, and the working directory's file wins over the user's
+	profilePath, defaultsFrom, err := applyConfigLayers(flag.CommandLine, *profile, []string{*configPath, userConfigPath()})
+	if err != nil {
+		fmt.Printf("[!!!] Error: %v\n", err)
+		os.Exit(1)
+	}
+	if profilePath != "" {
+		fmt.Printf("[*] Using profile %q from %s\n", *profile, profilePath)
+	}
+	for _, path := range defaultsFrom {
+		fmt.Printf("[*] Using defaults from %s\n", path)
+	}
+
 	// Check mutual exclusivity of cookie flags
 	if *cookies != "" && *cookiesFromBrowser != "" {
 		fmt.Println("[!!!] Error: Cannot use both --cookies and --cookies-from-browser")
@@ -1801,10 +8807,306 @@ func parseFlags() *Config {
 	config.OrganizeByCollection = !*flatStructure
 	config.SkipThumbnails = *noThumbnails
 	config.IndexOnly = *indexOnly
+	config.NDJSON = *ndjson
+	config.CountOnly = *countOnly
+	config.ExportCreators = *exportCreators
+	config.CopyCommand = *copyCommand
+	config.PrintCommand = *printCommand || config.CopyCommand
+	config.PrintPlan = *printPlan
+	config.DryRun = *dryRun
+	if config.DryRun && !config.PrintPlan {
+		fmt.Println("[!!!] Error: --dry-run needs --print-plan")
+		os.Exit(1)
+	}
+	if (config.NDJSON && config.CountOnly) || (config.PrintCommand && (config.NDJSON || config.CountOnly)) ||
+		(config.PrintPlan && (config.NDJSON || config.CountOnly || config.PrintCommand)) {
+		fmt.Println("[!!!] Error: only one of --ndjson, --count-only, --print-command and --print-plan can be used")
+		os.Exit(1)
+	}
 	config.DisableResume = *disableResume
+	config.AutoResume = *autoResume
 	config.DisableProgressBar = *noProgressBar
+	config.TUI = *tui
 	config.CookieFile = *cookies
 	config.CookieFromBrowser = *cookiesFromBrowser
+	config.FindDir = *findDir
+	config.WorkDir = *workDir
+	config.Paste = *paste
+	config.Find = *find || *findDir != ""
+	config.PerVideoTimeout = *perVideoTimeout
+	config.WriteTimings = *timings
+	config.RunRetries = *runRetries
+	config.RunRetryDelay = *runRetryDelay
+	if config.RunRetries < 0 || config.RunRetryDelay < 0 {
+		fmt.Println("[!!!] Error: --run-retries and --run-retry-delay must not be negative")
+		os.Exit(1)
+	}
+
+	config.IncrementalIndex = *incrementalIndex
+	config.SplitBySource = *splitBySource || *separateRuns
+	config.SeparateRuns = *separateRuns
+	config.NormalizeURLs = *normalizeURLs
+	config.StripQuery = *stripQueryFlag
+	config.MediaExtensions = parseMediaExtensions(*mediaExt)
+	config.ReportOnly = *reportOnly
+	config.ClientCert = *clientCert
+	config.ClientKey = *clientKey
+	config.InsecureSkipVerify = *insecureSkipVerify
+	config.ConcurrentFragments = *fragments
+	config.MaxFilesize = *maxFilesize
+	if err := validateMaxFilesize(config.MaxFilesize); err != nil {
+		fmt.Printf("[!!!] Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *sizeBudgetFlag != "" {
+		limit, err := parseByteSize(*sizeBudgetFlag)
+		if err != nil || limit <= 0 {
+			fmt.Printf("[!!!] Error: invalid --size-budget %q (expected e.g. 500M, 10G or 1.5T)\n", *sizeBudgetFlag)
+			os.Exit(1)
+		}
+		config.SizeBudget = newSizeBudget(limit)
+	}
+	config.WriteComments = *writeComments
+	config.WriteSubs = *writeSubs
+	config.WriteAutoSubs = *writeAutoSubs
+	config.SubLangs = *subLangs
+	if err := validateSubtitleFlags(config.SubLangs, config.WriteSubs, config.WriteAutoSubs); err != nil {
+		fmt.Printf("[!!!] Error: %v\n", err)
+		os.Exit(1)
+	}
+	config.URLMapping = *urlMapping
+	config.RunManifest = *runManifest
+	config.Manifest = *manifest
+	config.DBPath = *dbPath
+	config.WebhookURL = *webhookURL
+	config.MergeOutput = *mergeOutput
+	if config.WebhookURL != "" {
+		if u, err := url.Parse(config.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fmt.Println("[!!!] Error: --webhook-url must be an http:// or https:// URL")
+			os.Exit(1)
+		}
+	}
+	config.NotifyFormat = strings.ToLower(*notifyFormat)
+	switch config.NotifyFormat {
+	case NotifyFormatJSON, NotifyFormatDiscord, NotifyFormatSlack:
+	default:
+		fmt.Println("[!!!] Error: --notify-format must be json, discord or slack")
+		os.Exit(1)
+	}
+	config.IndexGrouping = strings.ToLower(*groupIndexBy)
+	switch config.IndexGrouping {
+	case GroupIndexNone, GroupIndexDate, GroupIndexUploader:
+	default:
+		fmt.Println("[!!!] Error: --group-index-by must be date, uploader or none")
+		os.Exit(1)
+	}
+	config.FilenameMatch = strings.ToLower(*filenameMatchFlag)
+	switch config.FilenameMatch {
+	case FilenameMatchExact, FilenameMatchID, FilenameMatchFuzzy:
+	default:
+		fmt.Println("[!!!] Error: --filename-match must be exact, id or fuzzy")
+		os.Exit(1)
+	}
+	config.ResultsFormat = strings.ToLower(*resultsFormat)
+	switch config.ResultsFormat {
+	case ResultsFormatText, ResultsFormatApache:
+	default:
+		fmt.Println("[!!!] Error: --results-format must be text or apache")
+		os.Exit(1)
+	}
+	config.Headers = headers
+	if len(outputTemplates) > 0 {
+		config.OutputTemplates = outputTemplates
+		// A template applies to a whole yt-dlp run, so each source needs its own
+		if !config.OrganizeByCollection && !config.SplitBySource {
+			fmt.Println("[*] --output-template: downloading each source separately (implies --split-by-source)")
+			config.SplitBySource = true
+		}
+	}
+	if *rotateUserAgent {
+		config.UserAgents = newUserAgentRotator(userAgents)
+	}
+	config.MinResolution = *minResolution
+	config.MaxResolution = *maxResolution
+	if err := validateResolutionRange(config.MinResolution, config.MaxResolution); err != nil {
+		fmt.Printf("[!!!] Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *schemaMap != "" {
+		schema, err := loadSchemaMap(*schemaMap)
+		if err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			os.Exit(1)
+		}
+		config.ExportSchema = schema
+	}
+	if *favoritesPath != "" {
+		schema, err := applyFavoritesPath(config.ExportSchema, *favoritesPath)
+		if err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			os.Exit(1)
+		}
+		config.ExportSchema = schema
+	}
+	if *releaseFile != "" {
+		if _, err := os.Stat(*releaseFile); err != nil {
+			fmt.Printf("[!!!] Error: --release-file: %v\n", err)
+			os.Exit(1)
+		}
+		config.ReleaseFile = *releaseFile
+	}
+	config.NoWaitYtdlp = *noWaitYtdlp
+	config.YtdlpPath = *ytdlpPath
+	config.TraceHTTP = *traceHTTP
+	config.WriteLauncher = *writeLauncher
+	config.WriteYtdlpConf = *writeYtdlpConf
+	if extra := os.Getenv(extraArgsEnv); extra != "" {
+		args, err := splitArgs(extra)
+		if err != nil {
+			fmt.Printf("[!!!] Error: %s: %v\n", extraArgsEnv, err)
+			os.Exit(1)
+		}
+		config.ExtraArgs = args
+		fmt.Printf("[*] Passing extra options to yt-dlp from %s: %s\n", extraArgsEnv, strings.Join(args, " "))
+	}
+	config.MaxConnections = *maxConnections
+	if config.MaxConnections < 0 {
+		fmt.Println("[!!!] Error: --max-connections must not be negative")
+		os.Exit(1)
+	}
+	config.BinaryDownloadTimeout = *binaryDownloadTimeout
+	if config.BinaryDownloadTimeout < 0 {
+		fmt.Println("[!!!] Error: --timeout-binary-download must not be negative")
+		os.Exit(1)
+	}
+	config.MaxRuntime = *maxRuntime
+	if config.MaxRuntime < 0 {
+		fmt.Println("[!!!] Error: --max-runtime must not be negative")
+		os.Exit(1)
+	}
+	if config.MaxRuntime > 0 {
+		config.Deadline = time.Now().Add(config.MaxRuntime)
+	}
+	if flagWasSet("fragments") && config.ConcurrentFragments < 1 {
+		fmt.Println("[!!!] Error: --fragments must be a positive integer")
+		os.Exit(1)
+	}
+	if *since != "" {
+		cutoff, auto, err := parseSinceValue(*since)
+		if err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			os.Exit(1)
+		}
+		config.Since, config.AutoSince = cutoff, auto
+	}
+	if *until != "" {
+		cutoff, err := parseDateFilter("until", *until, true, time.Now())
+		if err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			os.Exit(1)
+		}
+		config.Until = cutoff
+	}
+	for _, f := range []struct {
+		name, source, value string
+		until               bool
+	}{
+		{"favorites-since", "favorites", *favoritesSince, false},
+		{"favorites-until", "favorites", *favoritesUntil, true},
+		{"liked-since", "liked", *likedSince, false},
+		{"liked-until", "liked", *likedUntil, true},
+	} {
+		if f.value == "" {
+			continue
+		}
+		cutoff, err := parseDateFilter(f.name, f.value, f.until, time.Now())
+		if err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			os.Exit(1)
+		}
+		if config.SourceWindows == nil {
+			config.SourceWindows = make(map[string]DateWindow)
+		}
+		window := config.SourceWindows[f.source]
+		if f.until {
+			window.Until = cutoff
+		} else {
+			window.Since = cutoff
+		}
+		config.SourceWindows[f.source] = window
+	}
+	config.IncludeHistory = *includeHistory
+	if *watchedSince != "" {
+		cutoff, err := parseDateFilter("watched-since", *watchedSince, false, time.Now())
+		if err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			os.Exit(1)
+		}
+		if !config.IncludeHistory {
+			fmt.Println("[!!!] Error: --watched-since requires --include-history")
+			os.Exit(1)
+		}
+		config.WatchedSince = cutoff
+	}
+	config.DedupeExisting = *dedupeExisting
+	config.Precheck = *precheck
+	config.RunLog = *runLog
+	config.ChunkSize = *chunkSize
+	if config.ChunkSize < 0 {
+		fmt.Println("[!!!] Error: --chunk-size must not be negative")
+		os.Exit(1)
+	}
+	config.Order = strings.ToLower(*order)
+	if config.Order != OrderOriginal && config.Order != OrderChronological {
+		fmt.Println("[!!!] Error: --order must be original or chronological")
+		os.Exit(1)
+	}
+	config.LimitPerUploader = *limitPerUploaderFlag
+	if config.LimitPerUploader < 0 {
+		fmt.Println("[!!!] Error: --limit-per-uploader must not be negative")
+		os.Exit(1)
+	}
+	config.BatchByUploader = *batchByUploader
+	config.UploaderStats = *uploaderStats
+	if config.UploaderStats < 0 {
+		fmt.Println("[!!!] Error: --uploader-stats must not be negative")
+		os.Exit(1)
+	}
+	for _, idList := range []struct {
+		file   string
+		target *map[string]bool
+	}{{*includeIDsFile, &config.IncludeIDs}, {*excludeIDsFile, &config.ExcludeIDs}} {
+		if idList.file == "" {
+			continue
+		}
+		ids, err := parseIDsFile(idList.file)
+		if err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			os.Exit(1)
+		}
+		*idList.target = ids
+	}
+	if *afterID != "" {
+		config.AfterID = parseVideoIDValue(strings.TrimSpace(*afterID))
+		if config.AfterID == "" {
+			fmt.Printf("[!!!] Error: --after-id %q is not a video ID or video URL\n", *afterID)
+			os.Exit(1)
+		}
+	}
+	if *skipKnown != "" {
+		ids, err := parseKnownIDs(*skipKnown)
+		if err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			os.Exit(1)
+		}
+		config.KnownIDs = ids
+		fmt.Printf("[*] Loaded %d known video IDs from '%s'\n", len(ids), *skipKnown)
+	}
+
+	if config.PerVideoTimeout < 0 {
+		fmt.Println("[!!!] Error: --per-video-timeout must not be negative")
+		os.Exit(1)
+	}
 
 	// Validate cookie file if provided
 	if config.CookieFile != "" {
@@ -1822,12 +9124,12 @@ func parseFlags() *Config {
 		}
 	}
 
-	// Handle positional argument for JSON file
+	// Handle positional argument for JSON file (argument > TIKTOK_EXPORT_PATH > default)
 	args := flag.Args()
 	if len(args) > 0 {
 		config.JSONFile = args[0]
 	} else {
-		config.JSONFile = "user_data_tiktok.json"
+		config.JSONFile = defaultExportPath(os.Getenv)
 	}
 
 	return config
@@ -1839,14 +9141,105 @@ func printUsage() {
 
 	fmt.Println("\nUsage:")
 	fmt.Printf("  %s [flags] [optional path to user_data_tiktok.json]\n", exeName)
+	fmt.Printf("  %s <command>\n", exeName)
+	fmt.Println("\nCommands:")
+	fmt.Println("  self-update                Download and install the latest release of this tool")
+	fmt.Println("  doctor [--json] [JSON file]  Check yt-dlp, network access, output folder and export file")
+	fmt.Println("  stats [JSON file]          Summarize an export (counts, uploaders, date range) without downloading")
+	fmt.Println("  diff [--added FILE] <old.json> <new.json>  List videos added/removed between two exports")
+	fmt.Println("  seed-archive [dir]         Write download_archive.txt entries for videos already in dir and its folders")
+	fmt.Println("  resume-archive [dir]       Make download_archive.txt match the videos on disk (re-download deleted ones)")
+	fmt.Println("  clean [--force] [dir]      Delete generated lists, archives, reports and indexes (keeps videos)")
+	fmt.Println("  scan [--concurrency N] [dir]  Check downloaded files against manifest.json in parallel; report deleted or changed ones")
 	fmt.Println("\nFlags:")
 	fmt.Println("  --flat-structure           Disable collection organization (use flat directory structure)")
 	fmt.Println("  --no-thumbnails            Skip thumbnail download (faster, less storage)")
 	fmt.Println("  --index-only               Regenerate indexes from existing .info.json files")
+	fmt.Println("  --ndjson                   Print each extracted video as a JSON line (url, source, date) to stdout")
+	fmt.Println("  --count-only               Print only the number of extracted video URLs to stdout (health checks)")
+	fmt.Println("  --print-command            Write the URL lists and print only the yt-dlp command line(s), then exit")
+	fmt.Println("  --print-plan               Print the execution plan as JSON to stdout before downloading")
+	fmt.Println("  --dry-run                  With --print-plan, exit after the plan without downloading")
+	fmt.Println("  --copy-command             Like --print-command, and also copy the command to the clipboard")
+	fmt.Println("  --export-creators <FILE>   Write the creators' profile URLs to FILE (.opml outline or plain text), then exit")
 	fmt.Println("  --disable-resume           Disable resume functionality (force re-download all videos)")
+	fmt.Println("  --resume                   Resume an interrupted batch from where it stopped without asking")
 	fmt.Println("  --no-progress-bar          Disable progress bar (use traditional line-by-line output)")
+	fmt.Println("  --tui                      Show a live list of downloading/finished/failed videos (falls back on plain consoles)")
 	fmt.Println("  --cookies <FILE>           Path to Netscape cookies.txt file for authentication")
 	fmt.Println("  --cookies-from-browser <NAME>  Extract cookies from browser (chrome, firefox, edge, etc.)")
+	fmt.Println("  --per-video-timeout <DUR>  Run yt-dlp once per video, skipping any that take longer (e.g. 5m)")
+	fmt.Println("  --timings                  Run yt-dlp once per video and write timings.csv (id, seconds, bytes)")
+	fmt.Println("  --run-retries <N>          Re-run a failed yt-dlp invocation up to N times")
+	fmt.Println("  --run-retry-delay <DUR>    Wait this long between --run-retries attempts (default 30s)")
+	fmt.Println("  --split-by-source          With --flat-structure, write favorites.txt/liked.txt instead of one list")
+	fmt.Println("  --separate-runs            With --flat-structure, download each source into its own folder and report")
+	fmt.Println("  --output-template <SRC=T>  yt-dlp output template for one source (repeatable), e.g. liked=liked/%(title)s.%(ext)s")
+	fmt.Println("  --normalize-urls           Strip tracking params and regional paths from URLs (also removes duplicates)")
+	fmt.Println("  --strip-query              Remove only query strings from URLs, keeping the path as is (also removes duplicates)")
+	fmt.Println("  --media-ext <list>         Media extensions to index, e.g. mp3,m4a (default: mp4,mkv,webm,mov)")
+	fmt.Println("  --report-only <dir>        Regenerate index and results.txt for <dir> from the JSON export or a .txt URL list")
+	fmt.Println("  --client-cert <file>       PEM client certificate for mirrors that require one (with --client-key)")
+	fmt.Println("  --schema-map <file>        Map list paths/fields to a changed TikTok export layout")
+	fmt.Println("  --favorites-path <path>    Path to the favorites list in the export JSON")
+	fmt.Println("  --release-file <file>      Read yt-dlp's release info from a saved GitHub API response (offline/dev)")
+	fmt.Println("  --insecure-skip-verify     Don't verify TLS certificates on downloads (self-signed mirrors only; unsafe)")
+	fmt.Println("  --client-key <file>        PEM private key for --client-cert")
+	fmt.Println("  --fragments <N>            Download N fragments of each video in parallel (yt-dlp -N)")
+	fmt.Println("  --max-filesize <SIZE>      Skip videos larger than SIZE, e.g. 100M or 1.5G")
+	fmt.Println("  --size-budget <SIZE>       Stop starting new downloads once SIZE has been downloaded (e.g. 10G); re-run to continue")
+	fmt.Println("  --write-comments           Save each video's comments into its .info.json")
+	fmt.Println("  --write-subs               Download subtitles uploaded with each video")
+	fmt.Println("  --write-auto-subs          Download automatically generated captions")
+	fmt.Println("  --sub-langs <LANGS>        Subtitle languages, comma-separated (e.g. en,de or en.*)")
+	fmt.Println("  --max-runtime <duration>   Stop starting new downloads after this long (e.g. 2h); re-run to continue")
+	fmt.Println("  --timeout-binary-download <DUR>  Give up downloading yt-dlp.exe after this long (default 10m, 0 = no limit)")
+	fmt.Println("  --no-wait-ytdlp            If yt-dlp.exe can't be downloaded, show the manual link and continue")
+	fmt.Println("  --max-connections <N>      Open at most N connections per host for the yt-dlp.exe download")
+	fmt.Println("  --trace-http               Log DNS, connect, TLS and first-byte timings of the tool's downloads")
+	fmt.Println("  --ytdlp-path <file>        Use an existing yt-dlp binary; never contacts GitHub for it (offline mode)")
+	fmt.Println("  --write-launcher           Save the suggested yt-dlp command as run_download.bat/.sh to re-run later")
+	fmt.Println("  --write-ytdlp-conf         Save each list's yt-dlp options as <list>.yt-dlp.conf to re-run with plain yt-dlp")
+	fmt.Println("  --url-mapping              Write mapping.json (local filename -> original TikTok URL) per collection")
+	fmt.Println("  --manifest                 Record each downloaded file's size and SHA-256 in manifest.json per collection")
+	fmt.Println("  --run-manifest             Write run_manifest.json/.csv joining each export entry with its result")
+	fmt.Println("  --webhook-url <url>        POST a JSON run summary (status, counts, duration) when the run ends")
+	fmt.Println("  --merge-output <file>      Concatenate all downloaded videos, in list order, into one file (needs ffmpeg)")
+	fmt.Println("  --notify-format <fmt>      Webhook payload format: json (default), discord or slack")
+	fmt.Println("  --results-format <fmt>     Run report: text (results.txt, default) or apache (combined-log lines in results.log)")
+	fmt.Println("  --db <file>                Upsert per-video results (url, uploader, status, local path, ...) into a SQLite database")
+	fmt.Println("  --add-header \"Key: Value\"  Extra HTTP header forwarded to yt-dlp (repeatable)")
+	fmt.Println("  --min-resolution <px>      Prefer formats at least this tall, e.g. 480 (yt-dlp -S)")
+	fmt.Println("  --max-resolution <px>      Prefer formats at most this tall, e.g. 720 (yt-dlp -S)")
+	fmt.Println("  --rotate-user-agent        Use a different realistic browser User-Agent per request/yt-dlp run")
+	fmt.Println("  --incremental-index        Update the existing index instead of rebuilding it (faster for big libraries)")
+	fmt.Println("  --group-index-by <mode>    Section the index.html gallery by date (month saved), uploader or none (default)")
+	fmt.Println("  --filename-match <mode>    Match downloaded files to videos by exact name, id (default) or fuzzy ID search")
+	fmt.Println("  --limit-per-uploader <N>   Keep at most N videos per uploader")
+	fmt.Println("  --batch-by-uploader        Group the URL list by uploader so each creator's videos download together")
+	fmt.Println("  --uploader-stats <N>       Print the number of unique uploaders and the top N by video count")
+	fmt.Println("  --order <ORDER>            List order: original (default, export order) or chronological (by date, across sources)")
+	fmt.Println("  --include-ids-file <FILE>  Only download the video IDs/URLs listed in FILE (one per line)")
+	fmt.Println("  --exclude-ids-file <FILE>  Skip the video IDs/URLs listed in FILE (one per line)")
+	fmt.Println("  --skip-known <FILE>        Skip videos a previous index.json, index.html or .m3u playlist shows as downloaded")
+	fmt.Println("  --after-id <ID|URL>        Only download the videos after this one in its list (continue a partial run)")
+	fmt.Println("  --since <date|auto>        Only download videos favorited after YYYY-MM-DD or a relative date (30d, 6mo, 1y);")
+	fmt.Println("                             \"auto\" skips downloaded videos older than the newest existing file in the output folder")
+	fmt.Println("  --until <date>             Only download videos favorited up to YYYY-MM-DD or a relative date (30d, 6mo, 1y)")
+	fmt.Println("  --liked-since <date>       Like --since, but only for liked videos (also --liked-until,")
+	fmt.Println("                             --favorites-since, --favorites-until); other sources keep their window")
+	fmt.Println("  --include-history          Also download the videos in your browsing history")
+	fmt.Println("  --watched-since <date>     With --include-history, only videos watched after YYYY-MM-DD or a relative date (7d, 2w)")
+	fmt.Println("  --run-log                  Also save the full console output to run-<timestamp>.log (for diffs/bug reports)")
+	fmt.Println("  --dedupe-existing          Skip videos whose files are already in the output folder (even without an archive)")
+	fmt.Println("  --precheck                 Check each URL with a HEAD request first and drop dead (404/410) links")
+	fmt.Println("  --chunk-size <N>           Split lists into fav_videos_001.txt, _002.txt, ... of N URLs; one yt-dlp run each")
+	fmt.Println("  --find                     Use the newest TikTok export found in your Downloads/Desktop folder")
+	fmt.Println("  --paste                    If the JSON file isn't found, read the export copied to the clipboard")
+	fmt.Println("  --find-dir <DIR>           Search DIR for the newest TikTok export instead (implies --find)")
+	fmt.Println("  --work-dir <DIR>           Keep this run's lists, downloads and reports in DIR (one per export)")
+	fmt.Println("  --profile <name>           Apply a named set of flags from the config file (explicit flags win)")
+	fmt.Printf("  --config <file>            Config file holding profiles (default: %s)\n", defaultConfigFile)
 	fmt.Println("  --help, -h                 Show this help message")
 	fmt.Println("\nExamples:")
 	fmt.Println("  1) Double-click (no arguments) if 'user_data_tiktok.json' is in the same folder.")
@@ -1859,6 +9252,9 @@ func printUsage() {
 	fmt.Printf("  8) Disable progress bar: %s --no-progress-bar\n", exeName)
 	fmt.Printf("  9) Use cookies from file: %s --cookies cookies.txt\n", exeName)
 	fmt.Printf("  10) Extract cookies from Chrome: %s --cookies-from-browser chrome\n", exeName)
+	fmt.Println("\nEnvironment:")
+	fmt.Println("  YTDLP_EXTRA_ARGS           Extra yt-dlp options appended to every run, e.g. --limit-rate 2M --format \"best video\"")
+	fmt.Println("  TIKTOK_EXPORT_PATH         Export to use when no path is given (a path argument takes precedence)")
 	fmt.Println("\nCollection Organization (Default):")
 	fmt.Println("  Videos are organized into subdirectories by collection type:")
 	fmt.Println("    favorites/    - Your favorited videos")
@@ -1873,39 +9269,191 @@ func printUsage() {
 }
 
 func main() {
-	fmt.Printf("[*] TikTok Favorite Videos Extractor (Version %s)\n", version)
+	// --ndjson, --count-only, --print-command and --print-plan keep stdout for their
+	// output, so everything else is logged to stderr
+	pipelineOut := os.Stdout
+	if isNDJSON(os.Args[1:]) || isCountOnly(os.Args[1:]) || isPrintCommand(os.Args[1:]) || isPrintPlan(os.Args[1:]) {
+		os.Stdout = os.Stderr
+	}
+
+	// "doctor --json" keeps stdout pure JSON, so it skips the banner
+	if !isDoctorJSON(os.Args[1:]) {
+		fmt.Printf("[*] TikTok Favorite Videos Extractor (Version %s)\n", version)
+	}
+
+	// Subcommands are handled before regular flag parsing
+	if len(os.Args) > 1 {
+		if code, ok := runSubcommand(os.Args[1], os.Args[2:]); ok {
+			os.Exit(code)
+		}
+	}
 
 	// Parse command line flags
 	config := parseFlags()
 
+	if err := run(config, pipelineOut); err != nil {
+		os.Exit(1)
+	}
+}
+
+// run carries out a parsed command line. Errors are printed where they happen and
+// returned so main exits non-zero. A download run sends its --webhook-url summary on
+// the way out, whether it finished, stopped on an error or never started yt-dlp.
+func run(config *Config, pipelineOut io.Writer) (err error) {
+	session := &DownloadSession{
+		StartTime:   time.Now(),
+		Collections: make([]CollectionResult, 0),
+	}
+	ytdlpRan := false
+	if config.WebhookURL != "" && isDownloadRun(config) {
+		defer func() { notifyWebhook(config, session, ytdlpRan, err) }()
+	}
+
+	// Everything the run writes goes under --work-dir, so several exports don't collide
+	baseDir := workPath(config, ".")
+	if config.WorkDir != "" {
+		if err := os.MkdirAll(config.WorkDir, 0755); err != nil {
+			fmt.Printf("[!!!] Error creating work directory: %v\n", err)
+			return err
+		}
+		fmt.Printf("[*] Using work directory %s\n", config.WorkDir)
+	}
+
+	// Keep a plain-text transcript of the run alongside results.txt
+	if config.RunLog {
+		logPath := workPath(config, runLogFilename(time.Now()))
+		if stop, err := startRunLog(logPath); err != nil {
+			fmt.Printf("[!] Warning: %v\n", err)
+		} else {
+			defer func() { _ = stop() }()
+			fmt.Printf("[*] Writing run log to %s\n", logPath)
+		}
+	}
+
+	// Check for ffmpeg up front rather than after a long download
+	var ffmpegPath string
+	if config.MergeOutput != "" {
+		path, err := findFFmpeg()
+		if err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			return err
+		}
+		ffmpegPath = path
+	}
+
+	// Locate the export in the download folder if requested
+	if config.Find {
+		dirs := defaultFindDirs()
+		if config.FindDir != "" {
+			dirs = []string{config.FindDir}
+		}
+		path, err := locateExport(dirs)
+		if err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			return err
+		}
+		config.JSONFile = path
+	}
+
+	// With --paste (or if the user agrees when asked), fall back to an export copied to the clipboard
+	if _, err := os.Stat(config.JSONFile); os.IsNotExist(err) && (config.Paste || promptForPaste(config.JSONFile)) {
+		path, err := exportFromClipboard(systemClipboard{goos: runtime.GOOS}, config.ExportSchema)
+		if err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			return err
+		}
+		config.JSONFile = path
+	}
+
 	// Check if JSON file exists before proceeding
 	if _, err := os.Stat(config.JSONFile); os.IsNotExist(err) {
 		fmt.Printf("[!!!] Error: JSON file '%s' does not exist.\n", config.JSONFile)
 		printUsage()
-		os.Exit(1)
+		return fmt.Errorf("JSON file '%s' does not exist", config.JSONFile)
+	}
+
+	// Handle --report-only mode: rebuild reports for an existing download directory
+	if config.ReportOnly != "" {
+		runReportOnly(config)
+		return nil
+	}
+
+	// Handle --ndjson mode: stream the extracted entries into a pipeline instead of downloading
+	if config.NDJSON {
+		if err := runNDJSON(config, baseDir, pipelineOut); err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			return err
+		}
+		return nil
+	}
+
+	// Handle --count-only mode: print just the number of URLs for monitoring
+	if config.CountOnly {
+		if err := runCountOnly(config, baseDir, pipelineOut); err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			return err
+		}
+		return nil
+	}
+
+	// Make sure we can write our output before doing any work
+	if err := ensureOutputWritable(baseDir); err != nil {
+		fmt.Printf("[!!!] Error: %v\n", err)
+		return err
+	}
+
+	// Handle --export-creators mode: list the creators to follow elsewhere
+	if config.ExportCreators != "" {
+		if err := runExportCreators(config); err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			return err
+		}
+		return nil
+	}
+
+	// Handle --print-command mode: write the lists and hand over the yt-dlp command
+	if config.PrintCommand {
+		var clipboard ClipboardWriter
+		if config.CopyCommand {
+			clipboard = systemClipboard{goos: runtime.GOOS}
+		}
+		if err := runPrintCommand(config, baseDir, runtime.GOOS, pipelineOut, clipboard); err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			return err
+		}
+		return nil
+	}
+
+	// Handle --print-plan --dry-run: write the lists and describe the run without downloading
+	if config.DryRun {
+		if err := runDryRunPlan(config, baseDir, pipelineOut, silentCommandRunner{}); err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			return err
+		}
+		return nil
 	}
 
 	// Handle --index-only mode: regenerate indexes without downloading
 	if config.IndexOnly {
 		fmt.Println("[*] Index-only mode: regenerating indexes from existing .info.json files")
 
-		// Still need to ask about liked videos to know which collections to process
-		fmt.Print("[*] Would you like to include 'Liked' videos as well? (y/n, default is 'n'): ")
-		scanner := bufio.NewScanner(os.Stdin)
-		scanner.Scan()
-		input := strings.TrimSpace(strings.ToLower(scanner.Text()))
-		if input == "y" || input == "yes" {
-			config.IncludeLiked = true
+		// Still need to know about liked videos to know which collections to process
+		sections, err := detectExportSections(config.JSONFile, config.ExportSchema, config.IncludeHistory)
+		if err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			return err
 		}
+		config.IncludeLiked = promptForLiked(config.JSONFile, config.ExportSchema, sections, os.Stdin, os.Stdout)
 
 		// Parse JSON to get video entries
-		videoEntries, err := parseFavoriteVideosFromFile(config.JSONFile, config.IncludeLiked)
+		videoEntries, err := loadExportEntries(config, config.IncludeLiked)
 		if err != nil {
 			fmt.Printf("[!!!] Error parsing JSON: %v\n", err)
-			os.Exit(1)
+			return err
 		}
 
 		fmt.Printf("[*] Loaded %d video entries from '%s'\n", len(videoEntries), config.JSONFile)
+		videoEntries = applyEntryFilters(config, videoEntries)
 
 		if config.OrganizeByCollection {
 			// Regenerate indexes for each collection
@@ -1916,7 +9464,7 @@ func main() {
 			for collection := range collections {
 				collectionEntries := getEntriesForCollection(videoEntries, collection)
 				// No download, so no failure details
-				if err := generateCollectionIndex(collection, collectionEntries, nil); err != nil {
+				if err := indexCollection(config, filepath.Join(baseDir, collection), collectionEntries, nil); err != nil {
 					fmt.Printf("[!] Warning: Failed to generate index for %s: %v\n", collection, err)
 				} else {
 					fmt.Printf("[*] Generated index.html and index.json for %s\n", collection)
@@ -1924,41 +9472,70 @@ func main() {
 			}
 		} else {
 			// Regenerate index for flat structure
-			dir, err := filepath.Abs(".")
+			dir, err := filepath.Abs(baseDir)
 			if err != nil {
-				dir = "."
+				dir = baseDir
 			}
 			// No download, so no failure details
-			if err := generateCollectionIndex(dir, videoEntries, nil); err != nil {
+			if err := indexCollection(config, dir, videoEntries, nil); err != nil {
 				fmt.Printf("[!] Warning: Failed to generate index: %v\n", err)
 			} else {
 				fmt.Println("[*] Generated index.html and index.json")
 			}
 		}
-		return
+		return nil
 	}
 
 	// Check if yt-dlp already exists before attempting to get/download
 	// If it exists, we'll run it automatically later; if not, we'll ask the user
-	ytdlpExistedBefore := false
-	if _, err := os.Stat("yt-dlp.exe"); err == nil {
+	ytdlpExistedBefore := config.YtdlpPath != ""
+	if _, err := os.Stat("yt-dlp.exe"); err == nil && !isEmptyFile("yt-dlp.exe") {
 		ytdlpExistedBefore = true
 	}
 
 	// Attempt to get or download yt-dlp.exe (handles updates for existing files)
-	if err := getOrDownloadYtdlp(http.DefaultClient, "yt-dlp.exe"); err != nil {
+	timer := &phaseTimer{}
+	downloadClient, err := newHTTPClient(config)
+	if err != nil {
+		fmt.Printf("[!!!] Error: %v\n", err)
+		return err
+	}
+	if config.InsecureSkipVerify {
+		printInsecureWarning()
+	}
+	phaseStart := time.Now()
+	downloadCtx, cancelDownload := binaryDownloadContext(config)
+	err = prepareYtdlp(downloadCtx, downloadClient, silentCommandRunner{}, config)
+	cancelDownload()
+	timer.Record(PhaseYtdlpDownload, phaseStart)
+	if err != nil && config.YtdlpPath != "" {
+		fmt.Printf("[!!!] Error: %v\n", err)
+		return err
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		fmt.Printf("[!] Downloading yt-dlp took longer than %s (raise it with --timeout-binary-download)\n", config.BinaryDownloadTimeout)
+	}
+	if err != nil {
 		fmt.Printf("[!] Warning: %v\n", err)
+		if errors.Is(err, ErrNoAsset) {
+			fmt.Println("[!] The yt-dlp release layout may have changed.")
+		}
 		// Not exiting here so you can still generate fav_videos.txt if needed
+		if _, statErr := os.Stat("yt-dlp.exe"); statErr != nil {
+			printManualYtdlpInstructions(os.Stdout, "yt-dlp.exe", runtime.GOARCH)
+			if !config.NoWaitYtdlp {
+				waitForManualYtdlp("yt-dlp.exe", os.Stdin, os.Stdout)
+			}
+		}
 	}
 
-	fmt.Print("[*] Would you like to include 'Liked' videos as well? (y/n, default is 'n'): ")
-	scanner := bufio.NewScanner(os.Stdin)
-	scanner.Scan()
-	input := strings.TrimSpace(strings.ToLower(scanner.Text()))
-	// Update includeLiked to true if the input is "y"
-	if input == "y" || input == "yes" {
-		config.IncludeLiked = true
+	// Custom exports may lack a source; only ask about liked videos when both exist
+	sections, err := detectExportSections(config.JSONFile, config.ExportSchema, config.IncludeHistory)
+	if err != nil {
+		fmt.Printf("[!!!] Error: %v\n", err)
+		return err
 	}
+	config.IncludeLiked = promptForLiked(config.JSONFile, config.ExportSchema, sections, os.Stdin, os.Stdout)
 
 	// Prompt for cookies if not provided via flags
 	if config.CookieFile == "" && config.CookieFromBrowser == "" {
@@ -1970,23 +9547,55 @@ func main() {
 	}
 
 	// Extract video entries
-	videoEntries, err := parseFavoriteVideosFromFile(config.JSONFile, config.IncludeLiked)
+	phaseStart = time.Now()
+	videoEntries, err := loadExportEntries(config, config.IncludeLiked)
+	timer.Record(PhaseJSONParse, phaseStart)
 	if err != nil {
-		fmt.Printf("[!!!] Error parsing JSON. Are you sure '%s' is valid JSON?\n", config.JSONFile)
+		if errors.Is(err, ErrJSONParse) {
+			fmt.Printf("[!!!] Error parsing JSON. Are you sure '%s' is valid JSON?\n", config.JSONFile)
+		} else {
+			fmt.Printf("[!!!] Error reading '%s'.\n", config.JSONFile)
+		}
 		fmt.Printf("Details: %v\n", err)
-		os.Exit(1)
+		return err
 	}
 
 	fmt.Printf("[*] Successfully loaded %d video entries from '%s'\n", len(videoEntries), config.JSONFile)
+	videoEntries = applyEntryFilters(config, videoEntries)
+	if config.UploaderStats > 0 {
+		printUploaderStats(os.Stdout, videoEntries, config.UploaderStats)
+	}
+
+	// Only new favorites are downloaded; the index still covers every video
+	downloadEntries := applyAfterID(config, videoEntries)
+	downloadEntries = applySinceCutoff(config, downloadEntries, baseDir)
+	downloadEntries = applyDedupeExisting(config, downloadEntries, baseDir)
+	downloadEntries = applySkipKnown(config, downloadEntries)
+	downloadEntries = applyPrecheck(context.Background(), config, http.DefaultClient, downloadEntries)
 
-	// Write video entries to files
-	if err := writeFavoriteVideosToFile(videoEntries, config.OutputName, config.OrganizeByCollection); err != nil {
+	// Write video entries to files. In flat mode each list file gets its own yt-dlp run.
+	phaseStart = time.Now()
+	runs, err := writeDownloadLists(config, baseDir, downloadEntries)
+	if err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		return err
+	}
+	if config.OrganizeByCollection && config.SeparateRuns {
+		fmt.Println("[*] --separate-runs has no effect with collection organization (each collection already runs separately).")
+	} else if config.OrganizeByCollection && config.SplitBySource {
+		fmt.Println("[*] --split-by-source has no effect with collection organization (lists are already per collection).")
 	}
+	timer.Record(PhaseListWrite, phaseStart)
 
-	if !config.OrganizeByCollection {
-		fmt.Printf("[*] Extracted %d video URLs to '%s'.\n", len(videoEntries), config.OutputName)
+	// Describe the run before it starts (--print-plan)
+	if config.PrintPlan {
+		if err := writePlan(pipelineOut, buildExecutionPlan(config, baseDir, runs, downloadEntries, silentCommandRunner{})); err != nil {
+			fmt.Printf("[!] Warning: failed to write the plan: %v\n", err)
+		}
+	}
+
+	if !config.OrganizeByCollection && !config.SplitBySource {
+		fmt.Printf("[*] Extracted %d video URLs to '%s'.\n", len(downloadEntries), runs[0].file)
 	}
 
 	// Construct the recommended yt-dlp command
@@ -1999,9 +9608,37 @@ func main() {
 		fmt.Println("[*] Collection organization enabled. Videos will be downloaded to collection subdirectories.")
 		fmt.Println("[*] yt-dlp will process each collection's URL file separately.")
 	} else {
-		ytDlpCmd := fmt.Sprintf("%syt-dlp.exe -a \"%s\" --output \"%%(upload_date)s_%%(id)s_%%(title).50B.%%(ext)s\" --write-info-json --write-thumbnail", psPrefix, config.OutputName)
 		fmt.Println("[*] Done! You can now run yt-dlp like this:")
-		fmt.Printf("  %s\n", ytDlpCmd)
+		for _, run := range runs {
+			ytDlpCmd := fmt.Sprintf("%s -a \"%s\" --output \"%s\" --write-info-json --write-thumbnail", ytdlpCommand(config, psPrefix), run.file, workPath(run.config, outputTemplateFor(config, run.entries)))
+			fmt.Printf("  %s\n", ytDlpCmd)
+		}
+	}
+
+	// Save the suggested command(s) as a script to re-run the download later
+	if config.WriteLauncher {
+		var commands [][]string
+		for _, run := range runs {
+			commands = append(commands, suggestedYtdlpArgs(run.config, run.file, run.entries))
+		}
+		launcherPath := workPath(config, launcherFilename(runtime.GOOS))
+		if err := writeLauncher(launcherPath, runtime.GOOS, commands); err != nil {
+			fmt.Printf("[!] Warning: %v\n", err)
+		} else {
+			fmt.Printf("[*] Wrote %s; run it to start the download again later\n", launcherPath)
+		}
+	}
+
+	// Save the resolved options of each list as a yt-dlp config file
+	if config.WriteYtdlpConf {
+		for _, run := range runs {
+			confPath := ytdlpConfigFilename(run.file)
+			if err := writeYtdlpConfig(confPath, ytdlpConfigArgs(run.config, run.file, run.entries)); err != nil {
+				fmt.Printf("[!] Warning: %v\n", err)
+			} else {
+				fmt.Printf("[*] Wrote %s; run yt-dlp --config-location \"%s\" to repeat the download\n", confPath, confPath)
+			}
+		}
 	}
 
 	// If yt-dlp already existed, run automatically; otherwise ask user
@@ -2021,12 +9658,24 @@ func main() {
 		}
 	}
 
-	if shouldRunYtdlp {
-		// Initialize download session tracking
-		session := &DownloadSession{
-			StartTime:   time.Now(),
-			Collections: make([]CollectionResult, 0),
+	// Make sure the yt-dlp.exe we have can actually start on this PC (a --ytdlp-path
+	// binary was already test-run, and replacing it would mean going online)
+	if shouldRunYtdlp && config.YtdlpPath == "" {
+		repairCtx, cancelRepair := binaryDownloadContext(config)
+		err := repairYtdlpArchitecture(repairCtx, &RealCommandRunner{}, downloadClient, config.ReleaseFile, "yt-dlp.exe", runtime.GOARCH, promptForRedownload)
+		cancelRepair()
+		if errors.Is(err, context.DeadlineExceeded) {
+			fmt.Printf("[!] Downloading yt-dlp took longer than %s (raise it with --timeout-binary-download)\n", config.BinaryDownloadTimeout)
 		}
+		if err != nil {
+			fmt.Printf("[!!!] Error: %v\n", err)
+			return err
+		}
+	}
+
+	if shouldRunYtdlp {
+		ytdlpRan = true
+		session.StartTime = time.Now() // The summary times the download, not the setup
 
 		if config.OrganizeByCollection {
 			// Run yt-dlp for each collection
@@ -2037,11 +9686,13 @@ func main() {
 			for collection := range collections {
 				// Use collection-specific filename
 				collectionFilename := getOutputFilename(collection)
-				collectionOutputName := filepath.Join(collection, collectionFilename)
+				collectionOutputName := filepath.Join(baseDir, collection, collectionFilename)
 				collectionEntries := getEntriesForCollection(videoEntries, collection)
 
 				fmt.Printf("[*] Processing collection: %s\n", collection)
-				result, _ := runYtdlp(psPrefix, collectionOutputName, config.OrganizeByCollection, config.SkipThumbnails, config.DisableResume, config.DisableProgressBar, config.CookieFile, config.CookieFromBrowser, collectionEntries)
+				runStart := time.Now()
+				result, _ := runYtdlpChunked(psPrefix, collectionOutputName, config, getEntriesForCollection(downloadEntries, collection))
+				timer.Record(PhaseYtdlpRun, runStart)
 
 				// Track session results
 				if result != nil {
@@ -2052,35 +9703,66 @@ func main() {
 				var failures []FailureDetail
 				if result != nil {
 					failures = result.FailureDetails
+					collectionEntries = applySavedPaths(collectionEntries, result.SavedFiles)
 				}
-				if err := generateCollectionIndex(collection, collectionEntries, failures); err != nil {
+				if err := indexCollection(config, filepath.Join(baseDir, collection), collectionEntries, failures); err != nil {
 					fmt.Printf("[!] Warning: Failed to generate index for %s: %v\n", collection, err)
 				} else {
 					fmt.Printf("[*] Generated index.html and index.json for %s\n", collection)
 				}
 			}
 		} else {
-			// Flat structure
-			result, _ := runYtdlp(psPrefix, config.OutputName, config.OrganizeByCollection, config.SkipThumbnails, config.DisableResume, config.DisableProgressBar, config.CookieFile, config.CookieFromBrowser, videoEntries)
+			// Flat structure (one run per list file when split by source)
+			var failures []FailureDetail
+			savedFiles := make(map[string]string)
+			for _, run := range runs {
+				runStart := time.Now()
+				result, _ := runYtdlpChunked(psPrefix, run.file, run.config, run.entries)
+				timer.Record(PhaseYtdlpRun, runStart)
+
+				// Track session results
+				if result != nil {
+					session.Collections = append(session.Collections, *result)
+					failures = append(failures, result.FailureDetails...)
+					maps.Copy(savedFiles, result.SavedFiles)
+				}
 
-			// Track session results
-			if result != nil {
-				session.Collections = append(session.Collections, *result)
+				// Each --separate-runs source gets its own report and index
+				if config.SeparateRuns && result != nil {
+					if err := writeSourceResults(run, result, runStart); err != nil {
+						fmt.Printf("[!] Warning: Failed to write %s for %s: %v\n", resultsFilename(config.ResultsFormat), run.source, err)
+					}
+					sourceEntries := applySavedPaths(getEntriesForCollection(videoEntries, run.source), result.SavedFiles)
+					if err := indexCollection(run.config, run.config.WorkDir, sourceEntries, result.FailureDetails); err != nil {
+						fmt.Printf("[!] Warning: Failed to generate index for %s: %v\n", run.source, err)
+					} else {
+						fmt.Printf("[*] Generated index.html and index.json for %s\n", run.source)
+					}
+				}
 			}
 
 			// Generate index for flat structure in current directory
-			dir, err := filepath.Abs(".")
-			if err != nil {
-				dir = "."
-			}
-			var failures []FailureDetail
-			if result != nil {
-				failures = result.FailureDetails
+			if !config.SeparateRuns {
+				dir, err := filepath.Abs(baseDir)
+				if err != nil {
+					dir = baseDir
+				}
+				if err := indexCollection(config, dir, applySavedPaths(videoEntries, savedFiles), failures); err != nil {
+					fmt.Printf("[!] Warning: Failed to generate index: %v\n", err)
+				} else {
+					fmt.Println("[*] Generated index.html and index.json")
+				}
 			}
-			if err := generateCollectionIndex(dir, videoEntries, failures); err != nil {
-				fmt.Printf("[!] Warning: Failed to generate index: %v\n", err)
+		}
+
+		// Stitch everything downloaded into one compilation video
+		if config.MergeOutput != "" {
+			files := mergeInputFiles(config, baseDir, videoEntries)
+			mergePath := workPath(config, config.MergeOutput)
+			if err := mergeDownloads(&RealCommandRunner{}, ffmpegPath, files, mergePath); err != nil {
+				fmt.Printf("[!] Warning: %v\n", err)
 			} else {
-				fmt.Println("[*] Generated index.html and index.json")
+				fmt.Printf("[*] Merged %d videos into %s\n", len(files), mergePath)
 			}
 		}
 
@@ -2088,12 +9770,24 @@ func main() {
 		session.EndTime = time.Now()
 		session.TotalAttempted, session.TotalSuccess, session.TotalFailed, session.TotalSkipped =
 			calculateSessionTotals(session.Collections)
+		session.Phases = timer.Phases()
 
 		// Print summary
 		printSessionSummary(session)
-		// Write results.txt
-		if err := writeResultsFile(session); err != nil {
-			fmt.Printf("[!] Warning: Failed to write results.txt: %v\n", err)
+		// Write results.txt (or results.log with --results-format apache)
+		if err := writeResults(config, session, downloadEntries); err != nil {
+			fmt.Printf("[!] Warning: Failed to write %s: %v\n", resultsFilename(config.ResultsFormat), err)
+		}
+		// Write summary.json with the counts and timing breakdown
+		if err := writeSummaryFile(workPath(config, "summary.json"), session); err != nil {
+			fmt.Printf("[!] Warning: %v\n", err)
+		}
+		// Write unavailable.json for pruning deleted/private videos from favorites
+		if count, err := writeUnavailableFile(workPath(config, "unavailable.json"), session); err != nil {
+			fmt.Printf("[!] Warning: %v\n", err)
+		} else if count > 0 {
+			fmt.Printf("[*] %d unavailable videos listed in unavailable.json\n", count)
 		}
 	}
+	return nil
 }
@@ -1,16 +1,40 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -78,7 +102,7 @@ func TestParseFavoriteVideosFromFile(t *testing.T) {
 	_ = tmpFile.Close()
 
 	// Test case: only favorited videos
-	videoEntries, err := parseFavoriteVideosFromFile(tmpFile.Name(), false)
+	videoEntries, err := parseFavoriteVideosFromFile(tmpFile.Name(), false, nil)
 	if err != nil {
 		t.Errorf("expected no error, got %v", err)
 	}
@@ -99,7 +123,7 @@ func TestParseFavoriteVideosFromFile(t *testing.T) {
 	}
 
 	// Test case: favorited and liked videos
-	videoEntries, err = parseFavoriteVideosFromFile(tmpFile.Name(), true)
+	videoEntries, err = parseFavoriteVideosFromFile(tmpFile.Name(), true, nil)
 	if err != nil {
 		t.Errorf("expected no error, got %v", err)
 	}
@@ -304,6 +328,8 @@ func TestRunYtdlpWithRunner(t *testing.T) {
 		disableResume        bool
 		cookieFile           string
 		cookieFromBrowser    string
+		fragments            int
+		headers              []string
 		shouldFail           bool
 		expectCmd            string
 		expectArgs           []string
@@ -317,7 +343,7 @@ func TestRunYtdlpWithRunner(t *testing.T) {
 			disableResume:        true,
 			shouldFail:           false,
 			expectCmd:            "yt-dlp.exe",
-			expectArgs:           []string{"-a", "test_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--write-thumbnail", "--convert-thumbnails", "jpg"},
+			expectArgs:           []string{"-a", "test_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--print", savedPathTemplate, "--no-quiet", "--write-thumbnail", "--convert-thumbnails", "jpg"},
 		},
 		{
 			name:                 "successful execution with powershell prefix",
@@ -328,7 +354,7 @@ func TestRunYtdlpWithRunner(t *testing.T) {
 			disableResume:        true,
 			shouldFail:           false,
 			expectCmd:            ".\\yt-dlp.exe",
-			expectArgs:           []string{"-a", "fav_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--write-thumbnail", "--convert-thumbnails", "jpg"},
+			expectArgs:           []string{"-a", "fav_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--print", savedPathTemplate, "--no-quiet", "--write-thumbnail", "--convert-thumbnails", "jpg"},
 		},
 		{
 			name:                 "command execution failure",
@@ -339,7 +365,7 @@ func TestRunYtdlpWithRunner(t *testing.T) {
 			disableResume:        true,
 			shouldFail:           true,
 			expectCmd:            "yt-dlp.exe",
-			expectArgs:           []string{"-a", "videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--write-thumbnail", "--convert-thumbnails", "jpg"},
+			expectArgs:           []string{"-a", "videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--print", savedPathTemplate, "--no-quiet", "--write-thumbnail", "--convert-thumbnails", "jpg"},
 		},
 		{
 			name:                 "collection organized output goes to subdirectory",
@@ -350,7 +376,7 @@ func TestRunYtdlpWithRunner(t *testing.T) {
 			disableResume:        true,
 			shouldFail:           false,
 			expectCmd:            "yt-dlp.exe",
-			expectArgs:           []string{"-a", filepath.Join("favorites", "fav_videos.txt"), "--output", filepath.Join("favorites", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s"), "--write-info-json", "--write-thumbnail", "--convert-thumbnails", "jpg"},
+			expectArgs:           []string{"-a", filepath.Join("favorites", "fav_videos.txt"), "--output", filepath.Join("favorites", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s"), "--write-info-json", "--print", savedPathTemplate, "--no-quiet", "--write-thumbnail", "--convert-thumbnails", "jpg"},
 		},
 		{
 			name:                 "skip thumbnails omits --write-thumbnail flag",
@@ -361,7 +387,7 @@ func TestRunYtdlpWithRunner(t *testing.T) {
 			disableResume:        true,
 			shouldFail:           false,
 			expectCmd:            "yt-dlp.exe",
-			expectArgs:           []string{"-a", "test_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json"},
+			expectArgs:           []string{"-a", "test_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--print", savedPathTemplate, "--no-quiet"},
 		},
 		{
 			name:                 "with cookie file",
@@ -374,7 +400,7 @@ func TestRunYtdlpWithRunner(t *testing.T) {
 			cookieFromBrowser:    "",
 			shouldFail:           false,
 			expectCmd:            "yt-dlp.exe",
-			expectArgs:           []string{"-a", "test_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--write-thumbnail", "--convert-thumbnails", "jpg", "--cookies", "cookies.txt"},
+			expectArgs:           []string{"-a", "test_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--print", savedPathTemplate, "--no-quiet", "--write-thumbnail", "--convert-thumbnails", "jpg", "--cookies", "cookies.txt"},
 		},
 		{
 			name:                 "with cookies from browser",
@@ -387,7 +413,7 @@ func TestRunYtdlpWithRunner(t *testing.T) {
 			cookieFromBrowser:    "chrome",
 			shouldFail:           false,
 			expectCmd:            "yt-dlp.exe",
-			expectArgs:           []string{"-a", "test_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--write-thumbnail", "--convert-thumbnails", "jpg", "--cookies-from-browser", "chrome"},
+			expectArgs:           []string{"-a", "test_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--print", savedPathTemplate, "--no-quiet", "--write-thumbnail", "--convert-thumbnails", "jpg", "--cookies-from-browser", "chrome"},
 		},
 		{
 			name:                 "cookies with skip thumbnails",
@@ -400,7 +426,7 @@ func TestRunYtdlpWithRunner(t *testing.T) {
 			cookieFromBrowser:    "",
 			shouldFail:           false,
 			expectCmd:            "yt-dlp.exe",
-			expectArgs:           []string{"-a", "test_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--cookies", "cookies.txt"},
+			expectArgs:           []string{"-a", "test_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--print", savedPathTemplate, "--no-quiet", "--cookies", "cookies.txt"},
 		},
 		{
 			name:                 "cookies with collection organization",
@@ -413,7 +439,7 @@ func TestRunYtdlpWithRunner(t *testing.T) {
 			cookieFromBrowser:    "firefox",
 			shouldFail:           false,
 			expectCmd:            "yt-dlp.exe",
-			expectArgs:           []string{"-a", filepath.Join("favorites", "fav_videos.txt"), "--output", filepath.Join("favorites", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s"), "--write-info-json", "--write-thumbnail", "--convert-thumbnails", "jpg", "--cookies-from-browser", "firefox"},
+			expectArgs:           []string{"-a", filepath.Join("favorites", "fav_videos.txt"), "--output", filepath.Join("favorites", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s"), "--write-info-json", "--print", savedPathTemplate, "--no-quiet", "--write-thumbnail", "--convert-thumbnails", "jpg", "--cookies-from-browser", "firefox"},
 		},
 		{
 			name:                 "resume enabled with flat structure",
@@ -424,7 +450,7 @@ func TestRunYtdlpWithRunner(t *testing.T) {
 			disableResume:        false,
 			shouldFail:           false,
 			expectCmd:            "yt-dlp.exe",
-			expectArgs:           []string{"-a", "test_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--write-thumbnail", "--convert-thumbnails", "jpg", "--download-archive", "download_archive.txt", "--no-overwrites", "--continue"},
+			expectArgs:           []string{"-a", "test_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--print", savedPathTemplate, "--no-quiet", "--write-thumbnail", "--convert-thumbnails", "jpg", "--download-archive", "download_archive.txt", "--no-overwrites", "--continue"},
 		},
 		{
 			name:                 "resume enabled with collection organization",
@@ -435,7 +461,7 @@ func TestRunYtdlpWithRunner(t *testing.T) {
 			disableResume:        false,
 			shouldFail:           false,
 			expectCmd:            "yt-dlp.exe",
-			expectArgs:           []string{"-a", filepath.Join("favorites", "fav_videos.txt"), "--output", filepath.Join("favorites", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s"), "--write-info-json", "--write-thumbnail", "--convert-thumbnails", "jpg", "--download-archive", filepath.Join("favorites", "download_archive.txt"), "--no-overwrites", "--continue"},
+			expectArgs:           []string{"-a", filepath.Join("favorites", "fav_videos.txt"), "--output", filepath.Join("favorites", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s"), "--write-info-json", "--print", savedPathTemplate, "--no-quiet", "--write-thumbnail", "--convert-thumbnails", "jpg", "--download-archive", filepath.Join("favorites", "download_archive.txt"), "--no-overwrites", "--continue"},
 		},
 		{
 			name:                 "resume enabled with skip thumbnails",
@@ -446,7 +472,7 @@ func TestRunYtdlpWithRunner(t *testing.T) {
 			disableResume:        false,
 			shouldFail:           false,
 			expectCmd:            "yt-dlp.exe",
-			expectArgs:           []string{"-a", "test_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--download-archive", "download_archive.txt", "--no-overwrites", "--continue"},
+			expectArgs:           []string{"-a", "test_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--print", savedPathTemplate, "--no-quiet", "--download-archive", "download_archive.txt", "--no-overwrites", "--continue"},
 		},
 		{
 			name:                 "resume enabled with cookies",
@@ -458,7 +484,29 @@ func TestRunYtdlpWithRunner(t *testing.T) {
 			cookieFile:           "cookies.txt",
 			shouldFail:           false,
 			expectCmd:            "yt-dlp.exe",
-			expectArgs:           []string{"-a", "test_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--write-thumbnail", "--convert-thumbnails", "jpg", "--cookies", "cookies.txt", "--download-archive", "download_archive.txt", "--no-overwrites", "--continue"},
+			expectArgs:           []string{"-a", "test_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--print", savedPathTemplate, "--no-quiet", "--write-thumbnail", "--convert-thumbnails", "jpg", "--cookies", "cookies.txt", "--download-archive", "download_archive.txt", "--no-overwrites", "--continue"},
+		},
+		{
+			name:                 "concurrent fragments forwarded",
+			psPrefix:             "",
+			outputName:           "test_videos.txt",
+			organizeByCollection: false,
+			skipThumbnails:       true,
+			disableResume:        true,
+			fragments:            4,
+			expectCmd:            "yt-dlp.exe",
+			expectArgs:           []string{"-a", "test_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--print", savedPathTemplate, "--no-quiet", "--concurrent-fragments", "4"},
+		},
+		{
+			name:                 "custom headers forwarded in order",
+			psPrefix:             "",
+			outputName:           "test_videos.txt",
+			organizeByCollection: false,
+			skipThumbnails:       true,
+			disableResume:        true,
+			headers:              []string{"Accept-Language: de-DE", "Referer: https://www.tiktok.com/"},
+			expectCmd:            "yt-dlp.exe",
+			expectArgs:           []string{"-a", "test_videos.txt", "--output", "%(upload_date)s_%(id)s_%(title).50B.%(ext)s", "--write-info-json", "--print", savedPathTemplate, "--no-quiet", "--add-header", "Accept-Language: de-DE", "--add-header", "Referer: https://www.tiktok.com/"},
 		},
 	}
 
@@ -472,7 +520,16 @@ func TestRunYtdlpWithRunner(t *testing.T) {
 			}
 
 			// Capture output for verification
-			_, _ = runYtdlpWithRunner(mockRunner, tt.psPrefix, tt.outputName, tt.organizeByCollection, tt.skipThumbnails, tt.disableResume, tt.cookieFile, tt.cookieFromBrowser, testEntries)
+			config := &Config{
+				OrganizeByCollection: tt.organizeByCollection,
+				SkipThumbnails:       tt.skipThumbnails,
+				DisableResume:        tt.disableResume,
+				CookieFile:           tt.cookieFile,
+				CookieFromBrowser:    tt.cookieFromBrowser,
+				ConcurrentFragments:  tt.fragments,
+				Headers:              tt.headers,
+			}
+			_, _ = runYtdlpWithRunner(mockRunner, tt.psPrefix, tt.outputName, config, testEntries)
 
 			// Verify command was called correctly
 			if len(mockRunner.Commands) != 1 {
@@ -581,7 +638,7 @@ func TestParseFavoriteVideosFromFileErrorScenarios(t *testing.T) {
 			}
 			_ = tmpFile.Close()
 
-			_, err = parseFavoriteVideosFromFile(tmpFile.Name(), tt.includeLiked)
+			_, err = parseFavoriteVideosFromFile(tmpFile.Name(), tt.includeLiked, nil)
 			if tt.expectError && err == nil {
 				t.Error("expected error but got none")
 			} else if !tt.expectError && err != nil {
@@ -593,12 +650,296 @@ func TestParseFavoriteVideosFromFileErrorScenarios(t *testing.T) {
 
 // TestParseFavoriteVideosFromFileNotFound tests file not found scenario
 func TestParseFavoriteVideosFromFileNotFound(t *testing.T) {
-	_, err := parseFavoriteVideosFromFile("nonexistent_file.json", false)
+	_, err := parseFavoriteVideosFromFile("nonexistent_file.json", false, nil)
 	if err == nil {
 		t.Error("expected error for non-existent file")
 	}
 }
 
+// TestParseExportLayouts tests that favorites and liked videos are read from both the
+// current "Likes and Favorites" layout and the older "Activity" one
+func TestParseExportLayouts(t *testing.T) {
+	section := func(root, id string) string {
+		return fmt.Sprintf(`%q: {
+			"Favorite Videos": {"FavoriteVideoList": [{"Link": "https://www.tiktokv.com/share/video/%s1/", "Date": "2023-01-01 10:00:00"}]},
+			"Like List": {"ItemFavoriteList": [{"link": "https://www.tiktokv.com/share/video/%s2/", "date": "2023-01-02 10:00:00"}]}
+		}`, root, id, id)
+	}
+	tests := []struct {
+		name   string
+		export string
+		want   []string
+	}{
+		{"current layout", "{" + section("Likes and Favorites", "10") + "}", []string{"101", "102"}},
+		{"older layout", "{" + section("Activity", "20") + "}", []string{"201", "202"}},
+		{"both merged", "{" + section("Likes and Favorites", "10") + "," + section("Activity", "20") + "}", []string{"101", "201", "102", "202"}},
+		{"neither", `{"Profile": {}}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseFavoriteVideos(strings.NewReader(tt.export), true, nil)
+			if err != nil {
+				t.Fatalf("parseFavoriteVideos failed: %v", err)
+			}
+			var ids []string
+			for _, entry := range entries {
+				ids = append(ids, extractVideoID(entry.Link))
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("expected videos %v, got %v", tt.want, ids)
+			}
+			if len(entries) > 0 && (entries[0].Collection != "favorites" || entries[len(entries)-1].Collection != "liked") {
+				t.Errorf("expected favorites first and liked videos last, got %+v", entries)
+			}
+		})
+	}
+}
+
+// TestCaseInsensitiveKeys tests that export keys are matched regardless of case, both
+// by the built-in layout and by --schema-map/--favorites-path list paths
+func TestCaseInsensitiveKeys(t *testing.T) {
+	fixture := `{
+		"likes and favorites": {
+			"favorite videos": {"favoritevideolist": [
+				{"link": "https://www.tiktokv.com/share/video/111/", "date": "2024-01-01 10:00:00"},
+				{"LINK": "https://www.tiktokv.com/share/video/112/", "DATE": "2024-01-02 10:00:00"}
+			]},
+			"LIKE LIST": {"ItemFavoriteList": [
+				{"Link": "https://www.tiktokv.com/share/video/222/", "Date": "2024-01-03 10:00:00"}
+			]}
+		}
+	}`
+	want := []VideoEntry{
+		{Link: "https://www.tiktokv.com/share/video/111/", Date: "2024-01-01 10:00:00", Collection: "favorites"},
+		{Link: "https://www.tiktokv.com/share/video/112/", Date: "2024-01-02 10:00:00", Collection: "favorites"},
+		{Link: "https://www.tiktokv.com/share/video/222/", Date: "2024-01-03 10:00:00", Collection: "liked"},
+	}
+
+	entries, err := parseFavoriteVideos(strings.NewReader(fixture), true, nil)
+	if err != nil || !reflect.DeepEqual(entries, want) {
+		t.Errorf("built-in layout: expected %+v, got %+v (err %v)", want, entries, err)
+	}
+
+	// The same export through list paths in the built-in (differently cased) spelling
+	schema, err := applyFavoritesPath(nil, `["Likes and Favorites"]["Favorite Videos"].FavoriteVideoList`)
+	if err != nil {
+		t.Fatalf("applyFavoritesPath failed: %v", err)
+	}
+	entries, err = parseFavoriteVideos(strings.NewReader(fixture), true, schema)
+	if err != nil || !reflect.DeepEqual(entries, want) {
+		t.Errorf("list paths: expected %+v, got %+v (err %v)", want, entries, err)
+	}
+
+	// An exact key beats one that only matches ignoring case
+	root := json.RawMessage(`{"videos": "lower", "Videos": "exact", "VIDEOS": "upper"}`)
+	if got, err := lookupJSONPath(root, "Videos"); err != nil || string(got) != `"exact"` {
+		t.Errorf("expected the exact key, got %s (err %v)", got, err)
+	}
+	if got, err := lookupJSONPath(root, "vIdEoS"); err != nil || string(got) != `"upper"` {
+		t.Errorf("expected the first case-insensitive match in sorted order, got %s (err %v)", got, err)
+	}
+}
+
+// TestParseFavoriteVideosWithSchemaMap tests parsing a remapped export via --schema-map
+func TestParseFavoriteVideosWithSchemaMap(t *testing.T) {
+	tmpDir := t.TempDir()
+	exportFile := filepath.Join(tmpDir, "user_data_tiktok.json")
+	// A hypothetical future export with renamed keys and an index-keyed liked list
+	fixture := `{
+		"Activity": {
+			"Favorites": {"Videos": [
+				{"VideoLink": "https://www.tiktokv.com/share/video/111/", "SavedAt": "2024-01-01 10:00:00"},
+				{"VideoLink": "https://www.tiktokv.com/share/video/222/", "SavedAt": "2024-01-02 10:00:00"}
+			]},
+			"Likes": {"Videos": {"0": {"videolink": "https://www.tiktokv.com/share/video/333/", "savedat": "2024-01-03 10:00:00"}}}
+		}
+	}`
+	if err := os.WriteFile(exportFile, []byte(fixture), 0644); err != nil {
+		t.Fatalf("failed to write export: %v", err)
+	}
+
+	// Without a schema map the remapped export has no videos
+	entries, err := parseFavoriteVideosFromFile(exportFile, true, nil)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("expected no videos with the built-in layout, got %v (err %v)", entries, err)
+	}
+
+	schemaFile := filepath.Join(tmpDir, "schema.json")
+	schemaJSON := `{"favorites_list": "Activity.Favorites.Videos", "liked_list": "Activity.Likes.Videos", "link_field": "VideoLink", "date_field": "SavedAt"}`
+	if err := os.WriteFile(schemaFile, []byte(schemaJSON), 0644); err != nil {
+		t.Fatalf("failed to write schema map: %v", err)
+	}
+	schema, err := loadSchemaMap(schemaFile)
+	if err != nil {
+		t.Fatalf("loadSchemaMap failed: %v", err)
+	}
+
+	entries, err = parseFavoriteVideosFromFile(exportFile, true, schema)
+	if err != nil {
+		t.Fatalf("parse with schema map failed: %v", err)
+	}
+	expected := []VideoEntry{
+		{Link: "https://www.tiktokv.com/share/video/111/", Date: "2024-01-01 10:00:00", Collection: "favorites"},
+		{Link: "https://www.tiktokv.com/share/video/222/", Date: "2024-01-02 10:00:00", Collection: "favorites"},
+		{Link: "https://www.tiktokv.com/share/video/333/", Date: "2024-01-03 10:00:00", Collection: "liked"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("unexpected entries:\n got %+v\nwant %+v", entries, expected)
+	}
+
+	// Section detection follows the remapped lists too
+	sections, err := inspectExport(exportFile, schema)
+	if err != nil || !sections.HasFavorites || !sections.HasLiked {
+		t.Errorf("expected both remapped lists to be detected, got %+v (err %v)", sections, err)
+	}
+
+	// Liked videos stay out unless requested
+	entries, _ = parseFavoriteVideosFromFile(exportFile, false, schema)
+	if len(entries) != 2 {
+		t.Errorf("expected only the 2 favorites without liked videos, got %d", len(entries))
+	}
+
+	// Omitted fields keep the built-in layout, so the usual fixture still parses
+	defaultSchema := filepath.Join(tmpDir, "default.json")
+	_ = os.WriteFile(defaultSchema, []byte(`{}`), 0644)
+	if schema, err = loadSchemaMap(defaultSchema); err != nil {
+		t.Fatalf("loadSchemaMap failed: %v", err)
+	}
+	builtin := `{"Likes and Favorites": {"Favorite Videos": {"FavoriteVideoList": [{"Link": "https://www.tiktokv.com/share/video/444/", "Date": "2024-02-01 10:00:00"}]}}}`
+	entries, err = parseFavoriteVideos(strings.NewReader(builtin), true, schema)
+	if err != nil || len(entries) != 1 || entries[0].Link != "https://www.tiktokv.com/share/video/444/" {
+		t.Errorf("expected the built-in layout with an empty schema map, got %v (err %v)", entries, err)
+	}
+
+	// A path through a non-object and unknown map keys are errors
+	if _, err := parseFavoriteVideos(strings.NewReader(`{"Likes and Favorites": []}`), false, nil); !errors.Is(err, ErrJSONParse) {
+		t.Errorf("expected ErrJSONParse for a path through an array, got %v", err)
+	}
+	badSchema := filepath.Join(tmpDir, "bad.json")
+	_ = os.WriteFile(badSchema, []byte(`{"favourites_list": "x"}`), 0644)
+	if _, err := loadSchemaMap(badSchema); err == nil {
+		t.Error("expected an error for an unknown schema map key")
+	}
+}
+
+// TestFavoritesPath tests resolving --favorites-path expressions against an export
+func TestFavoritesPath(t *testing.T) {
+	root := json.RawMessage(`{
+		"Activity": {
+			"Favorite Videos": {"FavoriteVideoList": [{"Link": "https://www.tiktokv.com/share/video/111/"}]},
+			"v1.2": {"Lists": [[], {"Videos": "second"}]}
+		}
+	}`)
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"Activity.Favorite Videos.FavoriteVideoList", `[{"Link": "https://www.tiktokv.com/share/video/111/"}]`},
+		{`Activity["Favorite Videos"].FavoriteVideoList[0].Link`, `"https://www.tiktokv.com/share/video/111/"`},
+		{`Activity['v1.2'].Lists[1].Videos`, `"second"`},
+		{`Activity["v1.2"]["Lists"][1]["Videos"]`, `"second"`},
+		{"Activity.Favorite Videos.Missing", ""},
+		{"Activity.v1.2.Lists", ""},
+		{`Activity["v1.2"].Lists[5]`, ""},
+	}
+	for _, tt := range tests {
+		got, err := lookupJSONPath(root, tt.path)
+		if err != nil {
+			t.Errorf("lookupJSONPath(%q) failed: %v", tt.path, err)
+			continue
+		}
+		if string(got) != tt.expected {
+			t.Errorf("lookupJSONPath(%q) = %s, want %s", tt.path, got, tt.expected)
+		}
+	}
+
+	for _, bad := range []string{"", "Activity..Videos", "Activity.", `Activity["Videos`, "Activity[x]", "Activity[-1]", "Activity[0]Videos"} {
+		if _, err := splitJSONPath(bad); err == nil {
+			t.Errorf("splitJSONPath(%q): expected an error", bad)
+		}
+	}
+	if _, err := lookupJSONPath(root, "Activity.Favorite Videos[0]"); err == nil {
+		t.Error("expected an error indexing into an object")
+	}
+
+	// --favorites-path replaces only the favorites list of the built-in layout
+	schema, err := applyFavoritesPath(nil, "Activity.Favorite Videos.FavoriteVideoList")
+	if err != nil {
+		t.Fatalf("applyFavoritesPath failed: %v", err)
+	}
+	if schema.LikedList != defaultLikedListPath || schema.LinkField != defaultLinkField {
+		t.Errorf("expected the rest of the built-in layout, got %+v", schema)
+	}
+	entries, err := parseFavoriteVideos(bytes.NewReader(root), false, schema)
+	if err != nil || len(entries) != 1 || entries[0].Link != "https://www.tiktokv.com/share/video/111/" {
+		t.Errorf("expected the favorite at --favorites-path, got %v (err %v)", entries, err)
+	}
+	if _, err := applyFavoritesPath(nil, "Activity..Videos"); err == nil {
+		t.Error("expected an error for an invalid --favorites-path")
+	}
+}
+
+// TestParseFavoriteVideosHTML tests scraping videos from TikTok's HTML export
+func TestParseFavoriteVideosHTML(t *testing.T) {
+	fixture := `<!DOCTYPE html>
+<html>
+<head><title>TikTok Data</title></head>
+<body>
+  <h1>Likes and Favorites</h1>
+  <h2>Favorite Videos</h2>
+  <div class="item">
+    <p>Date: 2024-01-01 10:00:00</p>
+    <p>Link: <a href="https://www.tiktokv.com/share/video/111/">https://www.tiktokv.com/share/video/111/</a></p>
+  </div>
+  <div class="item">
+    <p>Date: 2024-01-02 11:30:00</p>
+    <p>Link: <a href="https://www.tiktok.com/@creator/video/222">https://www.tiktok.com/@creator/video/222</a></p>
+  </div>
+  <h2>Like List</h2>
+  <div class="item">
+    <p>Date: 2024-01-03 12:00:00</p>
+    <p>Link: <a href="https://www.tiktokv.com/share/video/333/">https://www.tiktokv.com/share/video/333/</a></p>
+  </div>
+  <h2>Profile</h2>
+  <p><a href="https://www.tiktok.com/@me">My profile</a></p>
+  <p><a href="https://www.tiktokv.com/share/video/999/">Not a favorite</a></p>
+</body>
+</html>`
+
+	tmpDir := t.TempDir()
+	// A renamed extension doesn't matter, the format is detected by content
+	exportFile := filepath.Join(tmpDir, "user_data_tiktok.json")
+	if err := os.WriteFile(exportFile, []byte(fixture), 0644); err != nil {
+		t.Fatalf("failed to write export: %v", err)
+	}
+
+	entries, err := parseFavoriteVideosFromFile(exportFile, true, nil)
+	if err != nil {
+		t.Fatalf("parse of HTML export failed: %v", err)
+	}
+	expected := []VideoEntry{
+		{Link: "https://www.tiktokv.com/share/video/111/", Date: "2024-01-01 10:00:00", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@creator/video/222", Date: "2024-01-02 11:30:00", Collection: "favorites"},
+		{Link: "https://www.tiktokv.com/share/video/333/", Date: "2024-01-03 12:00:00", Collection: "liked"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("unexpected entries:\n got %+v\nwant %+v", entries, expected)
+	}
+
+	entries, err = parseFavoriteVideosFromFile(exportFile, false, nil)
+	if err != nil || len(entries) != 2 {
+		t.Errorf("expected only the 2 favorites without liked videos, got %d (err %v)", len(entries), err)
+	}
+
+	// JSON exports still go through the JSON parser
+	if isHTMLExport([]byte(`{"Likes and Favorites": {}}`)) {
+		t.Error("expected a JSON export not to be detected as HTML")
+	}
+	if !isHTMLExport([]byte("\ufeff  <html><body></body></html>")) {
+		t.Error("expected an HTML export with a BOM to be detected")
+	}
+}
+
 // TestWriteFavoriteVideosToFileErrorScenarios tests write error conditions
 func TestWriteFavoriteVideosToFileErrorScenarios(t *testing.T) {
 	tests := []struct {
@@ -811,7 +1152,7 @@ func TestIntegrationWorkflow(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Parse JSON
-			videoEntries, err := parseFavoriteVideosFromFile(jsonFile, tt.includeLiked)
+			videoEntries, err := parseFavoriteVideosFromFile(jsonFile, tt.includeLiked, nil)
 			if err != nil {
 				t.Fatalf("failed to parse JSON: %v", err)
 			}
@@ -929,7 +1270,7 @@ func TestMainFunctionArguments(t *testing.T) {
 				}
 
 				// Test that we can parse the file
-				_, err := parseFavoriteVideosFromFile(jsonFile, false)
+				_, err := parseFavoriteVideosFromFile(jsonFile, false, nil)
 				if err != nil {
 					t.Errorf("failed to parse JSON file: %v", err)
 				}
@@ -966,7 +1307,7 @@ func TestEdgeCasesAndBoundaries(t *testing.T) {
 		}
 		_ = tmpFile.Close()
 
-		urls, err := parseFavoriteVideosFromFile(tmpFile.Name(), false)
+		urls, err := parseFavoriteVideosFromFile(tmpFile.Name(), false, nil)
 		if err != nil {
 			t.Errorf("failed to parse large JSON: %v", err)
 		}
@@ -989,7 +1330,7 @@ func TestEdgeCasesAndBoundaries(t *testing.T) {
 		}
 		_ = tmpFile.Close()
 
-		urls, err := parseFavoriteVideosFromFile(tmpFile.Name(), false)
+		urls, err := parseFavoriteVideosFromFile(tmpFile.Name(), false, nil)
 		if err != nil {
 			t.Errorf("unexpected error for empty JSON: %v", err)
 		}
@@ -1017,7 +1358,7 @@ func TestEdgeCasesAndBoundaries(t *testing.T) {
 		for i := 0; i < 2; i++ {
 			go func() {
 				defer func() { done <- true }()
-				_, err := parseFavoriteVideosFromFile(tmpFile.Name(), false)
+				_, err := parseFavoriteVideosFromFile(tmpFile.Name(), false, nil)
 				if err != nil {
 					t.Errorf("concurrent access failed: %v", err)
 				}
@@ -1130,7 +1471,7 @@ func TestCollectionOrganization(t *testing.T) {
 		}
 
 		// Test with organization enabled
-		err = createCollectionDirectories(videoEntries, true)
+		err = createCollectionDirectories(videoEntries, ".", true)
 		if err != nil {
 			t.Errorf("createCollectionDirectories failed: %v", err)
 		}
@@ -1148,7 +1489,7 @@ func TestCollectionOrganization(t *testing.T) {
 		_ = os.RemoveAll("liked")
 		_ = os.RemoveAll("custom collection")
 
-		err = createCollectionDirectories(videoEntries, false)
+		err = createCollectionDirectories(videoEntries, ".", false)
 		if err != nil {
 			t.Errorf("createCollectionDirectories failed: %v", err)
 		}
@@ -2016,7 +2357,7 @@ func TestIndexOnlyMode(t *testing.T) {
 		}
 
 		// Parse video entries
-		videoEntries, err := parseFavoriteVideosFromFile(jsonFile, false)
+		videoEntries, err := parseFavoriteVideosFromFile(jsonFile, false, nil)
 		if err != nil {
 			t.Fatalf("parseFavoriteVideosFromFile failed: %v", err)
 		}
@@ -2102,7 +2443,7 @@ func TestIndexOnlyMode(t *testing.T) {
 		}
 
 		// Parse and generate index for flat structure
-		videoEntries, err := parseFavoriteVideosFromFile(jsonFile, false)
+		videoEntries, err := parseFavoriteVideosFromFile(jsonFile, false, nil)
 		if err != nil {
 			t.Fatalf("parseFavoriteVideosFromFile failed: %v", err)
 		}
@@ -2155,7 +2496,7 @@ func TestIndexOnlyMode(t *testing.T) {
 		}
 
 		// Don't create any .info.json files - simulate no downloads yet
-		videoEntries, err := parseFavoriteVideosFromFile(jsonFile, false)
+		videoEntries, err := parseFavoriteVideosFromFile(jsonFile, false, nil)
 		if err != nil {
 			t.Fatalf("parseFavoriteVideosFromFile failed: %v", err)
 		}
@@ -3244,8 +3585,8 @@ func TestBackupYtdlp(t *testing.T) {
 	})
 }
 
-// TestDownloadLatestYtdlp tests the download function
-func TestDownloadLatestYtdlp(t *testing.T) {
+// TestDownloadYtdlpAsset tests the download function
+func TestDownloadYtdlpAsset(t *testing.T) {
 	tmpDir := t.TempDir()
 	oldCwd, _ := os.Getwd()
 	defer func() { _ = os.Chdir(oldCwd) }()
@@ -3291,7 +3632,7 @@ func TestDownloadLatestYtdlp(t *testing.T) {
 	}
 
 	// Test download
-	if err := downloadLatestYtdlp(customClient, exeName); err != nil {
+	if err := downloadYtdlpAsset(context.Background(), customClient, "", exeName, exeName); err != nil {
 		t.Errorf("download failed: %v", err)
 	}
 
@@ -3590,6 +3931,59 @@ func TestIsErrorLine(t *testing.T) {
 	}
 }
 
+// TestTUIModel tests the --tui model's state transitions as yt-dlp output arrives
+func TestTUIModel(t *testing.T) {
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@a/video/111"},
+		{Link: "https://www.tiktok.com/@a/video/222"},
+		{Link: "https://www.tiktok.com/@a/video/333"},
+		{Link: "https://www.tiktok.com/@a/video/444"},
+	}
+	m := newTUIModel("favorites", entries)
+	statuses := func() []tuiStatus {
+		var got []tuiStatus
+		for _, item := range m.Items {
+			got = append(got, item.Status)
+		}
+		return got
+	}
+	steps := []struct {
+		line string
+		want []tuiStatus
+	}{
+		{"[TikTok] Extracting URL: https://www.tiktok.com/@a/video/111", []tuiStatus{tuiDownloading, tuiPending, tuiPending, tuiPending}},
+		{"[download]  42.0% of   10.00MiB at    2.00MiB/s ETA 00:03", []tuiStatus{tuiDownloading, tuiPending, tuiPending, tuiPending}},
+		{"[download] 100% of   10.00MiB in 00:00:05 at 2.00MiB/s", []tuiStatus{tuiDone, tuiPending, tuiPending, tuiPending}},
+		{"[TikTok] Extracting URL: https://www.tiktok.com/@a/video/222", []tuiStatus{tuiDone, tuiDownloading, tuiPending, tuiPending}},
+		{"[download] 20260101_222_Two.mp4 has already been downloaded", []tuiStatus{tuiDone, tuiSkipped, tuiPending, tuiPending}},
+		{"[TikTok] Extracting URL: https://www.tiktok.com/@a/video/333", []tuiStatus{tuiDone, tuiSkipped, tuiDownloading, tuiPending}},
+		{"ERROR: [TikTok] 333: Video not available", []tuiStatus{tuiDone, tuiSkipped, tuiFailed, tuiPending}},
+		{"[TikTok] Extracting URL: https://www.tiktok.com/@a/video/444", []tuiStatus{tuiDone, tuiSkipped, tuiFailed, tuiDownloading}},
+	}
+	for _, step := range steps {
+		m.Update(step.line)
+		if got := statuses(); !reflect.DeepEqual(got, step.want) {
+			t.Fatalf("after %q: expected %v, got %v", step.line, step.want, got)
+		}
+	}
+
+	// A video still in progress when yt-dlp exits has finished
+	m.Finish()
+	if got := statuses(); got[3] != tuiDone {
+		t.Errorf("expected the last video done after Finish, got %v", got)
+	}
+	if done, skipped, failed := m.Counts(); done != 2 || skipped != 1 || failed != 1 {
+		t.Errorf("expected 2 done, 1 skipped, 1 failed; got %d, %d, %d", done, skipped, failed)
+	}
+	if m.Items[2].Error != "Video not available" {
+		t.Errorf("expected the failure reason kept, got %q", m.Items[2].Error)
+	}
+	lines := m.Lines()
+	if len(lines) != 5 || lines[0] != "favorites: 4/4 done, 1 skipped, 1 failed" {
+		t.Errorf("unexpected view lines: %q", lines)
+	}
+}
+
 // TestProgressRenderer tests the progress bar rendering
 func TestProgressRenderer(t *testing.T) {
 	t.Run("disabled renderer doesn't render", func(t *testing.T) {
@@ -3621,6 +4015,24 @@ func TestProgressRenderer(t *testing.T) {
 		renderer.renderProgress(state)
 		renderer.clearProgress()
 	})
+
+	t.Run("plain renderer updates one status line", func(t *testing.T) {
+		if got, want := statusLine(412, 1983, 21), "Downloaded 412/1983, 21 failed"; got != want {
+			t.Errorf("statusLine() = %q, want %q", got, want)
+		}
+
+		var buf bytes.Buffer
+		renderer := &ProgressRenderer{enabled: true, plain: true, writer: &buf}
+		renderer.renderProgress(&ProgressState{CurrentIndex: 9, TotalVideos: 10, FailureCount: 1})
+		renderer.renderProgress(&ProgressState{CurrentIndex: 10, TotalVideos: 10, FailureCount: 1})
+		want := "\rDownloaded 9/10, 1 failed\rDownloaded 10/10, 1 failed"
+		if buf.String() != want {
+			t.Errorf("expected %q, got %q", want, buf.String())
+		}
+		if strings.Contains(buf.String(), "\033[") {
+			t.Error("plain status line should not contain ANSI escape codes")
+		}
+	})
 }
 
 // TestParseArchiveFile tests the parseArchiveFile function with various inputs
@@ -3865,7 +4277,7 @@ func TestRunYtdlpWithSkipOptimization(t *testing.T) {
 
 	// Call runYtdlpWithRunner with disableResume=false (optimization enabled)
 	result, err := runYtdlpWithRunner(mockRunner, "", outputName,
-		true, false, false, "", "", entries)
+		&Config{OrganizeByCollection: true}, entries)
 
 	// Should not error
 	if err != nil {
@@ -3916,7 +4328,7 @@ func TestRunYtdlpWithDisableResume(t *testing.T) {
 
 	// Call with disableResume=true (optimization should be bypassed)
 	_, err := runYtdlpWithRunner(mockRunner, "", outputName,
-		true, false, true, "", "", entries)
+		&Config{OrganizeByCollection: true, DisableResume: true}, entries)
 
 	// Should not error
 	if err != nil {
@@ -3959,7 +4371,7 @@ func TestRunYtdlpPartialDownload(t *testing.T) {
 
 	// Call with disableResume=false (optimization enabled but should still call yt-dlp)
 	_, err := runYtdlpWithRunner(mockRunner, "", outputName,
-		true, false, false, "", "", entries)
+		&Config{OrganizeByCollection: true}, entries)
 
 	// Should not error
 	if err != nil {
@@ -4070,3 +4482,5735 @@ func TestOutputProcessing(t *testing.T) {
 		t.Error("Output should contain carriage returns for progress bar updates")
 	}
 }
+
+// TestSchemaVersion tests that every generated JSON file carries schema_version
+func TestSchemaVersion(t *testing.T) {
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@user/video/7600559584901647646", Collection: "favorites"},
+	}
+	session := &DownloadSession{StartTime: time.Now(), EndTime: time.Now()}
+
+	tests := []struct {
+		file  string
+		write func(dir string) error
+	}{
+		{"index.json", func(dir string) error { return generateCollectionIndex(dir, entries, nil) }},
+		{"manifest.json", func(dir string) error { return writeManifest(dir, entries) }},
+		{"run_manifest.json", func(dir string) error {
+			_, err := writeRunManifest(dir, entries)
+			return err
+		}},
+		{"summary.json", func(dir string) error { return writeSummaryFile(filepath.Join(dir, "summary.json"), session) }},
+		{"unavailable.json", func(dir string) error {
+			_, err := writeUnavailableFile(filepath.Join(dir, "unavailable.json"), session)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := tt.write(dir); err != nil {
+				t.Fatalf("writing %s failed: %v", tt.file, err)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, tt.file))
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", tt.file, err)
+			}
+
+			// Decode into a generic map so we check the raw key rather than the struct tag
+			var raw map[string]interface{}
+			if err := json.Unmarshal(data, &raw); err != nil {
+				t.Fatalf("failed to parse %s: %v", tt.file, err)
+			}
+			v, ok := raw["schema_version"]
+			if !ok {
+				t.Fatalf("%s is missing schema_version", tt.file)
+			}
+			if int(v.(float64)) != SchemaVersion {
+				t.Errorf("expected schema_version %d in %s, got %v", SchemaVersion, tt.file, v)
+			}
+		})
+	}
+}
+
+// TestCompareVersions tests semantic version comparison used by self-update
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		want    int
+		wantErr bool
+	}{
+		{"v1.7.0", "v1.8.0", -1, false},
+		{"v1.8.0", "v1.7.9", 1, false},
+		{"v1.8.0", "1.8.0", 0, false},
+		{"v1.10.0", "v1.9.0", 1, false},
+		{"v2", "v1.99.99", 1, false},
+		{"v1.8.0-rc1", "v1.8.0", 0, false},
+		{"dev", "v1.8.0", 0, true},
+		{"v1.8.0", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"_vs_"+tt.b, func(t *testing.T) {
+			got, err := compareVersions(tt.a, tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error=%v, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSelectSelfUpdateAsset tests picking the release binary for each platform
+func TestSelectSelfUpdateAsset(t *testing.T) {
+	assets := []GitHubAsset{
+		{Name: "README.md"},
+		{Name: "tiktok-favvideo-downloader-x86_64.exe"},
+		{Name: "tiktok-favvideo-downloader-ARM64.exe"},
+	}
+
+	tests := []struct {
+		goos, goarch string
+		want         string
+		wantErr      bool
+	}{
+		{"windows", "amd64", "tiktok-favvideo-downloader-x86_64.exe", false},
+		{"windows", "arm64", "tiktok-favvideo-downloader-ARM64.exe", false},
+		{"linux", "amd64", "", true},
+		{"windows", "386", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.goos+"/"+tt.goarch, func(t *testing.T) {
+			asset, err := selectSelfUpdateAsset(assets, tt.goos, tt.goarch)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error=%v, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && asset.Name != tt.want {
+				t.Errorf("expected asset %q, got %q", tt.want, asset.Name)
+			}
+		})
+	}
+
+	// Platform is supported but the release is missing the binary
+	if _, err := selectSelfUpdateAsset(assets[:1], "windows", "amd64"); err == nil {
+		t.Error("expected error when release has no matching asset")
+	}
+}
+
+// TestSelfUpdate tests the full self-update flow against a mock releases server
+func TestSelfUpdate(t *testing.T) {
+	newBinary := []byte("new exe bytes")
+	sum := sha256.Sum256(newBinary)
+	goodDigest := "sha256:" + hex.EncodeToString(sum[:])
+
+	newServer := func(tag, digest string) *httptest.Server {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/repos/ozskywalker/tiktok-favvideo-downloader/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprintf(w, `{"tag_name": %q, "assets": [{"name": "tiktok-favvideo-downloader-x86_64.exe", "browser_download_url": "https://github.com/download/app.exe", "digest": %q}]}`, tag, digest)
+		})
+		mux.HandleFunc("/download/app.exe", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(newBinary)
+		})
+		return httptest.NewServer(mux)
+	}
+
+	setup := func(t *testing.T, ts *httptest.Server) (*http.Client, string) {
+		tmpDir, err := os.MkdirTemp("", "self_update_test_*")
+		if err != nil {
+			t.Fatalf("failed to create temp dir: %v", err)
+		}
+		t.Cleanup(func() { _ = os.RemoveAll(tmpDir) })
+		exePath := filepath.Join(tmpDir, "tiktok-favvideo-downloader.exe")
+		if err := os.WriteFile(exePath, []byte("old exe bytes"), 0755); err != nil {
+			t.Fatalf("failed to write fake exe: %v", err)
+		}
+		client := &http.Client{Transport: &rewriterRoundTripper{rt: http.DefaultTransport, host: ts.URL}}
+		return client, exePath
+	}
+
+	t.Run("newer release replaces executable", func(t *testing.T) {
+		ts := newServer("v1.9.0", goodDigest)
+		defer ts.Close()
+		client, exePath := setup(t, ts)
+
+		if err := selfUpdate(client, exePath, "v1.8.0", "windows", "amd64"); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if got, _ := os.ReadFile(exePath); string(got) != string(newBinary) {
+			t.Errorf("expected executable to be replaced, got %q", got)
+		}
+		if got, _ := os.ReadFile(exePath + ".old"); string(got) != "old exe bytes" {
+			t.Errorf("expected previous version kept as .old, got %q", got)
+		}
+	})
+
+	t.Run("already up to date leaves executable alone", func(t *testing.T) {
+		ts := newServer("v1.8.0", goodDigest)
+		defer ts.Close()
+		client, exePath := setup(t, ts)
+
+		if err := selfUpdate(client, exePath, "v1.8.0", "windows", "amd64"); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if got, _ := os.ReadFile(exePath); string(got) != "old exe bytes" {
+			t.Errorf("expected executable unchanged, got %q", got)
+		}
+	})
+
+	t.Run("checksum mismatch aborts update", func(t *testing.T) {
+		ts := newServer("v1.9.0", "sha256:"+strings.Repeat("0", 64))
+		defer ts.Close()
+		client, exePath := setup(t, ts)
+
+		err := selfUpdate(client, exePath, "v1.8.0", "windows", "amd64")
+		if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Fatalf("expected checksum mismatch error, got %v", err)
+		}
+		if got, _ := os.ReadFile(exePath); string(got) != "old exe bytes" {
+			t.Errorf("expected executable unchanged, got %q", got)
+		}
+		// No temp files should be left behind
+		files, _ := os.ReadDir(filepath.Dir(exePath))
+		if len(files) != 1 {
+			t.Errorf("expected only the original executable in dir, got %d files", len(files))
+		}
+	})
+
+	t.Run("missing checksum aborts update", func(t *testing.T) {
+		ts := newServer("v1.9.0", "")
+		defer ts.Close()
+		client, exePath := setup(t, ts)
+
+		if err := selfUpdate(client, exePath, "v1.8.0", "windows", "amd64"); err == nil {
+			t.Fatal("expected error when release has no checksum")
+		}
+	})
+
+	t.Run("development build cannot be compared", func(t *testing.T) {
+		ts := newServer("v1.9.0", goodDigest)
+		defer ts.Close()
+		client, exePath := setup(t, ts)
+
+		if err := selfUpdate(client, exePath, "dev", "windows", "amd64"); err == nil {
+			t.Fatal("expected error for development build version")
+		}
+	})
+}
+
+// TestFindNewestExport tests that --find picks the newest export among several candidates
+func TestFindNewestExport(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "find_export_test_*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	now := time.Now()
+	files := []struct {
+		name string
+		age  time.Duration
+	}{
+		{"user_data_tiktok.json", 72 * time.Hour},
+		{"TikTok_Data_1706000000.zip", 24 * time.Hour},
+		{"user_data_tiktok (1).json", 2 * time.Hour}, // newest candidate
+		{"holiday_photos.zip", 1 * time.Hour},        // newer, but not a TikTok export
+		{"tiktok_notes.txt", 30 * time.Minute},       // newer, but wrong extension
+	}
+	for _, f := range files {
+		path := filepath.Join(tmpDir, f.name)
+		if err := os.WriteFile(path, []byte("{}"), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", f.name, err)
+		}
+		modTime := now.Add(-f.age)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("failed to set mtime on %s: %v", f.name, err)
+		}
+	}
+
+	got, err := findNewestExport([]string{tmpDir})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if want := filepath.Join(tmpDir, "user_data_tiktok (1).json"); got != want {
+		t.Errorf("expected newest export %q, got %q", want, got)
+	}
+
+	// Missing directories are skipped; an empty search is an error
+	if _, err := findNewestExport([]string{filepath.Join(tmpDir, "missing")}); err == nil {
+		t.Error("expected error when no export is found")
+	}
+}
+
+// TestLocateExportFromZip tests that a zipped export is extracted to a readable JSON file
+func TestLocateExportFromZip(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "find_zip_test_*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	zipPath := filepath.Join(tmpDir, "TikTok_Data_1706000000.zip")
+	zf, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatalf("failed to create zip: %v", err)
+	}
+	zw := zip.NewWriter(zf)
+	w, _ := zw.Create("user_data_tiktok.json")
+	_, _ = w.Write([]byte(`{"Likes and Favorites": {"Favorite Videos": {"FavoriteVideoList": [{"Link": "https://www.tiktok.com/@u/video/1"}]}}}`))
+	_ = zw.Close()
+	_ = zf.Close()
+
+	jsonPath, err := locateExport([]string{tmpDir})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer func() { _ = os.Remove(jsonPath) }()
+
+	entries, err := parseFavoriteVideosFromFile(jsonPath, false, nil)
+	if err != nil {
+		t.Fatalf("failed to parse extracted export: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected 1 entry from extracted export, got %d", len(entries))
+	}
+}
+
+// blockingRunner is a ContextCommandRunner that hangs on selected URLs until its context expires
+type blockingRunner struct {
+	hangOn   map[string]bool
+	Commands []MockCommand
+}
+
+func (b *blockingRunner) Run(name string, args ...string) (CapturedOutput, error) {
+	return b.RunContext(context.Background(), name, args...)
+}
+
+func (b *blockingRunner) RunContext(ctx context.Context, name string, args ...string) (CapturedOutput, error) {
+	b.Commands = append(b.Commands, MockCommand{Name: name, Args: args})
+	url := args[len(args)-1]
+	if b.hangOn[url] {
+		select {
+		case <-ctx.Done():
+			return CapturedOutput{}, ctx.Err()
+		case <-time.After(10 * time.Second):
+			return CapturedOutput{}, fmt.Errorf("runner was not cancelled")
+		}
+	}
+	return CapturedOutput{Combined: []string{"[download] Destination: " + url}}, nil
+}
+
+// TestRunYtdlpPerVideoTimeout tests that a hanging video is recorded as a timeout and the loop continues
+func TestRunYtdlpPerVideoTimeout(t *testing.T) {
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@user/video/111"},
+		{Link: "https://www.tiktok.com/@user/video/222"}, // hangs
+		{Link: "https://www.tiktok.com/@user/video/333"},
+	}
+	runner := &blockingRunner{hangOn: map[string]bool{entries[1].Link: true}}
+	config := &Config{DisableResume: true, SkipThumbnails: true, PerVideoTimeout: 50 * time.Millisecond}
+
+	start := time.Now()
+	result, _ := runYtdlpWithRunner(runner, "", "fav_videos.txt", config, entries)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("per-video timeout was not enforced (took %s)", elapsed)
+	}
+
+	// Every URL gets its own invocation, including the ones after the hang
+	if len(runner.Commands) != 3 {
+		t.Fatalf("expected 3 yt-dlp invocations, got %d", len(runner.Commands))
+	}
+	for i, cmd := range runner.Commands {
+		if got := cmd.Args[len(cmd.Args)-1]; got != entries[i].Link {
+			t.Errorf("invocation %d: expected URL %q, got %q", i, entries[i].Link, got)
+		}
+		for _, arg := range cmd.Args {
+			if arg == "-a" {
+				t.Errorf("invocation %d: per-video mode should not pass a batch file", i)
+			}
+		}
+	}
+
+	if result.Failed != 1 || len(result.FailureDetails) != 1 {
+		t.Fatalf("expected exactly 1 failure, got %d (%v)", result.Failed, result.FailureDetails)
+	}
+	failure := result.FailureDetails[0]
+	if failure.VideoID != "222" || failure.ErrorType != ErrorNetworkTimeout {
+		t.Errorf("expected timeout failure for video 222, got %+v", failure)
+	}
+	if result.Success != 2 {
+		t.Errorf("expected 2 successful videos, got %d", result.Success)
+	}
+}
+
+// versionRunner is a fake CommandRunner that answers "--version"
+type versionRunner struct {
+	version string
+	err     error
+}
+
+func (v *versionRunner) Run(name string, args ...string) (CapturedOutput, error) {
+	var out CapturedOutput
+	out.Stdout.WriteString(v.version + "\n")
+	return out, v.err
+}
+
+// TestDoctorChecks tests each environment diagnostic used by the doctor command
+func TestDoctorChecks(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "doctor_test_*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	t.Run("yt-dlp", func(t *testing.T) {
+		exePath := filepath.Join(tmpDir, "yt-dlp.exe")
+		if c := checkYtdlp(&versionRunner{version: "2025.01.15"}, exePath); c.Passed {
+			t.Errorf("expected failure when yt-dlp is missing, got %+v", c)
+		}
+
+		if err := os.WriteFile(exePath, []byte("fake"), 0755); err != nil {
+			t.Fatalf("failed to write fake exe: %v", err)
+		}
+		c := checkYtdlp(&versionRunner{version: "2025.01.15"}, exePath)
+		if !c.Passed || !strings.Contains(c.Detail, "2025.01.15") {
+			t.Errorf("expected pass with version detail, got %+v", c)
+		}
+		if c := checkYtdlp(&versionRunner{err: fmt.Errorf("exec format error")}, exePath); c.Passed {
+			t.Errorf("expected failure when yt-dlp cannot run, got %+v", c)
+		}
+	})
+
+	t.Run("network", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden) // Any response proves the host is reachable
+		}))
+		if c := checkReachable(ts.Client(), "test", ts.URL); !c.Passed {
+			t.Errorf("expected reachable server to pass, got %+v", c)
+		}
+		ts.Close()
+		if c := checkReachable(http.DefaultClient, "test", ts.URL); c.Passed {
+			t.Errorf("expected closed server to fail, got %+v", c)
+		}
+	})
+
+	t.Run("output directory", func(t *testing.T) {
+		if c := checkOutputDir(tmpDir); !c.Passed {
+			t.Errorf("expected writable temp dir to pass, got %+v", c)
+		}
+		if c := checkOutputDir(filepath.Join(tmpDir, "missing")); c.Passed {
+			t.Errorf("expected missing dir to fail, got %+v", c)
+		}
+	})
+
+	t.Run("json file", func(t *testing.T) {
+		jsonPath := filepath.Join(tmpDir, "user_data_tiktok.json")
+		if c := checkJSONFile(jsonPath); c.Passed {
+			t.Errorf("expected missing JSON to fail, got %+v", c)
+		}
+		if err := os.WriteFile(jsonPath, []byte(`{not json`), 0644); err != nil {
+			t.Fatalf("failed to write JSON: %v", err)
+		}
+		if c := checkJSONFile(jsonPath); c.Passed {
+			t.Errorf("expected invalid JSON to fail, got %+v", c)
+		}
+		valid := `{"Likes and Favorites": {"Favorite Videos": {"FavoriteVideoList": [{"Link": "https://www.tiktok.com/@u/video/1"}]}}}`
+		if err := os.WriteFile(jsonPath, []byte(valid), 0644); err != nil {
+			t.Fatalf("failed to write JSON: %v", err)
+		}
+		c := checkJSONFile(jsonPath)
+		if !c.Passed || !strings.Contains(c.Detail, "1 video entries") {
+			t.Errorf("expected valid JSON to pass with entry count, got %+v", c)
+		}
+	})
+
+	t.Run("report", func(t *testing.T) {
+		var buf bytes.Buffer
+		ok := printDoctorReport(&buf, []DoctorCheck{
+			{Name: "first", Passed: true, Detail: "fine"},
+			{Name: "second", Passed: false, Detail: "broken"},
+		})
+		if ok {
+			t.Error("expected report to indicate failure")
+		}
+		out := buf.String()
+		if !strings.Contains(out, "[PASS] first: fine") || !strings.Contains(out, "[FAIL] second: broken") {
+			t.Errorf("unexpected report output:\n%s", out)
+		}
+	})
+}
+
+// TestExtractUploader tests uploader handle extraction from TikTok URLs
+func TestExtractUploader(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://www.tiktok.com/@someone/video/7600559584901647646", "someone"},
+		{"https://www.tiktok.com/@some.one_2/video/1?lang=en", "some.one_2"},
+		{"https://www.tiktok.com/@creator", "creator"},
+		{"https://www.tiktokv.com/share/video/7600559584901647646/", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := extractUploader(tt.url); got != tt.want {
+			t.Errorf("extractUploader(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+// TestLimitPerUploader tests capping videos per uploader in first-seen order
+func TestLimitPerUploader(t *testing.T) {
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@alice/video/1"},
+		{Link: "https://www.tiktok.com/@bob/video/2"},
+		{Link: "https://www.tiktok.com/@alice/video/3"},
+		{Link: "https://www.tiktok.com/@Alice/video/4"}, // Same uploader, different case
+		{Link: "https://www.tiktok.com/@bob/video/5"},
+		{Link: "https://www.tiktok.com/@bob/video/6"},
+		{Link: "https://www.tiktokv.com/share/video/7/"}, // Unknown uploader, always kept
+		{Link: "https://www.tiktok.com/@carol/video/8"},
+	}
+
+	got, dropped := limitPerUploader(entries, 2)
+
+	wantLinks := []string{
+		"https://www.tiktok.com/@alice/video/1",
+		"https://www.tiktok.com/@bob/video/2",
+		"https://www.tiktok.com/@alice/video/3",
+		"https://www.tiktok.com/@bob/video/5",
+		"https://www.tiktokv.com/share/video/7/",
+		"https://www.tiktok.com/@carol/video/8",
+	}
+	if dropped != 2 {
+		t.Errorf("expected 2 dropped entries, got %d", dropped)
+	}
+	if len(got) != len(wantLinks) {
+		t.Fatalf("expected %d entries, got %d", len(wantLinks), len(got))
+	}
+	for i, want := range wantLinks {
+		if got[i].Link != want {
+			t.Errorf("entry %d: expected %q, got %q", i, want, got[i].Link)
+		}
+	}
+
+	// A cap of 1 keeps only the first video from each uploader
+	got, _ = limitPerUploader(entries, 1)
+	if len(got) != 4 {
+		t.Errorf("expected 4 entries with cap 1, got %d", len(got))
+	}
+}
+
+// TestBatchByUploader tests that --batch-by-uploader writes each uploader's videos together
+func TestBatchByUploader(t *testing.T) {
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@alice/video/1"},
+		{Link: "https://www.tiktokv.com/share/video/2/"}, // Unknown uploader, goes last
+		{Link: "https://www.tiktok.com/@bob/video/3"},
+		{Link: "https://www.tiktok.com/@Alice/video/4"}, // Same uploader, different case
+		{Link: "https://www.tiktok.com/@carol/video/5"},
+		{Link: "https://www.tiktok.com/@bob/video/6"},
+		{Link: "https://www.tiktok.com/@alice/video/7"},
+	}
+
+	listFile := filepath.Join(t.TempDir(), "fav_videos.txt")
+	if err := writeVideoEntriesToFile(applyEntryFilters(&Config{BatchByUploader: true}, entries), listFile); err != nil {
+		t.Fatalf("writeVideoEntriesToFile failed: %v", err)
+	}
+	data, err := os.ReadFile(listFile)
+	if err != nil {
+		t.Fatalf("failed to read list: %v", err)
+	}
+	want := strings.Join([]string{
+		"https://www.tiktok.com/@alice/video/1",
+		"https://www.tiktok.com/@Alice/video/4",
+		"https://www.tiktok.com/@alice/video/7",
+		"https://www.tiktok.com/@bob/video/3",
+		"https://www.tiktok.com/@bob/video/6",
+		"https://www.tiktok.com/@carol/video/5",
+		"https://www.tiktokv.com/share/video/2/",
+	}, "\n")
+	if got := strings.TrimSpace(strings.ReplaceAll(string(data), "\r\n", "\n")); got != want {
+		t.Errorf("unexpected list order:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Without the flag the export order is kept
+	if got := applyEntryFilters(&Config{}, entries); !reflect.DeepEqual(got, entries) {
+		t.Errorf("expected the original order without --batch-by-uploader, got %v", got)
+	}
+}
+
+// TestUploaderGroupsInIndex tests that the gallery groups videos by creator
+func TestUploaderGroupsInIndex(t *testing.T) {
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@zed/video/111"},
+		{Link: "https://www.tiktok.com/@alice/video/222"},
+		{Link: "https://www.tiktok.com/@zed/video/333"},
+		{Link: "https://www.tiktokv.com/share/video/444/", CreatorID: "bob"},
+		{Link: "https://www.tiktokv.com/share/video/555/"},
+	}
+
+	groups := groupEntriesByUploader(entries)
+	wantHandles := []string{"alice", "bob", "unknown", "zed"}
+	if len(groups) != len(wantHandles) {
+		t.Fatalf("expected %d groups, got %d", len(wantHandles), len(groups))
+	}
+	for i, want := range wantHandles {
+		if groups[i].Handle != want {
+			t.Errorf("group %d: expected handle %q, got %q", i, want, groups[i].Handle)
+		}
+	}
+	if len(groups[3].Videos) != 2 || groups[3].Videos[0].Link != entries[0].Link || groups[3].Videos[1].Link != entries[2].Link {
+		t.Errorf("expected zed's videos in original order, got %+v", groups[3].Videos)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "uploader_index_test_*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	if err := generateCollectionIndex(tmpDir, entries, nil); err != nil {
+		t.Fatalf("generateCollectionIndex failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(tmpDir, "index.html"))
+	if err != nil {
+		t.Fatalf("failed to read index.html: %v", err)
+	}
+	html := string(data)
+
+	// Extract a creator's section from the generated page
+	section := func(handle string) string {
+		start := strings.Index(html, `id="uploader-`+handle+`"`)
+		if start < 0 {
+			t.Fatalf("missing section for %s", handle)
+		}
+		end := strings.Index(html[start:], "</section>")
+		return html[start : start+end]
+	}
+
+	zed := section("zed")
+	if !strings.Contains(zed, "Video 111") || !strings.Contains(zed, "Video 333") || strings.Contains(zed, "Video 222") {
+		t.Errorf("zed's section has wrong videos:\n%s", zed)
+	}
+	if !strings.Contains(zed, `href="#top"`) {
+		t.Error("expected back-to-top link in creator section")
+	}
+	if alice := section("alice"); !strings.Contains(alice, "Video 222") {
+		t.Errorf("alice's section is missing her video:\n%s", alice)
+	}
+	if !strings.Contains(html, `href="#uploader-alice"`) {
+		t.Error("expected creator navigation link")
+	}
+
+	// Grouping is for the gallery only and must not leak into index.json
+	jsonData, _ := os.ReadFile(filepath.Join(tmpDir, "index.json"))
+	if strings.Contains(string(jsonData), "Uploaders") {
+		t.Error("index.json should not contain uploader groups")
+	}
+}
+
+// TestGroupIndexBy tests the --group-index-by gallery sections for each mode
+func TestGroupIndexBy(t *testing.T) {
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@zed/video/111", Date: "2024-01-15 10:00:00"},
+		{Link: "https://www.tiktok.com/@alice/video/222", Date: "2024-03-02 08:30:00"},
+		{Link: "https://www.tiktok.com/@zed/video/333", Date: "2024-03-20 21:00:00"},
+		{Link: "https://www.tiktok.com/@alice/video/444"},
+	}
+
+	tests := []struct {
+		mode   string
+		titles []string
+		videos [][]string // video IDs per section
+	}{
+		{GroupIndexNone, nil, nil},
+		{GroupIndexDate, []string{"March 2024", "January 2024", "Undated"}, [][]string{{"222", "333"}, {"111"}, {"444"}}},
+		{GroupIndexUploader, []string{"@alice", "@zed"}, [][]string{{"222", "444"}, {"111", "333"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			sections := buildIndexSections(tt.mode, entries)
+			var titles []string
+			var videos [][]string
+			for _, section := range sections {
+				titles = append(titles, section.Title)
+				var ids []string
+				for _, entry := range section.Videos {
+					ids = append(ids, extractVideoID(entry.Link))
+				}
+				videos = append(videos, ids)
+			}
+			if !reflect.DeepEqual(titles, tt.titles) || !reflect.DeepEqual(videos, tt.videos) {
+				t.Errorf("expected sections %v %v, got %v %v", tt.titles, tt.videos, titles, videos)
+			}
+
+			tmpDir := t.TempDir()
+			if err := generateCollectionIndexWithExtensions(tmpDir, entries, nil, nil, FilenameMatchID, tt.mode); err != nil {
+				t.Fatalf("generateCollectionIndexWithExtensions failed: %v", err)
+			}
+			data, err := os.ReadFile(filepath.Join(tmpDir, "index.html"))
+			if err != nil {
+				t.Fatalf("failed to read index.html: %v", err)
+			}
+			html := string(data)
+			if got := strings.Count(html, `class="gallery-section"`); got != len(tt.titles) {
+				t.Errorf("expected %d gallery sections, got %d", len(tt.titles), got)
+			}
+			if got := strings.Count(html, `class="video-card`); got != len(entries) {
+				t.Errorf("expected each video card once, got %d cards", got)
+			}
+			if tt.mode == GroupIndexNone && !strings.Contains(html, `id="videoGrid"`) {
+				t.Error("expected the flat grid without grouping")
+			}
+			for _, title := range tt.titles {
+				if !strings.Contains(html, "<h2>"+title+" ") {
+					t.Errorf("missing section heading %q", title)
+				}
+			}
+		})
+	}
+}
+
+// TestUpdateCollectionIndex tests merging new results into an existing index.json
+func TestUpdateCollectionIndex(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "incremental_index_test_*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	// Existing index: 111 downloaded (file still present, no .info.json left to re-read),
+	// 444 recorded as downloaded but its file has since been deleted
+	existing := CollectionIndex{
+		Name: filepath.Base(tmpDir),
+		Videos: []VideoEntry{
+			{Link: "https://www.tiktok.com/@a/video/111", VideoID: "111", Title: "Old Title", Downloaded: true, LocalFilename: "20260101_111_Old.mp4"},
+			{Link: "https://www.tiktok.com/@a/video/444", VideoID: "444", Title: "Gone", Downloaded: true, LocalFilename: "20260101_444_Gone.mp4"},
+		},
+	}
+	data, _ := json.Marshal(existing)
+	write("index.json", string(data))
+	write("20260101_111_Old.mp4", "video")
+
+	// New result for 222
+	write("20260102_222_New.info.json", `{"id": "222", "title": "New Title", "filename": "20260102_222_New.mp4"}`)
+	write("20260102_222_New.mp4", "video")
+
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@a/video/111", Date: "2026-01-01", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/222", Date: "2026-01-02", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/333", Date: "2026-01-03", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/444", Date: "2026-01-04", Collection: "favorites"},
+	}
+	failures := []FailureDetail{{VideoID: "333", ErrorMessage: "Video not available"}}
+
+	if err := updateCollectionIndex(tmpDir, entries, failures, nil, FilenameMatchID, GroupIndexNone); err != nil {
+		t.Fatalf("updateCollectionIndex failed: %v", err)
+	}
+
+	merged, err := loadExistingIndex(tmpDir)
+	if err != nil || merged == nil {
+		t.Fatalf("failed to load merged index: %v", err)
+	}
+	if merged.TotalVideos != 4 || merged.Downloaded != 2 || merged.Failed != 2 {
+		t.Errorf("unexpected counts: total=%d downloaded=%d failed=%d", merged.TotalVideos, merged.Downloaded, merged.Failed)
+	}
+
+	v := merged.Videos
+	if v[0].VideoID != "111" || v[0].Title != "Old Title" || !v[0].Downloaded || v[0].Date != "2026-01-01" {
+		t.Errorf("expected 111 reused from existing index with new date, got %+v", v[0])
+	}
+	if v[1].VideoID != "222" || v[1].Title != "New Title" || !v[1].Downloaded {
+		t.Errorf("expected 222 enriched from new metadata, got %+v", v[1])
+	}
+	if v[2].VideoID != "333" || v[2].Downloaded || v[2].DownloadError != "Video not available" {
+		t.Errorf("expected 333 marked failed with error, got %+v", v[2])
+	}
+	if v[3].VideoID != "444" || v[3].Downloaded {
+		t.Errorf("expected 444 refreshed as not downloaded since its file is gone, got %+v", v[3])
+	}
+
+	// Without an existing index, the update falls back to a full rebuild
+	emptyDir, err := os.MkdirTemp("", "incremental_index_empty_*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(emptyDir) }()
+	if err := updateCollectionIndex(emptyDir, entries, nil, nil, FilenameMatchID, GroupIndexNone); err != nil {
+		t.Fatalf("expected fallback rebuild to succeed, got %v", err)
+	}
+	if idx, _ := loadExistingIndex(emptyDir); idx == nil || idx.TotalVideos != 4 {
+		t.Error("expected full index to be generated when none existed")
+	}
+}
+
+// TestEnsureOutputWritable tests the up-front write permission check
+func TestEnsureOutputWritable(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "writable_test_*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer func() {
+		_ = os.Chmod(tmpDir, 0755)
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	if err := ensureOutputWritable(tmpDir); err != nil {
+		t.Errorf("expected writable dir to pass, got %v", err)
+	}
+	// The probe file must not be left behind
+	if files, _ := os.ReadDir(tmpDir); len(files) != 0 {
+		t.Errorf("expected write probe to be cleaned up, found %d files", len(files))
+	}
+
+	if err := ensureOutputWritable(filepath.Join(tmpDir, "missing")); err == nil {
+		t.Error("expected error for missing directory")
+	}
+
+	if err := os.Chmod(tmpDir, 0555); err != nil {
+		t.Skipf("cannot make directory read-only: %v", err)
+	}
+	// Permissions aren't enforced everywhere (e.g. Windows, or running as root)
+	if f, err := os.CreateTemp(tmpDir, "probe"); err == nil {
+		_ = f.Close()
+		t.Skip("read-only directories are not enforced on this system")
+	}
+
+	err = ensureOutputWritable(tmpDir)
+	if err == nil {
+		t.Fatal("expected error for read-only directory")
+	}
+	if !strings.Contains(err.Error(), "not writable") {
+		t.Errorf("expected a clear 'not writable' message, got %v", err)
+	}
+}
+
+// TestWriteEntriesBySource tests partitioning entries into one URL list per source
+func TestWriteEntriesBySource(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "split_by_source_test_*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@a/video/1", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/2", Collection: "liked"},
+		{Link: "https://www.tiktok.com/@a/video/3", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/4", Collection: "liked"},
+		{Link: "https://www.tiktok.com/@a/video/5", Collection: "favorites"},
+	}
+
+	sources, err := writeEntriesBySource(entries, tmpDir)
+	if err != nil {
+		t.Fatalf("writeEntriesBySource failed: %v", err)
+	}
+	if len(sources) != 2 || sources[0] != "favorites" || sources[1] != "liked" {
+		t.Fatalf("expected sources [favorites liked], got %v", sources)
+	}
+
+	expected := map[string][]string{
+		"favorites.txt": {entries[0].Link, entries[2].Link, entries[4].Link},
+		"liked.txt":     {entries[1].Link, entries[3].Link},
+	}
+	for name, wantLinks := range expected {
+		content, err := os.ReadFile(filepath.Join(tmpDir, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		if len(lines) != len(wantLinks) {
+			t.Fatalf("%s: expected %d lines, got %d", name, len(wantLinks), len(lines))
+		}
+		for i, want := range wantLinks {
+			if lines[i] != want {
+				t.Errorf("%s line %d: expected %q, got %q", name, i, want, lines[i])
+			}
+		}
+	}
+
+	// No merged list should be written
+	if _, err := os.Stat(filepath.Join(tmpDir, "fav_videos.txt")); !os.IsNotExist(err) {
+		t.Error("expected no merged fav_videos.txt when splitting by source")
+	}
+}
+
+// TestNormalizeURL tests canonicalization of messy TikTok URLs
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"already canonical", "https://www.tiktok.com/@user/video/123", "https://www.tiktok.com/@user/video/123"},
+		{"tracking params", "https://www.tiktok.com/@user/video/123?is_from_webapp=1&sender_device=pc", "https://www.tiktok.com/@user/video/123"},
+		{"language param", "https://www.tiktok.com/@user/video/123?lang=en", "https://www.tiktok.com/@user/video/123"},
+		{"uppercase host", "HTTPS://WWW.TikTok.COM/@User/video/123", "https://www.tiktok.com/@User/video/123"},
+		{"regional subpath", "https://www.tiktok.com/en/@user/video/123", "https://www.tiktok.com/@user/video/123"},
+		{"regional subpath with country", "https://www.tiktok.com/pt-BR/@user/video/123?lang=pt", "https://www.tiktok.com/@user/video/123"},
+		{"trailing slash", "https://www.tiktokv.com/share/video/123/", "https://www.tiktokv.com/share/video/123"},
+		{"fragment", "https://www.tiktok.com/@user/video/123#comments", "https://www.tiktok.com/@user/video/123"},
+		{"surrounding whitespace", "  https://www.tiktok.com/@user/video/123?a=b \n", "https://www.tiktok.com/@user/video/123"},
+		{"not a url", "not a url", "not a url"},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeURL(tt.input); got != tt.expected {
+				t.Errorf("normalizeURL(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestNormalizeEntries tests that normalization removes duplicates within a collection only
+func TestNormalizeEntries(t *testing.T) {
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@user/video/1?lang=en", Collection: "favorites"},
+		{Link: "https://WWW.TIKTOK.COM/@user/video/1", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/en/@user/video/1?is_from_webapp=1", Collection: "liked"},
+		{Link: "https://www.tiktok.com/@user/video/2", Collection: "favorites"},
+	}
+
+	result, removed := normalizeEntries(entries)
+	if removed != 1 {
+		t.Errorf("expected 1 duplicate removed, got %d", removed)
+	}
+	if len(result) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(result))
+	}
+	if result[1].Collection != "liked" || result[1].Link != "https://www.tiktok.com/@user/video/1" {
+		t.Errorf("unexpected liked entry: %+v", result[1])
+	}
+}
+
+// TestStripQuery tests that --strip-query removes only the query string
+func TestStripQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"no query", "https://www.tiktok.com/@user/video/123", "https://www.tiktok.com/@user/video/123"},
+		{"language param", "https://www.tiktok.com/@user/video/123?lang=en", "https://www.tiktok.com/@user/video/123"},
+		{"tracking params", "https://www.tiktok.com/@user/video/123?is_from_webapp=1&sender_device=pc", "https://www.tiktok.com/@user/video/123"},
+		{"empty query", "https://www.tiktok.com/@user/video/123?", "https://www.tiktok.com/@user/video/123"},
+		{"regional path and case kept", "HTTPS://WWW.TikTok.com/en/@User/video/123/?lang=en", "HTTPS://WWW.TikTok.com/en/@User/video/123/"},
+		{"fragment kept", "https://www.tiktok.com/@user/video/123?lang=en#comments", "https://www.tiktok.com/@user/video/123#comments"},
+		{"question mark in fragment", "https://www.tiktok.com/@user/video/123#why?", "https://www.tiktok.com/@user/video/123#why?"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripQuery(tt.input); got != tt.expected {
+				t.Errorf("stripQuery(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@user/video/1?lang=en", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@user/video/1?lang=de", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/en/@user/video/1", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@user/video/1?lang=en", Collection: "liked"},
+	}
+	result := applyEntryFilters(&Config{StripQuery: true}, entries)
+	want := []VideoEntry{
+		{Link: "https://www.tiktok.com/@user/video/1", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/en/@user/video/1", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@user/video/1", Collection: "liked"},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("expected %+v, got %+v", want, result)
+	}
+}
+
+// TestResumeInterruptedBatch simulates a run killed mid-batch and checks the next run
+// picks up from the checkpointed video
+func TestResumeInterruptedBatch(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "resume_batch_test_*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	var entries []VideoEntry
+	for i := 1; i <= 5; i++ {
+		entries = append(entries, VideoEntry{Link: fmt.Sprintf("https://www.tiktok.com/@user/video/%d", i), Collection: "favorites"})
+	}
+	outputName := filepath.Join(tmpDir, "favorites", "favorites.txt")
+	if err := os.MkdirAll(filepath.Dir(outputName), 0755); err != nil {
+		t.Fatalf("failed to create collection dir: %v", err)
+	}
+	progressPath := batchProgressPath(outputName)
+
+	// First run: yt-dlp reports items 1-3 and is then killed
+	checkpoint := newBatchCheckpoint(progressPath, outputName, entries, entries)
+	output := "[download] Downloading item 1 of 5\n[download] Downloading item 2 of 5\nsome other line\n[download] Downloading it"
+	_, _ = checkpoint.Write([]byte(output))
+	_, _ = checkpoint.Write([]byte("em 3 of 5\n[download] Destination: x.mp4"))
+
+	progress, err := loadBatchProgress(progressPath)
+	if err != nil || progress == nil {
+		t.Fatalf("expected checkpoint to be saved, got %v, %v", progress, err)
+	}
+	if progress.Index != 2 || progress.URL != entries[2].Link || progress.Total != 5 {
+		t.Fatalf("unexpected checkpoint: %+v", progress)
+	}
+
+	// A run where yt-dlp fails keeps the checkpoint for the next attempt
+	failing := &MockCommandRunner{ShouldFail: true}
+	if _, err := runYtdlpWithRunner(failing, "", outputName, &Config{OrganizeByCollection: true, AutoResume: true}, entries); err == nil {
+		t.Fatal("expected the failing run to return an error")
+	}
+	if _, err := os.Stat(progressPath); err != nil {
+		t.Fatalf("expected progress file to survive a failed run, got %v", err)
+	}
+
+	// Next run resumes from item 3
+	var batch []string
+	runner := &listCapturingRunner{lists: &batch}
+	config := &Config{OrganizeByCollection: true, AutoResume: true}
+	result, err := runYtdlpWithRunner(runner, "", outputName, config, entries)
+	if err != nil {
+		t.Fatalf("runYtdlpWithRunner failed: %v", err)
+	}
+	expected := []string{entries[2].Link, entries[3].Link, entries[4].Link}
+	if strings.Join(batch, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected resumed batch %v, got %v", expected, batch)
+	}
+	if result.Skipped != 2 {
+		t.Errorf("expected 2 skipped videos, got %d", result.Skipped)
+	}
+
+	// A finished run clears the checkpoint
+	if _, err := os.Stat(progressPath); !os.IsNotExist(err) {
+		t.Error("expected progress file to be removed after the run completed")
+	}
+}
+
+// TestResumePosition tests matching a checkpoint against the current list
+func TestResumePosition(t *testing.T) {
+	entries := []VideoEntry{{Link: "https://a/1"}, {Link: "https://a/2"}, {Link: "https://a/3"}}
+
+	if pos := resumePosition(nil, entries); pos != -1 {
+		t.Errorf("expected -1 without checkpoint, got %d", pos)
+	}
+	if pos := resumePosition(&BatchProgress{URL: "https://a/3", Index: 7}, entries); pos != 2 {
+		t.Errorf("expected match by URL at 2, got %d", pos)
+	}
+	if pos := resumePosition(&BatchProgress{URL: "https://a/9", Index: 1}, entries); pos != -1 {
+		t.Errorf("expected -1 for URL no longer in list, got %d", pos)
+	}
+}
+
+// listCapturingRunner records the URLs in the list file passed to yt-dlp via -a
+type listCapturingRunner struct {
+	lists *[]string
+}
+
+func (r *listCapturingRunner) Run(name string, args ...string) (CapturedOutput, error) {
+	for i, arg := range args {
+		if arg == "-a" && i+1 < len(args) {
+			content, err := os.ReadFile(args[i+1])
+			if err != nil {
+				return CapturedOutput{}, err
+			}
+			*r.lists = strings.Fields(string(content))
+		}
+	}
+	return CapturedOutput{}, nil
+}
+
+// TestSentinelErrors tests that each failure category wraps the right sentinel error
+func TestSentinelErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldCwd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(oldCwd) }()
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("failed to chdir to temp dir: %v", err)
+	}
+
+	// Serves a release whose JSON, assets and downloads are chosen per test case
+	var releaseJSON string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/yt-dlp/yt-dlp/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+		if releaseJSON == "" {
+			// GitHub answers a rate-limited request with 403 and a JSON message
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message": "API rate limit exceeded"}`))
+			return
+		}
+		_, _ = w.Write([]byte(releaseJSON))
+	})
+	mux.HandleFunc("/yt-dlp.exe", func(w http.ResponseWriter, r *http.Request) {
+		// Promise more bytes than are sent so the body ends early
+		w.Header().Set("Content-Length", "1000")
+		_, _ = w.Write([]byte("truncated"))
+	})
+	mux.HandleFunc("/missing/yt-dlp.exe", http.NotFound)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	client := &http.Client{Transport: &rewriterRoundTripper{rt: http.DefaultTransport, host: ts.URL}}
+
+	badJSON := filepath.Join(tmpDir, "bad.json")
+	if err := os.WriteFile(badJSON, []byte("{not json"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		releaseJSON string
+		run         func() error
+		want        error
+	}{
+		{
+			name: "malformed export",
+			run: func() error {
+				_, err := parseFavoriteVideosFromFile(badJSON, false, nil)
+				return err
+			},
+			want: ErrJSONParse,
+		},
+		{
+			name:        "malformed release JSON",
+			releaseJSON: "<html>rate limited</html>",
+			run:         func() error { return downloadYtdlpAsset(context.Background(), client, "", "yt-dlp.exe", "yt-dlp.exe") },
+			want:        ErrJSONParse,
+		},
+		{
+			name:        "missing release asset",
+			releaseJSON: `{"assets": [{"name": "yt-dlp_linux", "browser_download_url": "http://example.com/yt-dlp_linux"}]}`,
+			run:         func() error { return downloadYtdlpAsset(context.Background(), client, "", "yt-dlp.exe", "yt-dlp.exe") },
+			want:        ErrNoAsset,
+		},
+		{
+			name: "no self-update binary for platform",
+			run: func() error {
+				_, err := selectSelfUpdateAsset(nil, "plan9", "386")
+				return err
+			},
+			want: ErrNoAsset,
+		},
+		{
+			name:        "interrupted download",
+			releaseJSON: `{"assets": [{"name": "yt-dlp.exe", "browser_download_url": "http://example.com/yt-dlp.exe"}]}`,
+			run:         func() error { return downloadYtdlpAsset(context.Background(), client, "", "yt-dlp.exe", "yt-dlp.exe") },
+			want:        ErrDownload,
+		},
+		{
+			name: "rate-limited release API",
+			run: func() error {
+				return downloadYtdlpAsset(context.Background(), client, "", "yt-dlp.exe", "yt-dlp.exe")
+			},
+			want: ErrDownload,
+		},
+		{
+			name:        "asset download not found",
+			releaseJSON: `{"assets": [{"name": "yt-dlp.exe", "browser_download_url": "http://example.com/missing/yt-dlp.exe"}]}`,
+			run: func() error {
+				err := downloadYtdlpAsset(context.Background(), client, "", "yt-dlp.exe", "yt-dlp.exe")
+				// The 404 page must not be left behind as yt-dlp.exe
+				if _, statErr := os.Stat("yt-dlp.exe"); statErr == nil {
+					t.Error("expected no yt-dlp.exe after a failed download")
+				}
+				return err
+			},
+			want: ErrDownload,
+		},
+		{
+			name: "yt-dlp exits with an error",
+			run: func() error {
+				outputName := filepath.Join(tmpDir, "fav_videos.txt")
+				entries := []VideoEntry{{Link: "https://www.tiktok.com/@user/video/1"}}
+				_, err := runYtdlpWithRunner(&MockCommandRunner{ShouldFail: true}, "", outputName, &Config{DisableResume: true}, entries)
+				return err
+			},
+			want: ErrYtdlpRun,
+		},
+	}
+
+	sentinels := []error{ErrJSONParse, ErrNoAsset, ErrDownload, ErrYtdlpRun}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			releaseJSON = tt.releaseJSON
+			err := tt.run()
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected error wrapping %q, got %v", tt.want, err)
+			}
+			for _, other := range sentinels {
+				if other != tt.want && errors.Is(err, other) {
+					t.Errorf("error %v unexpectedly also matches %q", err, other)
+				}
+			}
+		})
+	}
+}
+
+// TestGenerateCollectionIndexMediaExtensions tests matching downloaded media by a configured
+// extension set in a directory with mixed extensions
+func TestGenerateCollectionIndexMediaExtensions(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "media_ext_test_*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	// 111: audio extracted to .mp3, but the info file still names the original .mp4
+	write("20260101_111_Song.info.json", `{"id": "111", "title": "Song", "filename": "20260101_111_Song.mp4"}`)
+	write("20260101_111_Song.mp3", "audio")
+	// 222: no filename in the info file, only an .m4a on disk
+	write("20260102_222_Talk.info.json", `{"id": "222", "title": "Talk"}`)
+	write("20260102_222_Talk.m4a", "audio")
+	// 333: a .webm, which isn't in the audio extension set
+	write("20260103_333_Clip.info.json", `{"id": "333", "title": "Clip"}`)
+	write("20260103_333_Clip.webm", "video")
+
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@a/video/111", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/222", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/333", Collection: "favorites"},
+	}
+
+	if err := generateCollectionIndexWithExtensions(tmpDir, entries, nil, parseMediaExtensions("mp3, .M4A"), FilenameMatchID, GroupIndexNone); err != nil {
+		t.Fatalf("generateCollectionIndexWithExtensions failed: %v", err)
+	}
+	index, err := loadExistingIndex(tmpDir)
+	if err != nil || index == nil {
+		t.Fatalf("failed to load index: %v", err)
+	}
+
+	expected := map[string]struct {
+		downloaded bool
+		filename   string
+	}{
+		"111": {true, "20260101_111_Song.mp3"},
+		"222": {true, "20260102_222_Talk.m4a"},
+		"333": {false, ""},
+	}
+	for _, v := range index.Videos {
+		want := expected[v.VideoID]
+		if v.Downloaded != want.downloaded || v.LocalFilename != want.filename {
+			t.Errorf("video %s: expected downloaded=%v file=%q, got downloaded=%v file=%q",
+				v.VideoID, want.downloaded, want.filename, v.Downloaded, v.LocalFilename)
+		}
+	}
+
+	// The default extension set still finds the .webm
+	if err := generateCollectionIndex(tmpDir, entries, nil); err != nil {
+		t.Fatalf("generateCollectionIndex failed: %v", err)
+	}
+	index, _ = loadExistingIndex(tmpDir)
+	if v := index.Videos[2]; !v.Downloaded || v.LocalFilename != "20260103_333_Clip.webm" {
+		t.Errorf("expected .webm to be matched by default extensions, got %+v", v)
+	}
+}
+
+// TestRegenerateReports tests rebuilding the index and results.txt from a prepared
+// download directory and the original URL list
+func TestRegenerateReports(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "report_only_test_*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	write("fav_videos.txt", "# favorites\nhttps://www.tiktok.com/@a/video/111\n\nhttps://www.tiktok.com/@a/video/222\nhttps://www.tiktok.com/@a/video/333\n")
+	write("20260101_111_One.info.json", `{"id": "111", "title": "One", "filename": "20260101_111_One.mp4"}`)
+	write("20260101_111_One.mp4", "video")
+	write("20260102_222_Two.info.json", `{"id": "222", "title": "Two", "filename": "20260102_222_Two.mp4"}`)
+
+	entries, err := readURLList(filepath.Join(tmpDir, "fav_videos.txt"))
+	if err != nil {
+		t.Fatalf("readURLList failed: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 URLs, got %d", len(entries))
+	}
+
+	session, err := regenerateReports(tmpDir, entries, false, nil, FilenameMatchID, GroupIndexNone)
+	if err != nil {
+		t.Fatalf("regenerateReports failed: %v", err)
+	}
+	if session.TotalAttempted != 3 || session.TotalSuccess != 1 || session.TotalFailed != 2 {
+		t.Errorf("unexpected totals: attempted=%d success=%d failed=%d",
+			session.TotalAttempted, session.TotalSuccess, session.TotalFailed)
+	}
+
+	for _, name := range []string{"index.json", "index.html"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); err != nil {
+			t.Errorf("expected %s to be regenerated: %v", name, err)
+		}
+	}
+
+	results, err := os.ReadFile(filepath.Join(tmpDir, "results.txt"))
+	if err != nil {
+		t.Fatalf("expected results.txt: %v", err)
+	}
+	for _, want := range []string{"Total Videos Attempted: 3", "Failed: 2", "Video ID: 222", "Video file missing (metadata only)", "Video ID: 333"} {
+		if !strings.Contains(string(results), want) {
+			t.Errorf("results.txt missing %q", want)
+		}
+	}
+}
+
+// TestRunManifest tests that run_manifest.json, run_manifest.csv, index.json and
+// results.txt agree on the videos of a collection
+func TestRunManifest(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	write("20260101_111_One.info.json", `{"id": "111", "title": "One", "filename": "20260101_111_One.mp4"}`)
+	write("20260101_111_One.mp4", "video data")
+	write("20260102_222_Two.info.json", `{"id": "222", "title": "Two", "filename": "20260102_222_Two.mp4"}`)
+
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@a/video/111", Date: "2026-01-01 10:00:00", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/222", Date: "2026-01-02 10:00:00", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/333", Date: "2026-01-03 10:00:00", Collection: "favorites"},
+	}
+	failures := []FailureDetail{{VideoID: "333", VideoURL: entries[2].Link, ErrorMessage: "Video unavailable"}}
+	if err := indexCollection(&Config{RunManifest: true}, dir, entries, failures); err != nil {
+		t.Fatalf("indexCollection failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, runManifestFilename))
+	if err != nil {
+		t.Fatalf("expected %s: %v", runManifestFilename, err)
+	}
+	var manifest RunManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("invalid run manifest: %v", err)
+	}
+	if want := (RunManifestTotals{Videos: 3, Downloaded: 1, Failed: 2}); manifest.Totals != want {
+		t.Errorf("expected totals %+v, got %+v", want, manifest.Totals)
+	}
+	want := RunManifestEntry{VideoID: "111", URL: entries[0].Link, Source: "favorites", Date: "2026-01-01 10:00:00",
+		Status: RunStatusDownloaded, LocalPath: "20260101_111_One.mp4", Size: int64(len("video data"))}
+	if manifest.Videos[0] != want {
+		t.Errorf("expected %+v, got %+v", want, manifest.Videos[0])
+	}
+	if v := manifest.Videos[2]; v.Status != RunStatusFailed || v.Error != "Video unavailable" {
+		t.Errorf("expected video 333 to have failed as unavailable, got %+v", v)
+	}
+
+	// The CSV has a row per manifest record
+	csvFile, err := os.Open(filepath.Join(dir, runManifestCSVFilename))
+	if err != nil {
+		t.Fatalf("expected %s: %v", runManifestCSVFilename, err)
+	}
+	defer func() { _ = csvFile.Close() }()
+	rows, err := csv.NewReader(csvFile).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	downloadedRows := 0
+	for _, row := range rows[1:] {
+		if row[4] == RunStatusDownloaded {
+			downloadedRows++
+		}
+	}
+	if len(rows)-1 != manifest.Totals.Videos || downloadedRows != manifest.Totals.Downloaded {
+		t.Errorf("CSV disagrees with the manifest: %d rows, %d downloaded", len(rows)-1, downloadedRows)
+	}
+
+	// index.json and the results derived from it count the same videos
+	index, err := loadExistingIndex(dir)
+	if err != nil || index == nil {
+		t.Fatalf("failed to load index.json: %v", err)
+	}
+	downloaded := 0
+	for _, v := range index.Videos {
+		if v.Downloaded {
+			downloaded++
+		}
+	}
+	if len(index.Videos) != manifest.Totals.Videos || downloaded != manifest.Totals.Downloaded {
+		t.Errorf("index disagrees with the manifest: %d videos, %d downloaded", len(index.Videos), downloaded)
+	}
+	session, err := regenerateReports(dir, entries, false, nil, FilenameMatchID, GroupIndexNone)
+	if err != nil {
+		t.Fatalf("regenerateReports failed: %v", err)
+	}
+	if session.TotalAttempted != manifest.Totals.Videos || session.TotalSuccess != manifest.Totals.Downloaded || session.TotalFailed != manifest.Totals.Failed {
+		t.Errorf("results disagree with the manifest: attempted=%d success=%d failed=%d",
+			session.TotalAttempted, session.TotalSuccess, session.TotalFailed)
+	}
+
+	// A video listed twice is one record
+	joined := joinRunManifest([]VideoEntry{
+		{VideoID: "1", Link: "https://www.tiktok.com/@a/video/1", Downloaded: true},
+		{VideoID: "1", Link: "https://www.tiktok.com/@a/video/1?lang=en"},
+		{Link: "https://vm.tiktok.com/abc/"},
+	})
+	if joined.Totals != (RunManifestTotals{Videos: 2, Downloaded: 1, Failed: 1}) {
+		t.Errorf("expected duplicates to be joined, got %+v", joined.Totals)
+	}
+}
+
+// writeTestClientCert generates a self-signed client certificate and key as PEM files in dir
+func writeTestClientCert(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "client.crt")
+	keyFile = filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return certFile, keyFile, cert
+}
+
+// TestNewHTTPClientClientCert tests that the configured client certificate is presented
+// to a server that requires one
+func TestNewHTTPClientClientCert(t *testing.T) {
+	tmpDir := t.TempDir()
+	certFile, keyFile, cert := writeTestClientCert(t, tmpDir)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("yt-dlp mirror"))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	ts.StartTLS()
+	defer ts.Close()
+
+	serverCAs := x509.NewCertPool()
+	serverCAs.AddCert(ts.Certificate())
+
+	// Without a certificate the handshake is rejected
+	client, err := newHTTPClient(&Config{})
+	if err != nil {
+		t.Fatalf("newHTTPClient failed: %v", err)
+	}
+	if client != http.DefaultClient {
+		t.Error("expected the default client without a client certificate")
+	}
+	noCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: serverCAs}}}
+	if resp, err := noCert.Get(ts.URL); err == nil {
+		_ = resp.Body.Close()
+		t.Fatal("expected request without client certificate to fail")
+	}
+
+	// With the certificate the request succeeds
+	client, err = newHTTPClient(&Config{ClientCert: certFile, ClientKey: keyFile})
+	if err != nil {
+		t.Fatalf("newHTTPClient failed: %v", err)
+	}
+	client.Transport.(*http.Transport).TLSClientConfig.RootCAs = serverCAs
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("request with client certificate failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "yt-dlp mirror" {
+		t.Errorf("unexpected response body: %q", body)
+	}
+
+	// Misconfiguration is reported
+	if _, err := newHTTPClient(&Config{ClientCert: certFile}); err == nil {
+		t.Error("expected error when --client-key is missing")
+	}
+	if _, err := newHTTPClient(&Config{ClientCert: keyFile, ClientKey: keyFile}); err == nil {
+		t.Error("expected error for an invalid certificate file")
+	}
+}
+
+// TestNewHTTPClientInsecureSkipVerify checks a self-signed mirror is only reachable with --insecure-skip-verify
+func TestNewHTTPClientInsecureSkipVerify(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("self-signed mirror"))
+	}))
+	defer ts.Close()
+
+	// Default client rejects the self-signed certificate
+	client, err := newHTTPClient(&Config{})
+	if err != nil {
+		t.Fatalf("newHTTPClient failed: %v", err)
+	}
+	if resp, err := client.Get(ts.URL); err == nil {
+		_ = resp.Body.Close()
+		t.Fatal("expected the self-signed certificate to be rejected without --insecure-skip-verify")
+	}
+
+	// With the flag the request succeeds
+	client, err = newHTTPClient(&Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("newHTTPClient failed: %v", err)
+	}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("request with --insecure-skip-verify failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "self-signed mirror" {
+		t.Errorf("unexpected response body: %q", body)
+	}
+}
+
+func TestNewHTTPClientMaxConnections(t *testing.T) {
+	client, err := newHTTPClient(&Config{})
+	if err != nil {
+		t.Fatalf("newHTTPClient failed: %v", err)
+	}
+	if client != http.DefaultClient {
+		t.Error("expected the default client without a connection cap")
+	}
+
+	for _, config := range []*Config{
+		{MaxConnections: 2},
+		{MaxConnections: 2, InsecureSkipVerify: true},
+		{MaxConnections: 2, UserAgents: newUserAgentRotator(userAgents)},
+	} {
+		client, err := newHTTPClient(config)
+		if err != nil {
+			t.Fatalf("newHTTPClient failed: %v", err)
+		}
+		rt := client.Transport
+		if uaTransport, ok := rt.(*userAgentTransport); ok {
+			rt = uaTransport.rt
+		}
+		transport, ok := rt.(*http.Transport)
+		if !ok {
+			t.Fatalf("expected an *http.Transport, got %T", rt)
+		}
+		if transport.MaxConnsPerHost != 2 || transport.MaxIdleConnsPerHost != 2 {
+			t.Errorf("expected a cap of 2 connections per host, got MaxConnsPerHost=%d MaxIdleConnsPerHost=%d",
+				transport.MaxConnsPerHost, transport.MaxIdleConnsPerHost)
+		}
+		if config.InsecureSkipVerify != (transport.TLSClientConfig != nil && transport.TLSClientConfig.InsecureSkipVerify) {
+			t.Errorf("expected InsecureSkipVerify=%v to carry over to the capped transport", config.InsecureSkipVerify)
+		}
+	}
+	if http.DefaultTransport.(*http.Transport).MaxConnsPerHost != 0 {
+		t.Error("expected the shared default transport to be left untouched")
+	}
+}
+
+// TestExportStats tests each aggregate computed by the stats command over an export fixture
+func TestExportStats(t *testing.T) {
+	tmpDir := t.TempDir()
+	fixture := `{
+		"Likes and Favorites": {
+			"Favorite Videos": {
+				"FavoriteVideoList": [
+					{"Date": "2024-03-01 10:00:00", "Link": "https://www.tiktok.com/@alice/video/1"},
+					{"Date": "2023-12-24 08:30:00", "Link": "https://www.tiktok.com/@Alice/video/2"},
+					{"Date": "2024-01-15 12:00:00", "Link": "https://www.tiktok.com/@bob/video/3"},
+					{"Date": "not a date", "Link": "https://www.tiktokv.com/share/video/4/"}
+				]
+			},
+			"Like List": {
+				"ItemFavoriteList": [
+					{"date": "2024-06-30 23:59:59", "link": "https://www.tiktok.com/@carol/video/5"},
+					{"date": "2024-02-02 02:02:02", "link": "https://www.tiktok.com/@bob/video/6"},
+					{"date": "2024-02-03 02:02:02", "link": "https://www.tiktok.com/@alice/video/7"}
+				]
+			}
+		}
+	}`
+	jsonFile := filepath.Join(tmpDir, "user_data_tiktok.json")
+	if err := os.WriteFile(jsonFile, []byte(fixture), 0644); err != nil {
+		t.Fatalf("failed to write fixture: %v", err)
+	}
+
+	entries, err := parseFavoriteVideosFromFile(jsonFile, true, nil)
+	if err != nil {
+		t.Fatalf("failed to parse fixture: %v", err)
+	}
+	stats := computeExportStats(entries)
+
+	if stats.Favorites != 4 {
+		t.Errorf("expected 4 favorites, got %d", stats.Favorites)
+	}
+	if stats.Liked != 3 {
+		t.Errorf("expected 3 liked, got %d", stats.Liked)
+	}
+	if stats.UnknownUploader != 1 {
+		t.Errorf("expected 1 entry without uploader, got %d", stats.UnknownUploader)
+	}
+
+	expectedUploaders := []UploaderCount{{"alice", 3}, {"bob", 2}, {"carol", 1}}
+	if len(stats.Uploaders) != len(expectedUploaders) {
+		t.Fatalf("expected %d unique uploaders, got %v", len(expectedUploaders), stats.Uploaders)
+	}
+	for i, want := range expectedUploaders {
+		if got := stats.Uploaders[i]; !strings.EqualFold(got.Handle, want.Handle) || got.Count != want.Count {
+			t.Errorf("uploader %d: expected %+v, got %+v", i, want, got)
+		}
+	}
+
+	if got := stats.FirstDate.Format(exportDateLayout); got != "2023-12-24 08:30:00" {
+		t.Errorf("expected first date 2023-12-24 08:30:00, got %s", got)
+	}
+	if got := stats.LastDate.Format(exportDateLayout); got != "2024-06-30 23:59:59" {
+		t.Errorf("expected last date 2024-06-30 23:59:59, got %s", got)
+	}
+
+	var buf bytes.Buffer
+	printExportStats(&buf, stats, 2)
+	out := buf.String()
+	for _, want := range []string{"Favorites:        4", "Liked:            3", "Unique uploaders: 3", "2023-12-24 to 2024-06-30", "@bob: 2"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("stats output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "@carol") {
+		t.Errorf("expected only the top 2 uploaders to be listed:\n%s", out)
+	}
+}
+
+// TestDiffExports tests the diff subcommand over two exports with known changes
+func TestDiffExports(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		return path
+	}
+	oldFile := write("old.json", `{"Likes and Favorites": {
+		"Favorite Videos": {"FavoriteVideoList": [
+			{"Date": "2024-01-01 00:00:00", "Link": "https://www.tiktokv.com/share/video/1/"},
+			{"Date": "2024-01-02 00:00:00", "Link": "https://www.tiktok.com/@a/video/2"}
+		]},
+		"Like List": {"ItemFavoriteList": [{"date": "2024-01-03 00:00:00", "link": "https://www.tiktok.com/@b/video/3"}]}
+	}}`)
+	newFile := write("new.json", `{"Likes and Favorites": {
+		"Favorite Videos": {"FavoriteVideoList": [
+			{"Date": "2024-03-01 00:00:00", "Link": "https://www.tiktok.com/@c/video/4"},
+			{"Date": "2024-01-01 00:00:00", "Link": "https://www.tiktok.com/@a/video/1"},
+			{"Date": "2024-02-01 00:00:00", "Link": "https://www.tiktok.com/@b/video/3"}
+		]},
+		"Like List": {"ItemFavoriteList": [{"date": "2024-01-03 00:00:00", "link": "https://www.tiktok.com/@b/video/3"}]}
+	}}`)
+
+	oldArg, newArg, addedFile, err := parseDiffArgs([]string{oldFile, "--added", filepath.Join(tmpDir, "added.txt"), newFile})
+	if err != nil || oldArg != oldFile || newArg != newFile {
+		t.Fatalf("unexpected parseDiffArgs result: %q %q %v", oldArg, newArg, err)
+	}
+	var out bytes.Buffer
+	if err := runDiff(oldArg, newArg, addedFile, &out); err != nil {
+		t.Fatalf("runDiff failed: %v", err)
+	}
+
+	// Video 1 only changed link form; video 3 was favorited on top of being liked
+	want := "[*] 2 added, 1 removed between '" + oldFile + "' and '" + newFile + "'\n" +
+		"+ [favorites] https://www.tiktok.com/@c/video/4\n" +
+		"+ [favorites] https://www.tiktok.com/@b/video/3\n" +
+		"- [favorites] https://www.tiktok.com/@a/video/2\n" +
+		"[*] Wrote 2 added URLs to '" + addedFile + "'\n"
+	if out.String() != want {
+		t.Errorf("expected output:\n%s\ngot:\n%s", want, out.String())
+	}
+	data, err := os.ReadFile(addedFile)
+	if err != nil {
+		t.Fatalf("expected added.txt: %v", err)
+	}
+	if string(data) != "https://www.tiktok.com/@c/video/4\nhttps://www.tiktok.com/@b/video/3\n" {
+		t.Errorf("unexpected added.txt:\n%s", data)
+	}
+
+	if _, _, _, err := parseDiffArgs([]string{oldFile}); err == nil {
+		t.Error("expected an error with only one export")
+	}
+}
+
+// TestParseNullSections tests that sections, lists and list items exported as null are
+// treated as empty for every source, with and without a schema map
+func TestParseNullSections(t *testing.T) {
+	fixtures := map[string]string{
+		"null document":   `null`,
+		"null sections":   `{"Likes and Favorites": null, "Your Activity": null, "Profile": null}`,
+		"null subsection": `{"Likes and Favorites": {"Favorite Videos": null, "Like List": null}, "Your Activity": {"Video Browsing History": null}, "Profile": {"Saved Videos": null}}`,
+		"null lists":      `{"Likes and Favorites": {"Favorite Videos": {"FavoriteVideoList": null}, "Like List": {"ItemFavoriteList": null}}, "Your Activity": {"Video Browsing History": {"VideoList": null}}, "Profile": {"Saved Videos": {"SavedVideoList": null}}}`,
+		"null items":      `{"Likes and Favorites": {"Favorite Videos": {"FavoriteVideoList": [null]}, "Like List": {"ItemFavoriteList": {"0": null}}}, "Your Activity": {"Video Browsing History": {"VideoList": [null]}}, "Profile": {"Saved Videos": {"SavedVideoList": [null]}}}`,
+	}
+	schema := &SchemaMap{FavoritesList: "Likes and Favorites.Favorite Videos.FavoriteVideoList", LikedList: "Likes and Favorites.Like List.ItemFavoriteList", LinkField: "Link", DateField: "Date"}
+	for name, fixture := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			entries, err := parseFavoriteVideos(strings.NewReader(fixture), true, nil)
+			if err != nil || len(entries) != 0 {
+				t.Errorf("parseFavoriteVideos: expected no videos, got %v (err %v)", entries, err)
+			}
+			history, err := parseBrowsingHistory(strings.NewReader(fixture))
+			if err != nil || len(history) != 0 {
+				t.Errorf("parseBrowsingHistory: expected no videos, got %v (err %v)", history, err)
+			}
+			entries, err = parseFavoriteVideosWithSchema(strings.NewReader(fixture), true, schema)
+			if err != nil || len(entries) != 0 {
+				t.Errorf("parseFavoriteVideosWithSchema: expected no videos, got %v (err %v)", entries, err)
+			}
+		})
+	}
+
+	// Null items don't hide the real videos around them
+	fixture := `{"Likes and Favorites": {"Favorite Videos": {"FavoriteVideoList": [null, {"Link": "https://www.tiktok.com/@a/video/1", "Date": "2024-01-01 00:00:00"}, null]}}}`
+	entries, err := parseFavoriteVideos(strings.NewReader(fixture), true, nil)
+	if err != nil || len(entries) != 1 || entries[0].Link != "https://www.tiktok.com/@a/video/1" {
+		t.Errorf("expected only the one real video, got %v (err %v)", entries, err)
+	}
+}
+
+// TestParseLinkArray tests exports where an item's Link is an array of URLs
+func TestParseLinkArray(t *testing.T) {
+	fixture := `{
+		"Likes and Favorites": {
+			"Favorite Videos": {"FavoriteVideoList": [
+				{"Date": "2024-01-01 00:00:00", "Link": ["https://www.tiktok.com/@a/video/1", "https://www.tiktok.com/@a/video/2"]},
+				{"Date": "2024-01-02 00:00:00", "Link": "https://www.tiktok.com/@b/video/3"},
+				{"Date": "2024-01-03 00:00:00", "Link": []}
+			]},
+			"Like List": {"ItemFavoriteList": [
+				{"date": "2024-02-01 00:00:00", "link": ["https://www.tiktok.com/@c/video/4"]}
+			]}
+		}
+	}`
+	entries, err := parseFavoriteVideos(strings.NewReader(fixture), true, nil)
+	if err != nil {
+		t.Fatalf("parseFavoriteVideos failed: %v", err)
+	}
+	want := []VideoEntry{
+		{Link: "https://www.tiktok.com/@a/video/1", Date: "2024-01-01 00:00:00", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/2", Date: "2024-01-01 00:00:00", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@b/video/3", Date: "2024-01-02 00:00:00", Collection: "favorites"},
+		{Link: "", Date: "2024-01-03 00:00:00", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@c/video/4", Date: "2024-02-01 00:00:00", Collection: "liked"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("expected %+v, got %+v", want, entries)
+	}
+
+	bad := `{"Likes and Favorites": {"Favorite Videos": {"FavoriteVideoList": [{"Link": 42}]}}}`
+	if _, err := parseFavoriteVideos(strings.NewReader(bad), false, nil); !errors.Is(err, ErrJSONParse) {
+		t.Errorf("expected ErrJSONParse for a numeric link, got %v", err)
+	}
+}
+
+// TestUploaderStats tests the --uploader-stats aggregation over repeated uploaders
+func TestUploaderStats(t *testing.T) {
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@bob/video/1"},
+		{Link: "https://www.tiktok.com/@alice/video/2"},
+		{Link: "https://www.tiktok.com/@Bob/video/3"},
+		{Link: "https://www.tiktok.com/@carol/video/4"},
+		{Link: "https://www.tiktok.com/@alice/video/5"},
+		{Link: "https://www.tiktok.com/@bob/video/6"},
+		{Link: "https://www.tiktokv.com/share/video/7/"},
+	}
+
+	uploaders, unknown := countUploaders(entries)
+	want := []UploaderCount{{Handle: "bob", Count: 3}, {Handle: "alice", Count: 2}, {Handle: "carol", Count: 1}}
+	if !reflect.DeepEqual(uploaders, want) || unknown != 1 {
+		t.Errorf("expected %+v with 1 unknown, got %+v with %d unknown", want, uploaders, unknown)
+	}
+
+	var buf bytes.Buffer
+	printUploaderStats(&buf, entries, 2)
+	wantOut := "[*] 3 unique uploaders (1 links don't name the uploader)\n" +
+		"[*] Top 2 uploaders by video count:\n" +
+		"    @bob: 3\n" +
+		"    @alice: 2\n"
+	if buf.String() != wantOut {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", buf.String(), wantOut)
+	}
+}
+
+// TestWriteCreatorFeed tests the --export-creators list of unique creator profiles
+func TestWriteCreatorFeed(t *testing.T) {
+	tmpDir := t.TempDir()
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@alice/video/1"},
+		{Link: "https://www.tiktok.com/@bob.b/video/2?is_from_webapp=1"},
+		{Link: "https://www.tiktok.com/@alice/video/3"},
+		{Link: "https://www.tiktokv.com/share/video/4/"},
+		{Link: "https://m.tiktok.com/@Bob.B/video/5"},
+		{Link: "https://www.tiktok.com/@carol/video/6"},
+	}
+
+	textPath := filepath.Join(tmpDir, "creators.txt")
+	count, err := writeCreatorFeed(textPath, entries)
+	if err != nil {
+		t.Fatalf("writeCreatorFeed failed: %v", err)
+	}
+	data, err := os.ReadFile(textPath)
+	if err != nil {
+		t.Fatalf("failed to read feed: %v", err)
+	}
+	want := "https://www.tiktok.com/@alice\nhttps://www.tiktok.com/@bob.b\nhttps://www.tiktok.com/@carol\n"
+	if count != 3 || string(data) != want {
+		t.Errorf("expected 3 creators:\n%s\ngot %d:\n%s", want, count, data)
+	}
+
+	opmlPath := filepath.Join(tmpDir, "creators.opml")
+	if _, err := writeCreatorFeed(opmlPath, entries); err != nil {
+		t.Fatalf("writeCreatorFeed failed: %v", err)
+	}
+	data, err = os.ReadFile(opmlPath)
+	if err != nil {
+		t.Fatalf("failed to read feed: %v", err)
+	}
+	var doc OPML
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("feed is not valid OPML: %v\n%s", err, data)
+	}
+	wantOutlines := []OPMLOutline{
+		{Text: "@alice", Type: "link", URL: "https://www.tiktok.com/@alice"},
+		{Text: "@bob.b", Type: "link", URL: "https://www.tiktok.com/@bob.b"},
+		{Text: "@carol", Type: "link", URL: "https://www.tiktok.com/@carol"},
+	}
+	if doc.Version != "2.0" || !reflect.DeepEqual(doc.Outlines, wantOutlines) {
+		t.Errorf("unexpected OPML outlines: %+v", doc)
+	}
+}
+
+// TestParseObjectKeyedExport tests exports whose video lists are objects keyed by index
+func TestParseObjectKeyedExport(t *testing.T) {
+	tmpDir := t.TempDir()
+	fixture := `{
+		"Likes and Favorites": {
+			"Favorite Videos": {
+				"FavoriteVideoList": {
+					"1": {"Date": "2024-01-02 00:00:00", "Link": "https://www.tiktok.com/@a/video/2"},
+					"0": {"Date": "2024-01-01 00:00:00", "Link": "https://www.tiktok.com/@a/video/1"},
+					"10": {"Date": "2024-01-11 00:00:00", "Link": "https://www.tiktok.com/@a/video/11"},
+					"2": {"Date": "2024-01-03 00:00:00", "Link": "https://www.tiktok.com/@a/video/3"}
+				}
+			},
+			"Like List": {
+				"ItemFavoriteList": [
+					{"date": "2024-02-01 00:00:00", "link": "https://www.tiktok.com/@b/video/20"}
+				]
+			}
+		}
+	}`
+	jsonFile := filepath.Join(tmpDir, "user_data_tiktok.json")
+	if err := os.WriteFile(jsonFile, []byte(fixture), 0644); err != nil {
+		t.Fatalf("failed to write fixture: %v", err)
+	}
+
+	entries, err := parseFavoriteVideosFromFile(jsonFile, true, nil)
+	if err != nil {
+		t.Fatalf("failed to parse object-keyed export: %v", err)
+	}
+
+	expected := []string{
+		"https://www.tiktok.com/@a/video/1",
+		"https://www.tiktok.com/@a/video/2",
+		"https://www.tiktok.com/@a/video/3",
+		"https://www.tiktok.com/@a/video/11",
+		"https://www.tiktok.com/@b/video/20",
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(entries))
+	}
+	for i, want := range expected {
+		if entries[i].Link != want {
+			t.Errorf("entry %d: expected %s, got %s", i, want, entries[i].Link)
+		}
+	}
+	if entries[0].Date != "2024-01-01 00:00:00" || entries[0].Collection != "favorites" {
+		t.Errorf("unexpected first entry: %+v", entries[0])
+	}
+
+	// A list that is neither an array nor an object is still an error
+	bad := filepath.Join(tmpDir, "bad.json")
+	badJSON := `{"Likes and Favorites": {"Favorite Videos": {"FavoriteVideoList": "oops"}}}`
+	if err := os.WriteFile(bad, []byte(badJSON), 0644); err != nil {
+		t.Fatalf("failed to write fixture: %v", err)
+	}
+	if _, err := parseFavoriteVideosFromFile(bad, false, nil); !errors.Is(err, ErrJSONParse) {
+		t.Errorf("expected ErrJSONParse for a string list, got %v", err)
+	}
+}
+
+// slowRunner simulates a yt-dlp invocation that takes delay per call
+type slowRunner struct {
+	delay time.Duration
+	calls []string
+}
+
+func (r *slowRunner) Run(name string, args ...string) (CapturedOutput, error) {
+	r.calls = append(r.calls, args[len(args)-1])
+	time.Sleep(r.delay)
+	return CapturedOutput{}, nil
+}
+
+// TestMaxRuntime tests that no downloads start after the run-time budget and that the
+// remaining videos are saved as a resumable checkpoint
+func TestMaxRuntime(t *testing.T) {
+	tmpDir := t.TempDir()
+	outputName := filepath.Join(tmpDir, "favorites", "fav_videos.txt")
+	if err := os.MkdirAll(filepath.Dir(outputName), 0755); err != nil {
+		t.Fatalf("failed to create collection dir: %v", err)
+	}
+
+	var entries []VideoEntry
+	for i := 1; i <= 10; i++ {
+		entries = append(entries, VideoEntry{Link: fmt.Sprintf("https://www.tiktok.com/@user/video/%d", i)})
+	}
+
+	runner := &slowRunner{delay: 40 * time.Millisecond}
+	config := &Config{OrganizeByCollection: true, DisableResume: true, Deadline: time.Now().Add(100 * time.Millisecond)}
+	result, err := runYtdlpWithRunner(runner, "", outputName, config, entries)
+	if err != nil {
+		t.Fatalf("runYtdlpWithRunner failed: %v", err)
+	}
+
+	calls := len(runner.calls)
+	if calls == 0 || calls >= len(entries) {
+		t.Fatalf("expected the budget to stop the run part-way, got %d runner calls", calls)
+	}
+	if result.Attempted != calls {
+		t.Errorf("expected %d attempted videos, got %d", calls, result.Attempted)
+	}
+
+	// The checkpoint points at the first video that was never started
+	progress, err := loadBatchProgress(batchProgressPath(outputName))
+	if err != nil || progress == nil {
+		t.Fatalf("expected progress to be saved, got %v, %v", progress, err)
+	}
+	if progress.URL != entries[calls].Link || progress.Index != calls {
+		t.Errorf("expected checkpoint at video %d (%s), got %+v", calls, entries[calls].Link, progress)
+	}
+
+	// Past the deadline, a later collection doesn't call the runner at all
+	before := len(runner.calls)
+	if _, err := runYtdlpWithRunner(runner, "", filepath.Join(tmpDir, "liked", "liked_videos.txt"), config, entries); err != nil {
+		t.Fatalf("runYtdlpWithRunner failed: %v", err)
+	}
+	if len(runner.calls) != before {
+		t.Errorf("expected no runner calls after the deadline, got %d", len(runner.calls)-before)
+	}
+}
+
+// TestInspectExport tests "All available data" vs "Custom" export detection and source selection
+func TestInspectExport(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	tests := []struct {
+		name         string
+		content      string
+		custom       bool
+		hasFavorites bool
+		hasLiked     bool
+		noticeHas    string
+	}{
+		{
+			name: "all available data",
+			content: `{"Profile": {}, "Video": {}, "Comment": {}, "Direct Message": {},
+				"Likes and Favorites": {"Favorite Videos": {"FavoriteVideoList": []}, "Like List": {"ItemFavoriteList": []}}}`,
+			hasFavorites: true,
+			hasLiked:     true,
+		},
+		{
+			name:      "custom export missing favorites",
+			content:   `{"Likes and Favorites": {"Like List": {"ItemFavoriteList": [{"date": "2024-01-01", "link": "https://www.tiktok.com/@u/video/1"}]}}}`,
+			custom:    true,
+			hasLiked:  true,
+			noticeHas: "Custom export has no Favorite Videos section",
+		},
+		{
+			name:         "custom export missing liked",
+			content:      `{"Likes and Favorites": {"Favorite Videos": {"FavoriteVideoList": []}}}`,
+			custom:       true,
+			hasFavorites: true,
+			noticeHas:    "no Like List section",
+		},
+		{
+			name:      "custom export without likes and favorites",
+			content:   `{"Profile": {}, "Comment": {}}`,
+			custom:    true,
+			noticeHas: "neither a Favorite Videos nor a Like List section",
+		},
+		{
+			name: "older export with only the Activity root",
+			content: `{"Activity": {"Favorite Videos": {"FavoriteVideoList": [{"Date": "2021-01-01", "Link": "https://www.tiktokv.com/share/video/1/"}]},
+				"Like List": {"ItemFavoriteList": [{"Date": "2021-01-02", "Link": "https://www.tiktokv.com/share/video/2/"}]}}}`,
+			custom:       true,
+			hasFavorites: true,
+			hasLiked:     true,
+		},
+		{
+			name: "older all available data export",
+			content: `{"Profile": {}, "Video": {}, "Comment": {}, "Direct Message": {},
+				"Activity": {"Favorite Videos": {"FavoriteVideoList": []}, "Like List": {"ItemFavoriteList": []}}}`,
+			hasFavorites: true,
+			hasLiked:     true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tmpDir, fmt.Sprintf("export_%d.json", i))
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			sections, err := inspectExport(path, nil)
+			if err != nil {
+				t.Fatalf("inspectExport failed: %v", err)
+			}
+			if sections.Custom != tt.custom || sections.HasFavorites != tt.hasFavorites || sections.HasLiked != tt.hasLiked {
+				t.Errorf("unexpected sections %+v", sections)
+			}
+
+			notice := sections.notice()
+			if tt.noticeHas == "" && notice != "" {
+				t.Errorf("expected no notice, got %q", notice)
+			}
+			if !strings.Contains(notice, tt.noticeHas) {
+				t.Errorf("expected notice to contain %q, got %q", tt.noticeHas, notice)
+			}
+		})
+	}
+
+	// The run goes ahead with an export in the older layout
+	legacy := filepath.Join(tmpDir, fmt.Sprintf("export_%d.json", len(tests)-2))
+	if sections, err := detectExportSections(legacy, nil, false); err != nil || !sections.HasFavorites || !sections.HasLiked {
+		t.Errorf("expected the Activity lists to be accepted, got %+v (err %v)", sections, err)
+	}
+
+	// With only one source, it is selected without asking
+	if !promptForLiked("", nil, ExportSections{Custom: true, HasLiked: true}, strings.NewReader("n\n"), io.Discard) {
+		t.Error("expected liked videos to be selected when favorites are missing")
+	}
+	if promptForLiked("", nil, ExportSections{Custom: true, HasFavorites: true}, strings.NewReader("y\n"), io.Discard) {
+		t.Error("expected liked videos to be skipped when the export has none")
+	}
+	if !promptForLiked("", nil, ExportSections{HasFavorites: true, HasLiked: true}, strings.NewReader("y\n"), io.Discard) {
+		t.Error("expected the answer to be used when both sources exist")
+	}
+
+	if _, err := inspectExport(filepath.Join(tmpDir, "missing.json"), nil); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+// TestSizeBudget tests the --size-budget size parsing and download accumulator
+func TestSizeBudget(t *testing.T) {
+	for size, want := range map[string]int64{"500": 500, "1k": 1024, "100M": 100 << 20, "1.5G": 3 << 29, "10g": 10 << 30} {
+		got, err := parseByteSize(size)
+		if err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v; expected %d", size, got, err, want)
+		}
+	}
+	if _, err := parseByteSize("10 GB"); err == nil {
+		t.Error("expected an error for an invalid size")
+	}
+
+	budget := newSizeBudget(20 << 20)
+	budget.Record([]string{
+		"[download] Destination: 20260101_1_One.mp4",
+		"[download]  50.0% of   12.00MiB at    3.00MiB/s ETA 00:02",
+		"[download] 100.0% of   12.00MiB at    3.00MiB/s ETA 00:00",
+		"[download] 100% of   12.00MiB in 00:00:04 at 3.00MiB/s",
+		"[download] 20260101_2_Two.mp4 has already been downloaded",
+	})
+	if budget.Used() != 12<<20 || budget.Exhausted() {
+		t.Fatalf("expected 12MiB used and budget left, got %d", budget.Used())
+	}
+	budget.Record([]string{"[download] 100% of ~  8.00MiB in 00:00:02 at 4.00MiB/s"})
+	if budget.Used() != 20<<20 || !budget.Exhausted() {
+		t.Errorf("expected 20MiB used and budget exhausted, got %d", budget.Used())
+	}
+
+	var unlimited *sizeBudget
+	unlimited.Record([]string{"[download] 100% of 1.00GiB in 00:01:00 at 17.00MiB/s"})
+	if unlimited.Exhausted() {
+		t.Error("expected a nil budget never to be exhausted")
+	}
+}
+
+// sizedRunner reports downloading a file of the given size for each video URL
+type sizedRunner struct {
+	sizes map[string]string // URL -> size as yt-dlp prints it; missing = skipped
+}
+
+func (r *sizedRunner) Run(name string, args ...string) (CapturedOutput, error) {
+	var out CapturedOutput
+	if size, ok := r.sizes[args[len(args)-1]]; ok {
+		out.Combined = []string{"[download] 100% of " + size + " in 00:00:01 at 1.00MiB/s"}
+	} else {
+		out.Combined = []string{"[download] clip.mp4 has already been downloaded"}
+	}
+	return out, nil
+}
+
+// TestTimings tests that --timings records each video's time and size in timings.csv
+func TestTimings(t *testing.T) {
+	tmpDir := t.TempDir()
+	outputName := filepath.Join(tmpDir, "favorites", "fav_videos.txt")
+	if err := os.MkdirAll(filepath.Dir(outputName), 0755); err != nil {
+		t.Fatalf("failed to create collection dir: %v", err)
+	}
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@a/video/1"},
+		{Link: "https://www.tiktok.com/@a/video/2"},
+		{Link: "https://www.tiktok.com/@b/video/3"},
+	}
+	runner := &sizedRunner{sizes: map[string]string{entries[0].Link: "2.00MiB", entries[2].Link: "512.00KiB"}}
+	config := &Config{OrganizeByCollection: true, DisableResume: true, WriteTimings: true}
+
+	// Two runs append to the same file under one header
+	for run := 0; run < 2; run++ {
+		if _, err := runYtdlpWithRunner(runner, "", outputName, config, entries); err != nil {
+			t.Fatalf("runYtdlpWithRunner failed: %v", err)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(tmpDir, "favorites", "timings.csv"))
+	if err != nil {
+		t.Fatalf("expected timings.csv: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("timings.csv is not valid CSV: %v", err)
+	}
+	if len(rows) != 7 || !reflect.DeepEqual(rows[0], []string{"id", "seconds", "bytes"}) {
+		t.Fatalf("expected a header and 6 rows, got %q", rows)
+	}
+	want := [][]string{{"1", "2097152"}, {"2", "0"}, {"3", "524288"}}
+	for i, row := range rows[1:4] {
+		if row[0] != want[i][0] || row[2] != want[i][1] {
+			t.Errorf("row %d: expected id %s with %s bytes, got %q", i, want[i][0], want[i][1], row)
+		}
+		if seconds, err := strconv.ParseFloat(row[1], 64); err != nil || seconds < 0 {
+			t.Errorf("row %d: invalid seconds %q", i, row[1])
+		}
+	}
+}
+
+// TestURLMapping tests the mapping.json sidecar and the "view original" link in index.html
+func TestURLMapping(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	write("20260101_111_One.info.json", `{"id": "111", "title": "One", "filename": "20260101_111_One.mp4"}`)
+	write("20260101_111_One.mp4", "video")
+
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@a/video/111", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/222", Collection: "favorites"},
+	}
+
+	// Off by default
+	if err := indexCollection(&Config{}, tmpDir, entries, nil); err != nil {
+		t.Fatalf("indexCollection failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "mapping.json")); !os.IsNotExist(err) {
+		t.Error("expected no mapping.json without --url-mapping")
+	}
+
+	if err := indexCollection(&Config{URLMapping: true}, tmpDir, entries, nil); err != nil {
+		t.Fatalf("indexCollection failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(tmpDir, "mapping.json"))
+	if err != nil {
+		t.Fatalf("expected mapping.json: %v", err)
+	}
+	var mapping map[string]string
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		t.Fatalf("invalid mapping.json: %v", err)
+	}
+	if len(mapping) != 1 || mapping["20260101_111_One.mp4"] != entries[0].Link {
+		t.Errorf("unexpected mapping: %v", mapping)
+	}
+
+	html, err := os.ReadFile(filepath.Join(tmpDir, "index.html"))
+	if err != nil {
+		t.Fatalf("failed to read index.html: %v", err)
+	}
+	if !strings.Contains(string(html), `class="original-link" href="https://www.tiktok.com/@a/video/111"`) {
+		t.Error("expected a view-original link for the downloaded video")
+	}
+}
+
+// TestHeaderList tests validation and ordering of repeated --add-header values
+func TestHeaderList(t *testing.T) {
+	var headers headerList
+	for _, h := range []string{"Accept-Language: de-DE", "X-Forwarded-For:1.2.3.4"} {
+		if err := headers.Set(h); err != nil {
+			t.Errorf("expected %q to be valid, got %v", h, err)
+		}
+	}
+	if len(headers) != 2 || headers[0] != "Accept-Language: de-DE" || headers[1] != "X-Forwarded-For:1.2.3.4" {
+		t.Errorf("unexpected headers: %v", headers)
+	}
+
+	for _, h := range []string{"NoColon", ": value", "Key:", "Key:   ", "Bad Key: value", "Bad/Key: value"} {
+		if err := headers.Set(h); err == nil {
+			t.Errorf("expected %q to be rejected", h)
+		}
+	}
+	if len(headers) != 2 {
+		t.Errorf("invalid headers should not be added, got %v", headers)
+	}
+}
+
+// TestBuildResolutionSort tests the -S value for min/max resolution combinations
+func TestBuildResolutionSort(t *testing.T) {
+	tests := []struct {
+		name    string
+		minRes  int
+		maxRes  int
+		want    string
+		wantErr bool
+	}{
+		{name: "no bounds", want: ""},
+		{name: "max only", maxRes: 720, want: "res:720,+size"},
+		{name: "min only", minRes: 480, want: "+res:480,+size"},
+		{name: "min and max", minRes: 480, maxRes: 1080, want: "res:1080,+size"},
+		{name: "equal bounds", minRes: 720, maxRes: 720, want: "res:720,+size"},
+		{name: "min above max", minRes: 1080, maxRes: 720, wantErr: true},
+		{name: "negative", minRes: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateResolutionRange(tt.minRes, tt.maxRes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error=%v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := buildResolutionSort(tt.minRes, tt.maxRes); got != tt.want {
+				t.Errorf("buildResolutionSort(%d, %d) = %q, want %q", tt.minRes, tt.maxRes, got, tt.want)
+			}
+		})
+	}
+
+	// The sort is forwarded to yt-dlp
+	mockRunner := &MockCommandRunner{}
+	config := &Config{SkipThumbnails: true, DisableResume: true, MaxResolution: 720}
+	_, _ = runYtdlpWithRunner(mockRunner, "", "test_videos.txt", config, []VideoEntry{{Link: "https://www.tiktok.com/@a/video/1"}})
+	args := strings.Join(mockRunner.Commands[0].Args, " ")
+	if !strings.Contains(args, "-S res:720,+size") {
+		t.Errorf("expected -S res:720,+size in yt-dlp args, got %s", args)
+	}
+}
+
+// TestWriteUnavailableFile tests that only videos classified as unavailable are listed
+func TestWriteUnavailableFile(t *testing.T) {
+	failure := func(id, msg string) FailureDetail {
+		return FailureDetail{
+			VideoID:      id,
+			VideoURL:     "https://www.tiktok.com/@a/video/" + id,
+			ErrorMessage: msg,
+			ErrorType:    categorizeError(msg),
+		}
+	}
+	session := &DownloadSession{
+		EndTime: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Collections: []CollectionResult{
+			{Name: "favorites", FailureDetails: []FailureDetail{
+				failure("111", "Video not available, status code 10204"),
+				failure("222", "Your IP address is blocked from accessing this post"),
+			}},
+			{Name: "liked", FailureDetails: []FailureDetail{
+				failure("333", "This is a private video"),
+				failure("444", "Read timed out"),
+			}},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "unavailable.json")
+	count, err := writeUnavailableFile(path, session)
+	if err != nil {
+		t.Fatalf("writeUnavailableFile failed: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 unavailable videos, got %d", count)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read unavailable.json: %v", err)
+	}
+	var report UnavailableReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("invalid unavailable.json: %v", err)
+	}
+	if report.SchemaVersion != SchemaVersion {
+		t.Errorf("expected schema_version %d, got %d", SchemaVersion, report.SchemaVersion)
+	}
+	expected := []UnavailableVideo{
+		{URL: "https://www.tiktok.com/@a/video/111", VideoID: "111", Collection: "favorites", Reason: "Video not available, status code 10204"},
+		{URL: "https://www.tiktok.com/@a/video/333", VideoID: "333", Collection: "liked", Reason: "This is a private video"},
+	}
+	if len(report.Videos) != len(expected) {
+		t.Fatalf("expected %d videos, got %+v", len(expected), report.Videos)
+	}
+	for i, want := range expected {
+		if report.Videos[i] != want {
+			t.Errorf("video %d: expected %+v, got %+v", i, want, report.Videos[i])
+		}
+	}
+	if !report.Generated.Equal(session.EndTime) {
+		t.Errorf("expected generated time %v, got %v", session.EndTime, report.Generated)
+	}
+
+	// A clean run rewrites the file with an empty list
+	if count, err := writeUnavailableFile(path, &DownloadSession{}); err != nil || count != 0 {
+		t.Fatalf("expected empty report, got %d, %v", count, err)
+	}
+	data, _ = os.ReadFile(path)
+	if !strings.Contains(string(data), `"videos": []`) {
+		t.Errorf("expected an empty videos list, got %s", data)
+	}
+}
+
+// TestUserAgentRotator tests that consecutive User-Agents differ and are applied to
+// HTTP requests and yt-dlp runs
+func TestUserAgentRotator(t *testing.T) {
+	rotator := newUserAgentRotator(userAgents)
+	seen := make(map[string]bool)
+	prev := rotator.Next()
+	seen[prev] = true
+	for i := 0; i < 2*len(userAgents); i++ {
+		ua := rotator.Next()
+		if ua == prev {
+			t.Fatalf("consecutive calls returned the same User-Agent %q", ua)
+		}
+		seen[ua] = true
+		prev = ua
+	}
+	if len(seen) != len(userAgents) {
+		t.Errorf("expected all %d User-Agents to be used, got %d", len(userAgents), len(seen))
+	}
+
+	var nilRotator *userAgentRotator
+	if ua := nilRotator.Next(); ua != "" {
+		t.Errorf("expected nil rotator to return empty User-Agent, got %q", ua)
+	}
+
+	// HTTP requests carry the rotated User-Agent
+	var received []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("User-Agent"))
+	}))
+	defer ts.Close()
+	client, err := newHTTPClient(&Config{UserAgents: newUserAgentRotator(userAgents)})
+	if err != nil {
+		t.Fatalf("newHTTPClient failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		_ = resp.Body.Close()
+	}
+	if len(received) != 2 || received[0] == received[1] || !strings.HasPrefix(received[0], "Mozilla/5.0") {
+		t.Errorf("expected two different browser User-Agents, got %q", received)
+	}
+
+	// Each per-video yt-dlp run gets its own User-Agent
+	runner := &MockCommandRunner{}
+	config := &Config{SkipThumbnails: true, DisableResume: true, PerVideoTimeout: time.Minute, UserAgents: newUserAgentRotator(userAgents)}
+	entries := []VideoEntry{{Link: "https://www.tiktok.com/@a/video/1"}, {Link: "https://www.tiktok.com/@a/video/2"}}
+	_, _ = runYtdlpWithRunner(runner, "", "test_videos.txt", config, entries)
+	if len(runner.Commands) != 2 {
+		t.Fatalf("expected 2 yt-dlp runs, got %d", len(runner.Commands))
+	}
+	agentOf := func(args []string) string {
+		for i, arg := range args {
+			if arg == "--user-agent" && i+1 < len(args) {
+				return args[i+1]
+			}
+		}
+		return ""
+	}
+	first, second := agentOf(runner.Commands[0].Args), agentOf(runner.Commands[1].Args)
+	if first == "" || second == "" || first == second {
+		t.Errorf("expected different --user-agent per run, got %q and %q", first, second)
+	}
+}
+
+// TestApacheResults tests the --results-format apache combined-log lines
+func TestApacheResults(t *testing.T) {
+	tmpDir := t.TempDir()
+	videoPath := filepath.Join(tmpDir, "20260101_111_One.mp4")
+	if err := os.WriteFile(videoPath, []byte("12345"), 0644); err != nil {
+		t.Fatalf("failed to write video: %v", err)
+	}
+
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@a/video/111"},
+		{Link: "https://www.tiktok.com/@a/video/222"},
+		{Link: "https://www.tiktok.com/@a/video/333"},
+	}
+	session := &DownloadSession{
+		EndTime: time.Date(2026, 10, 15, 9, 30, 5, 0, time.UTC),
+		Collections: []CollectionResult{{
+			Name:           "favorites",
+			SavedFiles:     map[string]string{"111": videoPath},
+			FailureDetails: []FailureDetail{{VideoID: "222", VideoURL: entries[1].Link, ErrorType: ErrorNotAvailable}},
+		}},
+	}
+
+	config := &Config{WorkDir: tmpDir, ResultsFormat: ResultsFormatApache}
+	for run := 0; run < 2; run++ {
+		if err := writeResults(config, session, entries); err != nil {
+			t.Fatalf("writeResults failed: %v", err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(tmpDir, "results.log"))
+	if err != nil {
+		t.Fatalf("expected results.log: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("expected 3 lines per run appended, got %d:\n%s", len(lines), data)
+	}
+	linePattern := regexp.MustCompile(`^- - - \[(\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\] "GET (\S+) HTTP/1\.1" (\d{3}) (\d+|-) "-" "yt-dlp"$`)
+	want := [][]string{
+		{"15/Oct/2026:09:30:05 +0000", entries[0].Link, "200", "5"},
+		{"15/Oct/2026:09:30:05 +0000", entries[1].Link, "404", "-"},
+		{"15/Oct/2026:09:30:05 +0000", entries[2].Link, "304", "-"},
+	}
+	for i, line := range lines[:3] {
+		m := linePattern.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("line %d is not in combined log format: %s", i, line)
+			continue
+		}
+		if !reflect.DeepEqual(m[1:], want[i]) {
+			t.Errorf("line %d: expected fields %v, got %v", i, want[i], m[1:])
+		}
+	}
+
+	// The default format still writes results.txt
+	if err := writeResults(&Config{WorkDir: tmpDir}, session, entries); err != nil {
+		t.Fatalf("writeResults failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "results.txt")); err != nil {
+		t.Errorf("expected results.txt for the text format: %v", err)
+	}
+}
+
+// TestWriteResultsDB tests that --db upserts one row per video and keeps downloaded_at across runs
+func TestWriteResultsDB(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "results.db")
+	if err := os.WriteFile(filepath.Join(tmpDir, "20260101_111_One.info.json"), []byte(`{"id": "111", "title": "One", "uploader": "alice", "filename": "20260101_111_One.mp4"}`), 0644); err != nil {
+		t.Fatalf("failed to write info file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "20260101_111_One.mp4"), []byte("video"), 0644); err != nil {
+		t.Fatalf("failed to write video: %v", err)
+	}
+
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@alice/video/111", Date: "2026-01-01 10:00:00", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@bob/video/222", Date: "2026-01-02 10:00:00", Collection: "favorites"},
+	}
+	failures := []FailureDetail{{VideoID: "222", VideoURL: entries[1].Link, ErrorMessage: "Video unavailable"}}
+	config := &Config{DBPath: dbPath}
+
+	for run := 0; run < 2; run++ {
+		if err := indexCollection(config, tmpDir, entries, failures); err != nil {
+			t.Fatalf("run %d: indexCollection failed: %v", run, err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	rows, err := db.Query(`SELECT id, url, uploader, date, status, local_path, downloaded_at FROM results ORDER BY id`)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	type row struct {
+		id, url, uploader, date, status string
+		localPath, downloadedAt         sql.NullString
+	}
+	var got []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.url, &r.uploader, &r.date, &r.status, &r.localPath, &r.downloadedAt); err != nil {
+			t.Fatalf("scan failed: %v", err)
+		}
+		got = append(got, r)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("rows failed: %v", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 rows after two runs, got %d", len(got))
+	}
+	if got[0].id != "111" || got[0].status != "downloaded" || got[0].uploader != "alice" || got[0].date != entries[0].Date {
+		t.Errorf("unexpected downloaded row: %+v", got[0])
+	}
+	if got[0].localPath.String != filepath.Join(tmpDir, "20260101_111_One.mp4") || !got[0].downloadedAt.Valid {
+		t.Errorf("expected local path and downloaded_at for downloaded row, got %+v", got[0])
+	}
+	if got[1].id != "222" || got[1].status != "failed" || got[1].localPath.Valid || got[1].downloadedAt.Valid {
+		t.Errorf("unexpected failed row: %+v", got[1])
+	}
+}
+
+// TestSendWebhook tests the JSON summary POSTed to --webhook-url
+func TestSendWebhook(t *testing.T) {
+	var got WebhookPayload
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("expected POST, got %s", r.Method)
+		}
+		contentType = r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	session := &DownloadSession{
+		StartTime: start,
+		EndTime:   start.Add(90 * time.Second),
+		Collections: []CollectionResult{
+			{Name: "favorites", Attempted: 3, Success: 2, Failed: 1},
+			{Name: "liked", Attempted: 2, Success: 1, Skipped: 1},
+		},
+		TotalAttempted: 5,
+		TotalSuccess:   3,
+		TotalFailed:    1,
+		TotalSkipped:   1,
+	}
+
+	if err := sendWebhook(server.Client(), server.URL, "", newWebhookPayload(session)); err != nil {
+		t.Fatalf("sendWebhook failed: %v", err)
+	}
+	if contentType != "application/json" {
+		t.Errorf("expected application/json, got %q", contentType)
+	}
+	if got.Status != "failure" || got.DurationSeconds != 90 {
+		t.Errorf("unexpected status/duration: %q %v", got.Status, got.DurationSeconds)
+	}
+	if got.Attempted != 5 || got.Success != 3 || got.Failed != 1 || got.Skipped != 1 {
+		t.Errorf("unexpected totals: %+v", got)
+	}
+	if len(got.Collections) != 2 || got.Collections[1].Name != "liked" || got.Collections[1].Skipped != 1 {
+		t.Errorf("unexpected collections: %+v", got.Collections)
+	}
+
+	// A clean run reports success
+	session.Collections[0].Failed = 0
+	session.TotalFailed = 0
+	if err := sendWebhook(server.Client(), server.URL, "", newWebhookPayload(session)); err != nil {
+		t.Fatalf("sendWebhook failed: %v", err)
+	}
+	if got.Status != "success" {
+		t.Errorf("expected success status, got %q", got.Status)
+	}
+
+	// Non-2xx responses are errors
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	if err := sendWebhook(failing.Client(), failing.URL, "", newWebhookPayload(session)); err == nil {
+		t.Error("expected error for HTTP 500 response")
+	}
+}
+
+// TestNotifyWebhookOnExit tests the summary sent when a run stops on an error or never
+// starts yt-dlp
+func TestNotifyWebhookOnExit(t *testing.T) {
+	var got WebhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = WebhookPayload{}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	config := &Config{WebhookURL: server.URL}
+
+	// An early error is reported as a failure carrying the error
+	session := &DownloadSession{StartTime: time.Now()}
+	notifyWebhook(config, session, false, errors.New("JSON file 'missing.json' does not exist"))
+	if got.Status != "failure" || got.Error != "JSON file 'missing.json' does not exist" {
+		t.Errorf("unexpected failure payload: %+v", got)
+	}
+	if got.EndTime.IsZero() || got.DurationSeconds < 0 {
+		t.Errorf("expected the end time to be filled in, got %+v", got)
+	}
+	if line := webhookSummaryLine(got); !strings.Contains(line, "failed: JSON file") {
+		t.Errorf("expected the error in the summary line, got %q", line)
+	}
+
+	// Declining to run yt-dlp is reported as skipped
+	notifyWebhook(config, &DownloadSession{StartTime: time.Now()}, false, nil)
+	if got.Status != "skipped" || got.Error != "" {
+		t.Errorf("unexpected skipped payload: %+v", got)
+	}
+
+	// Modes that don't download never notify
+	if isDownloadRun(&Config{CountOnly: true}) || !isDownloadRun(&Config{}) {
+		t.Error("expected only download runs to notify")
+	}
+}
+
+// TestNotifyFormats tests the Discord and Slack shapes of the webhook payload
+func TestNotifyFormats(t *testing.T) {
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	session := &DownloadSession{
+		StartTime:      start,
+		EndTime:        start.Add(2 * time.Minute),
+		Collections:    []CollectionResult{{Name: "favorites", Attempted: 4, Success: 3, Failed: 1}},
+		TotalAttempted: 4,
+		TotalSuccess:   3,
+		TotalFailed:    1,
+	}
+
+	decode := func(format string) map[string]any {
+		t.Helper()
+		data, err := buildWebhookBody(format, newWebhookPayload(session))
+		if err != nil {
+			t.Fatalf("buildWebhookBody(%q) failed: %v", format, err)
+		}
+		var body map[string]any
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Fatalf("invalid %s payload: %v", format, err)
+		}
+		return body
+	}
+
+	t.Run("discord", func(t *testing.T) {
+		body := decode(NotifyFormatDiscord)
+		if content, _ := body["content"].(string); !strings.Contains(content, "1 failures") {
+			t.Errorf("unexpected content: %v", body["content"])
+		}
+		embeds, ok := body["embeds"].([]any)
+		if !ok || len(embeds) != 1 {
+			t.Fatalf("expected one embed, got %v", body["embeds"])
+		}
+		embed := embeds[0].(map[string]any)
+		if embed["color"].(float64) != discordColorFailure {
+			t.Errorf("expected failure color, got %v", embed["color"])
+		}
+		fields := embed["fields"].([]any)
+		if len(fields) != 5 {
+			t.Fatalf("expected 5 fields, got %d", len(fields))
+		}
+		if f := fields[1].(map[string]any); f["name"] != "Downloaded" || f["value"] != "3" || f["inline"] != true {
+			t.Errorf("unexpected field: %v", f)
+		}
+		if f := fields[4].(map[string]any); f["value"] != "2m0s" {
+			t.Errorf("unexpected duration field: %v", f)
+		}
+		if !strings.Contains(embed["description"].(string), "**favorites**: 3/4 downloaded") {
+			t.Errorf("unexpected description: %v", embed["description"])
+		}
+		if _, ok := body["text"]; ok {
+			t.Error("discord payload should not have a text key")
+		}
+	})
+
+	t.Run("slack", func(t *testing.T) {
+		body := decode(NotifyFormatSlack)
+		if text, _ := body["text"].(string); !strings.Contains(text, "1 failures") {
+			t.Errorf("unexpected text: %v", body["text"])
+		}
+		blocks, ok := body["blocks"].([]any)
+		if !ok || len(blocks) != 3 {
+			t.Fatalf("expected 3 blocks, got %v", body["blocks"])
+		}
+		header := blocks[0].(map[string]any)
+		if header["type"] != "header" || header["text"].(map[string]any)["type"] != "plain_text" {
+			t.Errorf("unexpected header block: %v", header)
+		}
+		fields := blocks[1].(map[string]any)["fields"].([]any)
+		if len(fields) != 5 {
+			t.Fatalf("expected 5 section fields, got %d", len(fields))
+		}
+		if f := fields[2].(map[string]any); f["type"] != "mrkdwn" || f["text"] != "*Failed*\n1" {
+			t.Errorf("unexpected field: %v", f)
+		}
+		if _, ok := body["embeds"]; ok {
+			t.Error("slack payload should not have embeds")
+		}
+	})
+
+	if _, err := buildWebhookBody("teams", newWebhookPayload(session)); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+// TestWriteChunkedLists tests splitting a list larger than --chunk-size into numbered batch files
+func TestWriteChunkedLists(t *testing.T) {
+	tmpDir := t.TempDir()
+	outputName := filepath.Join(tmpDir, "fav_videos.txt")
+
+	var entries []VideoEntry
+	for i := 1; i <= 7; i++ {
+		entries = append(entries, VideoEntry{Link: fmt.Sprintf("https://www.tiktok.com/@u/video/%d", i)})
+	}
+
+	chunks, err := writeChunkedLists(entries, outputName, 3)
+	if err != nil {
+		t.Fatalf("writeChunkedLists failed: %v", err)
+	}
+	wantFiles := []string{"fav_videos_001.txt", "fav_videos_002.txt", "fav_videos_003.txt"}
+	wantSizes := []int{3, 3, 1}
+	if len(chunks) != len(wantFiles) {
+		t.Fatalf("expected %d chunks, got %d", len(wantFiles), len(chunks))
+	}
+	next := 1
+	for i, chunk := range chunks {
+		if chunk.File != filepath.Join(tmpDir, wantFiles[i]) || len(chunk.Entries) != wantSizes[i] {
+			t.Errorf("chunk %d: got %s with %d entries", i, chunk.File, len(chunk.Entries))
+		}
+		data, err := os.ReadFile(chunk.File)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", chunk.File, err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != wantSizes[i] {
+			t.Errorf("%s: expected %d URLs, got %d", wantFiles[i], wantSizes[i], len(lines))
+		}
+		for _, line := range lines {
+			if want := fmt.Sprintf("https://www.tiktok.com/@u/video/%d", next); line != want {
+				t.Errorf("%s: expected %s, got %s", wantFiles[i], want, line)
+			}
+			next++
+		}
+	}
+
+	// A list that fits in one chunk is used as-is
+	chunks, err = writeChunkedLists(entries, outputName, 10)
+	if err != nil || len(chunks) != 1 || chunks[0].File != outputName {
+		t.Errorf("expected the original list as a single chunk, got %+v (%v)", chunks, err)
+	}
+}
+
+// TestMergeCollectionResults tests combining per-chunk results
+func TestMergeCollectionResults(t *testing.T) {
+	first := &CollectionResult{Name: "favorites", Attempted: 3, Success: 2, Failed: 1, FailureDetails: []FailureDetail{{VideoID: "1"}}}
+	second := &CollectionResult{Name: "favorites", Attempted: 2, Success: 2, Skipped: 1}
+
+	total := mergeCollectionResults(nil, first)
+	total = mergeCollectionResults(total, nil)
+	total = mergeCollectionResults(total, second)
+
+	if total.Name != "favorites" || total.Attempted != 5 || total.Success != 4 || total.Failed != 1 || total.Skipped != 1 {
+		t.Errorf("unexpected merged result: %+v", total)
+	}
+	if len(total.FailureDetails) != 1 || first.Attempted != 3 {
+		t.Errorf("expected failures carried over without modifying the first result, got %+v / %+v", total, first)
+	}
+}
+
+// TestPhaseTimingSummary tests that phase timings are accumulated and written to summary.json
+func TestPhaseTimingSummary(t *testing.T) {
+	timer := &phaseTimer{}
+	timer.add(PhaseYtdlpDownload, 2*time.Second)
+	timer.add(PhaseJSONParse, 500*time.Millisecond)
+	timer.add(PhaseListWrite, 100*time.Millisecond)
+	timer.add(PhaseYtdlpRun, 30*time.Second)
+	timer.add(PhaseYtdlpRun, 15*time.Second) // second collection
+	timer.Record(PhaseJSONParse, time.Now())
+
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	session := &DownloadSession{
+		StartTime:      start,
+		EndTime:        start.Add(time.Minute),
+		TotalAttempted: 2,
+		TotalSuccess:   2,
+		Phases:         timer.Phases(),
+	}
+
+	path := filepath.Join(t.TempDir(), "summary.json")
+	if err := writeSummaryFile(path, session); err != nil {
+		t.Fatalf("writeSummaryFile failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read summary.json: %v", err)
+	}
+	var summary RunSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		t.Fatalf("invalid summary.json: %v", err)
+	}
+
+	if summary.Status != "success" || summary.Attempted != 2 {
+		t.Errorf("unexpected summary counts: %+v", summary.WebhookPayload)
+	}
+	if summary.SchemaVersion != SchemaVersion {
+		t.Errorf("expected schema_version %d, got %d", SchemaVersion, summary.SchemaVersion)
+	}
+	want := []struct {
+		name    string
+		seconds float64
+	}{
+		{PhaseYtdlpDownload, 2},
+		{PhaseJSONParse, 0.5},
+		{PhaseListWrite, 0.1},
+		{PhaseYtdlpRun, 45},
+	}
+	if len(summary.Phases) != len(want) {
+		t.Fatalf("expected %d phases, got %+v", len(want), summary.Phases)
+	}
+	for i, w := range want {
+		got := summary.Phases[i]
+		if got.Name != w.name || got.Seconds < w.seconds || got.Seconds > w.seconds+0.05 {
+			t.Errorf("phase %d: expected %s ~%vs, got %s %vs", i, w.name, w.seconds, got.Name, got.Seconds)
+		}
+	}
+}
+
+// TestApplySinceCutoff tests deriving the --since auto cutoff from the newest existing
+// media file and the download archive
+func TestApplySinceCutoff(t *testing.T) {
+	baseDir := t.TempDir()
+	touch := func(name string, mtime time.Time) {
+		path := filepath.Join(baseDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("failed to set mtime on %s: %v", name, err)
+		}
+	}
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 12, 0, 0, 0, time.UTC) }
+
+	touch("favorites/20260101_1_a.mp4", day(1))
+	touch("favorites/20260101_2_b.mp4", day(10)) // newest media file
+	touch("favorites/notes.json", day(20))       // not media, ignored
+	// liked/ has no files yet
+	if err := os.WriteFile(filepath.Join(baseDir, "favorites", "download_archive.txt"), []byte("tiktok 1\ntiktok 2\n"), 0644); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@a/video/1", Date: "2026-03-05 08:00:00", Collection: "favorites"},
+		// Older than the newest file but never downloaded (it failed last time)
+		{Link: "https://www.tiktok.com/@a/video/6", Date: "2026-03-06 08:00:00", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/3", Date: "2026-03-15 08:00:00", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/4", Date: "", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/5", Date: "2026-02-01 08:00:00", Collection: "liked"},
+	}
+	links := func(entries []VideoEntry) []string {
+		var out []string
+		for _, e := range entries {
+			out = append(out, e.Link[len(e.Link)-1:])
+		}
+		return out
+	}
+
+	tests := []struct {
+		name   string
+		config *Config
+		want   string
+	}{
+		{"no cutoff by default", &Config{OrganizeByCollection: true}, "16345"},
+		{"auto per collection retries unarchived videos", &Config{OrganizeByCollection: true, AutoSince: true}, "6345"},
+		{"explicit since wins", &Config{OrganizeByCollection: true, Since: day(1)}, "1634"},
+		{"flat mode without media files", &Config{AutoSince: true}, "16345"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Join(links(applySinceCutoff(tt.config, entries, baseDir)), "")
+			if got != tt.want {
+				t.Errorf("expected videos %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	// Flat mode looks at media files and the archive in the base directory itself
+	touch("20260101_9_z.webm", day(10))
+	if err := os.WriteFile(filepath.Join(baseDir, "download_archive.txt"), []byte("tiktok 1\ntiktok 5\n"), 0644); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+	if got := strings.Join(links(applySinceCutoff(&Config{AutoSince: true}, entries, baseDir)), ""); got != "634" {
+		t.Errorf("flat mode: expected videos 634, got %s", got)
+	}
+
+	if _, _, err := parseSinceValue("yesterday-ish"); err == nil {
+		t.Error("expected error for invalid --since value")
+	}
+	if cutoff, auto, err := parseSinceValue("Auto"); err != nil || !auto || !cutoff.IsZero() {
+		t.Errorf("expected \"auto\" to derive the cutoff, got %v %v %v", cutoff, auto, err)
+	}
+	if cutoff, auto, err := parseSinceValue("ALL"); err != nil || auto || !cutoff.IsZero() {
+		t.Errorf("expected \"all\" to mean no cutoff, got %v %v %v", cutoff, auto, err)
+	}
+}
+
+// TestSourceWindows tests that per-source date windows narrow only their own source
+func TestSourceWindows(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 0, 0, 0, 0, time.UTC) }
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@a/video/1", Date: "2025-01-01 08:00:00", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/2", Date: "2026-03-20 08:00:00", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/3", Date: "2026-01-15 08:00:00", Collection: "liked"},
+		{Link: "https://www.tiktok.com/@a/video/4", Date: "2026-03-05 08:00:00", Collection: "liked"},
+		{Link: "https://www.tiktok.com/@a/video/5", Date: "2026-03-25 08:00:00", Collection: "liked"},
+		{Link: "https://www.tiktok.com/@a/video/6", Date: "", Collection: "liked"},
+	}
+
+	tests := []struct {
+		name    string
+		windows map[string]DateWindow
+		want    string
+		dropped int
+	}{
+		{"liked since only", map[string]DateWindow{"liked": {Since: day(1)}}, "12456", 1},
+		{"liked window", map[string]DateWindow{"liked": {Since: day(1), Until: day(10)}}, "1246", 2},
+		{"independent windows", map[string]DateWindow{"favorites": {Until: day(1)}, "liked": {Since: day(20)}}, "156", 3},
+		{"unknown source", map[string]DateWindow{"history": {Since: day(1)}}, "123456", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, dropped := filterSourceWindows(entries, tt.windows)
+			var got string
+			for _, e := range kept {
+				got += e.Link[len(e.Link)-1:]
+			}
+			if got != tt.want || dropped != tt.dropped {
+				t.Errorf("expected videos %s (%d dropped), got %s (%d dropped)", tt.want, tt.dropped, got, dropped)
+			}
+		})
+	}
+
+	// Applied together with the global --until
+	config := &Config{Until: day(22), SourceWindows: map[string]DateWindow{"liked": {Since: day(1)}}}
+	if got := applySinceCutoff(config, entries, t.TempDir()); len(got) != 4 {
+		t.Errorf("expected 4 videos inside --until and the liked window, got %+v", got)
+	}
+}
+
+// launchErrorRunner fails every command with a fixed error, as if the executable couldn't start
+type launchErrorRunner struct {
+	err   error
+	calls int
+}
+
+func (r *launchErrorRunner) Run(name string, args ...string) (CapturedOutput, error) {
+	r.calls++
+	return CapturedOutput{}, r.err
+}
+
+// TestRepairYtdlpArchitecture tests replacing a yt-dlp.exe built for the wrong architecture
+func TestRepairYtdlpArchitecture(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldCwd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(oldCwd) }()
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("failed to chdir to temp dir: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/yt-dlp/yt-dlp/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"assets": [
+			{"name": "yt-dlp.exe", "browser_download_url": "http://example.com/yt-dlp.exe"},
+			{"name": "yt-dlp_arm64.exe", "browser_download_url": "http://example.com/yt-dlp_arm64.exe"}
+		]}`))
+	})
+	mux.HandleFunc("/yt-dlp_arm64.exe", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("arm64 exe"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	client := &http.Client{Transport: &rewriterRoundTripper{rt: http.DefaultTransport, host: ts.URL}}
+
+	badFormat := &exec.Error{Name: "yt-dlp.exe", Err: errorBadExeFormat}
+	reset := func() {
+		_ = os.Remove("yt-dlp.exe.old")
+		if err := os.WriteFile("yt-dlp.exe", []byte("x64 exe"), 0755); err != nil {
+			t.Fatalf("failed to write yt-dlp.exe: %v", err)
+		}
+	}
+	content := func() string {
+		data, _ := os.ReadFile("yt-dlp.exe")
+		return string(data)
+	}
+
+	t.Run("bad format is replaced with the matching asset", func(t *testing.T) {
+		reset()
+		var offered string
+		runner := &launchErrorRunner{err: badFormat}
+		err := repairYtdlpArchitecture(context.Background(), runner, client, "", "yt-dlp.exe", "arm64", func(asset string) bool { offered = asset; return true })
+		if err != nil {
+			t.Fatalf("expected repair to succeed, got %v", err)
+		}
+		if offered != "yt-dlp_arm64.exe" || content() != "arm64 exe" {
+			t.Errorf("expected yt-dlp_arm64.exe to replace yt-dlp.exe, offered %q, content %q", offered, content())
+		}
+		if old, _ := os.ReadFile("yt-dlp.exe.old"); string(old) != "x64 exe" {
+			t.Errorf("expected the previous copy kept as yt-dlp.exe.old, got %q", old)
+		}
+	})
+
+	t.Run("declined", func(t *testing.T) {
+		reset()
+		runner := &launchErrorRunner{err: badFormat}
+		if err := repairYtdlpArchitecture(context.Background(), runner, client, "", "yt-dlp.exe", "arm64", func(string) bool { return false }); err == nil {
+			t.Error("expected an error when the re-download is declined")
+		}
+		if content() != "x64 exe" {
+			t.Error("expected yt-dlp.exe to be left alone")
+		}
+	})
+
+	t.Run("other launch errors are ignored", func(t *testing.T) {
+		reset()
+		for _, runErr := range []error{nil, errors.New("exit status 2"), &exec.Error{Name: "yt-dlp.exe", Err: syscall.ENOENT}} {
+			runner := &launchErrorRunner{err: runErr}
+			err := repairYtdlpArchitecture(context.Background(), runner, client, "", "yt-dlp.exe", "arm64", func(string) bool {
+				t.Errorf("unexpected re-download offer for %v", runErr)
+				return false
+			})
+			if err != nil || runner.calls != 1 {
+				t.Errorf("%v: expected no error after one launch attempt, got %v (%d calls)", runErr, err, runner.calls)
+			}
+		}
+		if content() != "x64 exe" {
+			t.Error("expected yt-dlp.exe to be left alone")
+		}
+	})
+
+	t.Run("stalled download times out", func(t *testing.T) {
+		reset()
+		stall := make(chan struct{})
+		defer close(stall)
+		stallMux := http.NewServeMux()
+		stallMux.HandleFunc("/repos/yt-dlp/yt-dlp/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"assets": [{"name": "yt-dlp_arm64.exe", "browser_download_url": "http://example.com/yt-dlp_arm64.exe"}]}`))
+		})
+		stallMux.HandleFunc("/yt-dlp_arm64.exe", func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-stall:
+			case <-r.Context().Done():
+			}
+		})
+		stalled := httptest.NewServer(stallMux)
+		defer stalled.Close()
+		stalledClient := &http.Client{Transport: &rewriterRoundTripper{rt: http.DefaultTransport, host: stalled.URL}}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		runner := &launchErrorRunner{err: badFormat}
+		err := repairYtdlpArchitecture(ctx, runner, stalledClient, "", "yt-dlp.exe", "arm64", func(string) bool { return true })
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the stalled download to time out, got %v", err)
+		}
+		if content() != "x64 exe" {
+			t.Errorf("expected the previous yt-dlp.exe to be restored, got %q", content())
+		}
+	})
+
+	if ytdlpAssetName("amd64") != "yt-dlp.exe" || ytdlpAssetName("386") != "yt-dlp_x86.exe" {
+		t.Error("unexpected asset names")
+	}
+}
+
+// TestApplyProfile tests that profile values are applied and explicit flags take precedence
+func TestApplyProfile(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), defaultConfigFile)
+	configJSON := `{
+		"profiles": {
+			"audio": {
+				"media-ext": "mp3,m4a",
+				"no-thumbnails": true,
+				"fragments": 4,
+				"add-header": ["Accept-Language: de-DE", "X-Test: 1"]
+			},
+			"broken": {"no-such-flag": true}
+		}
+	}`
+	if err := os.WriteFile(configPath, []byte(configJSON), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	newFlagSet := func() (*flag.FlagSet, *string, *bool, *int, *headerList) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		mediaExt := fs.String("media-ext", "", "")
+		noThumbnails := fs.Bool("no-thumbnails", false, "")
+		fragments := fs.Int("fragments", 0, "")
+		var headers headerList
+		fs.Var(&headers, "add-header", "")
+		return fs, mediaExt, noThumbnails, fragments, &headers
+	}
+
+	cfg, err := loadConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("loadConfigFile failed: %v", err)
+	}
+	profile := cfg.Profiles["audio"]
+
+	// Profile values fill in unset flags
+	fs, mediaExt, noThumbnails, fragments, headers := newFlagSet()
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyProfile(fs, "audio", profile); err != nil {
+		t.Fatalf("applyProfile failed: %v", err)
+	}
+	if *mediaExt != "mp3,m4a" || !*noThumbnails || *fragments != 4 || len(*headers) != 2 {
+		t.Errorf("profile not applied: media-ext=%q no-thumbnails=%v fragments=%d headers=%v", *mediaExt, *noThumbnails, *fragments, *headers)
+	}
+
+	// Explicit flags win
+	fs, mediaExt, noThumbnails, fragments, headers = newFlagSet()
+	if err := fs.Parse([]string{"--fragments", "8", "--add-header", "X-Mine: yes"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyProfile(fs, "audio", profile); err != nil {
+		t.Fatalf("applyProfile failed: %v", err)
+	}
+	if *fragments != 8 || len(*headers) != 1 || (*headers)[0] != "X-Mine: yes" {
+		t.Errorf("explicit flags overridden: fragments=%d headers=%v", *fragments, *headers)
+	}
+	if *mediaExt != "mp3,m4a" || !*noThumbnails {
+		t.Errorf("unset flags should still come from the profile: media-ext=%q no-thumbnails=%v", *mediaExt, *noThumbnails)
+	}
+
+	// Errors
+	fs, _, _, _, _ = newFlagSet()
+	if err := applyProfile(fs, "broken", cfg.Profiles["broken"]); err == nil {
+		t.Error("expected error for unknown flag in profile")
+	}
+}
+
+// TestConfigLayers tests the precedence flags > profile > working-directory config > user config
+func TestConfigLayers(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		return path
+	}
+	userPath := write("user.json", `{
+		"defaults": {"fragments": 2, "media-ext": "mp4", "no-thumbnails": true, "work-dir": "D:/TikTok"},
+		"profiles": {"audio": {"media-ext": "mp3"}, "fast": {"fragments": 16}}
+	}`)
+	cwdPath := write("cwd.json", `{
+		"defaults": {"fragments": 4, "media-ext": "mkv"},
+		"profiles": {"audio": {"media-ext": "m4a"}}
+	}`)
+
+	type flags struct {
+		fragments    int
+		mediaExt     string
+		noThumbnails bool
+		workDir      string
+	}
+	parse := func(args []string, profile string, paths []string) (flags, string, []string, error) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		var f flags
+		fs.IntVar(&f.fragments, "fragments", 0, "")
+		fs.StringVar(&f.mediaExt, "media-ext", "", "")
+		fs.BoolVar(&f.noThumbnails, "no-thumbnails", false, "")
+		fs.StringVar(&f.workDir, "work-dir", "", "")
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		profilePath, defaultsFrom, err := applyConfigLayers(fs, profile, paths)
+		return f, profilePath, defaultsFrom, err
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		profile string
+		paths   []string
+		want    flags
+	}{
+		{"user config only", nil, "", []string{"", userPath}, flags{2, "mp4", true, "D:/TikTok"}},
+		{"cwd over user", nil, "", []string{cwdPath, userPath}, flags{4, "mkv", true, "D:/TikTok"}},
+		{"flags over both", []string{"--fragments", "8", "--no-thumbnails=false"}, "", []string{cwdPath, userPath}, flags{8, "mkv", false, "D:/TikTok"}},
+		{"cwd profile over defaults", nil, "audio", []string{cwdPath, userPath}, flags{4, "m4a", true, "D:/TikTok"}},
+		{"profile only in user config", nil, "fast", []string{cwdPath, userPath}, flags{16, "mkv", true, "D:/TikTok"}},
+		{"missing files are skipped", nil, "", []string{filepath.Join(tmpDir, "none.json"), userPath}, flags{2, "mp4", true, "D:/TikTok"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, _, err := parse(tt.args, tt.profile, tt.paths)
+			if err != nil {
+				t.Fatalf("applyConfigLayers failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+
+	_, profilePath, defaultsFrom, err := parse(nil, "fast", []string{cwdPath, userPath})
+	if err != nil || profilePath != userPath || !reflect.DeepEqual(defaultsFrom, []string{cwdPath, userPath}) {
+		t.Errorf("unexpected sources: profile %q, defaults %v, err %v", profilePath, defaultsFrom, err)
+	}
+	if _, _, _, err := parse(nil, "missing", []string{cwdPath, userPath}); err == nil || !strings.Contains(err.Error(), "audio, fast") {
+		t.Errorf("expected an error listing the profiles of both files, got %v", err)
+	}
+	if !strings.HasSuffix(userConfigPath(), filepath.Join("tiktok-dl", "config.json")) && userConfigPath() != "" {
+		t.Errorf("unexpected user config path %q", userConfigPath())
+	}
+}
+
+// fakeClipboard returns fixed clipboard contents
+type fakeClipboard struct {
+	text string
+	err  error
+}
+
+func (c fakeClipboard) ReadText() (string, error) {
+	return c.text, c.err
+}
+
+// TestExportFromClipboard tests reading a pasted export through an injected clipboard
+func TestExportFromClipboard(t *testing.T) {
+	export := `{"Likes and Favorites": {
+		"Favorite Videos": {"FavoriteVideoList": [{"Date": "2026-01-01 10:00:00", "Link": "https://www.tiktok.com/@a/video/1"}]},
+		"Like List": {"ItemFavoriteList": [{"date": "2026-01-02 10:00:00", "link": "https://www.tiktok.com/@b/video/2"}]}
+	}}`
+
+	// Clipboard text often comes with a BOM and trailing newline on Windows
+	path, err := exportFromClipboard(fakeClipboard{text: "\ufeff" + export + "\r\n"}, nil)
+	if err != nil {
+		t.Fatalf("exportFromClipboard failed: %v", err)
+	}
+	defer func() { _ = os.Remove(path) }()
+
+	entries, err := parseFavoriteVideosFromFile(path, true, nil)
+	if err != nil {
+		t.Fatalf("failed to parse the saved export: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Collection != "favorites" || entries[1].Link != "https://www.tiktok.com/@b/video/2" {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+
+	// The reader-based parser is shared with file parsing
+	entries, err = parseFavoriteVideos(strings.NewReader(export), false, nil)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("expected 1 favorite from reader, got %d (%v)", len(entries), err)
+	}
+
+	tests := []struct {
+		name      string
+		clipboard fakeClipboard
+		wantParse bool
+	}{
+		{"empty", fakeClipboard{text: "  \n"}, false},
+		{"not JSON", fakeClipboard{text: "hello world"}, true},
+		{"no videos", fakeClipboard{text: `{"Likes and Favorites": {}}`}, false},
+		{"read failure", fakeClipboard{err: errors.New("no clipboard tool")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := exportFromClipboard(tt.clipboard, nil)
+			if err == nil {
+				_ = os.Remove(path)
+				t.Fatal("expected an error")
+			}
+			if errors.Is(err, ErrJSONParse) != tt.wantParse {
+				t.Errorf("errors.Is(ErrJSONParse) = %v, want %v (%v)", !tt.wantParse, tt.wantParse, err)
+			}
+		})
+	}
+}
+
+// TestVideoIDFromFilename tests extracting video IDs from downloaded filenames
+func TestVideoIDFromFilename(t *testing.T) {
+	tests := map[string]string{
+		"20260101_7301234567890123456_Some title.mp4":  "7301234567890123456",
+		"NA_7301234567890123456_No date.webm":          "7301234567890123456",
+		"favorites/20260101_123_x_y_z.mp4":             "123",
+		"20260101_7301234567890123456_Title.info.json": "7301234567890123456",
+		"my holiday video.mp4":                         "",
+		"2026_123_short date.mp4":                      "",
+		"20260101_abc_not an id.mp4":                   "",
+	}
+	for name, want := range tests {
+		if got := videoIDFromFilename(name); got != want {
+			t.Errorf("videoIDFromFilename(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// TestApplyDedupeExisting tests skipping URLs whose videos are already on disk
+func TestApplyDedupeExisting(t *testing.T) {
+	baseDir := t.TempDir()
+	for _, name := range []string{
+		"favorites/20260101_111_Downloaded.mp4",
+		"favorites/20260101_222_Only metadata.info.json", // no media file, not downloaded
+		"liked/20260101_333_Copied by hand.mkv",
+		"20260101_444_Flat.mp4",
+	} {
+		path := filepath.Join(baseDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@a/video/111", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/222", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/333", Collection: "liked"},
+		{Link: "https://www.tiktok.com/@a/video/333", Collection: "favorites"}, // on disk in liked/ only
+		{Link: "https://www.tiktok.com/@a/video/444", Collection: "favorites"},
+	}
+	ids := func(entries []VideoEntry) string {
+		var out []string
+		for _, e := range entries {
+			out = append(out, extractVideoID(e.Link))
+		}
+		return strings.Join(out, ",")
+	}
+
+	if got := ids(applyDedupeExisting(&Config{OrganizeByCollection: true}, entries, baseDir)); got != "111,222,333,333,444" {
+		t.Errorf("expected no filtering without --dedupe-existing, got %s", got)
+	}
+	if got := ids(applyDedupeExisting(&Config{OrganizeByCollection: true, DedupeExisting: true}, entries, baseDir)); got != "222,333,444" {
+		t.Errorf("collection mode: expected 222,333,444, got %s", got)
+	}
+	if got := ids(applyDedupeExisting(&Config{DedupeExisting: true}, entries, baseDir)); got != "111,222,333,333" {
+		t.Errorf("flat mode: expected 111,222,333,333, got %s", got)
+	}
+
+	downloaded, err := scanDownloadedIDs(filepath.Join(baseDir, "missing"), nil)
+	if err != nil || len(downloaded) != 0 {
+		t.Errorf("expected an empty set for a missing directory, got %v (%v)", downloaded, err)
+	}
+}
+
+// TestSeedArchive tests building download archives from files already on disk
+func TestSeedArchive(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("20260101_100_Flat video.mp4", "x")
+	write("favorites/20260101_200_First.mp4", "x")
+	write("favorites/20260102_300_Second.webm", "x")
+	write("favorites/20260102_300_Second.info.json", "{}")
+	write("favorites/20260103_400_Metadata only.info.json", "{}")
+	write("favorites/renamed by hand.mp4", "x")
+	write("favorites/download_archive.txt", "tiktok 200") // existing entry, no trailing newline
+	write("liked/notes.txt", "x")
+
+	if err := runSeedArchive(root, nil); err != nil {
+		t.Fatalf("runSeedArchive failed: %v", err)
+	}
+
+	flat, err := parseArchiveFile(filepath.Join(root, "download_archive.txt"))
+	if err != nil || len(flat) != 1 || !flat["100"] {
+		t.Errorf("unexpected flat archive: %v (%v)", flat, err)
+	}
+	favorites, err := parseArchiveFile(filepath.Join(root, "favorites", "download_archive.txt"))
+	if err != nil || len(favorites) != 2 || !favorites["200"] || !favorites["300"] {
+		t.Errorf("unexpected favorites archive: %v (%v)", favorites, err)
+	}
+	data, _ := os.ReadFile(filepath.Join(root, "favorites", "download_archive.txt"))
+	if string(data) != "tiktok 200\ntiktok 300\n" {
+		t.Errorf("unexpected archive content: %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(root, "liked", "download_archive.txt")); !os.IsNotExist(err) {
+		t.Error("expected no archive for a folder without videos")
+	}
+
+	// Seeding again adds nothing
+	if added, err := seedArchive(filepath.Join(root, "favorites"), nil); err != nil || added != 0 {
+		t.Errorf("expected no new entries on a second run, got %d (%v)", added, err)
+	}
+}
+
+// TestResumeArchive tests reconciling download archives with the videos on disk
+func TestResumeArchive(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 200 is archived and on disk, 300 was deleted, 400 and 500 were never archived
+	write("favorites/20260101_200_Kept.mp4", "x")
+	write("favorites/20260103_400_Not archived.mp4", "x")
+	write("favorites/20260104_500_Also missing.webm", "x")
+	write("favorites/20260102_300_Deleted.info.json", "{}")
+	write("favorites/download_archive.txt", "tiktok 300\r\nyoutube abc\ntiktok 200\ntiktok 600")
+	write("liked/notes.txt", "x")
+
+	var out bytes.Buffer
+	if err := runResumeArchive(root, nil, &out); err != nil {
+		t.Fatalf("runResumeArchive failed: %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(root, "favorites", "download_archive.txt"))
+	if want := "youtube abc\ntiktok 200\ntiktok 400\ntiktok 500\n"; string(data) != want {
+		t.Errorf("unexpected archive content:\n%q\nwant:\n%q", data, want)
+	}
+	if !strings.Contains(out.String(), "added 2 videos found on disk, removed 2 videos missing from disk") ||
+		!strings.Contains(out.String(), "  - 300 (will be downloaded again)") || !strings.Contains(out.String(), "  - 600 ") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+	for _, dir := range []string{root, filepath.Join(root, "liked")} {
+		if _, err := os.Stat(filepath.Join(dir, "download_archive.txt")); !os.IsNotExist(err) {
+			t.Errorf("expected no archive in %s without videos", dir)
+		}
+	}
+
+	// A reconciled archive is left alone
+	added, removed, err := reconcileArchive(filepath.Join(root, "favorites"), nil)
+	if err != nil || len(added) != 0 || len(removed) != 0 {
+		t.Errorf("expected no changes on a second run, got +%v -%v (%v)", added, removed, err)
+	}
+	out.Reset()
+	if err := runResumeArchive(root, nil, &out); err != nil || !strings.Contains(out.String(), "already match") {
+		t.Errorf("expected archives to already match, got %q (%v)", out.String(), err)
+	}
+}
+
+// TestDoctorJSON tests the structured output of "doctor --json"
+func TestDoctorJSON(t *testing.T) {
+	tmpDir := t.TempDir()
+	checks := []DoctorCheck{
+		checkYtdlp(&versionRunner{version: "2025.01.15"}, filepath.Join(tmpDir, "yt-dlp.exe")),
+		{Name: "github.com reachable", Passed: true, Detail: "200 OK"},
+		checkOutputDir(tmpDir),
+		checkJSONFile(filepath.Join(tmpDir, "missing.json")),
+	}
+
+	var buf bytes.Buffer
+	if printDoctorJSON(&buf, checks) {
+		t.Error("expected all-passed false with yt-dlp and the export missing")
+	}
+
+	var report map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("doctor --json output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	if report["all_passed"] != false {
+		t.Errorf("expected all_passed false, got %v", report["all_passed"])
+	}
+	got, ok := report["checks"].([]any)
+	if !ok || len(got) != len(checks) {
+		t.Fatalf("expected %d checks, got %v", len(checks), report["checks"])
+	}
+	for i, item := range got {
+		check := item.(map[string]any)
+		if check["name"] != checks[i].Name {
+			t.Errorf("check %d: expected name %q, got %v", i, checks[i].Name, check["name"])
+		}
+		if passed, ok := check["passed"].(bool); !ok || passed != checks[i].Passed {
+			t.Errorf("check %q: expected boolean passed=%v, got %v", checks[i].Name, checks[i].Passed, check["passed"])
+		}
+		if _, ok := check["detail"].(string); !ok {
+			t.Errorf("check %q: expected a detail string", checks[i].Name)
+		}
+	}
+
+	if jsonOutput, file := parseDoctorArgs([]string{"export.json", "--json"}); !jsonOutput || file != "export.json" {
+		t.Errorf("unexpected doctor args: %v %q", jsonOutput, file)
+	}
+	if !isDoctorJSON([]string{"doctor", "--json"}) || isDoctorJSON([]string{"stats", "--json"}) || isDoctorJSON(nil) {
+		t.Error("unexpected isDoctorJSON result")
+	}
+}
+
+// TestParseRelativeDate tests relative --since/--until expressions
+func TestParseRelativeDate(t *testing.T) {
+	now := time.Date(2026, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	valid := []struct {
+		expr string
+		want time.Time
+	}{
+		{"30d", time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{"last 30d", time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{" 1D ", time.Date(2026, 3, 30, 12, 0, 0, 0, time.UTC)},
+		{"2w", time.Date(2026, 3, 17, 12, 0, 0, 0, time.UTC)},
+		{"6mo", time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)}, // Sep 31 normalizes to Oct 1
+		{"1mo", time.Date(2026, 3, 3, 12, 0, 0, 0, time.UTC)},  // Feb 31 normalizes to Mar 3
+		{"1y", time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC)},
+		{"Last 2 y", time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range valid {
+		got, err := parseRelativeDate(tt.expr, now)
+		if err != nil {
+			t.Errorf("parseRelativeDate(%q) failed: %v", tt.expr, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseRelativeDate(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"", "30", "d", "0d", "-5d", "30m", "3 months", "next 30d", "1.5y", "2026-01-01"} {
+		if _, err := parseRelativeDate(expr, now); err == nil {
+			t.Errorf("expected error for %q", expr)
+		}
+	}
+
+	// --until with an absolute date includes that whole day
+	until, err := parseDateFilter("until", "2026-01-31", true, now)
+	if err != nil || !until.Equal(time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected --until cutoff %v (%v)", until, err)
+	}
+	if _, err := parseDateFilter("since", "soon", false, now); err == nil || !strings.Contains(err.Error(), "--since") {
+		t.Errorf("expected an error naming --since, got %v", err)
+	}
+
+	entries := []VideoEntry{
+		{Link: "1", Date: "2026-01-31 23:59:59"},
+		{Link: "2", Date: "2026-02-01 00:00:00"},
+		{Link: "3"},
+	}
+	kept, dropped := filterUntil(entries, until)
+	if dropped != 1 || len(kept) != 2 || kept[0].Link != "1" || kept[1].Link != "3" {
+		t.Errorf("unexpected --until filtering: %+v (%d dropped)", kept, dropped)
+	}
+}
+
+// TestRunLog tests that --run-log captures the console transcript as plain text
+func TestRunLog(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), runLogFilename(time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)))
+	if filepath.Base(logPath) != "run-20260102-150405.log" {
+		t.Errorf("unexpected log name %s", filepath.Base(logPath))
+	}
+
+	stop, err := startRunLog(logPath)
+	if err != nil {
+		t.Fatalf("startRunLog failed: %v", err)
+	}
+	fmt.Println("[*] Successfully loaded 3 video entries")
+	fmt.Print("\r\033[K\033[32m[████░░░░] 1/3\033[0m")
+	fmt.Print("\r\033[K\033[32m[████████] 3/3\033[0m\n")
+	fmt.Fprintln(os.Stderr, "ERROR: [TikTok] 123: Video unavailable")
+	fmt.Print("[*] Done (no trailing newline)")
+	if err := stop(); err != nil {
+		t.Fatalf("stopping the run log failed: %v", err)
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("failed to read run log: %v", err)
+	}
+	log := string(data)
+	for _, want := range []string{
+		"[*] Successfully loaded 3 video entries\n",
+		"[████████] 3/3\n",
+		"ERROR: [TikTok] 123: Video unavailable\n",
+		"[*] Done (no trailing newline)\n",
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("run log missing %q:\n%s", want, log)
+		}
+	}
+	if strings.Contains(log, "\033") || strings.Contains(log, "1/3") {
+		t.Errorf("expected escape codes and overwritten progress to be dropped:\n%q", log)
+	}
+	if consoleStdout != nil {
+		t.Error("expected the console to be restored")
+	}
+}
+
+// TestPromptForLiked tests that the liked prompt is only shown when the export has liked videos
+func TestPromptForLiked(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		return path
+	}
+	favorites := `"Favorite Videos": {"FavoriteVideoList": [{"Link": "https://www.tiktok.com/@a/video/1"}]}`
+
+	tests := []struct {
+		name       string
+		path       string
+		wantPrompt bool
+	}{
+		{"no liked section", write("no_liked.json", `{"Likes and Favorites": {`+favorites+`}}`), false},
+		{"empty liked section", write("empty_liked.json", `{"Likes and Favorites": {`+favorites+`, "Like List": {"ItemFavoriteList": []}}}`), false},
+		{"liked videos", write("liked.json", `{"Likes and Favorites": {`+favorites+`, "Like List": {"ItemFavoriteList": [{"link": "https://www.tiktok.com/@b/video/2"}]}}}`), true},
+		{"unreadable export", filepath.Join(tmpDir, "missing.json"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sections, err := inspectExport(tt.path, nil)
+			if err != nil {
+				sections = ExportSections{HasFavorites: true, HasLiked: true}
+			}
+			var out bytes.Buffer
+			got := promptForLiked(tt.path, nil, sections, strings.NewReader("y\n"), &out)
+			prompted := strings.Contains(out.String(), "include 'Liked' videos")
+			if prompted != tt.wantPrompt {
+				t.Errorf("prompted = %v, want %v (output %q)", prompted, tt.wantPrompt, out.String())
+			}
+			if got != tt.wantPrompt {
+				t.Errorf("include liked = %v, want %v", got, tt.wantPrompt)
+			}
+		})
+	}
+}
+
+func TestBinaryDownloadTimeout(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldCwd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(oldCwd) }()
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("failed to chdir to temp dir: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/yt-dlp/yt-dlp/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"assets": [{"name": "yt-dlp.exe", "browser_download_url": "http://example.com/yt-dlp.exe"}]}`))
+	})
+	mux.HandleFunc("/yt-dlp.exe", func(w http.ResponseWriter, r *http.Request) {
+		// Send part of the binary, then stall until the client gives up
+		_, _ = w.Write([]byte("partial exe"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	client := &http.Client{Transport: &rewriterRoundTripper{rt: http.DefaultTransport, host: ts.URL}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := getOrDownloadYtdlpContext(ctx, client, "", "yt-dlp.exe")
+	if err == nil {
+		t.Fatal("expected the stalled download to fail")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrDownload) {
+		t.Errorf("expected ErrDownload wrapping context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the download to be abandoned promptly, took %s", elapsed)
+	}
+	if _, statErr := os.Stat("yt-dlp.exe"); !os.IsNotExist(statErr) {
+		t.Errorf("expected the partial yt-dlp.exe to be removed, stat err: %v", statErr)
+	}
+}
+
+func TestManualYtdlpInstructions(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldCwd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(oldCwd) }()
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("failed to chdir to temp dir: %v", err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rate limited", http.StatusForbidden)
+	}))
+	defer ts.Close()
+	client := &http.Client{Transport: &rewriterRoundTripper{rt: http.DefaultTransport, host: ts.URL}}
+	if err := getOrDownloadYtdlp(client, "yt-dlp.exe"); err == nil {
+		t.Fatal("expected the download to fail")
+	}
+
+	cwd, _ := os.Getwd()
+	var out bytes.Buffer
+	printManualYtdlpInstructions(&out, "yt-dlp.exe", "arm64")
+	for _, want := range []string{
+		"https://github.com/yt-dlp/yt-dlp/releases/latest/download/yt-dlp_arm64.exe",
+		"Save it as yt-dlp.exe in " + cwd,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected instructions to contain %q, got:\n%s", want, out.String())
+		}
+	}
+
+	// Still missing: re-check, then skip
+	out.Reset()
+	if waitForManualYtdlp("yt-dlp.exe", strings.NewReader("\nskip\n"), &out) {
+		t.Error("expected false when yt-dlp.exe was never placed")
+	}
+	if !strings.Contains(out.String(), "still not found") {
+		t.Errorf("expected a not-found message on re-check, got:\n%s", out.String())
+	}
+
+	// Placed by hand: the re-check picks it up
+	if err := os.WriteFile("yt-dlp.exe", []byte("exe"), 0755); err != nil {
+		t.Fatalf("failed to write yt-dlp.exe: %v", err)
+	}
+	out.Reset()
+	if !waitForManualYtdlp("yt-dlp.exe", strings.NewReader("\n"), &out) {
+		t.Errorf("expected true once yt-dlp.exe is in place, got:\n%s", out.String())
+	}
+}
+
+func TestGetOrDownloadYtdlpZeroByte(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldCwd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(oldCwd) }()
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("failed to chdir to temp dir: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/yt-dlp/yt-dlp/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"assets": [{"name": "yt-dlp.exe", "browser_download_url": "http://example.com/yt-dlp.exe"}]}`))
+	})
+	mux.HandleFunc("/yt-dlp.exe", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("fresh exe bytes"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	client := &http.Client{Transport: &rewriterRoundTripper{rt: http.DefaultTransport, host: ts.URL}}
+
+	// Left behind by an interrupted download
+	if err := os.WriteFile("yt-dlp.exe", nil, 0755); err != nil {
+		t.Fatalf("failed to create empty yt-dlp.exe: %v", err)
+	}
+	if !isEmptyFile("yt-dlp.exe") {
+		t.Fatal("expected isEmptyFile to report the zero-byte file")
+	}
+
+	if err := getOrDownloadYtdlp(client, "yt-dlp.exe"); err != nil {
+		t.Fatalf("expected the empty file to be re-downloaded, got %v", err)
+	}
+	data, err := os.ReadFile("yt-dlp.exe")
+	if err != nil {
+		t.Fatalf("failed to read yt-dlp.exe: %v", err)
+	}
+	if string(data) != "fresh exe bytes" {
+		t.Errorf("expected the re-downloaded binary, got %q", data)
+	}
+	if isEmptyFile("yt-dlp.exe") || isEmptyFile("missing.exe") {
+		t.Error("expected isEmptyFile to be false for a non-empty or missing file")
+	}
+}
+
+// TestReleaseFile tests that --release-file is parsed in place of the GitHub API response
+func TestReleaseFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldCwd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(oldCwd) }()
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("failed to chdir to temp dir: %v", err)
+	}
+
+	var apiCalls int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/yt-dlp/yt-dlp/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+		apiCalls++
+		_, _ = w.Write([]byte(`{"assets": []}`))
+	})
+	mux.HandleFunc("/mirror/yt-dlp.exe", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("pinned exe bytes"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	releasePath := filepath.Join(tmpDir, "release.json")
+	release := `{"tag_name": "2025.01.01", "assets": [{"name": "yt-dlp.exe", "browser_download_url": "` + ts.URL + `/mirror/yt-dlp.exe"}]}`
+	if err := os.WriteFile(releasePath, []byte(release), 0644); err != nil {
+		t.Fatalf("failed to write release file: %v", err)
+	}
+
+	if err := getOrDownloadYtdlpContext(context.Background(), ts.Client(), releasePath, "yt-dlp.exe"); err != nil {
+		t.Fatalf("getOrDownloadYtdlpContext failed: %v", err)
+	}
+	data, err := os.ReadFile("yt-dlp.exe")
+	if err != nil {
+		t.Fatalf("failed to read yt-dlp.exe: %v", err)
+	}
+	if string(data) != "pinned exe bytes" {
+		t.Errorf("expected the asset named in the release file, got %q", data)
+	}
+	if apiCalls != 0 {
+		t.Errorf("expected the GitHub API not to be called, got %d calls", apiCalls)
+	}
+
+	// A broken release file is reported as a parse error
+	if err := os.WriteFile(releasePath, []byte("{not json"), 0644); err != nil {
+		t.Fatalf("failed to write release file: %v", err)
+	}
+	if _, err := fetchYtdlpRelease(context.Background(), ts.Client(), releasePath); !errors.Is(err, ErrJSONParse) {
+		t.Errorf("expected ErrJSONParse for a broken release file, got %v", err)
+	}
+}
+
+// TestWithDriveRetry tests recovery from an output drive that disappears mid-run
+func TestWithDriveRetry(t *testing.T) {
+	driveGone := &os.PathError{Op: "write", Path: "E:\\TikTok\\video.mp4", Err: syscall.ENODEV}
+
+	t.Run("transient failure then success", func(t *testing.T) {
+		calls, prompts := 0, 0
+		err := withDriveRetry("E:\\TikTok", driveRetryLimit, func(string) bool { prompts++; return true }, func() error {
+			calls++
+			if calls == 1 {
+				return driveGone
+			}
+			return nil
+		})
+		if err != nil || calls != 2 || prompts != 1 {
+			t.Errorf("expected one retry to succeed, got err=%v calls=%d prompts=%d", err, calls, prompts)
+		}
+	})
+
+	t.Run("retries are bounded", func(t *testing.T) {
+		calls := 0
+		err := withDriveRetry("E:\\TikTok", driveRetryLimit, func(string) bool { return true }, func() error {
+			calls++
+			return driveGone
+		})
+		if !errors.Is(err, syscall.ENODEV) || calls != driveRetryLimit+1 {
+			t.Errorf("expected to give up after %d retries, got err=%v calls=%d", driveRetryLimit, err, calls)
+		}
+	})
+
+	t.Run("user skips", func(t *testing.T) {
+		calls := 0
+		err := withDriveRetry("E:\\TikTok", driveRetryLimit, func(string) bool { return false }, func() error {
+			calls++
+			return driveGone
+		})
+		if err == nil || calls != 1 {
+			t.Errorf("expected no retry after skip, got err=%v calls=%d", err, calls)
+		}
+	})
+
+	t.Run("other errors are not retried", func(t *testing.T) {
+		calls := 0
+		err := withDriveRetry("E:\\TikTok", driveRetryLimit, func(string) bool { t.Error("unexpected prompt"); return true }, func() error {
+			calls++
+			return &os.PathError{Op: "open", Path: "video.mp4", Err: syscall.EACCES}
+		})
+		if err == nil || calls != 1 {
+			t.Errorf("expected a single attempt, got err=%v calls=%d", err, calls)
+		}
+	})
+
+	// A folder that vanished counts as a lost drive
+	dir := filepath.Join(t.TempDir(), "usb")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := outputDirReachable(dir); err != nil {
+		t.Errorf("expected %s to be reachable, got %v", dir, err)
+	}
+	if err := os.Remove(dir); err != nil {
+		t.Fatalf("failed to remove dir: %v", err)
+	}
+	if err := outputDirReachable(dir); !isDriveGoneError(err) {
+		t.Errorf("expected a missing output folder to be reported as a lost drive, got %v", err)
+	}
+
+	// The folder checked is where yt-dlp saves, not where the list happens to be
+	if got := ytdlpOutputDir(&Config{WorkDir: "E:\\TikTok"}, "fav_videos.txt"); got != "E:\\TikTok" {
+		t.Errorf("expected the work dir in flat mode, got %q", got)
+	}
+	listPath := filepath.Join("E:", "TikTok", "favorites", "fav_videos.txt")
+	if got := ytdlpOutputDir(&Config{OrganizeByCollection: true}, listPath); got != filepath.Dir(listPath) {
+		t.Errorf("expected the collection dir in organize mode, got %q", got)
+	}
+
+	// yt-dlp's own write errors are classified from its output
+	outputName := filepath.Join(t.TempDir(), "fav_videos.txt")
+	entries := []VideoEntry{{Link: "https://www.tiktok.com/@user/video/1"}}
+	for _, tt := range []struct {
+		line      string
+		driveGone bool
+	}{
+		{"ERROR: unable to write data: [Errno 5] Input/output error", true},
+		{"ERROR: unable to open for writing: [WinError 21] The device is not ready", true},
+		{"ERROR: unable to write data: [Errno 28] No space left on device", false},
+		{"ERROR: [TikTok] 1: Video not available", false},
+	} {
+		runner := &cannedOutputRunner{lines: []string{"[download] Downloading item 1 of 1", tt.line}}
+		_, err := runYtdlpWithRunner(runner, "", outputName, &Config{DisableResume: true}, entries)
+		if isDriveGoneError(err) != tt.driveGone {
+			t.Errorf("%q: expected drive gone %v, got %v", tt.line, tt.driveGone, err)
+		}
+		if tt.driveGone && !errors.Is(err, ErrYtdlpRun) {
+			t.Errorf("%q: expected the error to wrap ErrYtdlpRun, got %v", tt.line, err)
+		}
+	}
+}
+
+// flakyRunner fails its first failures invocations, then succeeds
+type flakyRunner struct {
+	failures int
+	calls    int
+}
+
+func (r *flakyRunner) Run(name string, args ...string) (CapturedOutput, error) {
+	r.calls++
+	if r.calls <= r.failures {
+		return CapturedOutput{Combined: []string{"ERROR: [TikTok] 111: Unable to download webpage"}}, fmt.Errorf("exit status 1")
+	}
+	return CapturedOutput{Combined: []string{"[download] Destination: video.mp4"}}, nil
+}
+
+// TestRunRetries tests that --run-retries re-runs a failing yt-dlp invocation
+func TestRunRetries(t *testing.T) {
+	tmpDir := t.TempDir()
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@a/video/111", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/222", Collection: "favorites"},
+	}
+	listFile := filepath.Join(tmpDir, "fav_videos.txt")
+	if err := writeVideoEntriesToFile(entries, listFile); err != nil {
+		t.Fatalf("failed to write list: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		perVideo   time.Duration
+		retries    int
+		wantCalls  int
+		wantFailed int
+		wantErr    bool
+	}{
+		{"batch recovers", 0, 2, 3, 0, false},
+		{"batch gives up", 0, 1, 2, 1, true},
+		{"per URL recovers", time.Minute, 2, 4, 0, false},
+		{"no retries", 0, 0, 1, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &flakyRunner{failures: 2}
+			config := &Config{OrganizeByCollection: true, DisableResume: true, PerVideoTimeout: tt.perVideo, RunRetries: tt.retries}
+			result, err := runYtdlpWithRunner(runner, "", listFile, config, entries)
+			if runner.calls != tt.wantCalls {
+				t.Errorf("expected %d yt-dlp invocations, got %d", tt.wantCalls, runner.calls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if result == nil || result.Failed != tt.wantFailed {
+				t.Errorf("expected %d failures, got %+v", tt.wantFailed, result)
+			}
+		})
+	}
+	// Once --max-runtime is used up, a failed video isn't retried and no further video starts
+	runner := &flakyRunner{failures: 2}
+	config := &Config{OrganizeByCollection: true, DisableResume: true, PerVideoTimeout: time.Minute,
+		RunRetries: 2, RunRetryDelay: 10 * time.Second, Deadline: time.Now().Add(200 * time.Millisecond)}
+	start := time.Now()
+	if _, err := runYtdlpWithRunner(runner, "", listFile, config, entries); err == nil {
+		t.Error("expected the failed attempt to be reported")
+	}
+	if runner.calls != 1 {
+		t.Errorf("expected no yt-dlp invocation after the deadline, got %d", runner.calls)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the retry wait to end at the deadline, took %s", elapsed)
+	}
+}
+
+// concatCaptureRunner records the command and the concat list it was given
+type concatCaptureRunner struct {
+	name string
+	args []string
+	list string
+}
+
+func (r *concatCaptureRunner) Run(name string, args ...string) (CapturedOutput, error) {
+	r.name, r.args = name, args
+	for i, arg := range args {
+		if arg == "-i" && i+1 < len(args) {
+			data, _ := os.ReadFile(args[i+1])
+			r.list = string(data)
+		}
+	}
+	return CapturedOutput{}, nil
+}
+
+func TestMergeDownloads(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, dir := range []string{"favorites", "liked"} {
+		if err := os.MkdirAll(filepath.Join(tmpDir, dir), 0755); err != nil {
+			t.Fatalf("failed to create %s: %v", dir, err)
+		}
+	}
+	files := []string{
+		filepath.Join("favorites", "20240101_222_second.mp4"),
+		filepath.Join("favorites", "20240101_111_it's first.mp4"),
+		filepath.Join("favorites", "20240101_111_it's first.info.json"),
+		filepath.Join("liked", "20240102_333_third.mp4"),
+		filepath.Join("liked", "20240101_111_it's first.mp4"), // Same video in both collections
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, f), []byte("x"), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", f, err)
+		}
+	}
+
+	entries := []VideoEntry{
+		{Link: "https://www.tiktokv.com/share/video/111/", Collection: "favorites"},
+		{Link: "https://www.tiktokv.com/share/video/222/", Collection: "favorites"},
+		{Link: "https://www.tiktokv.com/share/video/444/", Collection: "favorites"}, // Not downloaded
+		{Link: "https://www.tiktokv.com/share/video/333/", Collection: "liked"},
+		{Link: "https://www.tiktokv.com/share/video/111/", Collection: "liked"},
+	}
+	config := &Config{OrganizeByCollection: true}
+	inputs := mergeInputFiles(config, tmpDir, entries)
+	expected := []string{
+		filepath.Join(tmpDir, "favorites", "20240101_111_it's first.mp4"),
+		filepath.Join(tmpDir, "favorites", "20240101_222_second.mp4"),
+		filepath.Join(tmpDir, "liked", "20240102_333_third.mp4"),
+	}
+	if !reflect.DeepEqual(inputs, expected) {
+		t.Fatalf("unexpected merge inputs:\n got %v\nwant %v", inputs, expected)
+	}
+
+	output := filepath.Join(tmpDir, "compilation.mp4")
+	runner := &concatCaptureRunner{}
+	if err := mergeDownloads(runner, "ffmpeg", inputs, output); err != nil {
+		t.Fatalf("mergeDownloads failed: %v", err)
+	}
+
+	wantArgs := []string{"-hide_banner", "-loglevel", "error", "-y", "-f", "concat", "-safe", "0", "-i", output + ".concat.txt", "-c", "copy", output}
+	if runner.name != "ffmpeg" || !reflect.DeepEqual(runner.args, wantArgs) {
+		t.Errorf("unexpected ffmpeg command: %s %v", runner.name, runner.args)
+	}
+	wantList := "file '" + strings.ReplaceAll(expected[0], "'", `'\''`) + "'\n" +
+		"file '" + expected[1] + "'\n" +
+		"file '" + expected[2] + "'\n"
+	if runner.list != wantList {
+		t.Errorf("unexpected concat list:\n got %q\nwant %q", runner.list, wantList)
+	}
+	if fileExists(output + ".concat.txt") {
+		t.Error("expected the concat list to be removed after merging")
+	}
+
+	// Failures are reported
+	if err := mergeDownloads(runner, "ffmpeg", nil, output); err == nil {
+		t.Error("expected an error with nothing to merge")
+	}
+	if err := mergeDownloads(&MockCommandRunner{ShouldFail: true}, "ffmpeg", inputs, output); err == nil {
+		t.Error("expected an error when ffmpeg fails")
+	}
+}
+
+// TestWorkDir tests that lists, downloads and archives of a run land under --work-dir
+func TestWorkDir(t *testing.T) {
+	workDir := filepath.Join(t.TempDir(), "export-a")
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		t.Fatalf("failed to create work dir: %v", err)
+	}
+	entries := []VideoEntry{
+		{Link: "https://www.tiktokv.com/share/video/111/", Collection: "favorites"},
+		{Link: "https://www.tiktokv.com/share/video/222/", Collection: "liked"},
+	}
+
+	t.Run("flat", func(t *testing.T) {
+		config := &Config{WorkDir: workDir, OutputName: "fav_videos.txt"}
+		if err := writeFavoriteVideosToDir(entries, workPath(config, "."), config.OutputName, false); err != nil {
+			t.Fatalf("writeFavoriteVideosToDir failed: %v", err)
+		}
+		listFile := filepath.Join(workDir, "fav_videos.txt")
+		if !fileExists(listFile) {
+			t.Fatalf("expected the URL list in the work dir at %s", listFile)
+		}
+
+		runner := &MockCommandRunner{}
+		result, err := runYtdlpWithRunner(runner, "", workPath(config, config.OutputName), config, entries)
+		if err != nil {
+			t.Fatalf("runYtdlpWithRunner failed: %v", err)
+		}
+		if result.Name != "videos" {
+			t.Errorf("expected the flat list to be reported as 'videos', got %q", result.Name)
+		}
+		args := strings.Join(runner.Commands[0].Args, " ")
+		for _, want := range []string{
+			"-a " + listFile,
+			"--output " + filepath.Join(workDir, "%(upload_date)s_%(id)s_%(title).50B.%(ext)s"),
+			"--download-archive " + filepath.Join(workDir, "download_archive.txt"),
+		} {
+			if !strings.Contains(args, want) {
+				t.Errorf("expected yt-dlp args to contain %q, got %s", want, args)
+			}
+		}
+	})
+
+	t.Run("organized by collection", func(t *testing.T) {
+		config := &Config{WorkDir: workDir, OrganizeByCollection: true}
+		if err := writeFavoriteVideosToDir(entries, workPath(config, "."), "ignored.txt", true); err != nil {
+			t.Fatalf("writeFavoriteVideosToDir failed: %v", err)
+		}
+		for _, list := range []string{filepath.Join("favorites", "fav_videos.txt"), filepath.Join("liked", "liked_videos.txt")} {
+			if !fileExists(filepath.Join(workDir, list)) {
+				t.Errorf("expected %s in the work dir", list)
+			}
+		}
+		if fileExists("favorites") || fileExists("liked") {
+			t.Error("expected no collection directories in the current directory")
+		}
+	})
+
+	// Absolute names and no work dir are left alone
+	if got := workPath(&Config{WorkDir: workDir}, filepath.Join(workDir, "x.mp4")); got != filepath.Join(workDir, "x.mp4") {
+		t.Errorf("expected an absolute path to be kept, got %q", got)
+	}
+	if got := workPath(&Config{}, "results.txt"); got != "results.txt" {
+		t.Errorf("expected the current directory without --work-dir, got %q", got)
+	}
+}
+
+// TestIndexUnicodeFilenames tests that videos with Unicode uploaders and titles are marked
+// as downloaded even when yt-dlp's sanitized filename differs from the one in .info.json
+func TestIndexUnicodeFilenames(t *testing.T) {
+	// Brackets in the directory name must not be treated as a glob pattern
+	collectionDir := filepath.Join(t.TempDir(), "favorites [李小龙]")
+	if err := os.MkdirAll(collectionDir, 0755); err != nil {
+		t.Fatalf("failed to create collection dir: %v", err)
+	}
+
+	videos := []struct {
+		id, uploader, recorded, onDisk string
+	}{
+		// Windows sanitization swaps reserved characters for fullwidth lookalikes
+		{"111", "李小龙🐉", "20240101_111_Q&A | 😀 what?.mp4", "20240101_111_Q&A ｜ 😀 what？.mp4"},
+		// Decomposed (NFD) name recorded, composed (NFC) name on disk
+		{"222", "Ñandú", "20240102_222_man\u0303ana.mp4", "20240102_222_ma\u00f1ana.mp4"},
+		{"333", "مرحبا", "20240103_333_مرحبا بالعالم.mp4", "20240103_333_مرحبا بالعالم.mp4"},
+	}
+
+	var entries []VideoEntry
+	for _, v := range videos {
+		entries = append(entries, VideoEntry{Link: "https://www.tiktok.com/@user/video/" + v.id, Collection: "favorites"})
+		info := fmt.Sprintf(`{"id": %q, "title": "t", "uploader": %q, "filename": %q}`, v.id, v.uploader, filepath.Join("favorites", v.recorded))
+		stem := strings.TrimSuffix(v.onDisk, ".mp4")
+		for name, content := range map[string]string{stem + ".info.json": info, v.onDisk: "video", stem + ".jpg": "thumb"} {
+			if err := os.WriteFile(filepath.Join(collectionDir, name), []byte(content), 0644); err != nil {
+				t.Fatalf("failed to write %s: %v", name, err)
+			}
+		}
+	}
+
+	if err := generateCollectionIndex(collectionDir, entries, nil); err != nil {
+		t.Fatalf("generateCollectionIndex failed: %v", err)
+	}
+	index, err := loadExistingIndex(collectionDir)
+	if err != nil || index == nil {
+		t.Fatalf("failed to load index.json: %v", err)
+	}
+
+	for i, v := range videos {
+		got := index.Videos[i]
+		if !got.Downloaded {
+			t.Errorf("video %s by %s: expected success, got %q", v.id, v.uploader, got.DownloadError)
+		}
+		if got.LocalFilename != v.onDisk {
+			t.Errorf("video %s: expected local filename %q, got %q", v.id, v.onDisk, got.LocalFilename)
+		}
+		if got.Creator != v.uploader {
+			t.Errorf("video %s: expected uploader %q, got %q", v.id, v.uploader, got.Creator)
+		}
+		if got.ThumbnailFile == "" {
+			t.Errorf("video %s: expected the thumbnail to be found", v.id)
+		}
+	}
+}
+
+// TestFilenameMatch tests the --filename-match fallbacks when the recorded filename
+// isn't on disk
+func TestFilenameMatch(t *testing.T) {
+	collectionDir := filepath.Join(t.TempDir(), "favorites")
+	if err := os.MkdirAll(collectionDir, 0755); err != nil {
+		t.Fatalf("failed to create collection dir: %v", err)
+	}
+	files := map[string]string{
+		// Recorded as "20240101_111_old title.mp4", saved under another name with the ID
+		"20240101_111_old title.info.json": `{"id": "111", "title": "t", "filename": "favorites/20240101_111_old title.mp4"}`,
+		"clip-111 renamed.mp4":             "video",
+		// No .info.json at all, only the media file
+		"222.webm": "video",
+		// Another video whose ID contains 333 must not be taken for it
+		"20240103_333_x.info.json": `{"id": "333", "title": "t", "filename": "favorites/20240103_333_x.mp4"}`,
+		"video 93339.mp4":          "video",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(collectionDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	var entries []VideoEntry
+	for _, id := range []string{"111", "222", "333"} {
+		entries = append(entries, VideoEntry{Link: "https://www.tiktok.com/@user/video/" + id, Collection: "favorites"})
+	}
+
+	tests := []struct {
+		mode       string
+		downloaded []bool
+		filename   string // LocalFilename of video 111
+	}{
+		{FilenameMatchExact, []bool{false, false, false}, "20240101_111_old title.mp4"},
+		{FilenameMatchID, []bool{false, false, false}, "20240101_111_old title.mp4"},
+		{FilenameMatchFuzzy, []bool{true, true, false}, "clip-111 renamed.mp4"},
+	}
+	for _, tt := range tests {
+		enriched := enrichEntries(collectionDir, entries, nil, mustFindInfoFiles(t, collectionDir), nil, tt.mode)
+		for i, want := range tt.downloaded {
+			if enriched[i].Downloaded != want {
+				t.Errorf("%s: video %s: expected downloaded %v, got %v (%q)", tt.mode, enriched[i].VideoID, want, enriched[i].Downloaded, enriched[i].DownloadError)
+			}
+		}
+		if enriched[0].LocalFilename != tt.filename {
+			t.Errorf("%s: expected local filename %q, got %q", tt.mode, tt.filename, enriched[0].LocalFilename)
+		}
+		if tt.mode == FilenameMatchFuzzy && enriched[1].LocalFilename != "222.webm" {
+			t.Errorf("fuzzy: expected 222.webm for a video without metadata, got %q", enriched[1].LocalFilename)
+		}
+	}
+
+	// Even with fuzzy, the usual _<id>_ names beat a fuzzy match
+	if err := os.WriteFile(filepath.Join(collectionDir, "x_111_y.mp4"), []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := findMediaFile(collectionDir, "111", defaultMediaExtensions, FilenameMatchFuzzy); got != "x_111_y.mp4" {
+		t.Errorf("expected the _111_ name to be preferred, got %q", got)
+	}
+}
+
+// cannedOutputRunner returns fixed yt-dlp output lines
+type cannedOutputRunner struct {
+	lines []string
+}
+
+func (r *cannedOutputRunner) Run(name string, args ...string) (CapturedOutput, error) {
+	return CapturedOutput{Combined: r.lines}, nil
+}
+
+// TestSavedPaths tests collecting the files yt-dlp reports via --print into results and the index
+func TestSavedPaths(t *testing.T) {
+	collectionDir := filepath.Join(t.TempDir(), "favorites")
+	if err := os.MkdirAll(collectionDir, 0755); err != nil {
+		t.Fatalf("failed to create collection dir: %v", err)
+	}
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@a/video/111", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@b/video/222", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@c/video/333", Collection: "favorites"},
+	}
+	runner := &cannedOutputRunner{lines: []string{
+		"[download] Downloading item 1 of 3",
+		"[download] Destination: " + filepath.Join(collectionDir, "20240101_111_first.mp4"),
+		"[saved] 111 " + filepath.Join(collectionDir, "20240101_111_first.mp4"),
+		"[download] Downloading item 2 of 3",
+		`[saved] 222 C:\Users\me\favorites\20240102_222_second one.mkv`, // Path with spaces, written on Windows
+		"[download] Downloading item 3 of 3",
+		"ERROR: [TikTok] 333: Video unavailable",
+		"[saved] 444 NA",
+	}}
+	config := &Config{OrganizeByCollection: true, DisableResume: true}
+	result, err := runYtdlpWithRunner(runner, "", filepath.Join(collectionDir, "fav_videos.txt"), config, entries)
+	if err != nil {
+		t.Fatalf("runYtdlpWithRunner failed: %v", err)
+	}
+	expected := map[string]string{
+		"111": filepath.Join(collectionDir, "20240101_111_first.mp4"),
+		"222": `C:\Users\me\favorites\20240102_222_second one.mkv`,
+	}
+	if !reflect.DeepEqual(result.SavedFiles, expected) {
+		t.Errorf("unexpected saved files:\n got %v\nwant %v", result.SavedFiles, expected)
+	}
+
+	// Saved files survive merging chunk results
+	merged := mergeCollectionResults(nil, result)
+	merged = mergeCollectionResults(merged, &CollectionResult{SavedFiles: map[string]string{"333": "x.mp4"}})
+	if len(merged.SavedFiles) != 3 || len(result.SavedFiles) != 2 {
+		t.Errorf("expected merged saved files to combine without changing the input, got %v / %v", merged.SavedFiles, result.SavedFiles)
+	}
+
+	// The index uses the reported names, even when .info.json records another one
+	for name, content := range map[string]string{
+		"20240101_111_first.mp4":        "video",
+		"20240101_111_first.info.json":  `{"id": "111", "filename": "20240101_111_guessed.mp4"}`,
+		"20240102_222_second one.mkv":   "video",
+		"20240102_222_second.info.json": `{"id": "222"}`,
+	} {
+		if err := os.WriteFile(filepath.Join(collectionDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	indexed := enrichEntries(collectionDir, applySavedPaths(entries, result.SavedFiles), result.FailureDetails, mustFindInfoFiles(t, collectionDir), nil, FilenameMatchID)
+	wantFiles := []string{"20240101_111_first.mp4", "20240102_222_second one.mkv", ""}
+	for i, want := range wantFiles {
+		if indexed[i].LocalFilename != want || indexed[i].Downloaded != (want != "") {
+			t.Errorf("video %s: expected file %q (downloaded %v), got %q (downloaded %v)",
+				indexed[i].VideoID, want, want != "", indexed[i].LocalFilename, indexed[i].Downloaded)
+		}
+	}
+	if indexed[2].DownloadError != "Video unavailable" {
+		t.Errorf("expected the failure message for 333, got %q", indexed[2].DownloadError)
+	}
+
+	// Reports are kept off the console
+	var stdout bytes.Buffer
+	_ = processOutput(strings.NewReader(strings.Join(runner.lines, "\n")), strings.NewReader(""), &stdout, io.Discard, nil, nil)
+	if strings.Contains(stdout.String(), "[saved]") {
+		t.Errorf("expected [saved] lines to be hidden, got:\n%s", stdout.String())
+	}
+}
+
+func mustFindInfoFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := findInfoFiles(dir)
+	if err != nil {
+		t.Fatalf("findInfoFiles failed: %v", err)
+	}
+	return files
+}
+
+// TestMetadataArgs tests that the comment and subtitle flags reach yt-dlp
+func TestMetadataArgs(t *testing.T) {
+	entries := []VideoEntry{{Link: "https://www.tiktok.com/@a/video/111", Collection: "favorites"}}
+	tests := []struct {
+		name   string
+		config Config
+		want   []string
+	}{
+		{"none", Config{}, nil},
+		{"comments", Config{WriteComments: true}, []string{"--write-comments"}},
+		{"subtitles with languages", Config{WriteSubs: true, WriteAutoSubs: true, SubLangs: "en,de"}, []string{"--write-subs", "--write-auto-subs", "--sub-langs", "en,de"}},
+		{"comments and auto captions", Config{WriteComments: true, WriteAutoSubs: true}, []string{"--write-comments", "--write-auto-subs"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.DisableResume = true
+			runner := &MockCommandRunner{}
+			if _, err := runYtdlpWithRunner(runner, "", "test_videos.txt", &config, entries); err != nil {
+				t.Fatalf("runYtdlpWithRunner failed: %v", err)
+			}
+			args := runner.Commands[0].Args
+			for _, flagName := range []string{"--write-comments", "--write-subs", "--write-auto-subs", "--sub-langs"} {
+				if slices.Contains(args, flagName) != slices.Contains(tt.want, flagName) {
+					t.Errorf("%s: expected present=%v in %v", flagName, slices.Contains(tt.want, flagName), args)
+				}
+			}
+			if got := metadataArgs(&config); !slices.Equal(got, tt.want) {
+				t.Errorf("metadataArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// --sub-langs is validated
+	if err := validateSubtitleFlags("en", false, false); err == nil {
+		t.Error("expected --sub-langs without a subtitle flag to be rejected")
+	}
+	if err := validateSubtitleFlags("en,,de", true, false); err == nil {
+		t.Error("expected an empty --sub-langs entry to be rejected")
+	}
+	if err := validateSubtitleFlags("en.*,-live_chat", false, true); err != nil {
+		t.Errorf("expected regex and exclusion entries to be accepted, got %v", err)
+	}
+}
+
+// TestFilterByIDs tests --include-ids-file and --exclude-ids-file
+func TestFilterByIDs(t *testing.T) {
+	tmpDir := t.TempDir()
+	includeFile := filepath.Join(tmpDir, "include.txt")
+	excludeFile := filepath.Join(tmpDir, "exclude.txt")
+	include := "# curated\n111\nhttps://www.tiktok.com/@b/video/222?lang=en\n\ntiktok 333\n"
+	exclude := "222\n"
+	if err := os.WriteFile(includeFile, []byte(include), 0644); err != nil {
+		t.Fatalf("failed to write include list: %v", err)
+	}
+	if err := os.WriteFile(excludeFile, []byte(exclude), 0644); err != nil {
+		t.Fatalf("failed to write exclude list: %v", err)
+	}
+
+	includeIDs, err := parseIDsFile(includeFile)
+	if err != nil {
+		t.Fatalf("parseIDsFile failed: %v", err)
+	}
+	if !reflect.DeepEqual(includeIDs, map[string]bool{"111": true, "222": true, "333": true}) {
+		t.Errorf("unexpected include IDs: %v", includeIDs)
+	}
+	excludeIDs, err := parseIDsFile(excludeFile)
+	if err != nil {
+		t.Fatalf("parseIDsFile failed: %v", err)
+	}
+
+	entries := []VideoEntry{
+		{Link: "https://www.tiktokv.com/share/video/111/"},
+		{Link: "https://www.tiktokv.com/share/video/222/"},
+		{Link: "https://www.tiktokv.com/share/video/333/"},
+		{Link: "https://www.tiktokv.com/share/video/444/"}, // In neither list
+		{Link: "https://vm.tiktok.com/ZMabc/"},             // No parseable ID
+	}
+	links := func(entries []VideoEntry) []string {
+		var out []string
+		for _, e := range entries {
+			out = append(out, e.Link)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name             string
+		include, exclude map[string]bool
+		wantKept         []int
+	}{
+		{"include only", includeIDs, nil, []int{0, 1, 2}},
+		{"exclude only", nil, excludeIDs, []int{0, 2, 3, 4}},
+		{"both", includeIDs, excludeIDs, []int{0, 2}},
+		{"neither", nil, nil, []int{0, 1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, dropped := filterByIDs(entries, tt.include, tt.exclude)
+			var want []VideoEntry
+			for _, i := range tt.wantKept {
+				want = append(want, entries[i])
+			}
+			if !slices.Equal(links(kept), links(want)) || dropped != len(entries)-len(want) {
+				t.Errorf("kept %v (dropped %d), want %v", links(kept), dropped, links(want))
+			}
+		})
+	}
+
+	// Lines that aren't IDs are reported with their line number
+	badFile := filepath.Join(tmpDir, "bad.txt")
+	_ = os.WriteFile(badFile, []byte("111\nnot-an-id\n"), 0644)
+	if _, err := parseIDsFile(badFile); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error for line 2, got %v", err)
+	}
+	if _, err := parseIDsFile(filepath.Join(tmpDir, "missing.txt")); err == nil {
+		t.Error("expected an error for a missing ID list")
+	}
+}
+
+// TestMaxFilesize tests that --max-filesize is validated and only forwarded when set
+func TestMaxFilesize(t *testing.T) {
+	entries := []VideoEntry{{Link: "https://www.tiktok.com/@a/video/111", Collection: "favorites"}}
+	for _, size := range []string{"", "100M"} {
+		runner := &MockCommandRunner{}
+		config := &Config{DisableResume: true, MaxFilesize: size}
+		if _, err := runYtdlpWithRunner(runner, "", "test_videos.txt", config, entries); err != nil {
+			t.Fatalf("runYtdlpWithRunner failed: %v", err)
+		}
+		args := runner.Commands[0].Args
+		i := slices.Index(args, "--max-filesize")
+		switch {
+		case size == "" && i >= 0:
+			t.Errorf("expected no --max-filesize without a limit, got %v", args)
+		case size != "" && (i < 0 || i+1 >= len(args) || args[i+1] != size):
+			t.Errorf("expected --max-filesize %s in %v", size, args)
+		}
+	}
+
+	for _, valid := range []string{"", "500k", "100M", "1.5G", "2g", "1048576"} {
+		if err := validateMaxFilesize(valid); err != nil {
+			t.Errorf("expected %q to be accepted, got %v", valid, err)
+		}
+	}
+	for _, invalid := range []string{"100MB", "M", "-5M", "1.M", "100 M", "ten"} {
+		if err := validateMaxFilesize(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+// TestOutputTemplatePerSource tests that each source's run gets its own --output template
+func TestOutputTemplatePerSource(t *testing.T) {
+	templates := outputTemplateMap{}
+	for _, value := range []string{"liked=liked/%(title)s.%(ext)s", " Favorites = %(id)s.%(ext)s"} {
+		if err := templates.Set(value); err != nil {
+			t.Fatalf("Set(%q) failed: %v", value, err)
+		}
+	}
+	for _, invalid := range []string{"liked", "=%(id)s.%(ext)s", "liked=", "liked=%(id)s", "liked=%(id)s.%(ext)s"} {
+		if err := templates.Set(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+
+	favorites := []VideoEntry{{Link: "https://www.tiktok.com/@a/video/111", Collection: "favorites"}}
+	liked := []VideoEntry{{Link: "https://www.tiktok.com/@b/video/222", Collection: "liked"}}
+	other := []VideoEntry{{Link: "https://www.tiktok.com/@c/video/333", Collection: "bookmarks"}}
+	mixed := append(append([]VideoEntry{}, favorites...), liked...)
+
+	tests := []struct {
+		name       string
+		organize   bool
+		outputName string
+		entries    []VideoEntry
+		want       string
+	}{
+		{"flat favorites", false, "favorites.txt", favorites, "%(id)s.%(ext)s"},
+		{"flat liked", false, "liked.txt", liked, filepath.Join("liked", "%(title)s.%(ext)s")},
+		{"source without template", false, "bookmarks.txt", other, defaultOutputTemplate},
+		{"mixed sources", false, "videos.txt", mixed, defaultOutputTemplate},
+		{"organized liked", true, filepath.Join("liked", "liked_videos.txt"), liked, filepath.Join("liked", "liked", "%(title)s.%(ext)s")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &MockCommandRunner{}
+			config := &Config{DisableResume: true, OrganizeByCollection: tt.organize, OutputTemplates: templates}
+			if _, err := runYtdlpWithRunner(runner, "", tt.outputName, config, tt.entries); err != nil {
+				t.Fatalf("runYtdlpWithRunner failed: %v", err)
+			}
+			args := runner.Commands[0].Args
+			i := slices.Index(args, "--output")
+			if i < 0 || i+1 >= len(args) || args[i+1] != tt.want {
+				t.Errorf("expected --output %s, got %v", tt.want, args)
+			}
+		})
+	}
+}
+
+// TestSeparateRuns tests that --separate-runs gives each source its own yt-dlp run,
+// output directory and results.txt
+func TestSeparateRuns(t *testing.T) {
+	tmpDir := t.TempDir()
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@a/video/111", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@b/video/222", Collection: "liked"},
+		{Link: "https://www.tiktok.com/@c/video/333", Collection: "favorites"},
+	}
+	config := &Config{DisableResume: true, SplitBySource: true, SeparateRuns: true, WorkDir: tmpDir}
+
+	runs, err := separateSourceRuns(config, entries)
+	if err != nil {
+		t.Fatalf("separateSourceRuns failed: %v", err)
+	}
+	runner := &MockCommandRunner{}
+	for _, run := range runs {
+		result, err := runYtdlpWithRunner(runner, "", run.file, run.config, run.entries)
+		if err != nil {
+			t.Fatalf("runYtdlpWithRunner failed for %s: %v", run.source, err)
+		}
+		if result.Name != run.source {
+			t.Errorf("expected result named %s, got %s", run.source, result.Name)
+		}
+		if err := writeSourceResults(run, result, time.Now()); err != nil {
+			t.Fatalf("writeSourceResults failed for %s: %v", run.source, err)
+		}
+	}
+
+	if len(runner.Commands) != 2 {
+		t.Fatalf("expected 2 yt-dlp runs, got %d", len(runner.Commands))
+	}
+	wantLinks := map[string]string{
+		"favorites": "https://www.tiktok.com/@a/video/111\nhttps://www.tiktok.com/@c/video/333\n",
+		"liked":     "https://www.tiktok.com/@b/video/222\n",
+	}
+	for i, source := range []string{"favorites", "liked"} {
+		dir := filepath.Join(tmpDir, source)
+		listFile := filepath.Join(dir, source+".txt")
+		args := runner.Commands[i].Args
+		if j := slices.Index(args, "-a"); j < 0 || args[j+1] != listFile {
+			t.Errorf("run %d: expected -a %s, got %v", i, listFile, args)
+		}
+		if j := slices.Index(args, "--output"); j < 0 || filepath.Dir(args[j+1]) != dir {
+			t.Errorf("run %d: expected output in %s, got %v", i, dir, args)
+		}
+		data, err := os.ReadFile(listFile)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", listFile, err)
+		}
+		if string(data) != wantLinks[source] {
+			t.Errorf("%s: expected %q, got %q", listFile, wantLinks[source], data)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "results.txt")); err != nil {
+			t.Errorf("expected results.txt for %s: %v", source, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "results.txt")); err == nil {
+		t.Error("expected no combined results.txt from the per-source runs")
+	}
+}
+
+// TestCountOnly tests that --count-only writes nothing but the number of URLs
+func TestCountOnly(t *testing.T) {
+	tmpDir := t.TempDir()
+	exportFile := filepath.Join(tmpDir, "user_data_tiktok.json")
+	fixture := `{
+		"Likes and Favorites": {
+			"Favorite Videos": {"FavoriteVideoList": [
+				{"Link": "https://www.tiktokv.com/share/video/111/", "Date": "2024-01-01 10:00:00"},
+				{"Link": "https://www.tiktokv.com/share/video/222/", "Date": "2024-01-02 10:00:00"}
+			]},
+			"Like List": {"ItemFavoriteList": [
+				{"link": "https://www.tiktokv.com/share/video/333/", "date": "2024-01-03 10:00:00"}
+			]}
+		}
+	}`
+	if err := os.WriteFile(exportFile, []byte(fixture), 0644); err != nil {
+		t.Fatalf("failed to write export: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := runCountOnly(&Config{JSONFile: exportFile}, tmpDir, &out); err != nil {
+		t.Fatalf("runCountOnly failed: %v", err)
+	}
+	if out.String() != "3\n" {
+		t.Errorf("expected just \"3\\n\", got %q", out.String())
+	}
+
+	brokenFile := filepath.Join(tmpDir, "broken.json")
+	if err := os.WriteFile(brokenFile, []byte("{not json"), 0644); err != nil {
+		t.Fatalf("failed to write export: %v", err)
+	}
+	out.Reset()
+	if err := runCountOnly(&Config{JSONFile: brokenFile}, tmpDir, &out); !errors.Is(err, ErrJSONParse) {
+		t.Errorf("expected ErrJSONParse, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected no output when parsing fails, got %q", out.String())
+	}
+
+	if !isCountOnly([]string{"--count-only", "export.json"}) || isCountOnly([]string{"--count-only=false"}) {
+		t.Error("isCountOnly did not detect the flag correctly")
+	}
+}
+
+// TestNDJSON tests the --ndjson output line by line
+func TestNDJSON(t *testing.T) {
+	tmpDir := t.TempDir()
+	exportFile := filepath.Join(tmpDir, "user_data_tiktok.json")
+	fixture := `{
+		"Likes and Favorites": {
+			"Favorite Videos": {"FavoriteVideoList": [
+				{"Link": "https://www.tiktokv.com/share/video/111/?a=1&b=2", "Date": "2024-01-01 10:00:00"},
+				{"Link": "https://www.tiktokv.com/share/video/222/", "Date": "2024-01-02 10:00:00"}
+			]},
+			"Like List": {"ItemFavoriteList": [
+				{"link": "https://www.tiktokv.com/share/video/333/", "date": "2024-01-03 10:00:00"}
+			]}
+		}
+	}`
+	if err := os.WriteFile(exportFile, []byte(fixture), 0644); err != nil {
+		t.Fatalf("failed to write export: %v", err)
+	}
+
+	var out bytes.Buffer
+	config := &Config{JSONFile: exportFile}
+	if err := runNDJSON(config, tmpDir, &out); err != nil {
+		t.Fatalf("runNDJSON failed: %v", err)
+	}
+
+	expected := []NDJSONEntry{
+		{URL: "https://www.tiktokv.com/share/video/111/?a=1&b=2", Source: "favorites", Date: "2024-01-01 10:00:00"},
+		{URL: "https://www.tiktokv.com/share/video/222/", Source: "favorites", Date: "2024-01-02 10:00:00"},
+		{URL: "https://www.tiktokv.com/share/video/333/", Source: "liked", Date: "2024-01-03 10:00:00"},
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d NDJSON lines, got %d:\n%s", len(expected), len(lines), out.String())
+	}
+	for i, line := range lines {
+		var got NDJSONEntry
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&got); err != nil {
+			t.Errorf("line %d is not a valid entry: %v (%s)", i+1, err, line)
+			continue
+		}
+		if got != expected[i] {
+			t.Errorf("line %d: got %+v, want %+v", i+1, got, expected[i])
+		}
+	}
+	if strings.Contains(out.String(), `\u0026`) {
+		t.Errorf("expected URLs without HTML escaping, got %s", out.String())
+	}
+
+	for args, want := range map[string]bool{
+		"--ndjson":                    true,
+		"-ndjson export.json":         true,
+		"--ndjson=false":              false,
+		"--flat-structure":            false,
+		"-- --ndjson":                 false,
+		"--index-only --ndjson=true":  true,
+		"export.json --no-thumbnails": false,
+	} {
+		if got := isNDJSON(strings.Fields(args)); got != want {
+			t.Errorf("isNDJSON(%q) = %v, want %v", args, got, want)
+		}
+	}
+}
+
+// TestClean tests that the clean command removes generated files but not the videos
+func TestClean(t *testing.T) {
+	tmpDir := t.TempDir()
+	artifacts := []string{
+		"fav_videos.txt",
+		"fav_videos_001.txt",
+		"fav_videos.txt.progress.json",
+		"results.txt",
+		"summary.json",
+		"unavailable.json",
+		"index.html",
+		"index.json",
+		"download_archive.txt",
+		"run-20240101-120000.log",
+		"merged.mp4.concat.txt",
+		filepath.Join("liked", "liked_videos.txt"),
+		filepath.Join("liked", "liked_videos.txt.partial.txt"),
+		filepath.Join("liked", "mapping.json"),
+	}
+	kept := []string{
+		"20240101_111_clip.mp4",
+		"20240101_111_clip.info.json",
+		"20240101_111_clip.jpg",
+		"user_data_tiktok.json",
+		"tiktok-favvideo-downloader.json",
+		"notes.txt",
+		filepath.Join("liked", "20240102_222_other.mp4"),
+	}
+	seed := func() {
+		for _, name := range append(append([]string{}, artifacts...), kept...) {
+			path := filepath.Join(tmpDir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("failed to create dir for %s: %v", name, err)
+			}
+			if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+				t.Fatalf("failed to write %s: %v", name, err)
+			}
+		}
+	}
+	seed()
+
+	// Declining the prompt deletes nothing
+	var out bytes.Buffer
+	removed, err := runClean(tmpDir, false, strings.NewReader("\n"), &out)
+	if err != nil {
+		t.Fatalf("runClean failed: %v", err)
+	}
+	if removed != 0 || !fileExists(filepath.Join(tmpDir, "results.txt")) {
+		t.Errorf("expected nothing removed without confirmation, removed %d", removed)
+	}
+	if !strings.Contains(out.String(), filepath.Join(tmpDir, "liked", "mapping.json")) {
+		t.Errorf("expected the files to be listed before confirming, got:\n%s", out.String())
+	}
+
+	check := func(removed int) {
+		t.Helper()
+		if removed != len(artifacts) {
+			t.Errorf("expected %d files removed, got %d", len(artifacts), removed)
+		}
+		for _, name := range artifacts {
+			if fileExists(filepath.Join(tmpDir, name)) {
+				t.Errorf("expected %s to be removed", name)
+			}
+		}
+		for _, name := range kept {
+			if !fileExists(filepath.Join(tmpDir, name)) {
+				t.Errorf("expected %s to be kept", name)
+			}
+		}
+	}
+
+	removed, err = runClean(tmpDir, false, strings.NewReader("y\n"), &out)
+	if err != nil {
+		t.Fatalf("runClean failed: %v", err)
+	}
+	check(removed)
+
+	seed()
+	removed, err = runClean(tmpDir, true, strings.NewReader(""), &out)
+	if err != nil {
+		t.Fatalf("runClean --force failed: %v", err)
+	}
+	check(removed)
+
+	if force, dir := parseCleanArgs([]string{"--force", "downloads"}); !force || dir != "downloads" {
+		t.Errorf("parseCleanArgs = %v, %q; want true, \"downloads\"", force, dir)
+	}
+}
+
+// TestSortChronological tests that --order chronological merges sources by date
+func TestSortChronological(t *testing.T) {
+	entries := []VideoEntry{
+		{Link: "https://www.tiktokv.com/share/video/3/", Date: "2024-03-01 09:00:00", Collection: "favorites"},
+		{Link: "https://www.tiktokv.com/share/video/1/", Date: "2024-01-01 09:00:00", Collection: "favorites"},
+		{Link: "https://www.tiktokv.com/share/video/9/", Date: "", Collection: "favorites"},
+		{Link: "https://www.tiktokv.com/share/video/4/", Date: "2024-04-01 09:00:00", Collection: "liked"},
+		{Link: "https://www.tiktokv.com/share/video/2/", Date: "2024-02-01 09:00:00", Collection: "liked"},
+		{Link: "https://www.tiktokv.com/share/video/8/", Date: "not a date", Collection: "liked"},
+		{Link: "https://www.tiktokv.com/share/video/5/", Date: "2024-02-01 09:00:00", Collection: "favorites"},
+	}
+
+	var got []string
+	for _, entry := range applyEntryFilters(&Config{Order: OrderChronological}, entries) {
+		got = append(got, extractVideoID(entry.Link))
+	}
+	// Equal dates and undated entries keep their export order
+	want := []string{"1", "2", "5", "3", "4", "9", "8"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("chronological order = %v, want %v", got, want)
+	}
+
+	original := applyEntryFilters(&Config{Order: OrderOriginal}, entries)
+	if !reflect.DeepEqual(original, entries) {
+		t.Error("expected --order original to keep the export order")
+	}
+	if entries[0].Date != "2024-03-01 09:00:00" {
+		t.Error("expected the input slice to be left untouched")
+	}
+}
+
+// TestProfileSavedVideos tests extraction of saved videos from the Profile section
+// used by newer exports
+func TestProfileSavedVideos(t *testing.T) {
+	fixture := `{
+		"Likes and Favorites": {
+			"Favorite Videos": {"FavoriteVideoList": [
+				{"Link": "https://www.tiktokv.com/share/video/111/", "Date": "2023-01-01 10:00:00"}
+			]},
+			"Like List": {"ItemFavoriteList": [
+				{"date": "2023-02-01 10:00:00", "link": "https://www.tiktokv.com/share/video/222/"}
+			]}
+		},
+		"Profile": {
+			"Saved Videos": {"SavedVideoList": [
+				{"Link": "https://www.tiktokv.com/share/video/333/", "Date": "2025-06-01 09:30:00"},
+				{"Link": "https://www.tiktokv.com/share/video/444/", "Date": "2025-06-02 18:45:00"}
+			]}
+		}
+	}`
+
+	entries, err := parseFavoriteVideos(strings.NewReader(fixture), true, nil)
+	if err != nil {
+		t.Fatalf("parseFavoriteVideos failed: %v", err)
+	}
+	want := []VideoEntry{
+		{Link: "https://www.tiktokv.com/share/video/111/", Date: "2023-01-01 10:00:00", Collection: "favorites"},
+		{Link: "https://www.tiktokv.com/share/video/333/", Date: "2025-06-01 09:30:00", Collection: "saved"},
+		{Link: "https://www.tiktokv.com/share/video/444/", Date: "2025-06-02 18:45:00", Collection: "saved"},
+		{Link: "https://www.tiktokv.com/share/video/222/", Date: "2023-02-01 10:00:00", Collection: "liked"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("expected %+v, got %+v", want, entries)
+	}
+
+	// Saved videos are favorites, so they don't depend on --include-liked
+	entries, err = parseFavoriteVideos(strings.NewReader(fixture), false, nil)
+	if err != nil {
+		t.Fatalf("parseFavoriteVideos failed: %v", err)
+	}
+	if len(entries) != 3 || entries[2].Collection != "saved" {
+		t.Errorf("expected favorites and saved videos without liked, got %+v", entries)
+	}
+	if got := getOutputFilename("saved"); got != "saved_videos.txt" {
+		t.Errorf("expected saved_videos.txt, got %s", got)
+	}
+
+	// An export with only saved videos still has favorites to download
+	tmpDir := t.TempDir()
+	savedOnly := filepath.Join(tmpDir, "saved_only.json")
+	content := `{"Profile": {"Saved Videos": {"SavedVideoList": [{"Link": "https://www.tiktokv.com/share/video/333/"}]}}}`
+	if err := os.WriteFile(savedOnly, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write export: %v", err)
+	}
+	sections, err := inspectExport(savedOnly, nil)
+	if err != nil {
+		t.Fatalf("inspectExport failed: %v", err)
+	}
+	if !sections.HasSaved || !sections.favorites() {
+		t.Errorf("expected saved videos to count as favorites, got %+v", sections)
+	}
+	if notice := sections.notice(); strings.Contains(notice, "Favorite Videos") {
+		t.Errorf("expected no missing-favorites notice, got %q", notice)
+	}
+}
+
+// TestBrowsingHistory tests that history videos carry their watch time and can be
+// filtered by it
+func TestBrowsingHistory(t *testing.T) {
+	tmpDir := t.TempDir()
+	exportFile := filepath.Join(tmpDir, "user_data_tiktok.json")
+	fixture := `{
+		"Likes and Favorites": {
+			"Favorite Videos": {"FavoriteVideoList": [
+				{"Link": "https://www.tiktokv.com/share/video/111/", "Date": "2023-01-01 10:00:00"}
+			]}
+		},
+		"Your Activity": {
+			"Video Browsing History": {"VideoList": [
+				{"Date": "2024-05-20 21:15:00", "Link": "https://www.tiktokv.com/share/video/222/"},
+				{"Date": "2024-03-02 08:00:00", "Link": "https://www.tiktokv.com/share/video/333/"},
+				{"Date": "", "Link": "https://www.tiktokv.com/share/video/444/"}
+			]}
+		}
+	}`
+	if err := os.WriteFile(exportFile, []byte(fixture), 0644); err != nil {
+		t.Fatalf("failed to write export: %v", err)
+	}
+
+	entries, err := loadExportEntries(&Config{JSONFile: exportFile}, false)
+	if err != nil {
+		t.Fatalf("loadExportEntries failed: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the favorite without --include-history, got %d entries", len(entries))
+	}
+
+	config := &Config{JSONFile: exportFile, IncludeHistory: true}
+	entries, err = loadExportEntries(config, false)
+	if err != nil {
+		t.Fatalf("loadExportEntries failed: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(entries))
+	}
+	if entries[0].WatchedAt != "" || entries[0].Collection != "favorites" {
+		t.Errorf("expected the favorite first without a watch time, got %+v", entries[0])
+	}
+	history := entries[1]
+	if history.Collection != "history" || history.WatchedAt != "2024-05-20 21:15:00" || history.Date != "" {
+		t.Errorf("expected a history entry watched at 2024-05-20 21:15:00, got %+v", history)
+	}
+
+	// Only the video watched after the cutoff (plus the favorite and the undated entry) remain
+	config.WatchedSince = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	var got []string
+	for _, entry := range applyEntryFilters(config, entries) {
+		got = append(got, extractVideoID(entry.Link))
+	}
+	if want := []string{"111", "222", "444"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after --watched-since got %v, want %v", got, want)
+	}
+
+	data, err := json.Marshal(history)
+	if err != nil {
+		t.Fatalf("failed to marshal entry: %v", err)
+	}
+	if !strings.Contains(string(data), `"watched_at":"2024-05-20 21:15:00"`) {
+		t.Errorf("expected watched_at in the index JSON, got %s", data)
+	}
+	if getOutputFilename("history") != "history_videos.txt" {
+		t.Errorf("expected history_videos.txt for the history collection, got %s", getOutputFilename("history"))
+	}
+
+	// A Custom export with only the browsing history is enough with --include-history
+	historyOnly := filepath.Join(tmpDir, "history_only.json")
+	content := `{"Your Activity": {"Video Browsing History": {"VideoList": [{"Date": "2024-05-20 21:15:00", "Link": "https://www.tiktokv.com/share/video/222/"}]}}}`
+	if err := os.WriteFile(historyOnly, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write export: %v", err)
+	}
+	sections, err := inspectExport(historyOnly, nil)
+	if err != nil || !sections.HasHistory || sections.favorites() || sections.HasLiked {
+		t.Errorf("expected only the browsing history to be detected, got %+v (err %v)", sections, err)
+	}
+	if got, err := detectExportSections(historyOnly, nil, true); err != nil || !got.HasHistory {
+		t.Errorf("expected the history-only export to be accepted, got %+v (err %v)", got, err)
+	}
+	if _, err := detectExportSections(historyOnly, nil, false); err == nil || !strings.Contains(err.Error(), "neither") {
+		t.Errorf("expected the history-only export to be rejected without --include-history, got %v", err)
+	}
+	entries, err = loadExportEntries(&Config{JSONFile: historyOnly, IncludeHistory: true}, false)
+	if err != nil || len(entries) != 1 || entries[0].Collection != "history" {
+		t.Errorf("expected the single history entry, got %+v (err %v)", entries, err)
+	}
+}
+
+// noNetworkRoundTripper fails the test on any HTTP request
+type noNetworkRoundTripper struct {
+	t *testing.T
+}
+
+func (n noNetworkRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	n.t.Errorf("unexpected network request to %s", req.URL)
+	return nil, fmt.Errorf("network disabled in test")
+}
+
+// TestYtdlpPath tests that --ytdlp-path is validated and used without going online
+func TestYtdlpPath(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldCwd, _ := os.Getwd()
+	defer func() { _ = os.Chdir(oldCwd) }()
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("failed to chdir to temp dir: %v", err)
+	}
+
+	ytdlpPath := filepath.Join(tmpDir, "tools", "yt-dlp")
+	if err := os.MkdirAll(filepath.Dir(ytdlpPath), 0755); err != nil {
+		t.Fatalf("failed to create tools dir: %v", err)
+	}
+	if err := os.WriteFile(ytdlpPath, []byte("bundled exe"), 0755); err != nil {
+		t.Fatalf("failed to write yt-dlp: %v", err)
+	}
+
+	client := &http.Client{Transport: noNetworkRoundTripper{t}}
+	config := &Config{YtdlpPath: ytdlpPath, DisableResume: true}
+	runner := &MockCommandRunner{}
+	if err := prepareYtdlp(context.Background(), client, runner, config); err != nil {
+		t.Fatalf("prepareYtdlp failed: %v", err)
+	}
+	if len(runner.Commands) != 1 || runner.Commands[0].Name != ytdlpPath || !slices.Equal(runner.Commands[0].Args, []string{"--version"}) {
+		t.Errorf("expected the given binary to be test-run with --version, got %+v", runner.Commands)
+	}
+	if _, err := os.Stat("yt-dlp.exe"); !os.IsNotExist(err) {
+		t.Error("expected no yt-dlp.exe to be downloaded")
+	}
+
+	// The download run uses the given binary too
+	runner = &MockCommandRunner{}
+	entries := []VideoEntry{{Link: "https://www.tiktok.com/@a/video/111", Collection: "favorites"}}
+	if _, err := runYtdlpWithRunner(runner, ".\\", "fav_videos.txt", config, entries); err != nil {
+		t.Fatalf("runYtdlpWithRunner failed: %v", err)
+	}
+	if runner.Commands[0].Name != ytdlpPath {
+		t.Errorf("expected yt-dlp to run as %s, got %s", ytdlpPath, runner.Commands[0].Name)
+	}
+
+	for name, path := range map[string]string{
+		"missing":   filepath.Join(tmpDir, "nope.exe"),
+		"directory": filepath.Dir(ytdlpPath),
+	} {
+		if err := prepareYtdlp(context.Background(), client, &MockCommandRunner{}, &Config{YtdlpPath: path}); err == nil {
+			t.Errorf("%s: expected an error for --ytdlp-path %s", name, path)
+		}
+	}
+	if err := prepareYtdlp(context.Background(), client, &MockCommandRunner{ShouldFail: true}, config); err == nil {
+		t.Error("expected an error when the binary fails to run")
+	}
+}
+
+// TestWriteLauncher tests the launcher script content and permissions per platform
+func TestWriteLauncher(t *testing.T) {
+	tmpDir := t.TempDir()
+	listFile := filepath.Join(tmpDir, "my videos", "fav_videos.txt")
+	config := &Config{OrganizeByCollection: true}
+	args := suggestedYtdlpArgs(config, listFile, nil)
+	if want := filepath.Join(tmpDir, "my videos", defaultOutputTemplate); args[4] != want {
+		t.Errorf("expected output template %s, got %s", want, args[4])
+	}
+	if !filepath.IsAbs(args[0]) || filepath.Base(args[0]) != "yt-dlp.exe" {
+		t.Errorf("expected an absolute yt-dlp.exe path, got %s", args[0])
+	}
+	commands := [][]string{{`C:\tools\yt-dlp.exe`, "-a", `C:\my videos\fav_videos.txt`, "--output", `C:\my videos\%(id)s.%(ext)s`, "--write-info-json"}}
+
+	t.Run("windows", func(t *testing.T) {
+		path := filepath.Join(tmpDir, launcherFilename("windows"))
+		if err := writeLauncher(path, "windows", commands); err != nil {
+			t.Fatalf("writeLauncher failed: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read launcher: %v", err)
+		}
+		want := "@echo off\r\n" +
+			"rem Re-runs the yt-dlp download suggested by tiktok-favvideo-downloader\r\n" +
+			`C:\tools\yt-dlp.exe -a "C:\my videos\fav_videos.txt" --output "C:\my videos\%%(id)s.%%(ext)s" --write-info-json` + "\r\n" +
+			"pause\r\n"
+		if string(data) != want {
+			t.Errorf("unexpected .bat content:\n%s\nwant:\n%s", data, want)
+		}
+	})
+
+	t.Run("posix", func(t *testing.T) {
+		path := filepath.Join(tmpDir, launcherFilename("linux"))
+		// An existing non-executable launcher is made executable on rewrite
+		if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+			t.Fatalf("failed to write old launcher: %v", err)
+		}
+		posixCommands := [][]string{{"/opt/yt-dlp", "-a", "/home/me/it's mine/fav_videos.txt", "--output", "/home/me/%(id)s.%(ext)s"}}
+		if err := writeLauncher(path, "linux", posixCommands); err != nil {
+			t.Fatalf("writeLauncher failed: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read launcher: %v", err)
+		}
+		want := "#!/bin/sh\n" +
+			"# Re-runs the yt-dlp download suggested by tiktok-favvideo-downloader\n" +
+			`/opt/yt-dlp -a '/home/me/it'\''s mine/fav_videos.txt' --output '/home/me/%(id)s.%(ext)s'` + "\n"
+		if string(data) != want {
+			t.Errorf("unexpected .sh content:\n%s\nwant:\n%s", data, want)
+		}
+		if runtime.GOOS != "windows" {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("failed to stat launcher: %v", err)
+			}
+			if info.Mode().Perm() != 0755 {
+				t.Errorf("expected the .sh launcher to be executable (0755), got %v", info.Mode().Perm())
+			}
+		}
+	})
+}
+
+// TestWriteYtdlpConfig tests that --write-ytdlp-conf records each selected option
+func TestWriteYtdlpConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+	listFile := filepath.Join(tmpDir, "favorites", "fav_videos.txt")
+	if err := os.MkdirAll(filepath.Dir(listFile), 0755); err != nil {
+		t.Fatalf("failed to create collection dir: %v", err)
+	}
+	config := &Config{
+		OrganizeByCollection: true,
+		OutputTemplates:      map[string]string{"favorites": "%(uploader)s/%(id)s.%(ext)s"},
+		CookieFile:           "cookies.txt",
+		MaxResolution:        1080,
+		MaxFilesize:          "50M",
+		ConcurrentFragments:  4,
+		ExtraArgs:            []string{"--limit-rate", "2M"},
+	}
+	entries := []VideoEntry{{Link: "https://www.tiktokv.com/share/video/111/", Collection: "favorites"}}
+
+	confPath := ytdlpConfigFilename(listFile)
+	if want := filepath.Join(tmpDir, "favorites", "fav_videos.yt-dlp.conf"); confPath != want {
+		t.Errorf("expected config %s, got %s", want, confPath)
+	}
+	if err := writeYtdlpConfig(confPath, ytdlpConfigArgs(config, listFile, entries)); err != nil {
+		t.Fatalf("writeYtdlpConfig failed: %v", err)
+	}
+	data, err := os.ReadFile(confPath)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	conf := string(data)
+
+	for _, line := range []string{
+		"--batch-file " + quoteShellArg(listFile),
+		"--output " + quoteShellArg(filepath.Join(tmpDir, "favorites", "%(uploader)s/%(id)s.%(ext)s")),
+		"--write-info-json",
+		"--write-thumbnail",
+		"--convert-thumbnails jpg",
+		"--cookies cookies.txt",
+		"-S " + quoteShellArg(buildResolutionSort(0, 1080)),
+		"--concurrent-fragments 4",
+		"--max-filesize 50M",
+		"--download-archive " + quoteShellArg(filepath.Join(tmpDir, "favorites", "download_archive.txt")),
+		"--no-overwrites",
+		"--limit-rate 2M",
+	} {
+		if !strings.Contains(conf, "\n"+line+"\n") {
+			t.Errorf("expected line %q in config:\n%s", line, conf)
+		}
+	}
+	if strings.Contains(conf, "--print") {
+		t.Errorf("expected no internal --print option in config:\n%s", conf)
+	}
+	if !isGeneratedArtifact(filepath.Base(confPath)) {
+		t.Errorf("expected %s to be cleaned as a generated artifact", filepath.Base(confPath))
+	}
+
+	// Options left at their defaults are left out
+	config = &Config{SkipThumbnails: true, DisableResume: true}
+	args := ytdlpConfigArgs(config, listFile, entries)
+	for _, arg := range args {
+		if arg == "--write-thumbnail" || arg == "--download-archive" || arg == "-S" {
+			t.Errorf("unexpected option %s in %v", arg, args)
+		}
+	}
+}
+
+// recordingClipboard remembers the text copied to it
+type recordingClipboard struct {
+	text string
+}
+
+func (c *recordingClipboard) WriteText(text string) error {
+	c.text = text
+	return nil
+}
+
+// TestPrintCommand tests that --print-command prints only the quoted yt-dlp command
+// line for each list it writes
+func TestPrintCommand(t *testing.T) {
+	args := []string{`C:\tools\yt-dlp.exe`, "-a", `C:\my videos\fav_videos.txt`, "--output", `C:\my videos\%(id)s.%(ext)s`, "--write-info-json"}
+	if got, want := commandLine("windows", args), `C:\tools\yt-dlp.exe -a "C:\my videos\fav_videos.txt" --output "C:\my videos\%(id)s.%(ext)s" --write-info-json`; got != want {
+		t.Errorf("windows command line:\n got %s\nwant %s", got, want)
+	}
+	args = []string{"/opt/yt-dlp", "-a", "/home/me/it's mine/fav_videos.txt", "--output", "/home/me/%(id)s.%(ext)s"}
+	if got, want := commandLine("linux", args), `/opt/yt-dlp -a '/home/me/it'\''s mine/fav_videos.txt' --output '/home/me/%(id)s.%(ext)s'`; got != want {
+		t.Errorf("posix command line:\n got %s\nwant %s", got, want)
+	}
+
+	tmpDir := t.TempDir()
+	exportFile := filepath.Join(tmpDir, "user_data_tiktok.json")
+	fixture := `{
+		"Likes and Favorites": {
+			"Favorite Videos": {"FavoriteVideoList": [
+				{"Link": "https://www.tiktokv.com/share/video/111/", "Date": "2024-01-01 10:00:00"}
+			]},
+			"Like List": {"ItemFavoriteList": [
+				{"link": "https://www.tiktokv.com/share/video/222/", "date": "2024-01-02 10:00:00"}
+			]}
+		}
+	}`
+	if err := os.WriteFile(exportFile, []byte(fixture), 0644); err != nil {
+		t.Fatalf("failed to write export: %v", err)
+	}
+
+	ytdlp := filepath.Join(tmpDir, "yt-dlp")
+	config := &Config{JSONFile: exportFile, OrganizeByCollection: true, YtdlpPath: ytdlp, OutputName: "fav_videos.txt"}
+	var out bytes.Buffer
+	clipboard := &recordingClipboard{}
+	if err := runPrintCommand(config, tmpDir, "linux", &out, clipboard); err != nil {
+		t.Fatalf("runPrintCommand failed: %v", err)
+	}
+
+	var want []string
+	for _, list := range []string{filepath.Join(tmpDir, "favorites", "fav_videos.txt"), filepath.Join(tmpDir, "liked", "liked_videos.txt")} {
+		if _, err := os.Stat(list); err != nil {
+			t.Errorf("expected %s to be written: %v", list, err)
+		}
+		output := filepath.Join(filepath.Dir(list), defaultOutputTemplate)
+		want = append(want, ytdlp+" -a "+list+" --output '"+output+"' --write-info-json --write-thumbnail")
+	}
+	if runtime.GOOS != "windows" && out.String() != strings.Join(want, "\n")+"\n" {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", out.String(), strings.Join(want, "\n"))
+	}
+	if clipboard.text != strings.TrimSuffix(out.String(), "\n") {
+		t.Errorf("expected the printed commands on the clipboard, got %q", clipboard.text)
+	}
+}
+
+// TestPrintPlan tests the --print-plan JSON describing sources, output, yt-dlp and commands
+func TestPrintPlan(t *testing.T) {
+	tmpDir := t.TempDir()
+	exportFile := filepath.Join(tmpDir, "user_data_tiktok.json")
+	fixture := `{
+		"Likes and Favorites": {
+			"Favorite Videos": {"FavoriteVideoList": [
+				{"Link": "https://www.tiktokv.com/share/video/111/", "Date": "2024-01-01 10:00:00"},
+				{"Link": "https://www.tiktokv.com/share/video/112/", "Date": "2024-01-01 11:00:00"}
+			]},
+			"Like List": {"ItemFavoriteList": [
+				{"link": "https://www.tiktokv.com/share/video/222/", "date": "2024-01-02 10:00:00"}
+			]}
+		}
+	}`
+	if err := os.WriteFile(exportFile, []byte(fixture), 0644); err != nil {
+		t.Fatalf("failed to write export: %v", err)
+	}
+	ytdlp := filepath.Join(tmpDir, "yt-dlp")
+	if err := os.WriteFile(ytdlp, []byte("binary"), 0755); err != nil {
+		t.Fatalf("failed to write yt-dlp: %v", err)
+	}
+
+	config := &Config{JSONFile: exportFile, OrganizeByCollection: true, YtdlpPath: ytdlp,
+		OutputName: "fav_videos.txt", MaxFilesize: "50M", DryRun: true}
+	var out bytes.Buffer
+	if err := runDryRunPlan(config, tmpDir, &out, &versionRunner{version: "2025.01.15"}); err != nil {
+		t.Fatalf("runDryRunPlan failed: %v", err)
+	}
+	var plan ExecutionPlan
+	if err := json.Unmarshal(out.Bytes(), &plan); err != nil {
+		t.Fatalf("plan is not valid JSON: %v\n%s", err, out.String())
+	}
+
+	if plan.JSONFile != exportFile || plan.OutputDir != tmpDir || !plan.OrganizeByCollection || !plan.DryRun {
+		t.Errorf("unexpected plan settings: %+v", plan)
+	}
+	if plan.TotalVideos != 3 {
+		t.Errorf("expected 3 videos, got %d", plan.TotalVideos)
+	}
+	wantSources := []PlanSource{{Name: "favorites", Videos: 2}, {Name: "liked", Videos: 1}}
+	if !reflect.DeepEqual(plan.Sources, wantSources) {
+		t.Errorf("expected sources %+v, got %+v", wantSources, plan.Sources)
+	}
+	if plan.Ytdlp.Path != ytdlp || plan.Ytdlp.Version != "2025.01.15" {
+		t.Errorf("unexpected yt-dlp: %+v", plan.Ytdlp)
+	}
+
+	if len(plan.Runs) != 2 {
+		t.Fatalf("expected a run per collection, got %+v", plan.Runs)
+	}
+	run := plan.Runs[0]
+	listFile := filepath.Join(tmpDir, "favorites", "fav_videos.txt")
+	outputTemplate := filepath.Join(tmpDir, "favorites", defaultOutputTemplate)
+	if run.Source != "favorites" || run.ListFile != listFile || run.Videos != 2 || run.OutputTemplate != outputTemplate {
+		t.Errorf("unexpected favorites run: %+v", run)
+	}
+	if _, err := os.Stat(listFile); err != nil {
+		t.Errorf("expected the list to be written: %v", err)
+	}
+	command := strings.Join(run.Command, " ")
+	for _, want := range []string{
+		"-a " + listFile,
+		"--output " + outputTemplate,
+		"--max-filesize 50M",
+		"--download-archive " + filepath.Join(tmpDir, "favorites", "download_archive.txt"),
+	} {
+		if !strings.Contains(command, want) {
+			t.Errorf("expected %q in command %s", want, command)
+		}
+	}
+	if run.Command[0] != localCommandPath(ytdlp) {
+		t.Errorf("expected the command to run %s, got %s", ytdlp, run.Command[0])
+	}
+
+	// A missing yt-dlp has no version
+	config.YtdlpPath = filepath.Join(tmpDir, "missing-yt-dlp")
+	if plan := buildExecutionPlan(config, tmpDir, nil, nil, &versionRunner{version: "x"}); plan.Ytdlp.Version != "" || len(plan.Sources) != 0 {
+		t.Errorf("expected no version or sources, got %+v", plan)
+	}
+}
+
+// TestScanManifest tests that scan reports files deleted or changed since the manifest was written
+func TestScanManifest(t *testing.T) {
+	root := t.TempDir()
+	collectionDir := filepath.Join(root, "favorites")
+	if err := os.MkdirAll(collectionDir, 0755); err != nil {
+		t.Fatalf("failed to create collection dir: %v", err)
+	}
+	files := map[string]string{
+		"20240101_111_a.mp4": "first video",
+		"20240101_222_b.mp4": "second video",
+		"20240101_333_c.mp4": "third video",
+		"20240101_444_d.mp4": "fourth video",
+	}
+	var entries []VideoEntry
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(collectionDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		entries = append(entries, VideoEntry{VideoID: videoIDFromFilename(name), LocalFilename: name, Downloaded: true})
+	}
+	entries = append(entries, VideoEntry{VideoID: "555", Downloaded: false})
+
+	if err := writeManifest(collectionDir, entries); err != nil {
+		t.Fatalf("writeManifest failed: %v", err)
+	}
+	manifest, err := loadManifest(collectionDir)
+	if err != nil || manifest == nil {
+		t.Fatalf("loadManifest failed: %v", err)
+	}
+	if len(manifest.Files) != 4 {
+		t.Fatalf("expected 4 recorded files, got %d", len(manifest.Files))
+	}
+
+	var out bytes.Buffer
+	if drifted, err := runScan(root, 1, &out); err != nil || drifted != 0 {
+		t.Fatalf("expected an intact archive, got %d drifted (err %v):\n%s", drifted, err, out.String())
+	}
+
+	// Alter the directory: one deleted, one rewritten at the same size, one truncated
+	_ = os.Remove(filepath.Join(collectionDir, "20240101_111_a.mp4"))
+	if err := os.WriteFile(filepath.Join(collectionDir, "20240101_222_b.mp4"), []byte("second VIDEO"), 0644); err != nil {
+		t.Fatalf("failed to alter file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(collectionDir, "20240101_333_c.mp4"), []byte("third"), 0644); err != nil {
+		t.Fatalf("failed to alter file: %v", err)
+	}
+
+	drift, checked, found, err := scanManifest(collectionDir, 1)
+	if err != nil || !found {
+		t.Fatalf("scanManifest failed: found=%v err=%v", found, err)
+	}
+	if checked != 4 {
+		t.Errorf("expected 4 files checked, got %d", checked)
+	}
+	got := make(map[string]string)
+	for _, d := range drift {
+		got[filepath.Base(d.Path)] = d.Kind
+	}
+	want := map[string]string{
+		"20240101_111_a.mp4": DriftDeleted,
+		"20240101_222_b.mp4": DriftChanged,
+		"20240101_333_c.mp4": DriftChanged,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("drift = %v, want %v", got, want)
+	}
+
+	out.Reset()
+	if drifted, err := runScan(root, 1, &out); err != nil || drifted != 3 {
+		t.Errorf("expected 3 drifted files, got %d (err %v)", drifted, err)
+	}
+	if !strings.Contains(out.String(), "deleted: "+filepath.Join(collectionDir, "20240101_111_a.mp4")) {
+		t.Errorf("expected the deleted file to be reported, got:\n%s", out.String())
+	}
+
+	// A rewrite keeps the record of the deleted file so it stays reported
+	if err := writeManifest(collectionDir, entries[:0]); err != nil {
+		t.Fatalf("writeManifest failed: %v", err)
+	}
+	if manifest, _ := loadManifest(collectionDir); manifest == nil || len(manifest.Files) != 4 {
+		t.Error("expected earlier records to be kept when rewriting the manifest")
+	}
+
+	if _, err := runScan(t.TempDir(), 1, &out); err == nil {
+		t.Error("expected an error for a directory without a manifest")
+	}
+}
+
+// TestParallelScan tests hashing many files concurrently against the manifest
+func TestParallelScan(t *testing.T) {
+	root := t.TempDir()
+	var entries []VideoEntry
+	for i := 1; i <= 40; i++ {
+		name := fmt.Sprintf("20240101_%d_clip.mp4", 1000+i)
+		if err := os.WriteFile(filepath.Join(root, name), []byte(strings.Repeat(name, i)), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		entries = append(entries, VideoEntry{LocalFilename: name, Downloaded: true})
+	}
+	if err := writeManifest(root, entries); err != nil {
+		t.Fatalf("writeManifest failed: %v", err)
+	}
+
+	// Same size, different contents: only the hash can tell
+	altered := filepath.Join(root, "20240101_1017_clip.mp4")
+	data, err := os.ReadFile(altered)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", altered, err)
+	}
+	data[0] ^= 0xff
+	if err := os.WriteFile(altered, data, 0644); err != nil {
+		t.Fatalf("failed to alter file: %v", err)
+	}
+
+	drift, checked, found, err := scanManifest(root, 8)
+	if err != nil || !found || checked != 40 {
+		t.Fatalf("scanManifest: checked=%d found=%v err=%v", checked, found, err)
+	}
+	if len(drift) != 1 || drift[0].Path != altered || drift[0].Kind != DriftChanged {
+		t.Errorf("expected only %s changed, got %+v", altered, drift)
+	}
+
+	for _, tt := range []struct {
+		args        []string
+		dir         string
+		concurrency int
+	}{
+		{nil, ".", runtime.NumCPU()},
+		{[]string{"--concurrency", "4", "videos"}, "videos", 4},
+		{[]string{"videos", "--concurrency=2"}, "videos", 2},
+	} {
+		dir, concurrency, err := parseScanArgs(tt.args)
+		if err != nil || dir != tt.dir || concurrency != tt.concurrency {
+			t.Errorf("parseScanArgs(%q) = %q, %d, %v; expected %q, %d", tt.args, dir, concurrency, err, tt.dir, tt.concurrency)
+		}
+	}
+	if _, _, err := parseScanArgs([]string{"--concurrency", "0"}); err == nil {
+		t.Error("expected an error for --concurrency 0")
+	}
+}
+
+// TestSplitArgs tests splitting YTDLP_EXTRA_ARGS values into yt-dlp arguments
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"--no-mtime", []string{"--no-mtime"}},
+		{"  --retries 10\t--no-mtime ", []string{"--retries", "10", "--no-mtime"}},
+		{`--format "best video" --limit-rate 1M`, []string{"--format", "best video", "--limit-rate", "1M"}},
+		{`--format="best video"`, []string{"--format=best video"}},
+		{`--output '%(title)s "quoted".%(ext)s'`, []string{"--output", `%(title)s "quoted".%(ext)s`}},
+		{`--ffmpeg-location "C:\Program Files\ffmpeg\bin"`, []string{"--ffmpeg-location", `C:\Program Files\ffmpeg\bin`}},
+		{`--match-filter ""`, []string{"--match-filter", ""}},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.value)
+		if err != nil {
+			t.Errorf("splitArgs(%q) failed: %v", tt.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+
+	for _, invalid := range []string{`--format "best`, `--output 'x`} {
+		if _, err := splitArgs(invalid); err == nil {
+			t.Errorf("expected an error for unterminated quote in %q", invalid)
+		}
+	}
+
+	// The extra arguments come after the tool's own
+	runner := &MockCommandRunner{}
+	config := &Config{DisableResume: true, ExtraArgs: []string{"--format", "best video"}}
+	entries := []VideoEntry{{Link: "https://www.tiktok.com/@a/video/111", Collection: "favorites"}}
+	if _, err := runYtdlpWithRunner(runner, "", "test_videos.txt", config, entries); err != nil {
+		t.Fatalf("runYtdlpWithRunner failed: %v", err)
+	}
+	args := runner.Commands[0].Args
+	if !slices.Equal(args[len(args)-2:], []string{"--format", "best video"}) {
+		t.Errorf("expected the extra arguments at the end, got %v", args)
+	}
+}
+
+// TestAfterID tests that --after-id continues a collection's list after the given video
+func TestAfterID(t *testing.T) {
+	entries := []VideoEntry{
+		{Link: "https://www.tiktok.com/@a/video/1", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@a/video/2", Collection: "favorites"},
+		{Link: "https://www.tiktokv.com/share/video/3/", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@b/video/4", Collection: "favorites"},
+		{Link: "https://www.tiktok.com/@c/video/5", Collection: "liked"},
+	}
+	ids := func(entries []VideoEntry) []string {
+		var got []string
+		for _, entry := range entries {
+			got = append(got, extractVideoID(entry.Link))
+		}
+		return got
+	}
+
+	tests := []struct {
+		afterID string
+		want    []string
+	}{
+		{"", []string{"1", "2", "3", "4", "5"}},
+		{"2", []string{"3", "4", "5"}},
+		{"3", []string{"4", "5"}},                  // found via a share link
+		{"4", []string{"5"}},                       // last favorite: only other collections remain
+		{"5", []string{"1", "2", "3", "4"}},        // cut in liked leaves favorites whole
+		{"999", []string{"1", "2", "3", "4", "5"}}, // unknown ID keeps everything
+	}
+	for _, tt := range tests {
+		got := applyAfterID(&Config{AfterID: tt.afterID}, entries)
+		if !reflect.DeepEqual(ids(got), tt.want) {
+			t.Errorf("--after-id %q: expected %v, got %v", tt.afterID, tt.want, ids(got))
+		}
+	}
+
+	if id := parseVideoIDValue("https://www.tiktok.com/@a/video/2?lang=en"); id != "2" {
+		t.Errorf("expected a URL to give its video ID, got %q", id)
+	}
+}
+
+// TestSkipKnown tests reading known video IDs from a previous run's index or playlist
+func TestSkipKnown(t *testing.T) {
+	tmpDir := t.TempDir()
+	entries := []VideoEntry{
+		{VideoID: "111", Link: "https://www.tiktok.com/@a/video/111", Downloaded: true, LocalFilename: "20240101_111_first.mp4", Collection: "favorites"},
+		{VideoID: "222", Link: "https://www.tiktok.com/@b/video/222", Downloaded: false, DownloadError: "Video unavailable", Collection: "favorites"},
+		{VideoID: "333", Link: "https://www.tiktok.com/@c/video/333", Downloaded: true, LocalFilename: "20240102_333_third & more.mp4", Collection: "favorites"},
+	}
+	for _, entry := range entries {
+		if entry.Downloaded {
+			if err := os.WriteFile(filepath.Join(tmpDir, entry.LocalFilename), []byte("x"), 0644); err != nil {
+				t.Fatalf("failed to write %s: %v", entry.LocalFilename, err)
+			}
+		}
+	}
+	if err := generateCollectionIndex(tmpDir, entries, nil); err != nil {
+		t.Fatalf("failed to generate the prior index: %v", err)
+	}
+	playlist := "#EXTM3U\n#EXTINF:12,First\nfavorites/20240101_111_first.mp4\n" +
+		"#EXTINF:-1,Remote\r\nhttps://www.tiktok.com/@d/video/444\r\n" +
+		`C:\Videos\liked\20240103_555_fifth.mp4` + "\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, "library.m3u8"), []byte(playlist), 0644); err != nil {
+		t.Fatalf("failed to write playlist: %v", err)
+	}
+
+	want := map[string]map[string]bool{
+		"index.json":   {"111": true, "333": true},
+		"index.html":   {"111": true, "333": true},
+		"library.m3u8": {"111": true, "444": true, "555": true},
+	}
+	for name, wantIDs := range want {
+		ids, err := parseKnownIDs(filepath.Join(tmpDir, name))
+		if err != nil {
+			t.Errorf("parseKnownIDs(%s) failed: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(ids, wantIDs) {
+			t.Errorf("parseKnownIDs(%s) = %v, want %v", name, ids, wantIDs)
+		}
+	}
+	if _, err := parseKnownIDs(filepath.Join(tmpDir, "results.txt")); err == nil {
+		t.Error("expected an error for an unsupported file")
+	}
+
+	// Known videos are skipped, the failed one is tried again
+	ids, _ := parseKnownIDs(filepath.Join(tmpDir, "index.html"))
+	var got []string
+	for _, entry := range applySkipKnown(&Config{KnownIDs: ids}, entries) {
+		got = append(got, entry.VideoID)
+	}
+	if !reflect.DeepEqual(got, []string{"222"}) {
+		t.Errorf("expected only 222 left to download, got %v", got)
+	}
+}
+
+// TestPrecheck tests that --precheck drops links the server reports as gone
+func TestPrecheck(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Method != http.MethodHead {
+			t.Errorf("expected a HEAD request, got %s", r.Method)
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/404"):
+			w.WriteHeader(http.StatusNotFound)
+		case strings.HasSuffix(r.URL.Path, "/410"):
+			w.WriteHeader(http.StatusGone)
+		case strings.HasSuffix(r.URL.Path, "/500"):
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	var entries []VideoEntry
+	for _, path := range []string{"1", "404", "2", "410", "500", "3"} {
+		entries = append(entries, VideoEntry{Link: ts.URL + "/@user/video/" + path})
+	}
+
+	kept, dropped := precheckEntries(context.Background(), ts.Client(), entries)
+	var keptLinks []string
+	for _, entry := range kept {
+		keptLinks = append(keptLinks, strings.TrimPrefix(entry.Link, ts.URL))
+	}
+	want := []string{"/@user/video/1", "/@user/video/2", "/@user/video/500", "/@user/video/3"}
+	if !reflect.DeepEqual(keptLinks, want) || len(dropped) != 2 {
+		t.Errorf("expected %v kept and 2 dropped, got %v and %d", want, keptLinks, len(dropped))
+	}
+
+	// Off by default, and a cancelled context keeps everything unchecked
+	requests.Store(0)
+	if got := applyPrecheck(context.Background(), &Config{}, ts.Client(), entries); len(got) != len(entries) {
+		t.Errorf("expected no filtering without --precheck, got %d entries", len(got))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got := applyPrecheck(ctx, &Config{Precheck: true}, ts.Client(), entries); len(got) != len(entries) {
+		t.Errorf("expected a cancelled precheck to keep all entries, got %d", len(got))
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("expected no requests, got %d", n)
+	}
+}
+
+// TestTraceHTTP tests that --trace-http logs the connection phases of a request
+func TestTraceHTTP(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	var out bytes.Buffer
+	client := &http.Client{Transport: &tracingTransport{rt: ts.Client().Transport, out: &out}}
+	resp, err := client.Get(ts.URL + "/yt-dlp.exe")
+	if err != nil {
+		t.Fatalf("traced request failed: %v", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	log := out.String()
+	for _, want := range []string{
+		"GET " + ts.URL + "/yt-dlp.exe",
+		"connecting to " + ts.Listener.Addr().String(),
+		"connected to " + ts.Listener.Addr().String(),
+		"TLS handshake done (TLS 1.3)",
+		"first response byte",
+		"response 200 OK",
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("expected trace to contain %q, got:\n%s", want, log)
+		}
+	}
+
+	// Only wired in with the flag
+	if client, err := newHTTPClient(&Config{TraceHTTP: true}); err != nil {
+		t.Fatalf("newHTTPClient failed: %v", err)
+	} else if _, ok := client.Transport.(*tracingTransport); !ok {
+		t.Errorf("expected a tracing transport with --trace-http, got %T", client.Transport)
+	}
+}
+
+// TestExportPathEnv tests the export path precedence: argument > TIKTOK_EXPORT_PATH > default
+func TestExportPathEnv(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(key string) string { return env[key] }
+	if got := defaultExportPath(getenv); got != "user_data_tiktok.json" {
+		t.Errorf("expected the default without %s, got %q", exportPathEnv, got)
+	}
+	env[exportPathEnv] = `D:\exports\user_data_tiktok.json`
+	if got := defaultExportPath(getenv); got != `D:\exports\user_data_tiktok.json` {
+		t.Errorf("expected the %s path, got %q", exportPathEnv, got)
+	}
+	env[exportPathEnv] = "   "
+	if got := defaultExportPath(getenv); got != "user_data_tiktok.json" {
+		t.Errorf("expected a blank %s to be ignored, got %q", exportPathEnv, got)
+	}
+
+	t.Setenv(exportPathEnv, "/data/export.json")
+	if _, file := parseDoctorArgs([]string{"--json"}); file != "/data/export.json" {
+		t.Errorf("expected %s to be used when no path is passed, got %q", exportPathEnv, file)
+	}
+	if _, file := parseDoctorArgs([]string{"mine.json"}); file != "mine.json" {
+		t.Errorf("expected the argument to take precedence, got %q", file)
+	}
+}